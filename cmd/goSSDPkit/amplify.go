@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// runAmplifyCommand implements the amplify subcommand
+func runAmplifyCommand(args []string) error {
+	var searchTarget, targetList string
+	var wait time.Duration
+
+	fs := newFlagSet("amplify", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s amplify [-st TARGET] [-w WAIT] [-targets LIST] interface\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Send one unicast M-SEARCH to each device and measure how many responses and\n")
+		fmt.Fprintf(os.Stderr, "bytes it sends back, to find devices usable for SSDP reflection attacks.\n")
+		fmt.Fprintf(os.Stderr, "Without -targets, the devices answering a multicast search are measured.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -st TARGET            Search target to ask for. Defaults to \"ssdp:all\",\n")
+		fmt.Fprintf(os.Stderr, "                        which draws the most responses.\n")
+		fmt.Fprintf(os.Stderr, "  -w WAIT               How long to wait for answers. Defaults to 3s.\n")
+		fmt.Fprintf(os.Stderr, "  -targets LIST         Comma-separated IPv4 addresses and ranges up to a /16\n")
+		fmt.Fprintf(os.Stderr, "                        to measure instead, e.g. routed subnets to check\n")
+		fmt.Fprintf(os.Stderr, "                        from off-link.\n")
+	})
+	fs.StringVar(&searchTarget, "st", "ssdp:all", "")
+	fs.DurationVar(&wait, "w", 3*time.Second, "")
+	fs.DurationVar(&wait, "wait", 3*time.Second, "")
+	fs.StringVar(&targetList, "targets", "", "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("interface is required")
+	}
+
+	localIP, err := getIPFromInterface(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var targets []string
+	if targetList != "" {
+		if targets, err = ssdp.ExpandTargets(strings.Split(targetList, ",")); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%sSending M-SEARCH for ssdp:all from %s, waiting %s...\n", ssdp.OkBox, localIP, wait)
+		found, err := ssdp.Scan(localIP, "ssdp:all", wait)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, result := range found {
+			if !seen[result.Addr] {
+				seen[result.Addr] = true
+				targets = append(targets, result.Addr)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Printf("%sNo devices to measure\n", ssdp.OkBox)
+		return nil
+	}
+
+	fmt.Printf("%sSending unicast M-SEARCH for %s to %d device(s), waiting %s...\n", ssdp.OkBox, searchTarget, len(targets), wait)
+	results, err := ssdp.Amplify(localIP, targets, searchTarget, wait)
+	if err != nil {
+		return err
+	}
+
+	offLink := 0
+	for _, a := range results {
+		fmt.Printf("%s%s answers unicast searches\n", ssdp.WarnBox, a.Addr)
+		fmt.Printf("    RESPONSES: %d (%d bytes for a %d byte search)\n", a.Responses, a.Bytes, a.Request)
+		fmt.Printf("    FACTOR:    %.1fx\n", a.Factor)
+		if a.OffLink {
+			fmt.Printf("    OFF-LINK:  answered a search from outside its subnet\n")
+			offLink++
+		}
+	}
+	fmt.Printf("%s%d of %d device(s) answered unicast searches, %d from off-link\n", ssdp.OkBox, len(results), len(targets), offLink)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/pgp"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// runAuditCommand implements the audit subcommand
+func runAuditCommand(args []string) error {
+	path := dataPath(audit.Path)
+	fs := newFlagSet("audit", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s audit [-f FILE]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "List the operator actions in the audit log and check that none were\n")
+		fmt.Fprintf(os.Stderr, "modified, removed or reordered.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -f FILE               Audit log to read. Defaults to %s.\n", dataPath(audit.Path))
+	})
+	fs.StringVar(&path, "f", path, "")
+	fs.StringVar(&path, "file", path, "")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	entries, verifyErr := audit.Verify(file)
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SEQ\tTIME\tACTION\tDETAILS")
+	for _, e := range entries {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\n", e.Seq, e.Time.Format(time.RFC3339), e.Action, formatDetails(e.Details))
+	}
+	table.Flush()
+
+	if verifyErr != nil {
+		return fmt.Errorf("audit log chain broken: %w", verifyErr)
+	}
+	if len(entries) > 0 {
+		fmt.Printf("\n%sChain intact: %d entries, last hash %s\n", ssdp.OkBox, len(entries), entries[len(entries)-1].Hash)
+	}
+	return nil
+}
+
+// formatDetails renders audit details as key=value pairs in key order
+func formatDetails(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + details[key]
+	}
+	return strings.Join(pairs, " ")
+}
+
+// recordAudit appends an action to the audit log, warning rather than
+// failing if it cannot
+func recordAudit(logger logging.Logger, action string, details map[string]string) {
+	log, err := audit.Open(dataPath(audit.Path))
+	if err == nil {
+		err = log.Record(action, details)
+	}
+	if err != nil {
+		logging.Notice(logger, "%sAudit log: %v", ssdp.WarnBox, err)
+	}
+}
+
+// configDigest returns the SHA-256 of config as YAML, identifying the
+// settings a run used without writing secrets such as webhook URLs to the
+// audit log
+func configDigest(config *Config) string {
+	data, _ := yaml.Marshal(config)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// changedSettings returns the config file keys whose values differ
+// between two configurations
+func changedSettings(old, next *Config) []string {
+	var a, b map[string]interface{}
+	oldData, _ := yaml.Marshal(old)
+	nextData, _ := yaml.Marshal(next)
+	yaml.Unmarshal(oldData, &a)
+	yaml.Unmarshal(nextData, &b)
+
+	var changed []string
+	for key := range b {
+		if !reflect.DeepEqual(a[key], b[key]) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// auditStart records the start of a serve or analyze run, with what it
+// serves where
+func auditStart(logger logging.Logger, config *Config, bindings []ssdp.Binding) {
+	command := "serve"
+	if config.AnalyzeMode {
+		command = "analyze"
+	}
+	names := make([]string, len(bindings))
+	for i, binding := range bindings {
+		names[i] = binding.Name
+	}
+	details := map[string]string{
+		"command":       command,
+		"interfaces":    strings.Join(names, ","),
+		"template":      config.Template,
+		"config_sha256": configDigest(config),
+		"version":       Version,
+	}
+	if configPath, _ := findConfigFlag(config.args); configPath != "" {
+		details["config_file"] = configPath
+	}
+	if campaign != "" {
+		details["campaign"] = campaign
+	}
+	if config.ScopeFile != "" {
+		details["scope_file"] = config.ScopeFile
+	}
+	if config.LootKey != "" {
+		if key, err := pgp.ReadKey(config.LootKey); err == nil {
+			details["loot_key"] = key.Fingerprint()
+		}
+	}
+	recordAudit(logger, audit.ActionStart, details)
+}
+
+// auditReload records a reload, the template switch and settings changed
+// by it, or why it failed
+func auditReload(logger logging.Logger, old, next *Config, err error) {
+	if err != nil {
+		recordAudit(logger, audit.ActionReload, map[string]string{"result": "failed", "error": err.Error()})
+		return
+	}
+	details := map[string]string{"result": "ok", "config_sha256": configDigest(next)}
+	if changed := changedSettings(old, next); len(changed) > 0 {
+		details["changed"] = strings.Join(changed, ",")
+	}
+	if next.Template != old.Template {
+		details["template"] = old.Template + " -> " + next.Template
+	}
+	if next.page != old.page {
+		details["page"] = old.page + " -> " + next.page
+	}
+	recordAudit(logger, audit.ActionReload, details)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// campaignsDir holds a directory of logs, loot and events per campaign
+const campaignsDir = "campaigns"
+
+// defaultCampaignState is where a campaign keeps its device identity
+// between runs, so each campaign answers as the same device throughout
+const defaultCampaignState = "state.json"
+
+// campaign is the name given with -campaign, "" when files are kept in the
+// working directory as usual
+var campaign string
+
+// setCampaign checks that name is usable as a directory name and keeps it
+func setCampaign(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid campaign name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	campaign = name
+	return nil
+}
+
+// dataPath returns where a file kept at path belongs: under the campaign's
+// directory when one is set and path is relative, otherwise path itself
+func dataPath(path string) string {
+	if campaign == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(campaignsDir, campaign, path)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/notify"
+)
+
+// parseCanaries turns the NAME=URL canary settings into the map templates
+// see
+func parseCanaries(canaries []string) (map[string]string, error) {
+	urls := make(map[string]string, len(canaries))
+	for _, canary := range canaries {
+		name, value, ok := strings.Cut(canary, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid canary %q, expected NAME=URL", canary)
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid canary URL for %s: %s", name, value)
+		}
+		urls[name] = value
+	}
+	return urls, nil
+}
+
+// parseSubnets parses CIDRs, taking a bare address as a single host
+func parseSubnets(subnets []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, subnet := range subnets {
+		if ip := net.ParseIP(subnet); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary subnet: %s", subnet)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// setCanary points canary at a canarytoken firing token, or at nothing when
+// token is empty, closing the one it replaces once its queue is delivered
+func setCanary(logger logging.Logger, canary *events.Switch, token string, subnets []string) {
+	var next events.Events
+	if token != "" {
+		// Subnets were checked when the configuration was parsed
+		nets, _ := parseSubnets(subnets)
+		next = notify.NewCanary(token, nets, logger)
+	}
+	if previous, ok := canary.Set(next).(*notify.Canary); ok {
+		previous.Close()
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/upnp"
+)
+
+// command is a single goSSDPkit subcommand
+type command struct {
+	name    string
+	summary string
+	banner  bool
+	run     func(args []string) error
+}
+
+// commands lists the available subcommands in the order shown by help
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "serve", summary: "Answer SSDP searches and serve the phishing template (default)", banner: true, run: runServeCommand},
+		{name: "analyze", summary: "Listen for SSDP searches without answering them", banner: true, run: runAnalyzeCommand},
+		{name: "scan", summary: "Send an M-SEARCH and list the devices that answer", banner: true, run: runScanCommand},
+		{name: "monitor", summary: "Watch the network for rogue SSDP responders (defensive)", banner: true, run: runMonitorCommand},
+		{name: "amplify", summary: "Measure the SSDP amplification factor of devices (defensive)", banner: true, run: runAmplifyCommand},
+		{name: "replay", summary: "Replay recorded M-SEARCH requests to a running listener", banner: true, run: runReplayCommand},
+		{name: "interfaces", summary: "List network interfaces with their indexes and addresses", run: runInterfacesCommand},
+		{name: "service", summary: "Install or remove goSSDPkit as a system service", run: runServiceCommand},
+		{name: "templates", summary: "List the available templates", run: runTemplatesCommand},
+		{name: "creds", summary: "Summarise the credentials captured in the log file", run: runCredsCommand},
+		{name: "export", summary: "Export captured events as CSV or JSON", run: runExportCommand},
+		{name: "training", summary: "List awareness exercise targets with their tokens and links", run: runTrainingCommand},
+		{name: "links", summary: "Manage tracked short links for lures sent by e-mail or chat", run: runLinksCommand},
+		{name: "tag", summary: "Attach tags and notes to hosts for exports", run: runTagCommand},
+		{name: "audit", summary: "List the operator audit log and check it is unmodified", run: runAuditCommand},
+		{name: "xxe", summary: "Generate XXE payloads pointing at this host's listeners", run: runXXECommand},
+		{name: "doctor", summary: "Check the local environment for common problems", banner: true, run: runDoctorCommand},
+	}
+}
+
+// verbosity is the console log level set by -q, -v and -vv
+var verbosity = logging.LevelNormal
+
+// globalFlags removes the options every command accepts from args, wherever
+// they appear, and applies them: -q, -v and -vv set the verbosity,
+// -no-color turns colors off and -campaign keeps files apart
+func globalFlags(args []string) (rest []string, noColor bool, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			// What follows is the command's, however it looks
+			return append(rest, args[i:]...), noColor, nil
+		}
+		name := ""
+		if strings.HasPrefix(arg, "-") {
+			name = strings.TrimLeft(arg, "-")
+		}
+		if value, ok := strings.CutPrefix(name, "campaign="); ok {
+			if err := setCampaign(value); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		switch name {
+		case "campaign":
+			if i+1 >= len(args) {
+				return nil, false, fmt.Errorf("-campaign needs a name")
+			}
+			i++
+			if err := setCampaign(args[i]); err != nil {
+				return nil, false, err
+			}
+		case "q", "quiet":
+			verbosity = logging.LevelQuiet
+		case "v", "verbose":
+			if verbosity < logging.LevelDebug {
+				verbosity++
+			}
+		case "vv":
+			verbosity = logging.LevelDebug
+		case "no-color":
+			noColor = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, noColor, nil
+}
+
+// newLogger creates the logger of a long-running command, writing to the
+// console at the chosen verbosity and to the log file. It falls back to the
+// console alone if the log file cannot be opened.
+func newLogger() *logging.UTCLogger {
+	logger, err := logging.NewUTCLogger(dataPath(upnp.LogPath))
+	if err != nil {
+		fmt.Printf("%s%v\n", ssdp.WarnBox, err)
+		logger = &logging.UTCLogger{}
+	}
+	logger.SetLevel(verbosity)
+	return logger
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// dispatch picks the subcommand from args and runs it. Anything that is not a
+// known subcommand is handed to serve so existing invocations keep working.
+func dispatch(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "-h", "--help", "help":
+			printCommandUsage()
+			return nil
+		case "-version", "--version", "version":
+			printVersion()
+			return nil
+		}
+	}
+
+	args, noColor, err := globalFlags(args)
+	if err != nil {
+		return err
+	}
+	cmd := findCommand("serve")
+	if len(args) > 0 {
+		if named := findCommand(args[0]); named != nil {
+			cmd = named
+			args = args[1:]
+		}
+	}
+
+	// Container log drivers and pipes get plain text, as does anyone who
+	// asks for it (https://no-color.org)
+	banner := getBanner()
+	if noColor || os.Getenv("NO_COLOR") != "" || !logging.IsTerminal(os.Stdout) {
+		ssdp.DisableColors()
+		banner = logging.StripANSI(banner)
+	}
+	if cmd.banner && verbosity > logging.LevelQuiet {
+		fmt.Print(banner)
+	}
+
+	return cmd.run(args)
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, and returns the positional arguments in order.
+// Everything after a -- terminator is positional, however it looks.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if terminated(fs, args[:len(args)-len(rest)]) {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// terminated reports whether the arguments fs parsed ended with a --
+// terminator, rather than a flag taking -- as its value
+func terminated(fs *flag.FlagSet, parsed []string) bool {
+	for i := 0; i < len(parsed); i++ {
+		if parsed[i] == "--" {
+			return true
+		}
+		if takesValue(fs, parsed[i]) {
+			i++
+		}
+	}
+	return false
+}
+
+// takesValue reports whether arg is a flag of fs that takes the next
+// argument as its value
+func takesValue(fs *flag.FlagSet, arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	if !strings.HasPrefix(arg, "-") || strings.Contains(name, "=") {
+		return false
+	}
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// newFlagSet creates a FlagSet for a subcommand that reports errors instead
+// of exiting, so every command shares the same error path in main.
+func newFlagSet(name string, usage func()) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = usage
+	return fs
+}
+
+// isHelp reports whether err came from -h/--help on a FlagSet
+func isHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
+
+func printCommandUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [options]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nglobal options, accepted anywhere:\n")
+	fmt.Fprintf(os.Stderr, "  -q, --quiet           Only print warnings, alerts and captures. The log file\n")
+	fmt.Fprintf(os.Stderr, "                        still records everything.\n")
+	fmt.Fprintf(os.Stderr, "  -v, -vv               Also print every search (-v) and per-packet and asset\n")
+	fmt.Fprintf(os.Stderr, "                        detail (-vv), e.g. to troubleshoot multicast.\n")
+	fmt.Fprintf(os.Stderr, "  --no-color            Print without colors, as when NO_COLOR is set.\n")
+	fmt.Fprintf(os.Stderr, "  --campaign NAME       Keep logs, loot, events, audit and state under\n")
+	fmt.Fprintf(os.Stderr, "                        %s/NAME, apart from other campaigns.\n", campaignsDir)
+	fmt.Fprintf(os.Stderr, "\nRun \"%s <command> -h\" for the options of a command. When no\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "command is given, serve is assumed.\n")
+}
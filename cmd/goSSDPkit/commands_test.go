@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		args       string
+		positional string
+		name       string
+		force      bool
+	}{
+		{"a -name x b", "a b", "x", false},
+		{"-force a -name=x", "a", "x", true},
+		{"-- a -force", "a -force", "", false},
+		{"a -- -name x", "a -name x", "", false},
+		{"a -name -- b -force", "a b", "--", true},
+		{"-force -- -- -name", "-- -name", "", true},
+		{"a --", "a", "", false},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		name := fs.String("name", "", "")
+		force := fs.Bool("force", false, "")
+		positional, err := parseInterspersed(fs, strings.Fields(tt.args))
+		if err != nil {
+			t.Errorf("%s: %v", tt.args, err)
+			continue
+		}
+		if strings.Join(positional, " ") != tt.positional || *name != tt.name || *force != tt.force {
+			t.Errorf("%s: positional %q, -name %q, -force %v; want %q, %q, %v",
+				tt.args, positional, *name, *force, tt.positional, tt.name, tt.force)
+		}
+	}
+}
+
+func TestGlobalFlagsTerminator(t *testing.T) {
+	rest, noColor, err := globalFlags([]string{"links", "--no-color", "--", "-q", "--no-color"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !noColor || strings.Join(rest, " ") != "links -- -q --no-color" {
+		t.Errorf("rest %q, noColor %v", rest, noColor)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads a YAML config file into config. It is applied before
+// the command line flags so that anything given on the command line wins.
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// findConfigFlag looks for -c/--config in args without consuming anything, so
+// the file can be loaded before the remaining flags are parsed on top of it.
+func findConfigFlag(args []string) (string, error) {
+	for i, arg := range args {
+		for _, prefix := range []string{"-c=", "--config=", "-config="} {
+			if strings.HasPrefix(arg, prefix) {
+				return strings.TrimPrefix(arg, prefix), nil
+			}
+		}
+		if arg == "-c" || arg == "--config" || arg == "-config" {
+			if i+1 >= len(args) || len(args[i+1]) == 0 || args[i+1][0] == '-' {
+				return "", fmt.Errorf("flag -c requires a value (config file path)")
+			}
+			return args[i+1], nil
+		}
+	}
+	return "", nil
+}
+
+// stringList is a flag and YAML value that may be repeated, comma separated,
+// or given as a YAML sequence
+type stringList []string
+
+// String implements flag.Value
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value, appending each comma separated item
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts either a single (comma separated) string or a list
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = nil
+		return l.Set(node.Value)
+	}
+
+	var items []string
+	if err := node.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// repeatedFlag is a flag that may be repeated, each use adding one value
+// as given, for values that may themselves contain commas
+type repeatedFlag []string
+
+// String implements flag.Value
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, " | ")
+}
+
+// Set implements flag.Value
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
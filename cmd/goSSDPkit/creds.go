@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/creds"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/upnp"
+)
+
+// defaultEngagement is the engagement exports are filed under unless one
+// is named
+const defaultEngagement = "default"
+
+// runCredsCommand implements the creds subcommand
+func runCredsCommand(args []string) error {
+	logPath := dataPath(upnp.LogPath)
+	all := false
+	exportDir := ""
+	engagement := defaultEngagement
+
+	fs := newFlagSet("creds", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s creds [-l LOGFILE] [-all] [-export DIR [-engagement NAME]]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Summarise the distinct credentials captured in the log file.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -l LOGFILE            Log file to read. Defaults to %s.\n", dataPath(upnp.LogPath))
+		fmt.Fprintf(os.Stderr, "  -all                  Print every captured credentials line instead,\n")
+		fmt.Fprintf(os.Stderr, "                        repeats included.\n")
+		fmt.Fprintf(os.Stderr, "  -export DIR           Also write the captures to DIR/ENGAGEMENT in hashcat\n")
+		fmt.Fprintf(os.Stderr, "                        (hashcat-<mode>.txt) and john (john-<format>.txt)\n")
+		fmt.Fprintf(os.Stderr, "                        formats.\n")
+		fmt.Fprintf(os.Stderr, "  -engagement NAME      Engagement the export is filed under. Defaults to\n")
+		fmt.Fprintf(os.Stderr, "                        \"%s\".\n", defaultEngagement)
+	})
+	fs.StringVar(&logPath, "l", logPath, "")
+	fs.StringVar(&logPath, "log", logPath, "")
+	fs.BoolVar(&all, "all", all, "")
+	fs.StringVar(&exportDir, "export", exportDir, "")
+	fs.StringVar(&engagement, "engagement", engagement, "")
+
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	if !all {
+		tracker, err := creds.ParseLog(file)
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+		entries := tracker.Entries()
+		if len(entries) == 0 {
+			fmt.Println("No credentials captured")
+			return nil
+		}
+		if err := creds.WriteTable(os.Stdout, entries); err != nil {
+			return err
+		}
+		if exportDir == "" {
+			return nil
+		}
+		files, err := creds.Export(exportDir, engagement, creds.Hashes(entries))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			fmt.Printf("%sWrote %s\n", ssdp.OkBox, file)
+		}
+		recordAudit(logging.NewConsoleLogger(os.Stderr), audit.ActionExport, map[string]string{
+			"type":       "hashes",
+			"output":     exportDir,
+			"engagement": engagement,
+			"files":      strconv.Itoa(len(files)),
+		})
+		return nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "[CREDS GIVEN]") {
+			fmt.Println(line)
+		}
+	}
+
+	return scanner.Err()
+}
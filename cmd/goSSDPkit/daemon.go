@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/upnp"
+)
+
+// daemonEnv marks the background copy started by -daemon, so it runs
+// instead of starting another
+const daemonEnv = "GOSSDPKIT_DAEMON"
+
+// defaultPIDFile is where -daemon records the background process ID
+const defaultPIDFile = "logs/goSSDPkit.pid"
+
+// daemonize starts this command again in the background, detached from the
+// terminal, and returns once it is up. Its output goes to the log file only.
+func daemonize(config *Config) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.SysProcAttr = detachedProcess()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start in the background: %w", err)
+	}
+
+	// Anything wrong with the config or the sockets shows up straight away
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case <-exited:
+		return fmt.Errorf("background process exited at startup, see %s", dataPath(upnp.LogPath))
+	case <-time.After(2 * time.Second):
+	}
+
+	fmt.Printf("%sRunning in the background as PID %d, logging to %s\n", ssdp.OkBox, cmd.Process.Pid, dataPath(upnp.LogPath))
+	return nil
+}
+
+// pidFilePath returns path, or the default location when it is empty
+func pidFilePath(path string) string {
+	if path == "" {
+		return defaultPIDFile
+	}
+	return path
+}
+
+// writePIDFile records the background process ID at path, or the default
+// location, and returns a function that removes it again
+func writePIDFile(path string) (func(), error) {
+	path = pidFilePath(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// detachedProcess starts the background process in a session of its own,
+// so it survives the terminal closing
+func detachedProcess() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// detachedProcessFlag is DETACHED_PROCESS, which the syscall package lacks
+const detachedProcessFlag = 0x00000008
+
+// detachedProcess starts the background process without a console, in a
+// process group of its own so Ctrl+C in the terminal does not reach it
+func detachedProcess() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcessFlag}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// healthPath is the health check endpoint served in docker mode
+const healthPath = "/healthz"
+
+// applyEnv reads settings from the environment, for containers where
+// passing flags is awkward. They override the config file; flags override
+// them.
+func applyEnv(config *Config) error {
+	if value := os.Getenv("GOSSDPKIT_INTERFACE"); value != "" {
+		var interfaces stringList
+		if err := interfaces.Set(value); err != nil {
+			return err
+		}
+		config.Interfaces = interfaces
+	}
+	if value := os.Getenv("GOSSDPKIT_ADVERTISE_IP"); value != "" {
+		config.AdvertiseIP = value
+	}
+	if value := os.Getenv("GOSSDPKIT_ADVERTISE_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid GOSSDPKIT_ADVERTISE_PORT: %s", value)
+		}
+		config.AdvertisePort = port
+	}
+	if value := os.Getenv("GOSSDPKIT_DOCKER"); value != "" {
+		docker, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid GOSSDPKIT_DOCKER: %s", value)
+		}
+		config.Docker = docker
+	}
+	return nil
+}
+
+// inContainer reports whether we are running in a Docker, Podman or
+// Kubernetes container
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, runtime := range []string{"docker", "containerd", "kubepods", "libpod"} {
+		if strings.Contains(string(cgroup), runtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVeth reports whether the named interface is one end of a veth pair, as
+// a container's interface is on a bridge network. Only Linux tells us.
+func isVeth(name string) bool {
+	iflink, err := os.ReadFile("/sys/class/net/" + name + "/iflink")
+	if err != nil {
+		return false
+	}
+	ifindex, err := os.ReadFile("/sys/class/net/" + name + "/ifindex")
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(iflink)) != strings.TrimSpace(string(ifindex))
+}
+
+// checkContainer reports how the container is networked and warns when
+// victims on the LAN will not be able to find or reach us
+func checkContainer(logger logging.Logger, config *Config, bindings []ssdp.Binding) {
+	if !inContainer() {
+		logging.Notice(logger, "%sDocker mode, but no container detected", ssdp.WarnBox)
+		return
+	}
+
+	bridged := true
+	for _, binding := range bindings {
+		if !isVeth(binding.Name) {
+			bridged = false
+		}
+	}
+	if !bridged {
+		logger.Log("%sContainer networking: host", ssdp.OkBox)
+		return
+	}
+
+	logging.Notice(logger, "%sContainer networking: bridge", ssdp.WarnBox)
+	logging.Notice(logger, "%sMulticast searches from the LAN do not cross the Docker bridge. Run with --network host, or on a macvlan network, to be found.", ssdp.WarnBox)
+	if config.AdvertiseIP == "" {
+		logging.Notice(logger, "%sLOCATION points at the container address %s, which the LAN cannot reach. Publish port %d and set GOSSDPKIT_ADVERTISE_IP to the Docker host's address.",
+			ssdp.WarnBox, bindings[0].LocalIP, config.Port)
+	}
+}
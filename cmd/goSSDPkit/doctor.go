@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+	"goSSDPkit/pkg/upnp"
+)
+
+// runDoctorCommand implements the doctor subcommand
+func runDoctorCommand(args []string) error {
+	port := 8888
+	templateName := "office365"
+	templatesDir := ""
+
+	fs := newFlagSet("doctor", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s doctor [-p PORT] [-t TEMPLATE] [-templates-dir DIR] [interface]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Check privileges, interfaces, ports, templates and the log directory.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -p PORT, --port PORT  HTTP port to check. Defaults to 8888.\n")
+		fmt.Fprintf(os.Stderr, "  -t TEMPLATE, --template TEMPLATE\n")
+		fmt.Fprintf(os.Stderr, "                        Template to validate. Defaults to \"office365\".\n")
+	})
+	fs.IntVar(&port, "p", port, "")
+	fs.IntVar(&port, "port", port, "")
+	fs.StringVar(&templateName, "t", templateName, "")
+	fs.StringVar(&templateName, "template", templateName, "")
+	fs.StringVar(&templatesDir, "templates-dir", templatesDir, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	check := func(ok bool, format string, args ...interface{}) {
+		box := ssdp.OkBox
+		if !ok {
+			box = ssdp.WarnBox
+			problems++
+		}
+		fmt.Printf("%s%s\n", box, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Printf("%sPlatform: %s/%s, goSSDPkit %s\n", ssdp.OkBox, runtime.GOOS, runtime.GOARCH, Version)
+
+	if runtime.GOOS != "windows" {
+		check(os.Geteuid() == 0, "Running as root: %t (binding UDP 1900 usually needs it)", os.Geteuid() == 0)
+	}
+
+	// Interfaces with a usable IPv4 address
+	interfaces, err := net.Interfaces()
+	check(err == nil, "Listing network interfaces: %v", errOrOK(err))
+	for _, iface := range interfaces {
+		if ip, err := getIPFromInterfaceStruct(iface); err == nil {
+			fmt.Printf("    %-20s %s\n", iface.Name, ip)
+		}
+	}
+
+	localIP := ""
+	if len(positional) > 0 {
+		localIP, err = getIPFromInterface(positional[0])
+		check(err == nil, "Interface %s has an IPv4 address: %v", positional[0], errOrValue(err, localIP))
+	}
+
+	// SSDP and HTTP ports
+	udpConn, err := ssdp.ListenShared("udp4", 1900)
+	check(err == nil, "UDP port 1900 can be bound: %v", errOrOK(err))
+	if err == nil {
+		udpConn.Close()
+	}
+
+	tcpListener, err := net.Listen("tcp4", fmt.Sprintf("%s:%d", localIP, port))
+	check(err == nil, "TCP port %d is free: %v", port, errOrOK(err))
+	if err == nil {
+		tcpListener.Close()
+	}
+
+	// Templates
+	templatesFS, err := openTemplates(templatesDir)
+	if err == nil {
+		err = template.ValidateTemplateDir(templatesFS, templateName)
+	}
+	check(err == nil, "Template %s is valid: %v", templateName, errOrOK(err))
+
+	// Log directory
+	logDir := filepath.Dir(upnp.LogPath)
+	err = os.MkdirAll(logDir, 0755)
+	if err == nil {
+		var probe *os.File
+		probe, err = os.CreateTemp(logDir, ".doctor-*")
+		if err == nil {
+			probe.Close()
+			os.Remove(probe.Name())
+		}
+	}
+	check(err == nil, "Log directory %s is writable: %v", logDir, errOrOK(err))
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+// errOrOK formats err for a doctor check line
+func errOrOK(err error) string {
+	return errOrValue(err, "ok")
+}
+
+// errOrValue formats err, or value when err is nil, for a doctor check line
+func errOrValue(err error, value string) string {
+	if err != nil {
+		return err.Error()
+	}
+	return value
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/store"
+)
+
+// exportTypes maps the -type names to record types. variants and training
+// read every type.
+var exportTypes = map[string]string{
+	"creds":     store.TypeCreds,
+	"hooks":     store.TypeHook,
+	"msearch":   store.TypeMSearch,
+	"variants":  "",
+	"analytics": store.TypeInteraction,
+	"training":  "",
+}
+
+// runExportCommand implements the export subcommand
+func runExportCommand(args []string) error {
+	path := dataPath(store.Path)
+	format := "csv"
+	kind := ""
+	since := ""
+	output := ""
+	targetsFile := ""
+
+	fs := newFlagSet("export", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s export -type creds|hooks|msearch|variants|analytics|training\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       [-format csv|json] [-since WHEN] [-targets FILE] [-f FILE] [-o OUTPUT]\n\n")
+		fmt.Fprintf(os.Stderr, "Export captured credentials, phishing page hits or SSDP searches from the\n")
+		fmt.Fprintf(os.Stderr, "event store for spreadsheets and reports.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -type TYPE            What to export: creds, hooks, msearch, variants for\n")
+		fmt.Fprintf(os.Stderr, "                        the hosts hooked and submitting per A/B test page, or\n")
+		fmt.Fprintf(os.Stderr, "                        analytics for how victims used each page, training\n")
+		fmt.Fprintf(os.Stderr, "                        for which education mode targets were lured, clicked\n")
+		fmt.Fprintf(os.Stderr, "                        and submitted.\n")
+		fmt.Fprintf(os.Stderr, "  -format FORMAT        csv or json. Defaults to csv.\n")
+		fmt.Fprintf(os.Stderr, "  -since WHEN           Only events since WHEN, a duration back from now (24h)\n")
+		fmt.Fprintf(os.Stderr, "                        or a date or time (2024-05-01, 2024-05-01T10:00:00Z).\n")
+		fmt.Fprintf(os.Stderr, "  -targets FILE         Targets file served with, so -type training lists\n")
+		fmt.Fprintf(os.Stderr, "                        targets that did nothing too.\n")
+		fmt.Fprintf(os.Stderr, "  -f FILE               Event store to read. Defaults to %s.\n", dataPath(store.Path))
+		fmt.Fprintf(os.Stderr, "  -o OUTPUT             File to write. Defaults to standard output.\n")
+	})
+	fs.StringVar(&kind, "type", kind, "")
+	fs.StringVar(&format, "format", format, "")
+	fs.StringVar(&since, "since", since, "")
+	fs.StringVar(&targetsFile, "targets", targetsFile, "")
+	fs.StringVar(&path, "f", path, "")
+	fs.StringVar(&path, "file", path, "")
+	fs.StringVar(&output, "o", output, "")
+	fs.StringVar(&output, "output", output, "")
+
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	t, ok := exportTypes[kind]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("type must be creds, hooks, msearch, variants, analytics or training")
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("format must be csv or json")
+	}
+	from, err := parseSince(since, time.Now())
+	if err != nil {
+		return err
+	}
+	var targets []string
+	if targetsFile != "" {
+		loaded, err := loadTargets(targetsFile)
+		if err != nil {
+			return err
+		}
+		for _, target := range loaded.List() {
+			targets = append(targets, target.Name)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open event store: %w", err)
+	}
+	defer file.Close()
+	records, err := store.Read(file, t, from)
+	if err != nil {
+		return fmt.Errorf("failed to read event store: %w", err)
+	}
+	// Hosts keep the tags set before the period exported
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read event store: %w", err)
+	}
+	tagged, err := store.Read(file, store.TypeTag, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to read event store: %w", err)
+	}
+	store.Annotate(records, store.Tags(tagged))
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer out.Close()
+		w = out
+	}
+
+	switch {
+	case kind == "variants":
+		conversions := store.Conversions(records)
+		if format == "json" {
+			if conversions == nil {
+				conversions = []store.Conversion{}
+			}
+			err = writeJSON(w, conversions)
+		} else {
+			err = writeConversionsCSV(w, conversions)
+		}
+	case kind == "training":
+		if format == "json" {
+			err = writeJSON(w, store.Training(records, targets))
+		} else {
+			err = writeTrainingCSV(w, store.Training(records, targets))
+		}
+	case kind == "analytics":
+		if format == "json" {
+			err = writeJSON(w, store.Analytics(records))
+		} else {
+			err = writeAnalyticsCSV(w, store.Analytics(records))
+		}
+	case format == "json":
+		if records == nil {
+			records = []store.Record{}
+		}
+		err = writeJSON(w, records)
+	default:
+		err = writeCSV(w, t, records)
+	}
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = "stdout"
+	}
+	details := map[string]string{
+		"type":    kind,
+		"format":  format,
+		"output":  output,
+		"records": strconv.Itoa(len(records)),
+	}
+	if since != "" {
+		details["since"] = since
+	}
+	recordAudit(logging.NewConsoleLogger(os.Stderr), audit.ActionExport, details)
+	return nil
+}
+
+// parseSince parses the -since value: empty for everything, a duration back
+// from now, or a date or time
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, since, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid -since value: %q", since)
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeConversionsCSV writes the conversions of A/B test pages, the rate
+// as a percentage
+func writeConversionsCSV(w io.Writer, conversions []store.Conversion) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"variant", "hooked", "submitted", "rate"})
+	for _, c := range conversions {
+		cw.Write([]string{c.Variant, strconv.Itoa(c.Hooked), strconv.Itoa(c.Submitted), strconv.FormatFloat(c.Rate*100, 'f', 1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTrainingCSV writes the result of each training target
+func writeTrainingCSV(w io.Writer, results []store.TrainingResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"target", "hosts", "lured", "clicked", "submitted", "clicks", "submissions"})
+	for _, r := range results {
+		cw.Write([]string{r.Target, strings.Join(r.Hosts, " "), fmt.Sprint(r.Lured), fmt.Sprint(r.Clicks > 0),
+			fmt.Sprint(r.Submissions > 0), strconv.Itoa(r.Clicks), strconv.Itoa(r.Submissions)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeAnalyticsCSV writes the analytics of each page, the fields abandoned
+// pages were left at most often first
+func writeAnalyticsCSV(w io.Writer, pages []store.PageAnalytics) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"page", "variant", "sessions", "interacted", "submitted", "abandoned",
+		"median_first_input_ms", "median_duration_ms", "focus_order", "abandoned_at"})
+	for _, p := range pages {
+		fields := make([]string, 0, len(p.AbandonedAt))
+		for field := range p.AbandonedAt {
+			fields = append(fields, field)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			if p.AbandonedAt[fields[i]] != p.AbandonedAt[fields[j]] {
+				return p.AbandonedAt[fields[i]] > p.AbandonedAt[fields[j]]
+			}
+			return fields[i] < fields[j]
+		})
+		for i, field := range fields {
+			fields[i] = field + ":" + strconv.Itoa(p.AbandonedAt[field])
+		}
+		cw.Write([]string{p.Page, p.Variant, strconv.Itoa(p.Sessions), strconv.Itoa(p.Interacted),
+			strconv.Itoa(p.Submitted), strconv.Itoa(p.Abandoned),
+			strconv.FormatInt(p.MedianFirstInput, 10), strconv.FormatInt(p.MedianDuration, 10),
+			p.FocusOrder, strings.Join(fields, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSV writes records of type t with a header row of the columns that
+// type fills
+func writeCSV(w io.Writer, t string, records []store.Record) error {
+	cw := csv.NewWriter(w)
+	var header []string
+	var row func(store.Record) []string
+	switch t {
+	case store.TypeCreds:
+		header = []string{"time", "interface", "host", "user_agent", "source", "username", "password", "extra", "validation", "new", "response_id"}
+		row = func(r store.Record) []string {
+			return []string{r.Time.Format(time.RFC3339), r.Label, r.Host, r.UserAgent, r.Source, r.Username, r.Password, url.Values(r.Extra).Encode(), r.Verdict, fmt.Sprint(r.New), r.ResponseID}
+		}
+	case store.TypeHook:
+		header = []string{"time", "interface", "host", "user_agent", "method", "path", "response_id"}
+		row = func(r store.Record) []string {
+			return []string{r.Time.Format(time.RFC3339), r.Label, r.Host, r.UserAgent, r.Method, r.Path, r.ResponseID}
+		}
+	case store.TypeMSearch:
+		header = []string{"time", "interface", "host", "st", "user_agent", "new", "response_id"}
+		row = func(r store.Record) []string {
+			return []string{r.Time.Format(time.RFC3339), r.Label, r.Host, r.ST, r.UserAgent, fmt.Sprint(r.New), r.ResponseID}
+		}
+	}
+
+	// Every type ends with the tags and note of the host
+	cw.Write(append(header, "tags", "note"))
+	for _, r := range records {
+		cw.Write(append(row(r), strings.Join(r.Tags, ","), r.Note))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/scope"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/upnp"
+)
+
+// Instance is another device served by the same process as the main one,
+// on the same interfaces: its own template, HTTP port, device identity and
+// scope, sharing the log, event store, loot and outputs (config file only)
+type Instance struct {
+	// Name labelling the instance's log lines and events
+	Name string `yaml:"name"`
+	// Template served; defaults to the main one, following its schedule
+	Template string `yaml:"template"`
+	// HTTP port, which must differ from every other instance's
+	Port int `yaml:"port"`
+	// Where the instance keeps its device identity across restarts
+	StateFile string `yaml:"state_file"`
+	// Scope of the instance; defaults to the main one
+	ScopeFile string `yaml:"scope_file"`
+}
+
+// checkInstances validates the instances of config, filling in what they
+// take from the main device
+func checkInstances(config *Config) error {
+	if len(config.Instances) > 0 && config.AnalyzeMode {
+		return fmt.Errorf("instances cannot be run in analyze mode")
+	}
+	names := make(map[string]bool)
+	ports := map[int]string{config.Port: "the main device"}
+	if config.FTPPort != 0 {
+		ports[config.FTPPort] = "-ftp-port"
+	}
+	states := make(map[string]string)
+	if config.StateFile != "" {
+		states[config.StateFile] = "the main device"
+	}
+	for i := range config.Instances {
+		instance := &config.Instances[i]
+		if !validName(instance.Name) {
+			return fmt.Errorf("instance %d: invalid name %q: use letters, digits, '.', '_' and '-'", i+1, instance.Name)
+		}
+		if names[instance.Name] {
+			return fmt.Errorf("instance %s: name used twice", instance.Name)
+		}
+		names[instance.Name] = true
+		if instance.Port < 1 || instance.Port > 65535 {
+			return fmt.Errorf("instance %s: port must be between 1 and 65535", instance.Name)
+		}
+		if other, ok := ports[instance.Port]; ok {
+			return fmt.Errorf("instance %s: port %d is already used by %s", instance.Name, instance.Port, other)
+		}
+		ports[instance.Port] = "instance " + instance.Name
+		if instance.StateFile == "" && campaign != "" {
+			instance.StateFile = dataPath("state-" + instance.Name + ".json")
+		}
+		if instance.StateFile != "" {
+			if other, ok := states[instance.StateFile]; ok {
+				return fmt.Errorf("instance %s: state file %s is already used by %s", instance.Name, instance.StateFile, other)
+			}
+			states[instance.StateFile] = "instance " + instance.Name
+		}
+		if instance.ScopeFile != "" {
+			if _, err := scope.Load(instance.ScopeFile); err != nil {
+				return fmt.Errorf("instance %s: %w", instance.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validName reports whether name is usable as a label and file name
+func validName(name string) bool {
+	if name == "" || name[0] == '.' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceConfig returns the configuration of instance: the main one with
+// the instance's own settings over it
+func instanceConfig(config *Config, instance Instance) *Config {
+	derived := *config
+	if instance.Template != "" {
+		derived.Template = instance.Template
+		derived.page = ""
+	}
+	derived.Port = instance.Port
+	derived.StateFile = instance.StateFile
+	if instance.ScopeFile != "" {
+		derived.ScopeFile = instance.ScopeFile
+	}
+	derived.Instances = nil
+	derived.Schedule = nil
+	return &derived
+}
+
+// instanceBindings returns bindings named after the instance, so its log
+// lines and events can be told from the main device's
+func instanceBindings(bindings []ssdp.Binding, name string) []ssdp.Binding {
+	named := make([]ssdp.Binding, len(bindings))
+	for i, binding := range bindings {
+		binding.Name = name + ":" + binding.Name
+		named[i] = binding
+	}
+	return named
+}
+
+// instancesScoped reports whether any instance has a scope of its own
+func instancesScoped(config *Config) bool {
+	for _, instance := range config.Instances {
+		if instance.ScopeFile != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceConfigs returns the configuration of each device config runs: the
+// main device's, then each instance's
+func deviceConfigs(config *Config) []*Config {
+	configs := []*Config{config}
+	for _, instance := range config.Instances {
+		configs = append(configs, instanceConfig(config, instance))
+	}
+	return configs
+}
+
+// labelled reports whether log lines and events name the interface, or
+// instance, they came from
+func labelled(config *Config, bindings []ssdp.Binding) bool {
+	return len(bindings) > 1 || len(config.Instances) > 0
+}
+
+// device is one spoofed device: an SSDP listener and the HTTP servers its
+// advertisements point at, one per interface, with their bound sockets
+type device struct {
+	name          string // instance name, empty for the main device
+	config        *Config
+	bindings      []ssdp.Binding
+	listener      *ssdp.Listener
+	servers       []*upnp.Server
+	httpListeners [][]net.Listener
+	stats         *deviceStats
+}
+
+// deviceStats counts the interactions of hosts with a device
+type deviceStats struct {
+	events.Nop
+	searches    atomic.Int64
+	descriptors atomic.Int64
+	phished     atomic.Int64
+	credentials atomic.Int64
+}
+
+func (s *deviceStats) OnMSearch(events.MSearch)         { s.searches.Add(1) }
+func (s *deviceStats) OnDescriptorFetch(events.Request) { s.descriptors.Add(1) }
+func (s *deviceStats) OnPhishHook(events.Request)       { s.phished.Add(1) }
+func (s *deviceStats) OnCredentials(events.Credentials) { s.credentials.Add(1) }
+
+// logDevices logs a table of the devices served, with what each has drawn
+// so far
+func logDevices(logger logging.Logger, devices []*device) {
+	var out strings.Builder
+	table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DEVICE\tTEMPLATE\tPORT\tUSN\tSCOPE\tSEARCHES\tDESCRIPTORS\tPHISHED\tCREDENTIALS")
+	for _, d := range devices {
+		name, scopeFile := d.name, d.config.ScopeFile
+		if name == "" {
+			name = "(main)"
+		}
+		if scopeFile == "" {
+			scopeFile = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t%d\n", name, d.config.Template, d.config.Port,
+			d.listener.GetSessionUSN(), scopeFile, d.stats.searches.Load(), d.stats.descriptors.Load(),
+			d.stats.phished.Load(), d.stats.credentials.Load())
+	}
+	table.Flush()
+	logging.Notice(logger, "%sServing %d devices:", ssdp.NoteBox, len(devices))
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		logging.Notice(logger, "    %s", line)
+	}
+}
+
+// newDevice creates the listener and servers of a device answering on
+// bindings, and binds its HTTP addresses
+func newDevice(logger logging.Logger, config *Config, templatesFS fs.FS, bindings []ssdp.Binding,
+	listenerOpts []ssdp.Option, serverOpts []upnp.Option, label bool) (*device, error) {
+	d := &device{config: config, bindings: append([]ssdp.Binding(nil), bindings...), stats: &deviceStats{}}
+	listenerOpts = append(listenerOpts[:len(listenerOpts):len(listenerOpts)], ssdp.WithLabels(label), ssdp.WithEvents(d.stats))
+	serverOpts = append(serverOpts[:len(serverOpts):len(serverOpts)], upnp.WithEvents(d.stats))
+
+	// A restart mid-engagement takes up the previous run's device identity
+	if config.StateFile != "" {
+		state, err := ssdp.LoadState(config.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			logger.Log("%sRestored session USN %s from %s", ssdp.OkBox, state.SessionUSN, config.StateFile)
+		}
+		listenerOpts = append(listenerOpts, ssdp.WithState(state))
+	}
+
+	// Create SSDP listener
+	listener, err := ssdp.NewListener(d.bindings, config.Port, listenerOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSDP listener: %w", err)
+	}
+	d.listener = listener
+	if !listener.IPv6() {
+		for i := range d.bindings {
+			d.bindings[i].LocalIP6 = ""
+		}
+	}
+
+	// Create one UPnP server per interface so every advertisement points at
+	// an address the requester can reach
+	for _, binding := range d.bindings {
+		templateManager, upnpConfig, err := newSite(config, templatesFS, binding, listener.GetSessionUSN(), label)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		// Create UPnP server
+		server, err := upnp.NewServer(templateManager, upnpConfig, serverOpts...)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to create UPnP server: %w", err)
+		}
+		d.servers = append(d.servers, server)
+
+		// Print configuration details
+		printDetails(logger, config, binding, upnpConfig.SMBServer)
+
+		// Every interface serves the same template, so its SSDP response
+		// and descriptors are set once
+		if len(d.servers) == 1 {
+			if err := setSSDPResponse(logger, listener, templateManager); err != nil {
+				listener.Close()
+				return nil, err
+			}
+			setDescriptors(listener, templateManager)
+		}
+	}
+
+	// The new BOOTID, and CONFIGID if the descriptors changed, are kept
+	// from now on
+	saveState(logger, listener, config.StateFile)
+
+	// Bind every HTTP address up front, each server serving IPv6 hosts on
+	// its interface as well when it has an address
+	d.httpListeners = make([][]net.Listener, len(d.servers))
+	for i := range d.servers {
+		addresses := []string{net.JoinHostPort(d.bindings[i].LocalIP, strconv.Itoa(config.Port))}
+		if d.bindings[i].LocalIP6 != "" {
+			addresses = append(addresses, net.JoinHostPort(d.bindings[i].LocalIP6, strconv.Itoa(config.Port)))
+		}
+		for _, address := range addresses {
+			ln, err := net.Listen("tcp", address)
+			if err != nil {
+				d.close()
+				return nil, fmt.Errorf("HTTP server error: %w", err)
+			}
+			d.httpListeners[i] = append(d.httpListeners[i], ln)
+		}
+	}
+	return d, nil
+}
+
+// close releases the sockets of a device that will not be started
+func (d *device) close() {
+	d.listener.Close()
+	for _, lns := range d.httpListeners {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}
+}
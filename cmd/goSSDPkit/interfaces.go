@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runInterfacesCommand implements the interfaces subcommand
+func runInterfacesCommand(args []string) error {
+	var all bool
+
+	fs := newFlagSet("interfaces", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s interfaces [-a] [PATTERN]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "List the network interfaces that can be listened on, with the index, name\n")
+		fmt.Fprintf(os.Stderr, "and addresses any of which may be given as the interface to listen on.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -a, --all             Include interfaces that are down or have no IPv4\n")
+		fmt.Fprintf(os.Stderr, "                        address.\n")
+		fmt.Fprintf(os.Stderr, "  PATTERN               Only list interfaces whose names match this glob\n")
+		fmt.Fprintf(os.Stderr, "                        pattern, e.g. \"eth*\".\n")
+	})
+	fs.BoolVar(&all, "a", all, "")
+	fs.BoolVar(&all, "all", all, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		fs.Usage()
+		return fmt.Errorf("at most one pattern may be given")
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	if len(positional) == 1 {
+		if interfaces, err = matchInterfaces(positional[0]); err != nil {
+			return err
+		}
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "INDEX\tNAME\tSTATE\tMAC\tADDRESSES")
+	for _, iface := range interfaces {
+		_, ipErr := getIPFromInterfaceStruct(iface)
+		if !all && (iface.Flags&net.FlagUp == 0 || ipErr != nil) {
+			continue
+		}
+
+		var addresses []string
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.String())
+			}
+		}
+		mac := iface.HardwareAddr.String()
+		if mac == "" {
+			mac = "-"
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n", iface.Index, iface.Name, interfaceState(iface),
+			mac, strings.Join(addresses, ", "))
+	}
+	return table.Flush()
+}
+
+// interfaceState describes the flags of iface that matter for SSDP
+func interfaceState(iface net.Interface) string {
+	state := "down"
+	if iface.Flags&net.FlagUp != 0 {
+		state = "up"
+	}
+	if iface.Flags&net.FlagLoopback != 0 {
+		state += ",loopback"
+	}
+	if iface.Flags&net.FlagMulticast == 0 {
+		state += ",no-multicast"
+	}
+	return state
+}
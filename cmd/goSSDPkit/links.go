@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/links"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// runLinksCommand implements the links subcommand
+func runLinksCommand(args []string) error {
+	path := dataPath(links.Path)
+	storePath := dataPath(store.Path)
+	add := ""
+	name := ""
+	token := ""
+	remove := ""
+	clicks := ""
+	base := ""
+
+	fs := newFlagSet("links", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s links [-f FILE] -add TARGET [-name NAME] [-token TOKEN] [-url URL]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s links [-f FILE] -remove TOKEN\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s links [-f FILE] -clicks TOKEN [-store FILE]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s links [-f FILE] [-store FILE] [-url URL]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Manage the short links serve answers under %s, for lures delivered by e-mail\n", links.Prefix)
+		fmt.Fprintf(os.Stderr, "or chat. A running server picks up changes at once. Without options, list\n")
+		fmt.Fprintf(os.Stderr, "the links with their clicks.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -add TARGET           Add a link redirecting to TARGET, a path on the server\n")
+		fmt.Fprintf(os.Stderr, "                        such as %s or an http(s) URL.\n", links.DefaultTarget)
+		fmt.Fprintf(os.Stderr, "  -name NAME            Name of the link added, e.g. where it was sent.\n")
+		fmt.Fprintf(os.Stderr, "  -token TOKEN          Token of the link added. Defaults to a random one.\n")
+		fmt.Fprintf(os.Stderr, "  -remove TOKEN         Remove a link; it is no longer served.\n")
+		fmt.Fprintf(os.Stderr, "  -clicks TOKEN         List the clicks on a link.\n")
+		fmt.Fprintf(os.Stderr, "  -url URL              Address of the server, to show the full links, e.g.\n")
+		fmt.Fprintf(os.Stderr, "                        http://192.168.1.10:8888.\n")
+		fmt.Fprintf(os.Stderr, "  -f FILE               Links file. Defaults to %s.\n", dataPath(links.Path))
+		fmt.Fprintf(os.Stderr, "  -store FILE           Event store clicks are read from. Defaults to\n")
+		fmt.Fprintf(os.Stderr, "                        %s.\n", dataPath(store.Path))
+	})
+	fs.StringVar(&path, "f", path, "")
+	fs.StringVar(&path, "file", path, "")
+	fs.StringVar(&storePath, "store", storePath, "")
+	fs.StringVar(&add, "add", add, "")
+	fs.StringVar(&name, "name", name, "")
+	fs.StringVar(&token, "token", token, "")
+	fs.StringVar(&remove, "remove", remove, "")
+	fs.StringVar(&clicks, "clicks", clicks, "")
+	fs.StringVar(&base, "url", base, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %s", positional[0])
+	}
+	if (name != "" || token != "") && add == "" {
+		return errors.New("-name and -token need -add")
+	}
+	base = strings.TrimRight(base, "/")
+
+	list, err := links.Load(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case add != "":
+		if err := links.CheckTarget(add); err != nil {
+			return err
+		}
+		if token == "" {
+			if token, err = links.NewToken(); err != nil {
+				return err
+			}
+		}
+		if !links.ValidToken(token) {
+			return fmt.Errorf("invalid token %q: use letters, digits, _ and -", token)
+		}
+		for _, link := range list {
+			if link.Token == token {
+				return fmt.Errorf("link %s already exists", token)
+			}
+		}
+		link := links.Link{Token: token, Target: add, Name: name, Created: time.Now().UTC()}
+		if err := links.Save(path, append(list, link)); err != nil {
+			return err
+		}
+		fmt.Printf("%s%s%s%s -> %s\n", ssdp.OkBox, base, links.Prefix, token, add)
+		recordAudit(logging.NewConsoleLogger(os.Stderr), audit.ActionLink, map[string]string{
+			"op":     "add",
+			"token":  token,
+			"target": add,
+			"name":   name,
+		})
+	case remove != "":
+		kept := list[:0:0]
+		for _, link := range list {
+			if link.Token != remove {
+				kept = append(kept, link)
+			}
+		}
+		if len(kept) == len(list) {
+			return fmt.Errorf("no link %s", remove)
+		}
+		if err := links.Save(path, kept); err != nil {
+			return err
+		}
+		fmt.Printf("%sRemoved %s%s\n", ssdp.OkBox, links.Prefix, remove)
+		recordAudit(logging.NewConsoleLogger(os.Stderr), audit.ActionLink, map[string]string{
+			"op":    "remove",
+			"token": remove,
+		})
+	case clicks != "":
+		records, err := readClicks(storePath)
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "TIME\tHOST\tUSER AGENT")
+		for _, r := range records[clicks] {
+			fmt.Fprintf(table, "%s\t%s\t%s\n", r.Time.Format(time.RFC3339), r.Host, r.UserAgent)
+		}
+		table.Flush()
+	default:
+		records, err := readClicks(storePath)
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "LINK\tNAME\tTARGET\tCLICKS\tHOSTS")
+		for _, link := range list {
+			hosts := make(map[string]bool)
+			for _, r := range records[link.Token] {
+				hosts[r.Host] = true
+			}
+			fmt.Fprintf(table, "%s%s%s\t%s\t%s\t%d\t%d\n", base, links.Prefix, link.Token, link.Name, link.Target, len(records[link.Token]), len(hosts))
+		}
+		table.Flush()
+	}
+	return nil
+}
+
+// readClicks returns the clicks on each short link in the event store at
+// path, by token, none when there is no store yet
+func readClicks(path string) (map[string][]store.Record, error) {
+	clicks := make(map[string][]store.Record)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return clicks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event store: %w", err)
+	}
+	defer file.Close()
+	records, err := store.Read(file, store.TypeHook, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event store: %w", err)
+	}
+	for _, r := range records {
+		if token, ok := strings.CutPrefix(r.Path, links.Prefix); ok {
+			clicks[token] = append(clicks[token], r)
+		}
+	}
+	return clicks, nil
+}
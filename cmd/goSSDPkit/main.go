@@ -1,21 +1,30 @@
 package main
 
 import (
-	"context"
 	"fmt"
+	"io/fs"
 	"net"
+	"net/url"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"regexp"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
-
+	"time"
+
+	"goSSDPkit/pkg/devicecode"
+	"goSSDPkit/pkg/links"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/pgp"
+	"goSSDPkit/pkg/scope"
+	"goSSDPkit/pkg/sink"
 	"goSSDPkit/pkg/ssdp"
 	"goSSDPkit/pkg/template"
+	"goSSDPkit/pkg/training"
 	"goSSDPkit/pkg/upnp"
+	"goSSDPkit/pkg/xxe"
+	"goSSDPkit/templates"
 )
 
 // Version information - set via ldflags during build
@@ -42,367 +51,835 @@ func getBanner() string {
 		versionInfo += fmt.Sprintf(" (%s)", GitCommit)
 	}
 	versionInfo += "\033[0m\n"
-	
+
 	return bannerTemplate + versionInfo
 }
 
+// printVersion prints the version information set at build time
+func printVersion() {
+	fmt.Printf("goSSDPkit %s\n", Version)
+	if GitCommit != "unknown" {
+		fmt.Printf("Git commit: %s\n", GitCommit)
+	}
+	if BuildTime != "unknown" {
+		fmt.Printf("Built: %s\n", BuildTime)
+	}
+}
+
 // Config holds all application configuration
 type Config struct {
-	Interface   string
-	Port        int
-	Template    string
-	SMBServer   string
-	BasicAuth   bool
-	Realm       string
-	RedirectURL string
-	AnalyzeMode bool
+	Interfaces stringList `yaml:"interface"`
+	IPs        stringList `yaml:"ip"`
+	Port       int        `yaml:"port"`
+	Template   string     `yaml:"template"`
+	SMBServer  string     `yaml:"smb_server"`
+	BasicAuth  bool       `yaml:"basic_auth"`
+	Realm      string     `yaml:"realm"`
+	// Basic auth attempts refused before one is accepted, to harvest more
+	// password guesses
+	AuthRetries int `yaml:"auth_retries"`
+	// Ask for Negotiate and NTLM auth, capturing NetNTLM hashes and
+	// Kerberos tickets
+	Negotiate bool `yaml:"negotiate"`
+	// Service captured passwords are tried against, to tag them valid or
+	// invalid; empty for none
+	Validate    string `yaml:"validate"`
+	RedirectURL string `yaml:"redirect_url"`
+	AnalyzeMode bool   `yaml:"analyze"`
+	// Inject a beacon into phishing pages recording how victims use them
+	Analytics bool `yaml:"analytics"`
+	// Save a screenshot of each page as submitted, drawn by html2canvas
+	// loaded from ScreenshotScript (config file only)
+	Screenshots      bool   `yaml:"screenshots"`
+	ScreenshotScript string `yaml:"screenshot_script"`
+	// Show victims who submit a login form an education page instead of
+	// capturing their password; the built-in page unless EducationPage
+	// names an HTML file
+	Education     bool   `yaml:"education"`
+	EducationPage string `yaml:"education_page"`
+	// Targets of the exercise, whose tokens attribute what they did
+	TargetsFile string `yaml:"targets_file"`
+
+	// Identity provider of templates phishing with device codes; Microsoft
+	// when empty (config file only)
+	DeviceCode devicecode.Config `yaml:"device_code"`
+
+	// Port of the FTP listener for ftp:// XXE exfiltration, 0 for none
+	FTPPort int `yaml:"ftp_port"`
+
+	// Domain delegated to the DNS exfiltration listener, empty for none,
+	// and the port it listens on
+	DNSDomain string `yaml:"dns_domain"`
+	DNSPort   int    `yaml:"dns_port"`
+
+	// File the XXE exfiltration templates read, a path or URL
+	XXEFile string `yaml:"xxe_file"`
+
+	// Bytes of each POST and SOAP body kept for the log and event store, 0
+	// for none
+	BodyLimit int `yaml:"body_limit"`
+
+	// How requests are answered before the built-in handlers, by path,
+	// User-Agent and source address (config file only)
+	Rules []upnp.Rule `yaml:"rules"`
+
+	// Template variables fetched from commands or URLs when phishing pages
+	// are rendered (config file only)
+	Vars []upnp.Var `yaml:"vars"`
+
+	// Directory of custom templates layered over the embedded ones
+	TemplatesDir string `yaml:"templates_dir"`
+
+	// Address put in LOCATION URLs and templates when it differs from the
+	// one the sockets bind to (NAT, containers, redirectors)
+	AdvertiseIP   string `yaml:"advertise_ip"`
+	AdvertisePort int    `yaml:"advertise_port"`
+	// URL advertised in LOCATION instead of the device descriptor, for
+	// redirectors and payloads hosted elsewhere
+	Location string `yaml:"location"`
+
+	// How long, and how many, searching hosts are remembered before they
+	// are reported as new again
+	HostTTL  time.Duration `yaml:"host_ttl"`
+	MaxHosts int           `yaml:"max_hosts"`
+
+	// Prefix of the analyze mode inventory files
+	Inventory string `yaml:"inventory"`
+
+	// Vendor list for labelling inventory hosts, beyond the built-in one
+	OUIFile string `yaml:"oui_file"`
+
+	// Searches per minute above which a host is reported as a likely
+	// scanner, 0 to disable
+	AlertRate int `yaml:"alert_rate"`
+
+	// HTTP requests a minute each host may make before it is answered 429,
+	// 0 for no limit
+	HTTPRate int `yaml:"http_rate"`
+
+	// How long each HTTP request from a likely scanner is held open, 0 to
+	// serve scanners normally
+	Tarpit time.Duration `yaml:"tarpit"`
+
+	// HTTP connections kept open in all and from one host, and requests
+	// handled at once; 0 for no limit
+	MaxConns        int `yaml:"max_conns"`
+	MaxConnsPerHost int `yaml:"max_conns_per_host"`
+	MaxHandlers     int `yaml:"max_handlers"`
+
+	// How long HTTP clients may take to send request headers, a whole
+	// request and to read a response, and how long idle connections are
+	// kept; 0 for no limit
+	HeaderTimeout time.Duration `yaml:"header_timeout"`
+	ReadTimeout   time.Duration `yaml:"read_timeout"`
+	WriteTimeout  time.Duration `yaml:"write_timeout"`
+	IdleTimeout   time.Duration `yaml:"idle_timeout"`
+
+	// Serve TLS on the HTTP port too, fingerprinting each client's
+	// ClientHello with JA3 and JA4, with this certificate and key or one
+	// made up at start
+	TLS     bool   `yaml:"tls"`
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+
+	// URL alerts are posted to as JSON
+	Webhook string `yaml:"webhook"`
+
+	// Elasticsearch or OpenSearch cluster every event is indexed into, with
+	// a user and password in the URL if it needs them. Empty for none.
+	Elastic      string `yaml:"elastic"`
+	ElasticIndex string `yaml:"elastic_index"`
+	// Skip verifying the cluster's certificate (config file only)
+	ElasticInsecure bool `yaml:"elastic_insecure"`
+
+	// MQTT broker every event is published to, under TOPIC/TYPE, with a
+	// user and password in the URL if it needs them. Empty for none.
+	MQTT      string `yaml:"mqtt"`
+	MQTTTopic string `yaml:"mqtt_topic"`
+	// Skip verifying the broker's certificate (config file only)
+	MQTTInsecure bool `yaml:"mqtt_insecure"`
+
+	// Kafka brokers, HOST[:PORT] separated by commas, every event is
+	// produced to, with SASL/PLAIN credentials in KAFKA_USERNAME and
+	// KAFKA_PASSWORD if the cluster needs them. Empty for none.
+	Kafka      string `yaml:"kafka"`
+	KafkaTopic string `yaml:"kafka_topic"`
+	// Connect over TLS, optionally without verifying the brokers'
+	// certificates (config file only)
+	KafkaTLS      bool `yaml:"kafka_tls"`
+	KafkaInsecure bool `yaml:"kafka_insecure"`
+
+	// Directory of hook scripts deciding on searches, rewriting response
+	// headers and transforming captures. Empty for none.
+	Scripts string `yaml:"scripts"`
+
+	// Directory of plugin programs started alongside the server, adding
+	// routes, SSDP response mutators and event consumers. Empty for none.
+	Plugins string `yaml:"plugins"`
+
+	// S3-compatible storage the logs, event store and loot are shipped to,
+	// s3://BUCKET[/PREFIX] or https://ENDPOINT/BUCKET[/PREFIX], signed with
+	// the AWS_* environment variables. Empty for none.
+	Ship         string        `yaml:"ship"`
+	ShipInterval time.Duration `yaml:"ship_interval"`
+	ShipRegion   string        `yaml:"ship_region"`
+	// Server-side encryption asked for: AES256, aws:kms or aws:kms:KEY-ID
+	ShipSSE string `yaml:"ship_sse"`
+
+	// Canary URLs templates embed by name (NAME=URL), and the canarytoken
+	// fired on XXE exfiltration and on credentials from CanarySubnets
+	Canaries      stringList `yaml:"canary"`
+	CanaryToken   string     `yaml:"canary_token"`
+	CanarySubnets stringList `yaml:"canary_subnet"`
+
+	// Test clients answered with malformed responses, and the mutations
+	// they get (all when empty)
+	FuzzClients   stringList `yaml:"fuzz_client"`
+	FuzzMutations stringList `yaml:"fuzz"`
+
+	// Test clients sent malformed device descriptors, and the mutations
+	// they get (all when empty)
+	FuzzXMLClients   stringList `yaml:"fuzz_xml_client"`
+	FuzzXMLMutations stringList `yaml:"fuzz_xml"`
+
+	// Vary the shape and timing of SSDP responses to evade signatures
+	Stealth bool `yaml:"stealth"`
+
+	// Give each SSDP response an ID in its LOCATION and tie the requests
+	// that follow to it
+	Track bool `yaml:"track"`
+
+	// How long control points may cache the device, and whether it is
+	// advertised with NOTIFYs at under half that interval
+	MaxAge    time.Duration `yaml:"max_age"`
+	Advertise bool          `yaml:"advertise"`
+
+	// Weekly windows, in local time, during which searches are answered;
+	// outside them searches are only observed. Always when empty.
+	ActiveHours []string `yaml:"active_hours"`
+
+	// Leave searches that look spoofed or crafted unanswered
+	RefuseSpoofed bool `yaml:"refuse_spoofed"`
+
+	// Further devices served alongside this one (config file only)
+	Instances []Instance `yaml:"instances"`
+
+	// Search targets answered, each as a device type and descriptor of its
+	// own; all are answered as themselves when empty (config file only)
+	Impersonate []ssdp.Impersonation `yaml:"impersonate"`
+
+	// File every HTTP request is written to in the Apache combined log
+	// format, empty for none
+	AccessLog string `yaml:"access_log"`
+
+	// File of the short links served under /l/, kept by the links command
+	// and read again whenever it changes; empty for none
+	LinksFile string `yaml:"links_file"`
+
+	// File of the addresses and ranges in scope; hosts outside it are only
+	// written to the violations log. Empty for no scope enforcement.
+	ScopeFile string `yaml:"scope_file"`
+
+	// Proxies, such as a redirector, whose X-Forwarded-For is believed for
+	// scope and rate limiting; other clients are judged by their own address
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// OpenPGP public key loot is encrypted to, keeping secrets out of the
+	// log and event store. Empty to save loot in plaintext.
+	LootKey string `yaml:"loot_key"`
+
+	// Real device whose descriptor is served, with the chosen fields
+	// pointed back at us so its control traffic passes through
+	Proxy        string     `yaml:"proxy"`
+	ProxyRewrite stringList `yaml:"proxy_rewrite"`
+	// Rules modifying the proxied SOAP calls (config file only)
+	ProxyTamper []upnp.Tamper `yaml:"proxy_tamper"`
+
+	// Only answer upnp:rootdevice and ssdp:all, as the root device
+	RootOnly bool `yaml:"root_only"`
+
+	// SERVER headers handed out to searching hosts, one per host
+	ServerHeaders []string `yaml:"server_header"`
+
+	// Send responses from a separate socket, with this source port (0 for
+	// an ephemeral one), TTL and DSCP
+	ReplySocket bool `yaml:"reply_socket"`
+	ReplyPort   int  `yaml:"reply_port"`
+	ReplyTTL    int  `yaml:"reply_ttl"`
+	ReplyDSCP   int  `yaml:"reply_dscp"`
+
+	// Answer IPv4 searches only, even on interfaces with an IPv6 address
+	IPv4Only bool `yaml:"ipv4_only"`
+
+	// Running in a container: check its networking, serve /healthz and
+	// default to every interface
+	Docker bool `yaml:"docker"`
+
+	// Where the session USN, BOOTID and known hosts are kept across
+	// restarts; nothing is kept when empty
+	StateFile string `yaml:"state_file"`
+
+	// Run in the background, recording the process ID in PIDFile
+	Daemon  bool   `yaml:"daemon"`
+	PIDFile string `yaml:"pid_file"`
+
+	// Unprivileged user, and optionally group, to switch to once every
+	// socket is bound
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+
+	// Templates or pages served instead of Template during some hours, the
+	// first entry covering the time winning (config file only)
+	Schedule []TemplateSchedule `yaml:"schedule"`
+
+	// Command line the configuration came from, parsed again over the
+	// config file on reload
+	args []string
+	// Template configured, served when no schedule entry covers the time
+	baseTemplate string
+	// Page of Template the schedule serves in place of present.html
+	page string
 }
 
 func main() {
-	fmt.Print(getBanner())
-
-	// Parse command line arguments
-	config, err := parseArgs()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+	if err := dispatch(os.Args[1:]); err != nil {
+		if isHelp(err) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	// Initialize logging
-	upnp.InitLogger()
+// getIPFromInterface gets the IP address from a network interface name,
+// index or address
+func getIPFromInterface(interfaceName string) (string, error) {
+	_, ip, err := findInterface(interfaceName)
+	return ip, err
+}
 
-	// Get local IP from interface
-	localIP, err := getIPFromInterface(config.Interface)
-	if err != nil {
-		upnp.Logger.Log("%sCould not get network interface info. Please check and try again.", ssdp.WarnBox)
-		os.Exit(1)
+// findInterface finds an interface by name, index or one of its IPv4
+// addresses, and returns it with the address to use: the one given, or
+// its first
+func findInterface(arg string) (*net.Interface, string, error) {
+	// First try exact match
+	if iface, err := net.InterfaceByName(arg); err == nil {
+		ip, err := getIPFromInterfaceStruct(*iface)
+		return iface, ip, err
+	}
+
+	if index, err := strconv.Atoi(arg); err == nil {
+		iface, err := net.InterfaceByIndex(index)
+		if err != nil {
+			return nil, "", fmt.Errorf("no interface with index %d", index)
+		}
+		ip, err := getIPFromInterfaceStruct(*iface)
+		return iface, ip, err
 	}
 
-	// Set SMB server IP
-	smbServer := setSMBServer(config.SMBServer, localIP)
+	if ip := net.ParseIP(arg); ip != nil {
+		if ip.To4() == nil {
+			return nil, "", fmt.Errorf("%s is not an IPv4 address; give its interface with -ip %s", arg, arg)
+		}
+		iface, err := interfaceByAddress(ip.String())
+		if err != nil {
+			return nil, "", fmt.Errorf("no interface has the address %s", arg)
+		}
+		return iface, ip.String(), nil
+	}
 
-	// Validate template directory
-	templateDir := filepath.Join("templates", config.Template)
-	if err := template.ValidateTemplateDir(templateDir); err != nil {
-		upnp.Logger.Log("Sorry, that template directory does not exist or is invalid.")
-		upnp.Logger.Log("Error: %v", err)
-		upnp.Logger.Log("Please double-check and try again.")
-		os.Exit(1)
+	// On Windows, try to find interface by partial name match
+	if runtime.GOOS == "windows" {
+		interfaces, listErr := net.Interfaces()
+		if listErr != nil {
+			return nil, "", fmt.Errorf("interface '%s' not found and failed to list interfaces: %w", arg, listErr)
+		}
+
+		// Try to find interface with partial name match (case-insensitive)
+		lowerName := strings.ToLower(arg)
+		for i, iface := range interfaces {
+			ifaceLower := strings.ToLower(iface.Name)
+			if strings.Contains(ifaceLower, lowerName) || strings.Contains(lowerName, ifaceLower) {
+				// Found a potential match, try to get IP
+				if ip, ipErr := getIPFromInterfaceStruct(iface); ipErr == nil {
+					fmt.Printf("%sUsing interface: %s (matched '%s')\n", ssdp.NoteBox, iface.Name, arg)
+					return &interfaces[i], ip, nil
+				}
+			}
+		}
+		return nil, "", fmt.Errorf("interface not found: %s (tried exact match and partial matching)", arg)
 	}
+	return nil, "", fmt.Errorf("interface not found: %s", arg)
+}
+
+// isInterfacePattern reports whether an interface argument is a glob
+// pattern such as "eth*" rather than a single interface
+func isInterfacePattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
 
-	// Create SSDP listener
-	listener, err := ssdp.NewListener(localIP, config.Port, config.AnalyzeMode)
+// matchInterfaces returns the up interfaces whose names match a glob
+// pattern, ignoring case as Windows friendly names are hard to type exactly
+func matchInterfaces(pattern string) ([]net.Interface, error) {
+	interfaces, err := net.Interfaces()
 	if err != nil {
-		upnp.Logger.Log("%sError creating SSDP listener: %v", ssdp.WarnBox, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	var matched []net.Interface
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(iface.Name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, iface)
+		}
 	}
+	return matched, nil
+}
 
-	// Create template manager
-	templateData := template.TemplateData{
-		LocalIP:     localIP,
-		LocalPort:   config.Port,
-		SMBServer:   smbServer,
-		SessionUSN:  listener.GetSessionUSN(),
-		RedirectURL: config.RedirectURL,
+// getIPFromInterfaceStruct gets IP from interface struct
+func getIPFromInterfaceStruct(iface net.Interface) (string, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses for interface %s: %w", iface.Name, err)
 	}
-	templateManager := template.NewManager(templateDir, templateData)
 
-	// Create UPnP server
-	upnpConfig := upnp.Config{
-		LocalIP:     localIP,
-		LocalPort:   config.Port,
-		SMBServer:   smbServer,
-		RedirectURL: config.RedirectURL,
-		IsAuth:      config.BasicAuth,
-		Realm:       config.Realm,
-		SessionUSN:  listener.GetSessionUSN(),
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			if ipNet.IP.To4() != nil {
+				return ipNet.IP.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no IPv4 address found for interface %s", iface.Name)
+}
+
+// getIPv6FromInterface returns the IPv6 address to advertise on the named
+// interface, preferring a global or unique local one, or "" if it has none.
+// A link-local address carries the interface as its zone.
+func getIPv6FromInterface(interfaceName string) string {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return ""
 	}
-	server, err := upnp.NewServer(templateManager, upnpConfig)
+	addrs, err := iface.Addrs()
 	if err != nil {
-		upnp.Logger.Log("%sError creating UPnP server: %v", ssdp.WarnBox, err)
-		os.Exit(1)
+		return ""
+	}
+
+	linkLocal := ""
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP.String()
+		}
+		if ipNet.IP.IsLinkLocalUnicast() && linkLocal == "" {
+			linkLocal = ipNet.IP.String() + "%" + iface.Name
+		}
 	}
+	return linkLocal
+}
 
-	// Print configuration details
-	printDetails(config, localIP, smbServer)
+// defaultTemplatesDir is where custom templates are looked for when no
+// templates directory is configured
+const defaultTemplatesDir = "templates"
 
-	// Set up context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	
-	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	if runtime.GOOS == "windows" {
-		signal.Notify(sigChan, os.Interrupt)
-	} else {
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// defaultInventory is where analyze mode writes its inventory
+const defaultInventory = "logs/inventory"
+
+// openTemplates returns the templates filesystem: the stock templates embedded
+// in the binary, overlaid by dir on disk so custom templates can be added or
+// stock ones replaced. Symbolic links in dir may not point outside it. A
+// missing dir is only an error if it was asked for.
+func openTemplates(dir string) (fs.FS, error) {
+	if dir == "" {
+		dir = defaultTemplatesDir
 	}
 
-	// Start SSDP listener in goroutine
-	go func() {
-		if err := listener.Listen(); err != nil {
-			upnp.Logger.Log("%sSSDP listener error: %v", ssdp.WarnBox, err)
-			cancel()
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		if dir != defaultTemplatesDir {
+			return nil, fmt.Errorf("templates directory not found: %s", dir)
 		}
-	}()
+		return templates.FS, nil
+	}
 
-	// Start HTTP server in goroutine
-	go func() {
-		address := fmt.Sprintf("%s:%d", localIP, config.Port)
-		if err := server.Start(address); err != nil {
-			upnp.Logger.Log("%sHTTP server error: %v", ssdp.WarnBox, err)
-			cancel()
-		}
-	}()
+	return template.Overlay(template.DirFS(dir), templates.FS), nil
+}
 
-	// Wait for shutdown signal
-	select {
-	case <-sigChan:
-		upnp.Logger.Log("%sThanks for playing! Stopping threads and exiting...", ssdp.WarnBox)
-	case <-ctx.Done():
-		upnp.Logger.Log("%sShutting down due to error...", ssdp.WarnBox)
+// screenshotScript returns where html2canvas is loaded from for
+// screenshots when that is the template's assets and it is not there, or
+// "" when it is or comes from elsewhere
+func screenshotScript(config *Config) string {
+	src := config.ScreenshotScript
+	if src == "" {
+		src = upnp.DefaultScreenshotScript
 	}
-
-	// Clean up
-	listener.Close()
-	server.Close()
+	assetPath, ok := strings.CutPrefix(src, "/assets/")
+	if !ok {
+		return ""
+	}
+	templatesFS, err := openTemplates(config.TemplatesDir)
+	if err != nil {
+		return ""
+	}
+	assets, err := template.AssetsFS(templatesFS, config.Template)
+	if err != nil {
+		return src
+	}
+	if _, err := fs.Stat(assets, assetPath); err != nil {
+		return src
+	}
+	return ""
 }
 
-// parseArgs parses and validates command line arguments
-func parseArgs() (*Config, error) {
-	var config Config
-	var showVersion bool
-
-	// Manual argument parsing to handle flags after positional arguments
-	args := os.Args[1:]
-	i := 0
-	
-	for i < len(args) {
-		arg := args[i]
-		
-		switch arg {
-		case "-h", "--help":
-			printUsage()
-			os.Exit(0)
-		case "-version", "--version":
-			showVersion = true
-			i++
-		case "-a", "--analyze":
-			config.AnalyzeMode = true
-			i++
-		case "-b", "--basic":
-			config.BasicAuth = true
-			i++
-		case "-p", "--port":
-			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
-				return nil, fmt.Errorf("flag -p requires a value (port number)")
-			}
-			port, err := strconv.Atoi(args[i+1])
+// resolveInterfaces turns interface names, indexes, addresses and glob
+// patterns into SSDP bindings. The name "auto" (or "all") selects every up,
+// non-loopback interface with an IPv4 address.
+func resolveInterfaces(names []string) ([]ssdp.Binding, error) {
+	var bindings []ssdp.Binding
+	seen := make(map[string]bool)
+
+	add := func(name, ip string) {
+		if !seen[ip] {
+			seen[ip] = true
+			bindings = append(bindings, ssdp.Binding{Name: name, LocalIP: ip})
+		}
+	}
+
+	for _, name := range names {
+		if name == "auto" || name == "all" {
+			interfaces, err := net.Interfaces()
 			if err != nil {
-				return nil, fmt.Errorf("invalid port value: %s", args[i+1])
+				return nil, fmt.Errorf("failed to list interfaces: %w", err)
 			}
-			config.Port = port
-			i += 2
-		case "-t", "--template":
-			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
-				return nil, fmt.Errorf("flag -t requires a value (template name)")
-			}
-			config.Template = args[i+1]
-			i += 2
-		case "-s", "--smb":
-			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
-				return nil, fmt.Errorf("flag -s requires a value (SMB server IP)")
-			}
-			config.SMBServer = args[i+1]
-			i += 2
-		case "-r", "--realm":
-			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
-				return nil, fmt.Errorf("flag -r requires a value (realm name)")
+			for _, iface := range interfaces {
+				if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+					continue
+				}
+				if ip, err := getIPFromInterfaceStruct(iface); err == nil {
+					add(iface.Name, ip)
+				}
 			}
-			config.Realm = args[i+1]
-			i += 2
-		case "-u", "--url":
-			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
-				return nil, fmt.Errorf("flag -u requires a value (URL)")
+			continue
+		}
+
+		if isInterfacePattern(name) {
+			matched, err := matchInterfaces(name)
+			if err != nil {
+				return nil, err
 			}
-			config.RedirectURL = args[i+1]
-			i += 2
-		case "-interface":
-			if i+1 >= len(args) {
-				return nil, fmt.Errorf("flag -interface requires a value")
+			found := false
+			for _, iface := range matched {
+				if ip, err := getIPFromInterfaceStruct(iface); err == nil {
+					add(iface.Name, ip)
+					found = true
+				}
 			}
-			config.Interface = args[i+1]
-			i += 2
-		default:
-			// If it doesn't start with -, treat as interface (positional argument)
-			if !strings.HasPrefix(arg, "-") && config.Interface == "" {
-				config.Interface = arg
-				i++
-			} else {
-				return nil, fmt.Errorf("unknown flag: %s", arg)
+			if !found {
+				return nil, fmt.Errorf("no interface with an IPv4 address matches %s", name)
 			}
+			continue
 		}
-	}
-	
-	// Set defaults if not specified
-	if config.Port == 0 {
-		config.Port = 8888
-	}
-	if config.Template == "" {
-		config.Template = "office365"
-	}
-	if config.Realm == "" {
-		config.Realm = "Microsoft Corporation"
-	}
 
-	// Handle version flag
-	if showVersion {
-		fmt.Printf("goSSDPkit %s\n", Version)
-		if GitCommit != "unknown" {
-			fmt.Printf("Git commit: %s\n", GitCommit)
-		}
-		if BuildTime != "unknown" {
-			fmt.Printf("Built: %s\n", BuildTime)
+		iface, ip, err := findInterface(name)
+		if err != nil {
+			return nil, err
 		}
-		os.Exit(0)
+		add(iface.Name, ip)
 	}
 
-	if config.Interface == "" {
-		return nil, fmt.Errorf("interface is required")
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("no interface with an IPv4 address found")
 	}
-
-	// Sanitize interface name (same as Python version)
-	charWhitelist := regexp.MustCompile(`[^a-zA-Z0-9 ._-]`)
-	config.Interface = charWhitelist.ReplaceAllString(config.Interface, "")
-
-	return &config, nil
-}
-
-func printUsage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [-h] [-p PORT] [-t TEMPLATE] [-s SMB] [-b] [-r REALM]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "                    [-u URL] [-a]\n")
-	fmt.Fprintf(os.Stderr, "                    interface\n\n")
-	fmt.Fprintf(os.Stderr, "positional arguments:\n")
-	fmt.Fprintf(os.Stderr, "  interface             Network interface to listen on.\n\n")
-	fmt.Fprintf(os.Stderr, "optional arguments:\n")
-	fmt.Fprintf(os.Stderr, "  -h, --help            show this help message and exit\n")
-	fmt.Fprintf(os.Stderr, "  -p PORT, --port PORT  Port for HTTP server. Defaults to 8888.\n")
-	fmt.Fprintf(os.Stderr, "  -t TEMPLATE, --template TEMPLATE\n")
-	fmt.Fprintf(os.Stderr, "                        Name of a folder in the templates directory. Defaults\n")
-	fmt.Fprintf(os.Stderr, "                        to \"office365\". This will determine xml and phishing\n")
-	fmt.Fprintf(os.Stderr, "                        pages used.\n")
-	fmt.Fprintf(os.Stderr, "  -s SMB, --smb SMB     IP address of your SMB server. Defalts to the primary\n")
-	fmt.Fprintf(os.Stderr, "                        address of the \"interface\" provided.\n")
-	fmt.Fprintf(os.Stderr, "  -b, --basic           Enable base64 authentication for templates and write\n")
-	fmt.Fprintf(os.Stderr, "                        credentials to log file.\n")
-	fmt.Fprintf(os.Stderr, "  -r REALM, --realm REALM\n")
-	fmt.Fprintf(os.Stderr, "                        Realm when prompting target for authentication via\n")
-	fmt.Fprintf(os.Stderr, "                        Basic Auth.\n")
-	fmt.Fprintf(os.Stderr, "  -u URL, --url URL     Redirect to this URL. Works with templates that do a\n")
-	fmt.Fprintf(os.Stderr, "                        POST for logon forms and with templates that include\n")
-	fmt.Fprintf(os.Stderr, "                        the custom redirect JavaScript (see README for more\n")
-	fmt.Fprintf(os.Stderr, "                        info).[example: -r https://google.com]\n")
-	fmt.Fprintf(os.Stderr, "  -a, --analyze         Run in analyze mode. Will NOT respond to any SSDP\n")
-	fmt.Fprintf(os.Stderr, "                        queries, but will still enable and run the web server\n")
-	fmt.Fprintf(os.Stderr, "                        for testing.\n")
+	return bindings, nil
 }
 
-// getIPFromInterface gets the IP address from a network interface name
-func getIPFromInterface(interfaceName string) (string, error) {
-	// First try exact match
-	iface, err := net.InterfaceByName(interfaceName)
-	if err != nil {
-		// On Windows, try to find interface by partial name match
-		if runtime.GOOS == "windows" {
-			interfaces, listErr := net.Interfaces()
-			if listErr != nil {
-				return "", fmt.Errorf("interface '%s' not found and failed to list interfaces: %w", interfaceName, listErr)
+// selectAddresses binds each interface that owns one of ips to that address
+// instead of its first one, for interfaces with aliases. IPv6 addresses
+// replace the one advertised to IPv6 hosts.
+func selectAddresses(bindings []ssdp.Binding, ips []string) error {
+	chosen := make(map[int]bool)
+	for _, ip := range ips {
+		target := net.ParseIP(ip)
+		found := false
+		for i := range bindings {
+			iface, err := interfaceByAddress(bindings[i].LocalIP)
+			if err != nil {
+				return err
 			}
-			
-			// Try to find interface with partial name match (case-insensitive)
-			lowerName := strings.ToLower(interfaceName)
-			for _, iface := range interfaces {
-				ifaceLower := strings.ToLower(iface.Name)
-				if strings.Contains(ifaceLower, lowerName) || strings.Contains(lowerName, ifaceLower) {
-					// Found a potential match, try to get IP
-					if ip, ipErr := getIPFromInterfaceStruct(iface); ipErr == nil {
-						upnp.Logger.Log("%sUsing interface: %s (matched '%s')", ssdp.NoteBox, iface.Name, interfaceName)
-						return ip, nil
-					}
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return fmt.Errorf("failed to get addresses for interface %s: %w", iface.Name, err)
+			}
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if !ok || !ipNet.IP.Equal(target) {
+					continue
 				}
+				switch {
+				case target.To4() != nil && chosen[i]:
+					return fmt.Errorf("more than one -ip given for interface %s", iface.Name)
+				case target.To4() != nil:
+					bindings[i].LocalIP = target.String()
+					chosen[i] = true
+				case target.IsLinkLocalUnicast():
+					bindings[i].LocalIP6 = target.String() + "%" + iface.Name
+				default:
+					bindings[i].LocalIP6 = target.String()
+				}
+				found = true
 			}
-			return "", fmt.Errorf("interface not found: %s (tried exact match and partial matching)", interfaceName)
 		}
-		return "", fmt.Errorf("interface not found: %w", err)
+		if !found {
+			return fmt.Errorf("address %s is not on any of the selected interfaces", ip)
+		}
 	}
-
-	return getIPFromInterfaceStruct(*iface)
+	return nil
 }
 
-// getIPFromInterfaceStruct gets IP from interface struct
-func getIPFromInterfaceStruct(iface net.Interface) (string, error) {
-	addrs, err := iface.Addrs()
+// interfaceByAddress finds the interface that owns ip
+func interfaceByAddress(ip string) (*net.Interface, error) {
+	interfaces, err := net.Interfaces()
 	if err != nil {
-		return "", fmt.Errorf("failed to get addresses for interface %s: %w", iface.Name, err)
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
 	}
-
-	for _, addr := range addrs {
-		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
-			if ipNet.IP.To4() != nil {
-				return ipNet.IP.String(), nil
+	for i := range interfaces {
+		addrs, err := interfaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == ip {
+				return &interfaces[i], nil
 			}
 		}
 	}
-
-	return "", fmt.Errorf("no IPv4 address found for interface %s", iface.Name)
+	return nil, fmt.Errorf("interface not found for IP %s", ip)
 }
 
 // setSMBServer sets the SMB server IP address
-func setSMBServer(smbArg, localIP string) string {
+func setSMBServer(smbArg, localIP string) (string, error) {
 	if smbArg != "" {
 		if net.ParseIP(smbArg) != nil {
-			return smbArg
+			return smbArg, nil
 		}
-		upnp.Logger.Log("%sSorry, that is not a valid IP address for your SMB server.", ssdp.WarnBox)
-		os.Exit(1)
+		return "", fmt.Errorf("sorry, that is not a valid IP address for your SMB server")
+	}
+	return localIP, nil
+}
+
+// advertisedAddress returns the IP and port hosts should be pointed at for
+// binding, honouring the advertise overrides
+func advertisedAddress(config *Config, binding ssdp.Binding) (string, int) {
+	ip, port := binding.LocalIP, config.Port
+	if config.AdvertiseIP != "" {
+		ip = config.AdvertiseIP
+	}
+	if config.AdvertisePort != 0 {
+		port = config.AdvertisePort
 	}
-	return localIP
+	return ip, port
 }
 
-// printDetails prints the configuration banner
-func printDetails(config *Config, localIP, smbServer string) {
-	devURL := fmt.Sprintf("http://%s:%d/ssdp/device-desc.xml", localIP, config.Port)
-	srvURL := fmt.Sprintf("http://%s:%d/ssdp/service-desc.xml", localIP, config.Port)
-	phishURL := fmt.Sprintf("http://%s:%d/ssdp/present.html", localIP, config.Port)
-	exfilURL := fmt.Sprintf("http://%s:%d/ssdp/data.dtd", localIP, config.Port)
+// printDetails prints the configuration banner for one interface
+func printDetails(logger logging.Logger, config *Config, binding ssdp.Binding, smbServer string) {
+	localIP, port := advertisedAddress(config, binding)
+	devURL := fmt.Sprintf("http://%s:%d/ssdp/device-desc.xml", localIP, port)
+	srvURL := fmt.Sprintf("http://%s:%d/ssdp/service-desc.xml", localIP, port)
+	phishURL := fmt.Sprintf("http://%s:%d/ssdp/present.html", localIP, port)
+	exfilURL := fmt.Sprintf("http://%s:%d/ssdp/data.dtd", localIP, port)
 	smbURL := fmt.Sprintf("file://///%s/smb/hash.jpg", smbServer)
-	templateDir := filepath.Join("templates", config.Template)
+	templateDir := config.Template
 
-	upnp.Logger.LogRaw("\n")
-	upnp.Logger.Log("########################################")
-	upnp.Logger.Log("%sEVIL TEMPLATE:           %s", ssdp.OkBox, templateDir)
-	upnp.Logger.Log("%sMSEARCH LISTENER:        %s", ssdp.OkBox, config.Interface)
-	upnp.Logger.Log("%sDEVICE DESCRIPTOR:       %s", ssdp.OkBox, devURL)
-	upnp.Logger.Log("%sSERVICE DESCRIPTOR:      %s", ssdp.OkBox, srvURL)
-	upnp.Logger.Log("%sPHISHING PAGE:           %s", ssdp.OkBox, phishURL)
+	logger.LogRaw("\n")
+	logger.Log("########################################")
+	if campaign != "" {
+		logger.Log("%sCAMPAIGN:                %s, files under %s", ssdp.OkBox, campaign, dataPath("."))
+	}
+	logger.Log("%sEVIL TEMPLATE:           %s", ssdp.OkBox, templateDir)
+	if config.page != "" {
+		logger.Log("%sSCHEDULED PAGE:          %s", ssdp.OkBox, config.page)
+	}
+	for _, entry := range config.Schedule {
+		logger.Log("%sSCHEDULED TEMPLATE:      %s during %s (%s)", ssdp.OkBox, scheduleLabel(config, entry), strings.Join(entry.Hours, ", "), time.Now().Format("MST"))
+	}
+	logger.Log("%sMSEARCH LISTENER:        %s", ssdp.OkBox, binding.Name)
+	if localIP != binding.LocalIP || port != config.Port {
+		logger.Log("%sHTTP BOUND TO:           %s:%d", ssdp.OkBox, binding.LocalIP, config.Port)
+	}
+	logger.Log("%sDEVICE DESCRIPTOR:       %s", ssdp.OkBox, devURL)
+	if binding.LocalIP6 != "" {
+		ip6, _, _ := strings.Cut(binding.LocalIP6, "%")
+		logger.Log("%sIPV6 DESCRIPTOR:         http://[%s]:%d/ssdp/device-desc.xml", ssdp.OkBox, ip6, port)
+	}
+	logger.Log("%sSERVICE DESCRIPTOR:      %s", ssdp.OkBox, srvURL)
+	if config.Location != "" {
+		logger.Log("%sADVERTISED LOCATION:     %s", ssdp.OkBox, config.Location)
+	}
+	if config.Proxy != "" {
+		fields := []string(config.ProxyRewrite)
+		if len(fields) == 0 {
+			fields = upnp.ProxyFields
+		}
+		logger.Log("%sPROXYING:                %s (%s)", ssdp.OkBox, config.Proxy, strings.Join(fields, ", "))
+		if len(config.ProxyTamper) > 0 {
+			logger.Log("%sTAMPER RULES:            %d", ssdp.OkBox, len(config.ProxyTamper))
+		}
+	}
+	for _, impersonation := range config.Impersonate {
+		advertised, location := impersonation.Type, impersonation.Location
+		if advertised == "" {
+			advertised = "itself"
+		}
+		if descPath, ok := impersonation.DescPath(); ok {
+			location = fmt.Sprintf("http://%s:%d%s", localIP, port, descPath)
+			if config.Location != "" && impersonation.Location == "" && impersonation.Template == "" {
+				location = config.Location
+			}
+		}
+		logger.Log("%sIMPERSONATING:           %s as %s at %s", ssdp.OkBox, impersonation.ST, advertised, location)
+	}
+	logger.Log("%sPHISHING PAGE:           %s", ssdp.OkBox, phishURL)
 
 	if config.RedirectURL != "" {
-		upnp.Logger.Log("%sREDIRECT URL:            %s", ssdp.OkBox, config.RedirectURL)
+		logger.Log("%sREDIRECT URL:            %s", ssdp.OkBox, config.RedirectURL)
 	}
 
 	if config.BasicAuth {
-		upnp.Logger.Log("%sAUTH ENABLED, REALM:     %s", ssdp.OkBox, config.Realm)
+		logger.Log("%sAUTH ENABLED, REALM:     %s", ssdp.OkBox, config.Realm)
+		if config.AuthRetries > 0 {
+			logger.Log("%sAUTH RETRIES:            %d refused before accepting", ssdp.OkBox, config.AuthRetries)
+		}
+	}
+
+	if config.Negotiate {
+		logger.Log("%sNEGOTIATE AUTH:          NetNTLM and Kerberos hashes saved under %s", ssdp.OkBox, dataPath(loot.Dir))
+	}
+
+	if config.Analytics {
+		logger.Log("%sANALYTICS BEACON:        %s, see export -type analytics", ssdp.OkBox, upnp.BeaconPath)
+	}
+
+	if config.Screenshots {
+		logger.Log("%sSCREENSHOTS:             saved under %s on submit", ssdp.OkBox, dataPath(loot.Dir))
+		if src := screenshotScript(config); src != "" {
+			logging.Notice(logger, "%sScreenshots need html2canvas at %s, which the template's assets lack", ssdp.WarnBox, src)
+		}
+	}
+
+	if config.Track && !config.AnalyzeMode {
+		logger.Log("%sTRACKING RESPONSES:      LOCATION carries ?%s=ID, recorded as response_id", ssdp.OkBox, ssdp.ResponseIDParam)
+	}
+
+	if config.LinksFile != "" {
+		if list, err := links.Load(config.LinksFile); err == nil && len(list) > 0 {
+			logger.Log("%sSHORT LINKS:             %d under %s, from %s", ssdp.OkBox, len(list), links.Prefix, config.LinksFile)
+		}
+	}
+
+	if config.Education {
+		page := config.EducationPage
+		if page == "" {
+			page = "built-in page"
+		}
+		logger.Log("%sEDUCATION MODE:          submissions shown %s, passwords not kept", ssdp.OkBox, page)
+		if config.TargetsFile != "" {
+			logger.Log("%sTRAINING TARGETS:        %s, tokens in ?%s= links", ssdp.OkBox, config.TargetsFile, training.TokenParam)
+		}
+	}
+
+	if config.Validate != "" {
+		logger.Log("%sVALIDATING CREDS:        %s (real logins, failures count towards lockout)", ssdp.WarnBox, config.Validate)
 	}
 
 	if strings.Contains(templateDir, "xxe-exfil") {
-		upnp.Logger.Log("%sEXFIL PAGE:              %s", ssdp.OkBox, exfilURL)
+		logger.Log("%sEXFIL PAGE:              %s", ssdp.OkBox, exfilURL)
+		logger.Log("%sEXFIL FILE:              %s", ssdp.OkBox, xxe.FileURL(config.XXEFile))
+		if config.FTPPort != 0 {
+			logger.Log("%sFTP EXFIL LISTENER:      ftp://%s:%d/", ssdp.OkBox, localIP, config.FTPPort)
+		} else if strings.Contains(templateDir, "ftp") {
+			logging.Notice(logger, "%sThe %s template exfiltrates over FTP but -ftp-port is not set", ssdp.WarnBox, templateDir)
+		}
+		if config.DNSDomain != "" {
+			logger.Log("%sDNS EXFIL LISTENER:      *.%s on %s:%d/udp", ssdp.OkBox, config.DNSDomain, localIP, config.DNSPort)
+		} else if strings.Contains(templateDir, "dns") {
+			logging.Notice(logger, "%sThe %s template exfiltrates over DNS but -dns-domain is not set", ssdp.WarnBox, templateDir)
+		}
 	} else {
-		upnp.Logger.Log("%sSMB POINTER:             %s", ssdp.OkBox, smbURL)
+		logger.Log("%sSMB POINTER:             %s", ssdp.OkBox, smbURL)
+	}
+
+	if config.TLS {
+		cert := "self-signed certificate"
+		if config.TLSCert != "" {
+			cert = config.TLSCert
+		}
+		logger.Log("%sTLS, JA3/JA4 LOGGED:     https://%s:%d/ssdp/present.html, %s", ssdp.OkBox, localIP, port, cert)
+	}
+
+	if config.LootKey != "" {
+		if key, err := pgp.ReadKey(config.LootKey); err == nil {
+			logger.Log("%sLOOT ENCRYPTED TO:       %s (%s)", ssdp.OkBox, key.UserID(), key.Fingerprint())
+		}
+	}
+
+	if config.Elastic != "" {
+		if u, err := url.Parse(config.Elastic); err == nil {
+			index := config.ElasticIndex
+			if index == "" {
+				index = sink.DefaultIndex
+			}
+			logger.Log("%sELASTICSEARCH:           %s, index %s", ssdp.OkBox, u.Redacted(), index)
+		}
+	}
+
+	if config.MQTT != "" {
+		if u, err := url.Parse(config.MQTT); err == nil {
+			topic := config.MQTTTopic
+			if topic == "" {
+				topic = sink.DefaultTopic
+			}
+			logger.Log("%sMQTT:                    %s, topics %s/#", ssdp.OkBox, u.Redacted(), topic)
+		}
+	}
+
+	if config.Kafka != "" {
+		topic := config.KafkaTopic
+		if topic == "" {
+			topic = sink.DefaultKafkaTopic
+		}
+		logger.Log("%sKAFKA:                   %s, topic %s", ssdp.OkBox, config.Kafka, topic)
+	}
+
+	if config.Ship != "" {
+		encryption := config.ShipSSE
+		if encryption == "" {
+			encryption = "bucket default"
+		}
+		logger.Log("%sSHIPPING TO:             %s every %s (encryption: %s)", ssdp.OkBox, config.Ship, config.ShipInterval, encryption)
+	}
+
+	if config.ScopeFile != "" {
+		logger.Log("%sSCOPE:                   %s, violations logged to %s", ssdp.OkBox, config.ScopeFile, dataPath(scope.LogPath))
+	}
+
+	if len(config.ActiveHours) > 0 && !config.AnalyzeMode {
+		logger.Log("%sACTIVE HOURS:            %s (%s)", ssdp.OkBox, strings.Join(config.ActiveHours, ", "), time.Now().Format("MST"))
 	}
 
 	if config.AnalyzeMode {
-		upnp.Logger.Log("%sANALYZE MODE:            ENABLED", ssdp.WarnBox)
+		logger.Log("%sANALYZE MODE:            ENABLED", ssdp.WarnBox)
 	}
 
-	upnp.Logger.Log("########################################")
-	upnp.Logger.LogRaw("\n")
-}
\ No newline at end of file
+	logger.Log("########################################")
+	logger.LogRaw("\n")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"goSSDPkit/pkg/notify"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// runMonitorCommand implements the monitor subcommand
+func runMonitorCommand(args []string) error {
+	var allowlist, webhookURL string
+	var interval time.Duration
+
+	fs := newFlagSet("monitor", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s monitor [-allow FILE] [-i INTERVAL] [-webhook URL] interface\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Watch the segment for rogue SSDP responders, such as another evil-ssdp.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -allow FILE           Known SSDP devices, one IP address or CIDR range per\n")
+		fmt.Fprintf(os.Stderr, "                        line. Without it, responders present at start are\n")
+		fmt.Fprintf(os.Stderr, "                        trusted and new ones are reported.\n")
+		fmt.Fprintf(os.Stderr, "  -i INTERVAL           How often to search the segment. Defaults to 1m.\n")
+		fmt.Fprintf(os.Stderr, "  -webhook URL          POST alerts to URL as JSON.\n")
+	})
+	fs.StringVar(&allowlist, "allow", "", "")
+	fs.DurationVar(&interval, "i", ssdp.DefaultMonitorInterval, "")
+	fs.DurationVar(&interval, "interval", ssdp.DefaultMonitorInterval, "")
+	fs.StringVar(&webhookURL, "webhook", "", "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("interface is required")
+	}
+	if interval < 5*time.Second {
+		return fmt.Errorf("interval must be at least 5s")
+	}
+
+	localIP, err := getIPFromInterface(positional[0])
+	if err != nil {
+		return err
+	}
+
+	logger := newLogger()
+	defer logger.Close()
+
+	opts := []ssdp.MonitorOption{ssdp.WithMonitorInterval(interval), ssdp.WithMonitorLogger(logger)}
+	if allowlist != "" {
+		allowed, err := ssdp.ReadAllowlist(allowlist)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, ssdp.WithAllowlist(allowed))
+	}
+	if webhookURL != "" {
+		webhook := notify.NewWebhook(webhookURL, notify.WithLogger(logger))
+		defer webhook.Close()
+		opts = append(opts, ssdp.WithMonitorEvents(webhook))
+	}
+
+	monitor, err := ssdp.NewMonitor(localIP, opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return monitor.Run(ctx)
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to username, and to group or else the
+// user's primary group, once every socket is bound. Leaving root this way
+// also clears every Linux capability. The dirs written to later on, such as
+// the log directory, are handed to the user first.
+func dropPrivileges(username, group string, dirs ...string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("user %s has a non-numeric uid %s", username, u.Uid)
+	}
+	gidString := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return fmt.Errorf("failed to look up group %s: %w", group, err)
+		}
+		gidString = g.Gid
+	}
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return fmt.Errorf("group of %s has a non-numeric gid %s", username, gidString)
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to hand %s to %s: %w", dir, username, err)
+		}
+	}
+
+	// Groups first, as they can no longer be changed once the uid is not 0
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to switch to gid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to switch to uid %d: %w", uid, err)
+	}
+	return nil
+}
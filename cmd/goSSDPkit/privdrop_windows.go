@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// dropPrivileges is not available on Windows, where binding port 1900 does
+// not need an administrator in the first place
+func dropPrivileges(username, group string, dirs ...string) error {
+	return fmt.Errorf("-user is not supported on Windows")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	texttemplate "text/template"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/notify"
+	"goSSDPkit/pkg/scope"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+	"goSSDPkit/pkg/upnp"
+	"goSSDPkit/pkg/xxe"
+)
+
+// newSite returns the template manager and server configuration for the
+// HTTP server on binding. Templates link to the advertised address, which
+// is also where victims find the SMB server unless one is given.
+func newSite(config *Config, templatesFS fs.FS, binding ssdp.Binding, sessionUSN string, label bool) (*template.Manager, upnp.Config, error) {
+	advertiseIP, advertisePort := advertisedAddress(config, binding)
+	smbServer, err := setSMBServer(config.SMBServer, advertiseIP)
+	if err != nil {
+		return nil, upnp.Config{}, err
+	}
+
+	canaries, err := parseCanaries(config.Canaries)
+	if err != nil {
+		return nil, upnp.Config{}, err
+	}
+
+	var targets map[string]string
+	if config.TargetsFile != "" {
+		loaded, err := loadTargets(config.TargetsFile)
+		if err != nil {
+			return nil, upnp.Config{}, err
+		}
+		targets = loaded.Names()
+	}
+
+	var educationPage []byte
+	if config.EducationPage != "" {
+		if educationPage, err = os.ReadFile(config.EducationPage); err != nil {
+			return nil, upnp.Config{}, fmt.Errorf("failed to read education page: %w", err)
+		}
+	}
+
+	data := template.TemplateData{
+		LocalIP:     advertiseIP,
+		LocalPort:   advertisePort,
+		SMBServer:   smbServer,
+		SessionUSN:  sessionUSN,
+		RedirectURL: config.RedirectURL,
+		FTPPort:     config.FTPPort,
+		DNSDomain:   config.DNSDomain,
+		XXEFile:     xxe.FileURL(config.XXEFile),
+		Canaries:    canaries,
+	}
+	templateManager := template.NewManager(templatesFS, config.Template, data)
+
+	// Search targets impersonated with another template get its device
+	// descriptor, rendered for this site
+	descriptors := make(map[string]*template.Manager)
+	for _, impersonation := range config.Impersonate {
+		if impersonation.Template == "" {
+			continue
+		}
+		if err := template.ValidateTemplateDir(templatesFS, impersonation.Template); err != nil {
+			return nil, upnp.Config{}, fmt.Errorf("impersonation of %s: %w", impersonation.ST, err)
+		}
+		descriptors[ssdp.TemplateDescPath(impersonation.Template)] = template.NewManager(templatesFS, impersonation.Template, data)
+	}
+	upnpConfig := upnp.Config{
+		LocalIP:          advertiseIP,
+		LocalPort:        advertisePort,
+		SMBServer:        smbServer,
+		RedirectURL:      config.RedirectURL,
+		IsAuth:           config.BasicAuth,
+		Realm:            config.Realm,
+		AuthRetries:      config.AuthRetries,
+		Negotiate:        config.Negotiate,
+		SessionUSN:       sessionUSN,
+		Page:             config.page,
+		Rules:            config.Rules,
+		Vars:             config.Vars,
+		Descriptors:      descriptors,
+		Proxy:            upnp.Proxy{Location: config.Proxy, Rewrite: config.ProxyRewrite, Tamper: config.ProxyTamper},
+		DeviceCode:       config.DeviceCode,
+		Validate:         config.Validate,
+		RateLimit:        config.HTTPRate,
+		Tarpit:           config.Tarpit,
+		Analytics:        config.Analytics,
+		Screenshots:      config.Screenshots,
+		ScreenshotScript: config.ScreenshotScript,
+		Education:        config.Education,
+		EducationPage:    string(educationPage),
+		Targets:          targets,
+	}
+	if label {
+		upnpConfig.Label = binding.Name
+	}
+	return templateManager, upnpConfig, nil
+}
+
+// setWebhook points notifications at url, or at nothing when url is empty,
+// closing the webhook it replaces once its queue is delivered
+func setWebhook(logger logging.Logger, notifications *events.Switch, url string) {
+	var next events.Events
+	if url != "" {
+		next = notify.NewWebhook(url, notify.WithLogger(logger))
+	}
+	if previous, ok := notifications.Set(next).(*notify.Webhook); ok {
+		previous.Close()
+	}
+}
+
+// reloadable returns config with the settings that can change while running
+// taken from next. Everything else needs the sockets bound again.
+func reloadable(config, next *Config) *Config {
+	merged := *config
+	merged.Template = next.Template
+	merged.ScopeFile = next.ScopeFile
+	merged.Schedule = next.Schedule
+	merged.baseTemplate = next.baseTemplate
+	merged.page = next.page
+	merged.TemplatesDir = next.TemplatesDir
+	merged.SMBServer = next.SMBServer
+	merged.BasicAuth = next.BasicAuth
+	merged.Realm = next.Realm
+	merged.AuthRetries = next.AuthRetries
+	merged.Negotiate = next.Negotiate
+	merged.DeviceCode = next.DeviceCode
+	merged.Validate = next.Validate
+	merged.Analytics = next.Analytics
+	merged.Screenshots = next.Screenshots
+	merged.ScreenshotScript = next.ScreenshotScript
+	merged.Education = next.Education
+	merged.EducationPage = next.EducationPage
+	merged.TargetsFile = next.TargetsFile
+	merged.RedirectURL = next.RedirectURL
+	merged.Rules = next.Rules
+	merged.Vars = next.Vars
+	merged.Proxy = next.Proxy
+	merged.ProxyRewrite = next.ProxyRewrite
+	merged.ProxyTamper = next.ProxyTamper
+	merged.XXEFile = next.XXEFile
+	merged.Webhook = next.Webhook
+	merged.Canaries = next.Canaries
+	merged.CanaryToken = next.CanaryToken
+	merged.CanarySubnets = next.CanarySubnets
+
+	// Instances keep their ports and identities, but take their template
+	// and scope from next
+	merged.Instances = append([]Instance(nil), config.Instances...)
+	for i := range merged.Instances {
+		for _, instance := range next.Instances {
+			if instance.Name == merged.Instances[i].Name {
+				merged.Instances[i].Template = instance.Template
+				merged.Instances[i].ScopeFile = instance.ScopeFile
+			}
+		}
+	}
+	return &merged
+}
+
+// setSSDPResponse makes listener answer searches with the template's
+// ssdp-response.tmpl, or with its built-in response when there is none
+func setSSDPResponse(logger logging.Logger, listener *ssdp.Listener, manager *template.Manager) error {
+	tmpl, err := manager.SSDPResponseTemplate()
+	if err != nil {
+		return err
+	}
+	if tmpl == nil {
+		listener.SetResponseTemplate(nil)
+		return nil
+	}
+	listener.SetResponseTemplate(tmpl)
+	logger.Log("%sAnswering searches with %s", ssdp.OkBox, template.SSDPResponseFile)
+	return nil
+}
+
+// setDescriptors tells listener what the descriptors manager builds hash
+// to, so CONFIGID changes when they do
+func setDescriptors(listener *ssdp.Listener, manager *template.Manager) {
+	hash := sha256.New()
+	for _, build := range []func() (string, error){manager.BuildDeviceXML, manager.BuildServiceXML} {
+		xml, _ := build()
+		hash.Write([]byte(xml))
+	}
+	listener.SetDescriptors(hex.EncodeToString(hash.Sum(nil)))
+}
+
+// reloadServe parses the config file and command line again and switches
+// the running devices, instances included, to the new template, scope,
+// authentication, redirect, rule, proxy and canary settings, notification
+// targets and SSDP response template. Session USNs are kept, so hosts that
+// already found a device see the same one. It returns the configuration now
+// in effect; on error nothing has changed.
+func reloadServe(logger logging.Logger, config *Config, devices []*device, violations *scope.Violations, notifications, canary *events.Switch) (*Config, error) {
+	logger.Log("%sReloading configuration...", ssdp.OkBox)
+
+	next, err := parseServeArgs(config.args)
+	if err != nil {
+		// The file is read again as the user the tool dropped to
+		if errors.Is(err, fs.ErrPermission) && config.User != "" {
+			return nil, fmt.Errorf("%w (reloads run as user %s, which must be able to read the config file)", err, config.User)
+		}
+		return nil, err
+	}
+	// The analyze command forces analyze mode on top of its arguments
+	next.AnalyzeMode = next.AnalyzeMode || config.AnalyzeMode
+	merged := reloadable(config, next)
+	if !reflect.DeepEqual(merged, next) {
+		logging.Notice(logger, "%sInterface, port, instance and SSDP changes need a restart; only template, scope, auth, redirect, rule, proxy, webhook and canary settings were reloaded", ssdp.WarnBox)
+	}
+	if err := switchDevices(logger, merged, devices, violations); err != nil {
+		return nil, err
+	}
+
+	if merged.Webhook != config.Webhook {
+		setWebhook(logger, notifications, merged.Webhook)
+	}
+	if merged.CanaryToken != config.CanaryToken || !reflect.DeepEqual(merged.CanarySubnets, config.CanarySubnets) {
+		setCanary(logger, canary, merged.CanaryToken, merged.CanarySubnets)
+	}
+	logger.Log("%sConfiguration reloaded", ssdp.OkBox)
+	return merged, nil
+}
+
+// switchDevices switches devices, the main one and then each instance, to
+// config. Every site, scope and SSDP response is built before any device
+// is switched, so on error nothing has changed.
+func switchDevices(logger logging.Logger, config *Config, devices []*device, violations *scope.Violations) error {
+	templatesFS, err := openTemplates(config.TemplatesDir)
+	if err != nil {
+		return err
+	}
+	if err := validateSchedule(templatesFS, config); err != nil {
+		return err
+	}
+
+	type pending struct {
+		config   *Config
+		scope    *scope.Scope
+		sites    []*upnp.Site
+		smb      []string
+		manager  *template.Manager
+		response *texttemplate.Template
+	}
+	configs := deviceConfigs(config)
+	prepared := make([]pending, len(devices))
+	for i, d := range devices {
+		c := configs[i]
+		name := "the main device"
+		if i > 0 {
+			name = "instance " + config.Instances[i-1].Name
+		}
+		if err := template.ValidateTemplateDir(templatesFS, c.Template); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		p := pending{config: c}
+		if c.ScopeFile != "" {
+			if p.scope, err = scope.Load(c.ScopeFile); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		sessionUSN := d.listener.GetSessionUSN()
+		for j, binding := range d.bindings {
+			manager, upnpConfig, err := newSite(c, templatesFS, binding, sessionUSN, labelled(config, devices[0].bindings))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			site, err := d.servers[j].Prepare(manager, upnpConfig)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			p.sites = append(p.sites, site)
+			p.smb = append(p.smb, upnpConfig.SMBServer)
+			if j == 0 {
+				p.manager = manager
+			}
+		}
+		if p.response, err = p.manager.SSDPResponseTemplate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		prepared[i] = p
+	}
+
+	for i, d := range devices {
+		p := prepared[i]
+		for j, server := range d.servers {
+			server.Switch(p.sites[j])
+			server.SetScope(p.scope)
+			printDetails(logger, p.config, d.bindings[j], p.smb[j])
+		}
+		d.listener.SetScope(p.scope)
+		if p.response == nil {
+			d.listener.SetResponseTemplate(nil)
+		} else {
+			d.listener.SetResponseTemplate(p.response)
+		}
+		setDescriptors(d.listener, p.manager)
+		d.config = p.config
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"goSSDPkit/pkg/replay"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// runReplayCommand implements the replay subcommand
+func runReplayCommand(args []string) error {
+	var target string
+	var speed float64
+	var wait time.Duration
+
+	fs := newFlagSet("replay", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s replay [-target ADDR] [-speed N] [-w WAIT] FILE\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Send the M-SEARCH requests recorded in a pcap, pcapng or JSONL file to a\n")
+		fmt.Fprintf(os.Stderr, "running listener.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -target ADDR          Where to send the searches. Defaults to %s.\n", replay.DefaultTarget)
+		fmt.Fprintf(os.Stderr, "  -speed N              Replay N times faster than recorded, 0 for no delay.\n")
+		fmt.Fprintf(os.Stderr, "                        Defaults to 1.\n")
+		fmt.Fprintf(os.Stderr, "  -w WAIT               How long to wait for answers after the last search.\n")
+		fmt.Fprintf(os.Stderr, "                        Defaults to 2s.\n")
+	})
+	fs.StringVar(&target, "target", replay.DefaultTarget, "")
+	fs.Float64Var(&speed, "speed", 1, "")
+	fs.DurationVar(&wait, "w", 2*time.Second, "")
+	fs.DurationVar(&wait, "wait", 2*time.Second, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("capture file is required")
+	}
+	if speed < 0 {
+		return fmt.Errorf("speed must not be negative")
+	}
+
+	packets, err := replay.ReadFile(positional[0])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", positional[0], err)
+	}
+	fmt.Printf("%sReplaying %d M-SEARCH requests to %s...\n", ssdp.OkBox, len(packets), target)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := replay.Send(ctx, packets, target, replay.WithSpeed(speed), replay.WithWait(wait))
+	fmt.Printf("%s%d sent, %d responses\n", ssdp.OkBox, result.Sent, result.Answered)
+	return err
+}
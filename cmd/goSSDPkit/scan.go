@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// runScanCommand implements the scan subcommand
+func runScanCommand(args []string) error {
+	var searchTarget string
+	var wait time.Duration
+
+	fs := newFlagSet("scan", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s scan [-st TARGET] [-w WAIT] interface\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Send an M-SEARCH out of the interface and list the devices that answer.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -st TARGET            Search target to ask for. Defaults to \"ssdp:all\".\n")
+		fmt.Fprintf(os.Stderr, "  -w WAIT               How long to wait for answers. Defaults to 3s.\n")
+	})
+	fs.StringVar(&searchTarget, "st", "ssdp:all", "")
+	fs.DurationVar(&wait, "w", 3*time.Second, "")
+	fs.DurationVar(&wait, "wait", 3*time.Second, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("interface is required")
+	}
+
+	localIP, err := getIPFromInterface(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%sSending M-SEARCH for %s from %s, waiting %s...\n", ssdp.OkBox, searchTarget, localIP, wait)
+
+	results, err := ssdp.Scan(localIP, searchTarget, wait)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		fmt.Printf("%s%s\n", ssdp.NoteBox, result.Addr)
+		fmt.Printf("    ST:       %s\n", result.ST)
+		fmt.Printf("    USN:      %s\n", result.USN)
+		fmt.Printf("    LOCATION: %s\n", result.Location)
+		fmt.Printf("    SERVER:   %s\n", result.Server)
+	}
+	fmt.Printf("%s%d responses\n", ssdp.OkBox, len(results))
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+)
+
+// templateScheduleCheck is how often the schedule is checked for a change
+// of template
+const templateScheduleCheck = 30 * time.Second
+
+// TemplateSchedule serves another template or page during some weekly
+// hours, such as a maintenance portal after office hours (config file only)
+type TemplateSchedule struct {
+	// Weekly windows in local time, written as for active_hours
+	Hours []string `yaml:"hours"`
+	// Template served during them; defaults to the configured one
+	Template string `yaml:"template"`
+	// File of the template served in place of present.html during them
+	Page string `yaml:"page"`
+}
+
+// checkSchedule validates the template schedule of config and switches
+// config.Template and its page to those scheduled now, remembering the
+// configured template for the hours no entry covers
+func checkSchedule(config *Config) error {
+	config.baseTemplate = config.Template
+	for i, entry := range config.Schedule {
+		if entry.Template == "" && entry.Page == "" {
+			return fmt.Errorf("schedule %d: no template or page given", i+1)
+		}
+		if len(entry.Hours) == 0 {
+			return fmt.Errorf("schedule %d: no hours given", i+1)
+		}
+		if _, err := ssdp.ParseSchedule(entry.Hours); err != nil {
+			return fmt.Errorf("schedule %d: %w", i+1, err)
+		}
+	}
+	config.Template, config.page = scheduled(config, time.Now())
+	return nil
+}
+
+// scheduled returns the template and page config serves at now: those of
+// the first schedule entry covering now, or the configured template and
+// its present.html
+func scheduled(config *Config, now time.Time) (templateDir, page string) {
+	for _, entry := range config.Schedule {
+		if schedule, err := ssdp.ParseSchedule(entry.Hours); err == nil && schedule.Active(now) {
+			if entry.Template == "" {
+				return config.baseTemplate, entry.Page
+			}
+			return entry.Template, entry.Page
+		}
+	}
+	return config.baseTemplate, ""
+}
+
+// validateSchedule checks that every template and page the schedule
+// switches to exists, so a switch at night cannot fail on a typo
+func validateSchedule(templatesFS fs.FS, config *Config) error {
+	for _, entry := range config.Schedule {
+		templateDir := entry.Template
+		if templateDir == "" {
+			templateDir = config.baseTemplate
+		}
+		if err := template.ValidateTemplateDir(templatesFS, templateDir); err != nil {
+			return fmt.Errorf("scheduled template %s: %w", templateDir, err)
+		}
+		if entry.Page == "" {
+			continue
+		}
+		if _, err := fs.Stat(templatesFS, path.Join(templateDir, entry.Page)); err != nil {
+			return fmt.Errorf("scheduled page %s of %s: %w", entry.Page, templateDir, err)
+		}
+	}
+	return nil
+}
+
+// scheduleLabel names what entry switches to, for the banner
+func scheduleLabel(config *Config, entry TemplateSchedule) string {
+	templateDir := entry.Template
+	if templateDir == "" {
+		templateDir = config.baseTemplate
+	}
+	if entry.Page == "" {
+		return templateDir
+	}
+	return templateDir + ", page " + entry.Page
+}
@@ -0,0 +1,1468 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/creds"
+	"goSSDPkit/pkg/detect"
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/fingerprint"
+	"goSSDPkit/pkg/inventory"
+	"goSSDPkit/pkg/links"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/oob"
+	"goSSDPkit/pkg/pgp"
+	"goSSDPkit/pkg/plugin"
+	"goSSDPkit/pkg/scope"
+	"goSSDPkit/pkg/script"
+	"goSSDPkit/pkg/ship"
+	"goSSDPkit/pkg/sink"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+	"goSSDPkit/pkg/systemd"
+	"goSSDPkit/pkg/template"
+	"goSSDPkit/pkg/upnp"
+	"goSSDPkit/pkg/validate"
+	"goSSDPkit/pkg/xxe"
+)
+
+// runServeCommand implements the serve subcommand
+func runServeCommand(args []string) error {
+	config, err := parseServeArgs(args)
+	if err != nil {
+		return err
+	}
+	if config.Daemon && os.Getenv(daemonEnv) == "" {
+		return daemonize(config)
+	}
+	runServe(context.Background(), config)
+	return nil
+}
+
+// runAnalyzeCommand implements the analyze subcommand, which is serve with
+// analyze mode forced on
+func runAnalyzeCommand(args []string) error {
+	config, err := parseServeArgs(args)
+	if err != nil {
+		return err
+	}
+	config.AnalyzeMode = true
+	if config.Daemon && os.Getenv(daemonEnv) == "" {
+		return daemonize(config)
+	}
+	runServe(context.Background(), config)
+	return nil
+}
+
+// parseServeArgs parses and validates the serve/analyze command line
+func parseServeArgs(args []string) (*Config, error) {
+	config := Config{
+		Port:            8888,
+		Template:        "office365",
+		Realm:           "Microsoft Corporation",
+		HostTTL:         ssdp.DefaultHostTTL,
+		MaxHosts:        ssdp.DefaultMaxHosts,
+		Inventory:       defaultInventory,
+		AlertRate:       detect.DefaultRate,
+		BodyLimit:       upnp.DefaultBodyLimit,
+		MaxConns:        upnp.DefaultMaxConns,
+		MaxConnsPerHost: upnp.DefaultMaxConnsPerHost,
+		MaxHandlers:     upnp.DefaultMaxHandlers,
+		HeaderTimeout:   upnp.DefaultTimeouts.ReadHeader,
+		ReadTimeout:     upnp.DefaultTimeouts.Read,
+		WriteTimeout:    upnp.DefaultTimeouts.Write,
+		IdleTimeout:     upnp.DefaultTimeouts.Idle,
+		DNSPort:         53,
+		XXEFile:         xxe.DefaultFile,
+		MaxAge:          ssdp.DefaultMaxAge,
+		AccessLog:       upnp.AccessLogPath,
+		LinksFile:       links.Path,
+		ShipInterval:    ship.DefaultInterval,
+		Scripts:         script.Dir,
+	}
+
+	// Load the config file first so command line flags override its values
+	configPath, err := findConfigFlag(args)
+	if err != nil {
+		return nil, err
+	}
+	if configPath != "" {
+		if err := loadConfigFile(configPath, &config); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(&config); err != nil {
+		return nil, err
+	}
+
+	var showVersion bool
+	fs := newFlagSet("serve", printUsage)
+	fs.String("c", configPath, "")
+	fs.String("config", configPath, "")
+	fs.BoolVar(&showVersion, "version", false, "")
+	fs.BoolVar(&config.AnalyzeMode, "a", config.AnalyzeMode, "")
+	fs.BoolVar(&config.AnalyzeMode, "analyze", config.AnalyzeMode, "")
+	fs.BoolVar(&config.BasicAuth, "b", config.BasicAuth, "")
+	fs.BoolVar(&config.BasicAuth, "basic", config.BasicAuth, "")
+	fs.IntVar(&config.Port, "p", config.Port, "")
+	fs.IntVar(&config.Port, "port", config.Port, "")
+	fs.StringVar(&config.Template, "t", config.Template, "")
+	fs.StringVar(&config.Template, "template", config.Template, "")
+	fs.StringVar(&config.TemplatesDir, "templates-dir", config.TemplatesDir, "")
+	fs.StringVar(&config.SMBServer, "s", config.SMBServer, "")
+	fs.StringVar(&config.SMBServer, "smb", config.SMBServer, "")
+	fs.StringVar(&config.Realm, "r", config.Realm, "")
+	fs.StringVar(&config.Realm, "realm", config.Realm, "")
+	fs.IntVar(&config.AuthRetries, "auth-retries", config.AuthRetries, "")
+	fs.BoolVar(&config.Negotiate, "negotiate", config.Negotiate, "")
+	fs.StringVar(&config.Validate, "validate", config.Validate, "")
+	fs.BoolVar(&config.Analytics, "analytics", config.Analytics, "")
+	fs.BoolVar(&config.Screenshots, "screenshots", config.Screenshots, "")
+	fs.BoolVar(&config.Education, "education", config.Education, "")
+	fs.StringVar(&config.EducationPage, "education-page", config.EducationPage, "")
+	fs.StringVar(&config.TargetsFile, "targets", config.TargetsFile, "")
+	fs.StringVar(&config.RedirectURL, "u", config.RedirectURL, "")
+	fs.StringVar(&config.RedirectURL, "url", config.RedirectURL, "")
+	fs.IntVar(&config.BodyLimit, "body-limit", config.BodyLimit, "")
+	fs.IntVar(&config.FTPPort, "ftp-port", config.FTPPort, "")
+	fs.StringVar(&config.DNSDomain, "dns-domain", config.DNSDomain, "")
+	fs.IntVar(&config.DNSPort, "dns-port", config.DNSPort, "")
+	fs.StringVar(&config.XXEFile, "xxe-file", config.XXEFile, "")
+	var interfaces, ips stringList
+	fs.Var(&interfaces, "interface", "")
+	fs.Var(&ips, "ip", "")
+	fs.StringVar(&config.AdvertiseIP, "advertise-ip", config.AdvertiseIP, "")
+	fs.IntVar(&config.AdvertisePort, "advertise-port", config.AdvertisePort, "")
+	fs.StringVar(&config.Location, "location", config.Location, "")
+	fs.StringVar(&config.AccessLog, "access-log", config.AccessLog, "")
+	fs.StringVar(&config.LinksFile, "links", config.LinksFile, "")
+	fs.StringVar(&config.ScopeFile, "scope", config.ScopeFile, "")
+	var trustedProxies repeatedFlag
+	fs.Var(&trustedProxies, "trusted-proxy", "")
+	fs.StringVar(&config.LootKey, "loot-key", config.LootKey, "")
+	fs.StringVar(&config.Proxy, "proxy", config.Proxy, "")
+	var proxyRewrite stringList
+	fs.Var(&proxyRewrite, "proxy-rewrite", "")
+	fs.DurationVar(&config.HostTTL, "host-ttl", config.HostTTL, "")
+	fs.IntVar(&config.MaxHosts, "max-hosts", config.MaxHosts, "")
+	fs.StringVar(&config.Inventory, "inventory", config.Inventory, "")
+	fs.StringVar(&config.OUIFile, "oui-file", config.OUIFile, "")
+	fs.IntVar(&config.AlertRate, "alert-rate", config.AlertRate, "")
+	fs.IntVar(&config.HTTPRate, "http-rate", config.HTTPRate, "")
+	fs.DurationVar(&config.Tarpit, "tarpit", config.Tarpit, "")
+	fs.IntVar(&config.MaxConns, "max-conns", config.MaxConns, "")
+	fs.IntVar(&config.MaxConnsPerHost, "max-conns-per-host", config.MaxConnsPerHost, "")
+	fs.IntVar(&config.MaxHandlers, "max-handlers", config.MaxHandlers, "")
+	fs.DurationVar(&config.HeaderTimeout, "header-timeout", config.HeaderTimeout, "")
+	fs.DurationVar(&config.ReadTimeout, "read-timeout", config.ReadTimeout, "")
+	fs.DurationVar(&config.WriteTimeout, "write-timeout", config.WriteTimeout, "")
+	fs.DurationVar(&config.IdleTimeout, "idle-timeout", config.IdleTimeout, "")
+	fs.BoolVar(&config.TLS, "tls", config.TLS, "")
+	fs.StringVar(&config.TLSCert, "tls-cert", config.TLSCert, "")
+	fs.StringVar(&config.TLSKey, "tls-key", config.TLSKey, "")
+	fs.BoolVar(&config.Stealth, "stealth", config.Stealth, "")
+	fs.BoolVar(&config.Track, "track", config.Track, "")
+	fs.BoolVar(&config.RefuseSpoofed, "refuse-spoofed", config.RefuseSpoofed, "")
+	var activeHours repeatedFlag
+	fs.Var(&activeHours, "active-hours", "")
+	fs.BoolVar(&config.RootOnly, "root-only", config.RootOnly, "")
+	fs.DurationVar(&config.MaxAge, "max-age", config.MaxAge, "")
+	fs.BoolVar(&config.Advertise, "advertise", config.Advertise, "")
+	fs.BoolVar(&config.IPv4Only, "ipv4-only", config.IPv4Only, "")
+	fs.BoolVar(&config.Docker, "docker", config.Docker, "")
+	fs.BoolVar(&config.Daemon, "daemon", config.Daemon, "")
+	fs.StringVar(&config.PIDFile, "pid-file", config.PIDFile, "")
+	fs.StringVar(&config.StateFile, "state", config.StateFile, "")
+	fs.StringVar(&config.User, "user", config.User, "")
+	fs.StringVar(&config.Group, "group", config.Group, "")
+	var serverHeaders repeatedFlag
+	fs.Var(&serverHeaders, "server-header", "")
+	fs.BoolVar(&config.ReplySocket, "reply-socket", config.ReplySocket, "")
+	fs.IntVar(&config.ReplyPort, "reply-port", config.ReplyPort, "")
+	fs.IntVar(&config.ReplyTTL, "reply-ttl", config.ReplyTTL, "")
+	fs.IntVar(&config.ReplyDSCP, "reply-dscp", config.ReplyDSCP, "")
+	fs.StringVar(&config.Webhook, "webhook", config.Webhook, "")
+	fs.StringVar(&config.Elastic, "elastic", config.Elastic, "")
+	fs.StringVar(&config.ElasticIndex, "elastic-index", config.ElasticIndex, "")
+	fs.StringVar(&config.MQTT, "mqtt", config.MQTT, "")
+	fs.StringVar(&config.MQTTTopic, "mqtt-topic", config.MQTTTopic, "")
+	fs.StringVar(&config.Kafka, "kafka", config.Kafka, "")
+	fs.StringVar(&config.KafkaTopic, "kafka-topic", config.KafkaTopic, "")
+	fs.StringVar(&config.Scripts, "scripts", config.Scripts, "")
+	fs.StringVar(&config.Plugins, "plugins", config.Plugins, "")
+	fs.StringVar(&config.Ship, "ship", config.Ship, "")
+	fs.DurationVar(&config.ShipInterval, "ship-interval", config.ShipInterval, "")
+	fs.StringVar(&config.ShipRegion, "ship-region", config.ShipRegion, "")
+	fs.StringVar(&config.ShipSSE, "ship-sse", config.ShipSSE, "")
+	var canaries, canarySubnets stringList
+	fs.Var(&canaries, "canary", "")
+	fs.StringVar(&config.CanaryToken, "canary-token", config.CanaryToken, "")
+	fs.Var(&canarySubnets, "canary-subnet", "")
+	var fuzzClients, fuzzMutations stringList
+	fs.Var(&fuzzClients, "fuzz-client", "")
+	fs.Var(&fuzzMutations, "fuzz", "")
+	var fuzzXMLClients, fuzzXMLMutations stringList
+	fs.Var(&fuzzXMLClients, "fuzz-xml-client", "")
+	fs.Var(&fuzzXMLMutations, "fuzz-xml", "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle version flag
+	if showVersion {
+		printVersion()
+		os.Exit(0)
+	}
+
+	// Interfaces from the command line, positional or -interface, replace
+	// those from the config file
+	for _, arg := range positional {
+		if err := interfaces.Set(arg); err != nil {
+			return nil, err
+		}
+	}
+	if len(interfaces) > 0 {
+		config.Interfaces = interfaces
+	}
+	if len(ips) > 0 {
+		config.IPs = ips
+	}
+	for _, ip := range config.IPs {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid interface IP: %s", ip)
+		}
+		if parsed.To4() == nil && config.IPv4Only {
+			return nil, fmt.Errorf("IPv6 interface IP %s given with -ipv4-only", ip)
+		}
+	}
+	if len(serverHeaders) > 0 {
+		config.ServerHeaders = serverHeaders
+	}
+	if len(fuzzClients) > 0 {
+		config.FuzzClients = fuzzClients
+	}
+	if len(fuzzMutations) > 0 {
+		config.FuzzMutations = fuzzMutations
+	}
+	if len(fuzzXMLClients) > 0 {
+		config.FuzzXMLClients = fuzzXMLClients
+	}
+	if len(fuzzXMLMutations) > 0 {
+		config.FuzzXMLMutations = fuzzXMLMutations
+	}
+	if len(activeHours) > 0 {
+		config.ActiveHours = activeHours
+	}
+	if len(proxyRewrite) > 0 {
+		config.ProxyRewrite = proxyRewrite
+	}
+	if len(trustedProxies) > 0 {
+		config.TrustedProxies = trustedProxies
+	}
+	if len(canaries) > 0 {
+		config.Canaries = canaries
+	}
+	if len(canarySubnets) > 0 {
+		config.CanarySubnets = canarySubnets
+	}
+	if _, err := parseCanaries(config.Canaries); err != nil {
+		return nil, err
+	}
+	if _, err := parseSubnets(config.CanarySubnets); err != nil {
+		return nil, err
+	}
+	for _, client := range append(config.FuzzClients, config.FuzzXMLClients...) {
+		if net.ParseIP(client) == nil {
+			return nil, fmt.Errorf("invalid fuzz client IP: %s", client)
+		}
+	}
+
+	if config.FTPPort < 0 || config.FTPPort > 65535 || (config.FTPPort != 0 && config.FTPPort == config.Port) {
+		return nil, fmt.Errorf("invalid FTP port value: %d", config.FTPPort)
+	}
+	config.DNSDomain = strings.ToLower(strings.Trim(config.DNSDomain, "."))
+	if config.DNSDomain != "" && (config.DNSPort < 1 || config.DNSPort > 65535) {
+		return nil, fmt.Errorf("invalid DNS port value: %d", config.DNSPort)
+	}
+	if err := xxe.CheckFile(config.XXEFile); err != nil {
+		return nil, err
+	}
+	if config.BodyLimit < 0 {
+		return nil, fmt.Errorf("invalid body limit: %d", config.BodyLimit)
+	}
+	if config.HTTPRate < 0 {
+		return nil, fmt.Errorf("invalid HTTP rate limit: %d", config.HTTPRate)
+	}
+	if config.Tarpit < 0 {
+		return nil, fmt.Errorf("invalid tarpit duration: %s", config.Tarpit)
+	}
+	if config.MaxConns < 0 || config.MaxConnsPerHost < 0 || config.MaxHandlers < 0 {
+		return nil, fmt.Errorf("connection and handler limits cannot be negative")
+	}
+	if config.HeaderTimeout < 0 || config.ReadTimeout < 0 || config.WriteTimeout < 0 || config.IdleTimeout < 0 {
+		return nil, fmt.Errorf("HTTP timeouts cannot be negative")
+	}
+
+	if config.Port <= 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid port value: %d", config.Port)
+	}
+
+	// Any reply socket setting implies a separate reply socket
+	if config.ReplyPort != 0 || config.ReplyTTL != 0 || config.ReplyDSCP != 0 {
+		config.ReplySocket = true
+	}
+	if config.ReplyPort < 0 || config.ReplyPort > 65535 {
+		return nil, fmt.Errorf("invalid reply port value: %d", config.ReplyPort)
+	}
+	if config.ReplyTTL < 0 || config.ReplyTTL > 255 {
+		return nil, fmt.Errorf("invalid reply TTL value: %d", config.ReplyTTL)
+	}
+	if config.ReplyDSCP < 0 || config.ReplyDSCP > 63 {
+		return nil, fmt.Errorf("invalid reply DSCP value: %d", config.ReplyDSCP)
+	}
+
+	if config.MaxAge < 10*time.Second {
+		return nil, fmt.Errorf("max-age must be at least 10s")
+	}
+
+	if config.RootOnly {
+		if len(config.Impersonate) > 0 {
+			return nil, fmt.Errorf("-root-only cannot be combined with impersonate")
+		}
+		config.Impersonate = append([]ssdp.Impersonation(nil), ssdp.RootDevice...)
+	}
+	for _, impersonation := range config.Impersonate {
+		if err := impersonation.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.AdvertiseIP != "" && net.ParseIP(config.AdvertiseIP) == nil {
+		return nil, fmt.Errorf("invalid advertise IP: %s", config.AdvertiseIP)
+	}
+	if config.AdvertisePort < 0 || config.AdvertisePort > 65535 {
+		return nil, fmt.Errorf("invalid advertise port value: %d", config.AdvertisePort)
+	}
+	if config.Location != "" {
+		if err := ssdp.CheckLocation(config.Location); err != nil {
+			return nil, err
+		}
+	}
+	if config.Proxy == "" && len(config.ProxyTamper) > 0 {
+		return nil, fmt.Errorf("proxy_tamper needs -proxy")
+	}
+	if err := (upnp.Proxy{Location: config.Proxy, Rewrite: config.ProxyRewrite, Tamper: config.ProxyTamper}).Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.DeviceCode.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.AuthRetries < 0 {
+		return nil, fmt.Errorf("invalid auth retries value: %d", config.AuthRetries)
+	}
+	if config.Validate != "" {
+		if _, err := validate.New(config.Validate); err != nil {
+			return nil, err
+		}
+	}
+	if config.EducationPage != "" {
+		if !config.Education {
+			return nil, fmt.Errorf("-education-page needs -education")
+		}
+		if _, err := os.ReadFile(config.EducationPage); err != nil {
+			return nil, fmt.Errorf("failed to read education page: %w", err)
+		}
+	}
+	if config.TargetsFile != "" {
+		if !config.Education {
+			return nil, fmt.Errorf("-targets needs -education")
+		}
+		if _, err := loadTargets(config.TargetsFile); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := ssdp.ParseSchedule(config.ActiveHours); err != nil {
+		return nil, err
+	}
+	if config.ScopeFile != "" {
+		if _, err := scope.Load(config.ScopeFile); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := scope.Parse(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("-trusted-proxy: %w", err)
+	}
+	if config.LootKey != "" {
+		if _, err := pgp.ReadKey(config.LootKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTLS(&config); err != nil {
+		return nil, err
+	}
+	if config.Elastic != "" {
+		elastic, err := newElastic(&config, logging.Discard)
+		if err != nil {
+			return nil, err
+		}
+		elastic.Close()
+	}
+	if config.MQTT != "" {
+		mqtt, err := newMQTT(&config, logging.Discard)
+		if err != nil {
+			return nil, err
+		}
+		mqtt.Close()
+	}
+	if config.Kafka != "" {
+		kafka, err := newKafka(&config, logging.Discard)
+		if err != nil {
+			return nil, err
+		}
+		kafka.Close()
+	}
+	if config.Ship != "" {
+		if config.ShipInterval <= 0 {
+			return nil, fmt.Errorf("-ship-interval must be positive")
+		}
+		if _, err := newShipper(&config, logging.Discard); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := script.Load(config.Scripts, logging.Discard); err != nil {
+		return nil, err
+	}
+
+	if config.Group != "" && config.User == "" {
+		return nil, fmt.Errorf("-group needs -user")
+	}
+
+	if len(config.Interfaces) == 0 && config.Docker {
+		config.Interfaces = stringList{"auto"}
+	}
+	if len(config.Interfaces) == 0 {
+		return nil, fmt.Errorf("interface is required")
+	}
+
+	// Sanitize interface names (same as Python version, plus glob and
+	// IPv6 characters), dropping the parent that "ip link" shows after VLAN
+	// interfaces, as in eth0.20@eth0
+	charWhitelist := regexp.MustCompile(`[^a-zA-Z0-9 ._:*?\[\]^-]`)
+	for i, name := range config.Interfaces {
+		name, _, _ = strings.Cut(name, "@")
+		config.Interfaces[i] = charWhitelist.ReplaceAllString(name, "")
+	}
+
+	// A campaign keeps its files, and its session USN, to itself unless
+	// they were put elsewhere on purpose
+	if campaign != "" {
+		if config.AccessLog == upnp.AccessLogPath {
+			config.AccessLog = dataPath(config.AccessLog)
+		}
+		if config.LinksFile == links.Path {
+			config.LinksFile = dataPath(config.LinksFile)
+		}
+		if config.Inventory == defaultInventory {
+			config.Inventory = dataPath(config.Inventory)
+		}
+		if config.StateFile == "" {
+			config.StateFile = dataPath(defaultCampaignState)
+		}
+	}
+	if err := checkInstances(&config); err != nil {
+		return nil, err
+	}
+	if err := checkSchedule(&config); err != nil {
+		return nil, err
+	}
+
+	config.args = args
+	return &config, nil
+}
+
+// runServe starts the SSDP listener and one HTTP server per interface, and
+// blocks until parent is cancelled, a shutdown signal or a fatal error
+func runServe(parent context.Context, config *Config) {
+	// Initialize logging
+	logger := newLogger()
+	defer logger.Close()
+
+	if os.Getenv(daemonEnv) != "" {
+		remove, err := writePIDFile(config.PIDFile)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		} else {
+			defer remove()
+		}
+	}
+
+	// Get local IPs from the interfaces
+	bindings, err := resolveInterfaces(config.Interfaces)
+	if err != nil {
+		logging.Notice(logger, "%sCould not get network interface info. Please check and try again.", ssdp.WarnBox)
+		logger.Log("Error: %v", err)
+		os.Exit(1)
+	}
+
+	// Validate template directory
+	templatesFS, err := openTemplates(config.TemplatesDir)
+	if err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+	if err := template.ValidateTemplateDir(templatesFS, config.Template); err != nil {
+		logger.Log("Sorry, that template directory does not exist or is invalid.")
+		logger.Log("Error: %v", err)
+		logger.Log("Please double-check and try again.")
+		os.Exit(1)
+	}
+	for _, instance := range config.Instances {
+		if instance.Template == "" {
+			continue
+		}
+		if err := template.ValidateTemplateDir(templatesFS, instance.Template); err != nil {
+			logging.Notice(logger, "%sInstance %s: invalid template: %v", ssdp.WarnBox, instance.Name, err)
+			os.Exit(1)
+		}
+	}
+	if err := validateSchedule(templatesFS, config); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+
+	// Point LOCATION at the advertised address if it differs from the bound
+	// one, and answer over IPv6 too where the interface has an address
+	for i := range bindings {
+		bindings[i].AdvertiseIP = config.AdvertiseIP
+		bindings[i].AdvertisePort = config.AdvertisePort
+		if !config.IPv4Only {
+			bindings[i].LocalIP6 = getIPv6FromInterface(bindings[i].Name)
+		}
+	}
+	if err := selectAddresses(bindings, config.IPs); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+
+	// Analyze mode keeps an inventory of everything it hears
+	listenerOpts := []ssdp.Option{
+		ssdp.WithAnalyzeMode(config.AnalyzeMode), ssdp.WithLogger(logger),
+		ssdp.WithHostTTL(config.HostTTL), ssdp.WithMaxHosts(config.MaxHosts),
+		ssdp.WithStealth(config.Stealth), ssdp.WithServerHeaders(config.ServerHeaders...),
+		ssdp.WithRefuseSpoofed(config.RefuseSpoofed), ssdp.WithImpersonations(config.Impersonate...),
+		ssdp.WithMaxAge(config.MaxAge), ssdp.WithAdvertise(config.Advertise),
+		ssdp.WithLocation(config.Location), ssdp.WithTracking(config.Track),
+	}
+	if len(config.ActiveHours) > 0 {
+		schedule, _ := ssdp.ParseSchedule(config.ActiveHours)
+		listenerOpts = append(listenerOpts, ssdp.WithSchedule(schedule))
+	}
+	if config.ReplySocket {
+		listenerOpts = append(listenerOpts, ssdp.WithReplySocket(ssdp.ReplySocket{
+			Port: config.ReplyPort,
+			TTL:  config.ReplyTTL,
+			DSCP: config.ReplyDSCP,
+		}))
+	}
+	if len(config.FuzzClients) > 0 {
+		listenerOpts = append(listenerOpts, ssdp.WithFuzz(config.FuzzClients, config.FuzzMutations...))
+	}
+	serverOpts := []upnp.Option{upnp.WithLogger(logger), upnp.WithBodyLimit(config.BodyLimit), upnp.WithTracking(config.Track)}
+	serverOpts = append(serverOpts,
+		upnp.WithMaxConns(config.MaxConns),
+		upnp.WithMaxConnsPerHost(config.MaxConnsPerHost),
+		upnp.WithMaxHandlers(config.MaxHandlers),
+		upnp.WithTimeouts(upnp.Timeouts{
+			ReadHeader: config.HeaderTimeout,
+			Read:       config.ReadTimeout,
+			Write:      config.WriteTimeout,
+			Idle:       config.IdleTimeout,
+		}))
+	tlsConfig, err := serverTLS(config, bindings)
+	if err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, upnp.WithTLS(tlsConfig))
+	}
+	if config.Docker {
+		checkContainer(logger, config, bindings)
+		serverOpts = append(serverOpts, upnp.WithHealthCheck(healthPath))
+	}
+	if len(config.FuzzXMLClients) > 0 {
+		serverOpts = append(serverOpts, upnp.WithDescriptorFuzz(config.FuzzXMLClients, config.FuzzXMLMutations...))
+	}
+
+	// One detector sees both the searches and the HTTP requests, so each
+	// alert is raised once per host, and one tracker sees the credentials
+	// captured on every interface
+	detector := detect.New(detect.WithRate(config.AlertRate, time.Minute))
+	listenerOpts = append(listenerOpts, ssdp.WithDetector(detector))
+	serverOpts = append(serverOpts, upnp.WithDetector(detector))
+	credentials := creds.NewTracker()
+	serverOpts = append(serverOpts, upnp.WithCredentialTracker(credentials))
+	// Notification targets sit behind a switch so a reload can change them
+	notifications := &events.Switch{}
+	setWebhook(logger, notifications, config.Webhook)
+	defer setWebhook(logger, notifications, "")
+	canary := &events.Switch{}
+	setCanary(logger, canary, config.CanaryToken, config.CanarySubnets)
+	defer setCanary(logger, canary, "", nil)
+	listenerOpts = append(listenerOpts, ssdp.WithEvents(notifications), ssdp.WithEvents(canary))
+	serverOpts = append(serverOpts, upnp.WithEvents(notifications), upnp.WithEvents(canary))
+
+	// With a loot key, secrets are only written encrypted, to the loot
+	var lootKey *pgp.Key
+	if config.LootKey != "" {
+		if lootKey, err = pgp.ReadKey(config.LootKey); err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		serverOpts = append(serverOpts, upnp.WithRedaction())
+	}
+
+	// Every event is stored for the export command, and sent to any
+	// outputs set
+	eventStore, err := store.Open(dataPath(store.Path))
+	if err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := eventStore.Close(); err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		}
+	}()
+	outputs := []events.Events{eventStore}
+	if config.Elastic != "" {
+		elastic, err := newElastic(config, logger)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		defer elastic.Close()
+		if err := elastic.InstallTemplate(); err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		}
+		outputs = append(outputs, elastic)
+	}
+	if config.MQTT != "" {
+		mqtt, err := newMQTT(config, logger)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		defer mqtt.Close()
+		if err := mqtt.Connect(); err != nil {
+			logging.Notice(logger, "%sMQTT: %v, retrying with the first events", ssdp.WarnBox, err)
+		}
+		outputs = append(outputs, mqtt)
+	}
+	if config.Kafka != "" {
+		kafka, err := newKafka(config, logger)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		defer kafka.Close()
+		if err := kafka.Connect(); err != nil {
+			logging.Notice(logger, "%sKafka: %v, retrying with the first events", ssdp.WarnBox, err)
+		}
+		outputs = append(outputs, kafka)
+	}
+
+	// Scripts decide which searches are answered, rewrite response headers
+	// and transform captures on their way to the outputs
+	scripts, err := script.Load(config.Scripts, logger)
+	if err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+	if scripts != nil {
+		if hook := scripts.MSearchHook(); hook != nil {
+			listenerOpts = append(listenerOpts, ssdp.WithResponseMutator(hook))
+		}
+		if hook := scripts.ResponseHook(); hook != nil {
+			serverOpts = append(serverOpts, upnp.WithResponseHook(hook))
+		}
+		logger.Log("%sScripts loaded from %s: %s", ssdp.OkBox, config.Scripts, strings.Join(scripts.Files(), ", "))
+	}
+
+	// Plugins add routes, rewrite responses and take events, redacted
+	// like the outputs
+	if config.Plugins != "" {
+		plugins, err := plugin.Load(config.Plugins, logger)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		for _, p := range plugins {
+			defer p.Close()
+			for _, route := range p.Routes {
+				serverOpts = append(serverOpts, upnp.WithHandler(route, p))
+			}
+			if p.Mutator {
+				listenerOpts = append(listenerOpts, ssdp.WithResponseMutator(p.MutateResponse))
+			}
+			if p.Events {
+				outputs = append(outputs, p.Subscriber())
+			}
+			logger.Log("%sPlugin %s started: %s", ssdp.OkBox, p.Name, describePlugin(p))
+		}
+	}
+	stored := events.Multi(outputs...)
+	if lootKey != nil {
+		stored = events.Redact(stored)
+	}
+	stored = scripts.Credentials(stored)
+	listenerOpts = append(listenerOpts, ssdp.WithEvents(stored))
+	serverOpts = append(serverOpts, upnp.WithEvents(stored))
+
+	// Hosts outside the engagement scope are neither answered nor phished,
+	// only written to the violations log. Without a scope the log is only
+	// created if a reload sets one.
+	violations := scope.NewViolations(dataPath(scope.LogPath))
+	if config.ScopeFile != "" || instancesScoped(config) {
+		violations, err = scope.OpenViolations(dataPath(scope.LogPath))
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+	}
+	defer violations.Close()
+	var inScope *scope.Scope
+	if config.ScopeFile != "" {
+		inScope, err = scope.Load(config.ScopeFile)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+	}
+	listenerOpts = append(listenerOpts, ssdp.WithScope(inScope, violations))
+	serverOpts = append(serverOpts, upnp.WithScope(inScope, violations))
+	if len(config.TrustedProxies) > 0 {
+		proxies, _ := scope.Parse(config.TrustedProxies)
+		serverOpts = append(serverOpts, upnp.WithTrustedProxies(proxies))
+	}
+
+	// Every request also goes to the access log, for web log tooling
+	if config.AccessLog != "" {
+		accessLog, err := upnp.OpenAccessLog(config.AccessLog)
+		if err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		defer accessLog.Close()
+		serverOpts = append(serverOpts, upnp.WithAccessLog(accessLog))
+	}
+
+	// Short links are managed with the links command while serving
+	if config.LinksFile != "" {
+		serverOpts = append(serverOpts, upnp.WithLinks(links.NewTable(config.LinksFile)))
+	}
+
+	// Exfiltrated data is saved whole, as the log only previews it
+	lootSaver := loot.NewSaver(dataPath(loot.Dir), lootKey, logger)
+	serverOpts = append(serverOpts, upnp.WithEvents(lootSaver))
+
+	var inv *inventory.Inventory
+	if config.AnalyzeMode {
+		fp := fingerprint.New()
+		if config.OUIFile != "" {
+			if err := fp.LoadOUIFile(config.OUIFile); err != nil {
+				logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			}
+		}
+		inv = inventory.New(fp)
+		listenerOpts = append(listenerOpts, ssdp.WithEvents(inv))
+		serverOpts = append(serverOpts, upnp.WithEvents(inv))
+	}
+
+	// The main device, then the instances sharing the process, each with
+	// HTTP servers of its own. The instances answer the searches reaching
+	// the main device's SSDP sockets, as only one socket would get unicast
+	// searches if each opened its own.
+	primary, err := newDevice(logger, config, templatesFS, bindings, listenerOpts, serverOpts, labelled(config, bindings))
+	if err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		os.Exit(1)
+	}
+	devices := []*device{primary}
+	for _, instance := range config.Instances {
+		// Each instance is a device of its own, so alerts count the
+		// searches and requests it sees rather than every device's
+		opts := append(listenerOpts[:len(listenerOpts):len(listenerOpts)], ssdp.WithShared(primary.listener),
+			ssdp.WithDetector(detect.New(detect.WithRate(config.AlertRate, time.Minute))))
+		sOpts := append(serverOpts[:len(serverOpts):len(serverOpts)], upnp.WithDetector(detect.New(detect.WithRate(config.AlertRate, time.Minute))))
+		if instance.ScopeFile != "" {
+			inScope, err := scope.Load(instance.ScopeFile)
+			if err != nil {
+				logging.Notice(logger, "%sInstance %s: %v", ssdp.WarnBox, instance.Name, err)
+				os.Exit(1)
+			}
+			opts = append(opts, ssdp.WithScope(inScope, violations))
+			sOpts = append(sOpts, upnp.WithScope(inScope, violations))
+		}
+		d, err := newDevice(logger, instanceConfig(config, instance), templatesFS, instanceBindings(bindings, instance.Name), opts, sOpts, true)
+		if err != nil {
+			logging.Notice(logger, "%sInstance %s: %v", ssdp.WarnBox, instance.Name, err)
+			os.Exit(1)
+		}
+		d.name = instance.Name
+		devices = append(devices, d)
+	}
+	bindings = primary.bindings
+	if len(devices) > 1 {
+		logDevices(logger, devices)
+	}
+
+	// The FTP exfiltration listeners take the server's events, so they are
+	// logged, stored and notified like the HTTP ones
+	var ftpServers []*oob.FTPServer
+	var ftpListeners []net.Listener
+	if config.FTPPort != 0 {
+		for _, binding := range bindings {
+			ln, err := net.Listen("tcp", net.JoinHostPort(binding.LocalIP, strconv.Itoa(config.FTPPort)))
+			if err != nil {
+				logging.Notice(logger, "%sFTP server error: %v", ssdp.WarnBox, err)
+				os.Exit(1)
+			}
+			ftpOpts := []oob.FTPOption{oob.WithFTPLogger(logger), oob.WithFTPEvents(notifications),
+				oob.WithFTPEvents(canary), oob.WithFTPEvents(stored),
+				oob.WithFTPEvents(lootSaver)}
+			if len(bindings) > 1 {
+				ftpOpts = append(ftpOpts, oob.WithFTPLabel(binding.Name))
+			}
+			if inv != nil {
+				ftpOpts = append(ftpOpts, oob.WithFTPEvents(inv))
+			}
+			if lootKey != nil {
+				ftpOpts = append(ftpOpts, oob.WithFTPRedaction())
+			}
+			ftpServers = append(ftpServers, oob.NewFTPServer(ftpOpts...))
+			ftpListeners = append(ftpListeners, ln)
+		}
+	}
+
+	var dnsServers []*oob.DNSServer
+	var dnsConns []net.PacketConn
+	if config.DNSDomain != "" {
+		for _, binding := range bindings {
+			answer := binding.AdvertiseIP
+			if answer == "" {
+				answer = binding.LocalIP
+			}
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(binding.LocalIP, strconv.Itoa(config.DNSPort)))
+			if err != nil {
+				logging.Notice(logger, "%sDNS server error: %v", ssdp.WarnBox, err)
+				os.Exit(1)
+			}
+			dnsOpts := []oob.DNSOption{oob.WithDNSLogger(logger), oob.WithDNSEvents(notifications),
+				oob.WithDNSEvents(canary), oob.WithDNSEvents(stored), oob.WithDNSEvents(lootSaver),
+				oob.WithDNSAnswer(net.ParseIP(answer))}
+			if len(bindings) > 1 {
+				dnsOpts = append(dnsOpts, oob.WithDNSLabel(binding.Name))
+			}
+			if inv != nil {
+				dnsOpts = append(dnsOpts, oob.WithDNSEvents(inv))
+			}
+			if lootKey != nil {
+				dnsOpts = append(dnsOpts, oob.WithDNSRedaction())
+			}
+			dnsServers = append(dnsServers, oob.NewDNSServer(config.DNSDomain, dnsOpts...))
+			dnsConns = append(dnsConns, conn)
+		}
+	}
+
+	// Every socket is open, so root is no longer needed. The log directory
+	// and anything else written later must stay writable.
+	if config.User != "" {
+		dirs := []string{filepath.Dir(dataPath(upnp.LogPath)), dataPath(loot.Dir)}
+		if inv != nil {
+			dirs = append(dirs, filepath.Dir(config.Inventory))
+		}
+		if os.Getenv(daemonEnv) != "" {
+			dirs = append(dirs, filepath.Dir(pidFilePath(config.PIDFile)))
+		}
+		for _, d := range devices {
+			if d.config.StateFile != "" {
+				dirs = append(dirs, filepath.Dir(d.config.StateFile))
+			}
+		}
+		if err := dropPrivileges(config.User, config.Group, dirs...); err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		logger.Log("%sRunning as user %s", ssdp.OkBox, config.User)
+
+		// Reloads read the config file again, now as this user
+		if path, _ := findConfigFlag(config.args); path != "" {
+			if _, err := os.ReadFile(path); err != nil {
+				logging.Notice(logger, "%sReloads will fail: user %s cannot read %s: %v", ssdp.WarnBox, config.User, path, err)
+			}
+		}
+	}
+
+	// Set up context for graceful shutdown, cancelled by the caller, a
+	// signal or the first component to fail
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	// Set up signal handling
+	sigChan := make(chan os.Signal, 1)
+	if runtime.GOOS == "windows" {
+		signal.Notify(sigChan, os.Interrupt)
+	} else {
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	}
+	defer signal.Stop(sigChan)
+
+	var wg sync.WaitGroup
+	failed := make(chan struct{}, 1)
+	fail := func(format string, args ...interface{}) {
+		logging.Notice(logger, format, args...)
+		select {
+		case failed <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, d := range devices {
+		// Start SSDP listener in goroutine
+		wg.Add(1)
+		go func(listener *ssdp.Listener) {
+			defer wg.Done()
+			if err := listener.Listen(ctx); err != nil {
+				fail("%sSSDP listener error: %v", ssdp.WarnBox, err)
+			}
+		}(d.listener)
+
+		// Start HTTP servers in goroutines
+		for i, server := range d.servers {
+			for _, ln := range d.httpListeners[i] {
+				wg.Add(1)
+				go func(server *upnp.Server, ln net.Listener) {
+					defer wg.Done()
+					if err := server.Serve(ctx, ln); err != nil {
+						fail("%sHTTP server error: %v", ssdp.WarnBox, err)
+					}
+				}(server, ln)
+			}
+		}
+	}
+
+	for i, ftpServer := range ftpServers {
+		wg.Add(1)
+		go func(ftpServer *oob.FTPServer, ln net.Listener) {
+			defer wg.Done()
+			if err := ftpServer.Serve(ctx, ln); err != nil {
+				fail("%sFTP server error: %v", ssdp.WarnBox, err)
+			}
+		}(ftpServer, ftpListeners[i])
+	}
+
+	for i, dnsServer := range dnsServers {
+		wg.Add(1)
+		go func(dnsServer *oob.DNSServer, conn net.PacketConn) {
+			defer wg.Done()
+			if err := dnsServer.Serve(ctx, conn); err != nil {
+				fail("%sDNS server error: %v", ssdp.WarnBox, err)
+			}
+		}(dnsServer, dnsConns[i])
+	}
+
+	// Logs and loot go to object storage while we run, and once more at the
+	// end for the lines written while stopping
+	var shipper *ship.Shipper
+	if config.Ship != "" {
+		if shipper, err = newShipper(config, logger); err != nil {
+			logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+			os.Exit(1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shipper.Run(ctx, config.ShipInterval)
+		}()
+	}
+
+	// Every socket is bound, so a Type=notify systemd unit can be marked
+	// started, and its watchdog fed while we run
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+	}
+	auditStart(logger, config, bindings)
+	if interval, err := systemd.WatchdogInterval(); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+	} else if interval > 0 {
+		go feedWatchdog(ctx, interval/2)
+	}
+
+	// Write the inventory on demand while running
+	inventoryChan := make(chan os.Signal, 1)
+	if inv != nil && len(inventorySignals) > 0 {
+		signal.Notify(inventoryChan, inventorySignals...)
+		defer signal.Stop(inventoryChan)
+	}
+
+	// Re-read the configuration on demand while running
+	reloadChan := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reloadChan, reloadSignals...)
+		defer signal.Stop(reloadChan)
+	}
+
+	// Log the devices table on demand while running
+	statusChan := make(chan os.Signal, 1)
+	if len(statusSignals) > 0 {
+		signal.Notify(statusChan, statusSignals...)
+		defer signal.Stop(statusChan)
+	}
+
+	reload := func() {
+		systemd.Notify(systemd.Reloading)
+		reloaded, err := reloadServe(logger, config, devices, violations, notifications, canary)
+		auditReload(logger, config, reloaded, err)
+		if err != nil {
+			logging.Notice(logger, "%sReload failed, keeping the running configuration: %v", ssdp.WarnBox, err)
+		} else {
+			config = reloaded
+			saveState(logger, primary.listener, config.StateFile)
+		}
+		systemd.Notify(systemd.Ready)
+	}
+
+	// Switch the template and page of every device as the schedule says,
+	// keeping the rest of the running configuration
+	scheduleTicker := time.NewTicker(templateScheduleCheck)
+	defer scheduleTicker.Stop()
+
+	// Wait for shutdown signal
+	stopReason := ""
+	for stopReason == "" {
+		select {
+		case <-sigChan:
+			logging.Notice(logger, "%sThanks for playing! Stopping threads and exiting...", ssdp.WarnBox)
+			stopReason = "signal"
+		case <-parent.Done():
+			logging.Notice(logger, "%sService stopping...", ssdp.WarnBox)
+			stopReason = "service"
+		case <-failed:
+			logging.Notice(logger, "%sShutting down due to error...", ssdp.WarnBox)
+			stopReason = "error"
+		case <-inventoryChan:
+			saveInventory(logger, inv, config.Inventory)
+		case <-reloadChan:
+			reload()
+		case <-statusChan:
+			logDevices(logger, devices)
+		case now := <-scheduleTicker.C:
+			templateDir, page := scheduled(config, now)
+			if templateDir == config.Template && page == config.page {
+				continue
+			}
+			next := *config
+			next.Template, next.page = templateDir, page
+			if err := switchDevices(logger, &next, devices, violations); err != nil {
+				logging.Notice(logger, "%sSchedule switch failed, retrying: %v", ssdp.WarnBox, err)
+				continue
+			}
+			if page != "" {
+				logging.Notice(logger, "%sSchedule switches to template %s, page %s", ssdp.NoteBox, templateDir, page)
+			} else {
+				logging.Notice(logger, "%sSchedule switches to template %s", ssdp.NoteBox, templateDir)
+			}
+			auditReload(logger, config, &next, nil)
+			config = &next
+		}
+	}
+
+	// Stop everything and let in-flight requests drain before the deferred
+	// logger close
+	systemd.Notify(systemd.Stopping)
+	cancel()
+	wg.Wait()
+
+	for _, d := range devices {
+		saveState(logger, d.listener, d.config.StateFile)
+	}
+	if inv != nil {
+		saveInventory(logger, inv, config.Inventory)
+	}
+	for _, d := range devices {
+		for _, server := range d.servers {
+			logFuzzResults(logger, server.FuzzResults())
+		}
+	}
+	if len(devices) > 1 {
+		logDevices(logger, devices)
+	}
+	entries := credentials.Entries()
+	if lootKey != nil {
+		for i := range entries {
+			entries[i].Passwords = []string{events.Redacted}
+		}
+	}
+	logCredentials(logger, entries)
+	recordAudit(logger, audit.ActionStop, map[string]string{"reason": stopReason, "credentials": strconv.Itoa(len(entries))})
+	if shipper != nil {
+		shipper.Flush(time.Minute)
+	}
+}
+
+// saveState saves the listener's state to path, if one is set
+func saveState(logger logging.Logger, listener *ssdp.Listener, path string) {
+	if path == "" {
+		return
+	}
+	if err := ssdp.SaveState(path, listener.State()); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+	}
+}
+
+// newElastic creates the Elasticsearch sink
+func newElastic(config *Config, logger logging.Logger) (*sink.Elastic, error) {
+	opts := []sink.ElasticOption{sink.WithElasticIndex(config.ElasticIndex), sink.WithElasticLogger(logger)}
+	if key := os.Getenv("ELASTIC_API_KEY"); key != "" {
+		opts = append(opts, sink.WithElasticAPIKey(key))
+	}
+	if config.ElasticInsecure {
+		opts = append(opts, sink.WithElasticInsecure())
+	}
+	return sink.NewElastic(config.Elastic, opts...)
+}
+
+// newMQTT creates the MQTT sink
+func newMQTT(config *Config, logger logging.Logger) (*sink.MQTT, error) {
+	opts := []sink.MQTTOption{sink.WithMQTTTopic(config.MQTTTopic), sink.WithMQTTLogger(logger)}
+	if config.MQTTInsecure {
+		opts = append(opts, sink.WithMQTTInsecure())
+	}
+	return sink.NewMQTT(config.MQTT, opts...)
+}
+
+// newKafka creates the Kafka sink
+func newKafka(config *Config, logger logging.Logger) (*sink.Kafka, error) {
+	opts := []sink.KafkaOption{sink.WithKafkaTopic(config.KafkaTopic), sink.WithKafkaLogger(logger)}
+	if user := os.Getenv("KAFKA_USERNAME"); user != "" {
+		opts = append(opts, sink.WithKafkaSASL(user, os.Getenv("KAFKA_PASSWORD")))
+	}
+	if config.KafkaTLS || config.KafkaInsecure {
+		opts = append(opts, sink.WithKafkaTLS(config.KafkaInsecure))
+	}
+	return sink.NewKafka(config.Kafka, opts...)
+}
+
+// newShipper creates the shipper of the logs, event store and loot
+func newShipper(config *Config, logger logging.Logger) (*ship.Shipper, error) {
+	creds, err := ship.EnvCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return ship.New(config.Ship, []string{filepath.Dir(dataPath(upnp.LogPath)), dataPath(loot.Dir)}, creds,
+		ship.WithRegion(config.ShipRegion), ship.WithEncryption(config.ShipSSE), ship.WithLogger(logger))
+}
+
+// logCredentials prints a table of the distinct credentials captured, if
+// there are any
+func logCredentials(logger logging.Logger, entries []creds.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	var table strings.Builder
+	creds.WriteTable(&table, entries)
+	logging.Notice(logger, "%sCaptured %d distinct credential(s):", ssdp.NoteBox, len(entries))
+	for _, line := range strings.Split(strings.TrimRight(table.String(), "\n"), "\n") {
+		logging.Notice(logger, "    %s", line)
+	}
+}
+
+// logFuzzResults reports the last descriptor each fuzzed client was sent. A
+// client that stopped fetching long before exit likely choked on it.
+func logFuzzResults(logger logging.Logger, results []upnp.FuzzResult) {
+	for _, result := range results {
+		logger.Log("%sHost: %s fetched %d fuzzed descriptors, the last (%s) at %s", ssdp.FuzzBox,
+			result.Host, result.Fetches, result.Last, result.LastSent.UTC().Format(time.RFC3339))
+	}
+}
+
+// feedWatchdog tells systemd we are alive every interval until ctx is
+// cancelled
+func feedWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			systemd.Notify(systemd.Watchdog)
+		}
+	}
+}
+
+// saveInventory writes the analyze mode inventory to prefix.json and
+// prefix.txt
+func saveInventory(logger logging.Logger, inv *inventory.Inventory, prefix string) {
+	if err := inv.Save(prefix); err != nil {
+		logging.Notice(logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	logger.Log("%sInventory of %d host(s) written to %s.json and %s.txt", ssdp.OkBox, len(inv.Hosts()), prefix, prefix)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [serve|analyze] [-h] [-c CONFIG] [-p PORT] [-t TEMPLATE]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "                    [-s SMB] [-b] [-r REALM] [-u URL] [-a]\n")
+	fmt.Fprintf(os.Stderr, "                    interface [interface ...]\n\n")
+	fmt.Fprintf(os.Stderr, "positional arguments:\n")
+	fmt.Fprintf(os.Stderr, "  interface             Network interface to listen on, by name, index, IPv4\n")
+	fmt.Fprintf(os.Stderr, "                        address or glob pattern such as \"eth*\" (see the\n")
+	fmt.Fprintf(os.Stderr, "                        interfaces command). Several may be given, as\n")
+	fmt.Fprintf(os.Stderr, "                        arguments, with repeated -interface flags or comma\n")
+	fmt.Fprintf(os.Stderr, "                        separated, or \"auto\" for every interface with an\n")
+	fmt.Fprintf(os.Stderr, "                        IPv4 address. May instead be set in the config file.\n\n")
+	fmt.Fprintf(os.Stderr, "optional arguments:\n")
+	fmt.Fprintf(os.Stderr, "  -h, --help            show this help message and exit\n")
+	fmt.Fprintf(os.Stderr, "  -c CONFIG, --config CONFIG\n")
+	fmt.Fprintf(os.Stderr, "                        YAML config file. Flags given on the command line\n")
+	fmt.Fprintf(os.Stderr, "                        override values from the file.\n")
+	fmt.Fprintf(os.Stderr, "  -p PORT, --port PORT  Port for HTTP server. Defaults to 8888.\n")
+	fmt.Fprintf(os.Stderr, "  -t TEMPLATE, --template TEMPLATE\n")
+	fmt.Fprintf(os.Stderr, "                        Name of a folder in the templates directory. Defaults\n")
+	fmt.Fprintf(os.Stderr, "                        to \"office365\". This will determine xml and phishing\n")
+	fmt.Fprintf(os.Stderr, "                        pages used.\n")
+	fmt.Fprintf(os.Stderr, "  -templates-dir DIR    Directory of custom templates, layered over the ones\n")
+	fmt.Fprintf(os.Stderr, "                        built into the binary. Defaults to \"templates\" if it\n")
+	fmt.Fprintf(os.Stderr, "                        exists.\n")
+	fmt.Fprintf(os.Stderr, "  -s SMB, --smb SMB     IP address of your SMB server. Defalts to the primary\n")
+	fmt.Fprintf(os.Stderr, "                        address of the \"interface\" provided.\n")
+	fmt.Fprintf(os.Stderr, "  -b, --basic           Enable base64 authentication for templates and write\n")
+	fmt.Fprintf(os.Stderr, "                        credentials to log file.\n")
+	fmt.Fprintf(os.Stderr, "  -r REALM, --realm REALM\n")
+	fmt.Fprintf(os.Stderr, "                        Realm when prompting target for authentication via\n")
+	fmt.Fprintf(os.Stderr, "                        Basic Auth.\n")
+	fmt.Fprintf(os.Stderr, "  -auth-retries N       Refuse the first N Basic Auth attempts from each host,\n")
+	fmt.Fprintf(os.Stderr, "                        logging every password tried, before accepting one.\n")
+	fmt.Fprintf(os.Stderr, "  -negotiate            Ask for Negotiate and NTLM authentication, saving the\n")
+	fmt.Fprintf(os.Stderr, "                        NetNTLM hashes and Kerberos tickets Windows clients\n")
+	fmt.Fprintf(os.Stderr, "                        send under loot/ for cracking.\n")
+	fmt.Fprintf(os.Stderr, "  -validate URL         Try each captured password once against URL, an\n")
+	fmt.Fprintf(os.Stderr, "                        http(s) page taking Basic Auth or an ldap(s) server,\n")
+	fmt.Fprintf(os.Stderr, "                        tagging captures [VALID] or [INVALID]. These are real\n")
+	fmt.Fprintf(os.Stderr, "                        logins: they can alert defenders and lock accounts.\n")
+	fmt.Fprintf(os.Stderr, "  -analytics            Inject a beacon into phishing pages recording the time\n")
+	fmt.Fprintf(os.Stderr, "                        to first input, the order fields are focused in and\n")
+	fmt.Fprintf(os.Stderr, "                        where victims gave up, for export -type analytics.\n")
+	fmt.Fprintf(os.Stderr, "  -screenshots          Save a screenshot of each phishing page as the victim\n")
+	fmt.Fprintf(os.Stderr, "                        submits it under loot/, drawn by html2canvas (put\n")
+	fmt.Fprintf(os.Stderr, "                        html2canvas.min.js in the templates assets directory).\n")
+	fmt.Fprintf(os.Stderr, "  -education            Security awareness exercise: show victims who submit a\n")
+	fmt.Fprintf(os.Stderr, "                        login form an education page and log the submission\n")
+	fmt.Fprintf(os.Stderr, "                        without its password.\n")
+	fmt.Fprintf(os.Stderr, "  -education-page FILE  HTML education page, a Go template seeing .Victim and\n")
+	fmt.Fprintf(os.Stderr, "                        .Username (default: built-in page).\n")
+	fmt.Fprintf(os.Stderr, "  -targets FILE         Education mode targets, attributed by the tokens in\n")
+	fmt.Fprintf(os.Stderr, "                        their links (see the training command).\n")
+	fmt.Fprintf(os.Stderr, "  -u URL, --url URL     Redirect to this URL. Works with templates that do a\n")
+	fmt.Fprintf(os.Stderr, "                        POST for logon forms and with templates that include\n")
+	fmt.Fprintf(os.Stderr, "                        the custom redirect JavaScript (see README for more\n")
+	fmt.Fprintf(os.Stderr, "                        info).[example: -r https://google.com]\n")
+	fmt.Fprintf(os.Stderr, "  -ftp-port PORT        Run an FTP listener on PORT for XXE templates that\n")
+	fmt.Fprintf(os.Stderr, "                        exfiltrate over ftp:// (xxe-exfil-ftp). Off by default.\n")
+	fmt.Fprintf(os.Stderr, "  -dns-domain DOMAIN    Answer DNS for DOMAIN, delegated to this host, logging\n")
+	fmt.Fprintf(os.Stderr, "                        and decoding data exfiltrated in query names.\n")
+	fmt.Fprintf(os.Stderr, "  -dns-port PORT        Port of the DNS listener. Defaults to 53.\n")
+	fmt.Fprintf(os.Stderr, "  -xxe-file PATH        File the XXE exfiltration templates read, a path or\n")
+	fmt.Fprintf(os.Stderr, "                        URL. Defaults to %s.\n", xxe.DefaultFile)
+	fmt.Fprintf(os.Stderr, "  -body-limit BYTES     Log and store up to this much of each POST and SOAP\n")
+	fmt.Fprintf(os.Stderr, "                        body. 0 disables. Defaults to %d.\n", upnp.DefaultBodyLimit)
+	fmt.Fprintf(os.Stderr, "  -scope FILE           Only answer, phish and capture from the addresses and\n")
+	fmt.Fprintf(os.Stderr, "                        ranges listed in FILE. Other hosts get a benign page\n")
+	fmt.Fprintf(os.Stderr, "                        and are written to %s only.\n", scope.LogPath)
+	fmt.Fprintf(os.Stderr, "  -trusted-proxy CIDR   Believe X-Forwarded-For from this proxy, e.g. a\n")
+	fmt.Fprintf(os.Stderr, "                        redirector, for scope and rate limits (repeatable).\n")
+	fmt.Fprintf(os.Stderr, "  -loot-key FILE        Encrypt loot to the OpenPGP public key in FILE (gpg\n")
+	fmt.Fprintf(os.Stderr, "                        --export), and keep passwords, hashes, tokens and\n")
+	fmt.Fprintf(os.Stderr, "                        exfiltrated data out of the log and event store.\n")
+	fmt.Fprintf(os.Stderr, "  -access-log FILE      Write every HTTP request to FILE in the Apache combined\n")
+	fmt.Fprintf(os.Stderr, "                        format. \"\" disables. Defaults to %s.\n", upnp.AccessLogPath)
+	fmt.Fprintf(os.Stderr, "  -links FILE           Serve the short links in FILE under %s, as kept by the\n", links.Prefix)
+	fmt.Fprintf(os.Stderr, "                        links command. \"\" disables. Defaults to %s.\n", links.Path)
+	fmt.Fprintf(os.Stderr, "  -ip IP                Address to use on an interface with several, instead\n")
+	fmt.Fprintf(os.Stderr, "                        of its first. May be repeated, once per interface,\n")
+	fmt.Fprintf(os.Stderr, "                        and given an IPv6 address to advertise to IPv6 hosts.\n")
+	fmt.Fprintf(os.Stderr, "  -advertise-ip IP      Address to put in LOCATION URLs and templates instead\n")
+	fmt.Fprintf(os.Stderr, "                        of the interface address, e.g. behind NAT or a\n")
+	fmt.Fprintf(os.Stderr, "                        redirector. Sockets still bind to the interface.\n")
+	fmt.Fprintf(os.Stderr, "  -advertise-port PORT  Port to advertise instead of -p.\n")
+	fmt.Fprintf(os.Stderr, "  -location URL         Advertise this URL in LOCATION instead of the device\n")
+	fmt.Fprintf(os.Stderr, "                        descriptor, e.g. on a redirector or over HTTPS.\n")
+	fmt.Fprintf(os.Stderr, "  -proxy URL            Serve the real device descriptor at URL as our own and\n")
+	fmt.Fprintf(os.Stderr, "                        pass its control traffic through, logging every call.\n")
+	fmt.Fprintf(os.Stderr, "  -proxy-rewrite LIST   Descriptor fields pointed at us: presentationURL,\n")
+	fmt.Fprintf(os.Stderr, "                        controlURL, eventSubURL, SCPDURL, icons. Defaults to all.\n")
+	fmt.Fprintf(os.Stderr, "  -a, --analyze         Run in analyze mode. Will NOT respond to any SSDP\n")
+	fmt.Fprintf(os.Stderr, "                        queries, but will still enable and run the web server\n")
+	fmt.Fprintf(os.Stderr, "                        for testing.\n")
+	fmt.Fprintf(os.Stderr, "  -host-ttl DURATION    Forget hosts that stop searching after this long, so\n")
+	fmt.Fprintf(os.Stderr, "                        they are logged as new when they return. 0 never\n")
+	fmt.Fprintf(os.Stderr, "                        forgets. Defaults to 30m.\n")
+	fmt.Fprintf(os.Stderr, "  -max-hosts N          Remember at most N host/service type pairs, dropping\n")
+	fmt.Fprintf(os.Stderr, "                        the least recently seen. 0 is unlimited. Defaults to\n")
+	fmt.Fprintf(os.Stderr, "                        10000.\n")
+	fmt.Fprintf(os.Stderr, "  -inventory PREFIX     Where analyze mode writes its inventory of hosts, as\n")
+	fmt.Fprintf(os.Stderr, "                        PREFIX.json and PREFIX.txt, on exit and on SIGUSR1.\n")
+	fmt.Fprintf(os.Stderr, "                        Defaults to logs/inventory.\n")
+	fmt.Fprintf(os.Stderr, "  -oui-file FILE        IEEE oui.txt or Wireshark manuf file used to name the\n")
+	fmt.Fprintf(os.Stderr, "                        vendors of hosts in the inventory, in addition to the\n")
+	fmt.Fprintf(os.Stderr, "                        built-in list of common ones.\n")
+	fmt.Fprintf(os.Stderr, "  -stealth              Vary SSDP responses: shuffle headers, mix header case,\n")
+	fmt.Fprintf(os.Stderr, "                        skew DATE and delay each answer within the searcher's\n")
+	fmt.Fprintf(os.Stderr, "                        MX, so responses do not match a fixed signature.\n")
+	fmt.Fprintf(os.Stderr, "  -track                Give each SSDP response an ID in its LOCATION and tie\n")
+	fmt.Fprintf(os.Stderr, "                        the requests and captures that follow to it.\n")
+	fmt.Fprintf(os.Stderr, "  -max-age DURATION     How long control points may cache the device.\n")
+	fmt.Fprintf(os.Stderr, "                        Defaults to 30m.\n")
+	fmt.Fprintf(os.Stderr, "  -advertise            Multicast ssdp:alive NOTIFYs for the device at start\n")
+	fmt.Fprintf(os.Stderr, "                        and every third to half of -max-age.\n")
+	fmt.Fprintf(os.Stderr, "  -root-only            Only answer upnp:rootdevice and ssdp:all searches, both\n")
+	fmt.Fprintf(os.Stderr, "                        as the root device, like most real devices.\n")
+	fmt.Fprintf(os.Stderr, "  -refuse-spoofed       Do not answer searches whose source port or headers no\n")
+	fmt.Fprintf(os.Stderr, "                        real control point would send, e.g. from port 1900 or\n")
+	fmt.Fprintf(os.Stderr, "                        without MAN. They are reported either way.\n")
+	fmt.Fprintf(os.Stderr, "  -active-hours WINDOW  Only answer searches and advertise during WINDOW, in\n")
+	fmt.Fprintf(os.Stderr, "                        local time, e.g. \"Mon-Fri 09:00-17:00\". May be\n")
+	fmt.Fprintf(os.Stderr, "                        repeated. Searches are still observed outside it.\n")
+	fmt.Fprintf(os.Stderr, "  -daemon               Run in the background, detached from the terminal,\n")
+	fmt.Fprintf(os.Stderr, "                        logging to the log file only.\n")
+	fmt.Fprintf(os.Stderr, "  -pid-file FILE        Where -daemon records the process ID. Defaults to\n")
+	fmt.Fprintf(os.Stderr, "                        logs/goSSDPkit.pid.\n")
+	fmt.Fprintf(os.Stderr, "  -state FILE           Keep the session USN, BOOTID and hosts already seen\n")
+	fmt.Fprintf(os.Stderr, "                        in FILE, so a restart presents the same device.\n")
+	fmt.Fprintf(os.Stderr, "  -user USER            Switch to this unprivileged user once the sockets\n")
+	fmt.Fprintf(os.Stderr, "                        are bound, so requests are not handled as root. The\n")
+	fmt.Fprintf(os.Stderr, "                        log directory is handed to the user. Not on Windows.\n")
+	fmt.Fprintf(os.Stderr, "  -group GROUP          Group to switch to with -user. Defaults to the\n")
+	fmt.Fprintf(os.Stderr, "                        user's primary group.\n")
+	fmt.Fprintf(os.Stderr, "  -docker               Container mode: report host or bridge networking and\n")
+	fmt.Fprintf(os.Stderr, "                        warn when the LAN cannot reach us, serve /healthz,\n")
+	fmt.Fprintf(os.Stderr, "                        and use every interface unless one is given.\n")
+	fmt.Fprintf(os.Stderr, "  -ipv4-only            Only answer IPv4 searches. By default IPv6 searches\n")
+	fmt.Fprintf(os.Stderr, "                        are answered too on interfaces with an IPv6 address.\n")
+	fmt.Fprintf(os.Stderr, "  -server-header VALUE  SERVER header to answer with. Repeat it to give each\n")
+	fmt.Fprintf(os.Stderr, "                        host one of several, always the same for a host.\n")
+	fmt.Fprintf(os.Stderr, "  -reply-socket         Send SSDP responses from a separate socket on an\n")
+	fmt.Fprintf(os.Stderr, "                        ephemeral port instead of port 1900.\n")
+	fmt.Fprintf(os.Stderr, "  -reply-port PORT      Source port for responses. Implies -reply-socket.\n")
+	fmt.Fprintf(os.Stderr, "  -reply-ttl N          IP TTL of responses. Implies -reply-socket.\n")
+	fmt.Fprintf(os.Stderr, "  -reply-dscp N         DSCP of responses (0-63). Implies -reply-socket.\n")
+	fmt.Fprintf(os.Stderr, "  -alert-rate N         Alert on hosts sending more than N searches a minute.\n")
+	fmt.Fprintf(os.Stderr, "                        0 disables. Defaults to 60.\n")
+	fmt.Fprintf(os.Stderr, "  -http-rate N          Answer hosts making more than N HTTP requests a minute\n")
+	fmt.Fprintf(os.Stderr, "                        with 429 Too Many Requests. 0 disables (default).\n")
+	fmt.Fprintf(os.Stderr, "  -tarpit DURATION      Hold each HTTP request from likely scanners open this\n")
+	fmt.Fprintf(os.Stderr, "                        long, trickling bytes, instead of serving them.\n")
+	fmt.Fprintf(os.Stderr, "  -max-conns N          HTTP connections kept open at once; more wait. 0 for no\n")
+	fmt.Fprintf(os.Stderr, "                        limit. Defaults to %d.\n", upnp.DefaultMaxConns)
+	fmt.Fprintf(os.Stderr, "  -max-conns-per-host N Connections one host may keep open; more are closed.\n")
+	fmt.Fprintf(os.Stderr, "                        0 for no limit. Defaults to %d.\n", upnp.DefaultMaxConnsPerHost)
+	fmt.Fprintf(os.Stderr, "  -max-handlers N       Requests handled at once; more are answered 503. 0 for\n")
+	fmt.Fprintf(os.Stderr, "                        no limit. Defaults to %d.\n", upnp.DefaultMaxHandlers)
+	fmt.Fprintf(os.Stderr, "  -header-timeout DURATION\n")
+	fmt.Fprintf(os.Stderr, "                        Time HTTP clients get to send request headers.\n")
+	fmt.Fprintf(os.Stderr, "                        0 for no limit. Defaults to %s.\n", upnp.DefaultTimeouts.ReadHeader)
+	fmt.Fprintf(os.Stderr, "  -read-timeout DURATION\n")
+	fmt.Fprintf(os.Stderr, "                        Time to send a whole request. Defaults to %s.\n", upnp.DefaultTimeouts.Read)
+	fmt.Fprintf(os.Stderr, "  -write-timeout DURATION\n")
+	fmt.Fprintf(os.Stderr, "                        Time to read a response. Defaults to %s.\n", upnp.DefaultTimeouts.Write)
+	fmt.Fprintf(os.Stderr, "  -idle-timeout DURATION\n")
+	fmt.Fprintf(os.Stderr, "                        How long idle keep-alive connections are kept.\n")
+	fmt.Fprintf(os.Stderr, "                        Defaults to %s.\n", upnp.DefaultTimeouts.Idle)
+	fmt.Fprintf(os.Stderr, "  -tls                  Serve TLS on the HTTP port too, with a self-signed\n")
+	fmt.Fprintf(os.Stderr, "                        certificate, logging each client's JA3 and JA4.\n")
+	fmt.Fprintf(os.Stderr, "  -tls-cert FILE        PEM certificate to serve TLS with instead; implies -tls.\n")
+	fmt.Fprintf(os.Stderr, "  -tls-key FILE         PEM private key of -tls-cert.\n")
+	fmt.Fprintf(os.Stderr, "  -webhook URL          POST alerts about likely scanners and detection tools\n")
+	fmt.Fprintf(os.Stderr, "                        to URL as JSON.\n")
+	fmt.Fprintf(os.Stderr, "  -elastic URL          Bulk-index every event into Elasticsearch or OpenSearch\n")
+	fmt.Fprintf(os.Stderr, "                        at URL (http(s)://[USER:PASSWORD@]HOST:PORT), or with\n")
+	fmt.Fprintf(os.Stderr, "                        the API key in ELASTIC_API_KEY.\n")
+	fmt.Fprintf(os.Stderr, "  -elastic-index NAME   Index events go to. Defaults to %s.\n", sink.DefaultIndex)
+	fmt.Fprintf(os.Stderr, "  -mqtt URL             Publish every event as JSON to an MQTT broker at URL\n")
+	fmt.Fprintf(os.Stderr, "                        (mqtt(s)://[USER:PASSWORD@]HOST[:PORT]).\n")
+	fmt.Fprintf(os.Stderr, "  -mqtt-topic TOPIC     Topic prefix; events go to TOPIC/TYPE. Defaults to %s.\n", sink.DefaultTopic)
+	fmt.Fprintf(os.Stderr, "  -kafka BROKERS        Produce every event as JSON to Kafka, keyed by source\n")
+	fmt.Fprintf(os.Stderr, "                        address, through HOST[:PORT][,HOST[:PORT]...]. SASL/PLAIN\n")
+	fmt.Fprintf(os.Stderr, "                        credentials are read from KAFKA_USERNAME and\n")
+	fmt.Fprintf(os.Stderr, "                        KAFKA_PASSWORD.\n")
+	fmt.Fprintf(os.Stderr, "  -kafka-topic TOPIC    Topic events go to. Defaults to %s.\n", sink.DefaultKafkaTopic)
+	fmt.Fprintf(os.Stderr, "  -scripts DIR          Run the hook scripts in DIR on searches, HTTP responses\n")
+	fmt.Fprintf(os.Stderr, "                        and captures. \"\" disables. Defaults to %s.\n", script.Dir)
+	fmt.Fprintf(os.Stderr, "  -plugins DIR          Start every program in DIR as a plugin, adding HTTP\n")
+	fmt.Fprintf(os.Stderr, "                        routes, SSDP response mutators and event consumers.\n")
+	fmt.Fprintf(os.Stderr, "  -ship URL             Upload the logs, event store and loot to S3-compatible\n")
+	fmt.Fprintf(os.Stderr, "                        storage as they change: s3://BUCKET[/PREFIX] or\n")
+	fmt.Fprintf(os.Stderr, "                        https://ENDPOINT/BUCKET[/PREFIX]. Keys are read from\n")
+	fmt.Fprintf(os.Stderr, "                        AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.\n")
+	fmt.Fprintf(os.Stderr, "  -ship-interval DURATION\n")
+	fmt.Fprintf(os.Stderr, "                        How often to ship changed files. Defaults to 5m.\n")
+	fmt.Fprintf(os.Stderr, "  -ship-region REGION   Region to sign for. Defaults to %s.\n", ship.DefaultRegion)
+	fmt.Fprintf(os.Stderr, "  -ship-sse MODE        Server-side encryption of shipped objects: AES256,\n")
+	fmt.Fprintf(os.Stderr, "                        aws:kms or aws:kms:KEY-ID.\n")
+	fmt.Fprintf(os.Stderr, "  -canary NAME=URL      Canary URL templates embed as {{.Canaries.NAME}}. May\n")
+	fmt.Fprintf(os.Stderr, "                        be repeated.\n")
+	fmt.Fprintf(os.Stderr, "  -canary-token URL     Request URL, e.g. a canarytoken, when a host's XML\n")
+	fmt.Fprintf(os.Stderr, "                        parser exfiltrates data or fetches the XXE canary, and\n")
+	fmt.Fprintf(os.Stderr, "                        on new credentials from a -canary-subnet.\n")
+	fmt.Fprintf(os.Stderr, "  -canary-subnet CIDR   Flag credentials from this subnet for -canary-token.\n")
+	fmt.Fprintf(os.Stderr, "                        May be repeated.\n")
+	fmt.Fprintf(os.Stderr, "  -fuzz-client IP       Answer searches from this test client with malformed\n")
+	fmt.Fprintf(os.Stderr, "                        responses, cycling through the mutations. May be\n")
+	fmt.Fprintf(os.Stderr, "                        repeated.\n")
+	fmt.Fprintf(os.Stderr, "  -fuzz NAMES           Comma separated mutations to use with -fuzz-client.\n")
+	fmt.Fprintf(os.Stderr, "                        Defaults to all of them (see README).\n")
+	fmt.Fprintf(os.Stderr, "  -fuzz-xml-client IP   Send this test client malformed device descriptors,\n")
+	fmt.Fprintf(os.Stderr, "                        a different mutation on each fetch. May be repeated.\n")
+	fmt.Fprintf(os.Stderr, "  -fuzz-xml NAMES       Comma separated descriptor mutations to use with\n")
+	fmt.Fprintf(os.Stderr, "                        -fuzz-xml-client. Defaults to all of them.\n")
+}
+
+// describePlugin says what a plugin does, for the log
+func describePlugin(p *plugin.Plugin) string {
+	var does []string
+	if len(p.Routes) > 0 {
+		does = append(does, "routes "+strings.Join(p.Routes, ", "))
+	}
+	if p.Mutator {
+		does = append(does, "SSDP responses")
+	}
+	if p.Events {
+		does = append(does, "events")
+	}
+	if len(does) == 0 {
+		return "nothing"
+	}
+	return strings.Join(does, "; ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// defaultServiceName is the name goSSDPkit is installed under as a service
+const defaultServiceName = "goSSDPkit"
+
+// runServiceCommand implements the service subcommand
+func runServiceCommand(args []string) error {
+	name := defaultServiceName
+
+	fs := newFlagSet("service", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s service [-n NAME] install [serve options] interface\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s service [-n NAME] uninstall\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Install goSSDPkit as a service that starts at boot and keeps running, e.g.\n")
+		fmt.Fprintf(os.Stderr, "as a rogue device honeypot. On Linux this writes a systemd unit; on\n")
+		fmt.Fprintf(os.Stderr, "Windows it registers with the service manager. The options after install\n")
+		fmt.Fprintf(os.Stderr, "are those of serve, and the service runs in the current directory.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -n NAME, --name NAME  Service name. Defaults to \"goSSDPkit\".\n")
+	})
+	fs.StringVar(&name, "n", name, "")
+	fs.StringVar(&name, "name", name, "")
+
+	// Options after the action belong to serve, so stop at the first
+	// argument that is not a flag
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("action is required")
+	}
+
+	action, serveArgs := fs.Arg(0), fs.Args()[1:]
+	switch action {
+	case "install":
+		// Check the options now rather than when the service first starts
+		if _, err := parseServeArgs(serveArgs); err != nil {
+			return err
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get working directory: %w", err)
+		}
+		return installService(name, dir, serveArgs)
+	case "uninstall":
+		return uninstallService(name)
+	case "run":
+		// Used by the Windows service manager to start the service
+		return runService(name, serveArgs)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown action: %s", action)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// systemdUnitDir is where installed units are written
+const systemdUnitDir = "/etc/systemd/system"
+
+// systemdUnit runs goSSDPkit as a Type=notify service, restarted if it
+// fails or stops feeding the watchdog
+const systemdUnit = `[Unit]
+Description=goSSDPkit SSDP responder
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory=%s
+Restart=on-failure
+RestartSec=5
+WatchdogSec=30
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// installService writes a systemd unit that runs serve with args in dir
+func installService(name, dir string, args []string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("service install supports systemd and Windows; use your init system to run \"serve\"")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	command := []string{systemdQuote(exe), "serve"}
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+	path := filepath.Join(systemdUnitDir, name+".service")
+	unit := fmt.Sprintf(systemdUnit, strings.Join(command, " "), strings.ReplaceAll(dir, "%", "%%"))
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write unit: %w", err)
+	}
+
+	fmt.Printf("%sWrote %s. Start it, and at every boot, with:\n", ssdp.OkBox, path)
+	fmt.Printf("    systemctl daemon-reload && systemctl enable --now %s\n", name)
+	return nil
+}
+
+// uninstallService removes the unit written by installService
+func uninstallService(name string) error {
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unit: %w", err)
+	}
+	fmt.Printf("%sRemoved %s. If it is running, stop it with:\n", ssdp.OkBox, path)
+	fmt.Printf("    systemctl disable --now %s && systemctl daemon-reload\n", name)
+	return nil
+}
+
+// runService is only used by the Windows service manager
+func runService(name string, args []string) error {
+	return fmt.Errorf("service run is for the Windows service manager; systemd runs \"serve\" directly")
+}
+
+// systemdQuote quotes arg for an ExecStart line when it needs it
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`).Replace(arg)
+	return `"` + arg + `"`
+}
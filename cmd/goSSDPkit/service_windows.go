@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// installService registers a service that runs serve with args in dir,
+// started at boot and restarted if it fails
+func installService(name, dir string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	// The service manager starts services in the system directory, so the
+	// working directory travels on the command line
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "goSSDPkit SSDP responder",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"service", "-n", name, "run", dir}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	recovery := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}
+	if err := s.SetRecoveryActions(recovery, 86400); err != nil {
+		fmt.Printf("%sFailed to set the service to restart on failure: %v\n", ssdp.WarnBox, err)
+	}
+
+	fmt.Printf("%sInstalled service %s. Start it with:\n", ssdp.OkBox, name)
+	fmt.Printf("    sc start %s\n", name)
+	return nil
+}
+
+// uninstallService removes the service registered by installService
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	fmt.Printf("%sRemoved service %s. If it is running, it is removed once stopped:\n", ssdp.OkBox, name)
+	fmt.Printf("    sc stop %s\n", name)
+	return nil
+}
+
+// runService runs serve under the service manager. The first argument is
+// the working directory, the rest are the serve options.
+func runService(name string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("working directory is required")
+	}
+	if err := os.Chdir(args[0]); err != nil {
+		return fmt.Errorf("failed to change to %s: %w", args[0], err)
+	}
+	config, err := parseServeArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	return svc.Run(name, &service{config: config})
+}
+
+// service answers the service manager's requests
+type service struct {
+	config *Config
+}
+
+// Execute runs serve until the service manager stops it
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runServe(ctx, s.config)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			// Stopped on its own, which only happens on an error
+			return true, 1
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
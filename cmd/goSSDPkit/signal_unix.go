@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// inventorySignals ask a running analyze session to write its inventory
+var inventorySignals = []os.Signal{syscall.SIGUSR1}
+
+// reloadSignals ask a running session to re-read its configuration
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// statusSignals ask a running session to log what each device has drawn
+var statusSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// inventorySignals ask a running analyze session to write its inventory.
+// Windows has no user signals, so the inventory is only written on exit.
+var inventorySignals []os.Signal
+
+// reloadSignals ask a running session to re-read its configuration. Windows
+// has no SIGHUP, so changes need a restart.
+var reloadSignals []os.Signal
+
+// statusSignals ask a running session to log what each device has drawn.
+// Windows has no user signals, so it is only logged on exit.
+var statusSignals []os.Signal
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"goSSDPkit/pkg/audit"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// runTagCommand implements the tag subcommand
+func runTagCommand(args []string) error {
+	path := dataPath(store.Path)
+	note := ""
+	remove := false
+	clearAll := false
+	list := false
+
+	fs := newFlagSet("tag", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s tag [-f FILE] [-remove] [-note TEXT] HOST [TAG ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s tag [-f FILE] -clear HOST\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s tag [-f FILE] -list\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Attach tags and a note to a host, kept in the event store and added to its\n")
+		fmt.Fprintf(os.Stderr, "rows by export. Without tags or options, show those of HOST.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -note TEXT            Set the note of HOST, e.g. \"DC admin workstation\".\n")
+		fmt.Fprintf(os.Stderr, "  -remove               Remove the tags given instead of adding them.\n")
+		fmt.Fprintf(os.Stderr, "  -clear                Remove every tag and the note of HOST.\n")
+		fmt.Fprintf(os.Stderr, "  -list                 List the tagged hosts.\n")
+		fmt.Fprintf(os.Stderr, "  -f FILE               Event store. Defaults to %s.\n", dataPath(store.Path))
+	})
+	fs.StringVar(&path, "f", path, "")
+	fs.StringVar(&path, "file", path, "")
+	fs.StringVar(&note, "note", note, "")
+	fs.BoolVar(&remove, "remove", remove, "")
+	fs.BoolVar(&clearAll, "clear", clearAll, "")
+	fs.BoolVar(&list, "list", list, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	noteSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "note" {
+			noteSet = true
+		}
+	})
+
+	tags, err := readTags(path)
+	if err != nil {
+		return err
+	}
+	if list {
+		writeTags(tags)
+		return nil
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return errors.New("a host is required")
+	}
+	host, names := positional[0], positional[1:]
+
+	current := tags[host]
+	switch {
+	case clearAll:
+		current = store.HostTags{}
+	case remove:
+		current = current.Remove(names...)
+	default:
+		current = current.Add(names...)
+	}
+	if noteSet {
+		current.Note = note
+	}
+	if !clearAll && !noteSet && len(names) == 0 {
+		writeTags(map[string]store.HostTags{host: current})
+		return nil
+	}
+
+	st, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	st.Records(store.Tag(host, current, time.Now()))
+	if err := st.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("%s%s: %s\n", ssdp.OkBox, host, formatTags(current))
+	recordAudit(logging.NewConsoleLogger(os.Stderr), audit.ActionTag, map[string]string{
+		"host": host,
+		"tags": strings.Join(current.Tags, ","),
+		"note": current.Note,
+	})
+	return nil
+}
+
+// readTags returns the tags of each host in the event store at path, none
+// when there is no store yet
+func readTags(path string) (map[string]store.HostTags, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]store.HostTags{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event store: %w", err)
+	}
+	defer file.Close()
+	records, err := store.Read(file, store.TypeTag, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event store: %w", err)
+	}
+	return store.Tags(records), nil
+}
+
+// writeTags prints the tags of hosts as a table, sorted by host
+func writeTags(tags map[string]store.HostTags) {
+	hosts := make([]string, 0, len(tags))
+	for host := range tags {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HOST\tTAGS\tNOTE")
+	for _, host := range hosts {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", host, strings.Join(tags[host].Tags, ","), tags[host].Note)
+	}
+	table.Flush()
+}
+
+// formatTags describes tags on one line
+func formatTags(t store.HostTags) string {
+	text := "no tags"
+	if len(t.Tags) > 0 {
+		text = "tags " + strings.Join(t.Tags, ",")
+	}
+	if t.Note != "" {
+		text += fmt.Sprintf(", note %q", t.Note)
+	}
+	return text
+}
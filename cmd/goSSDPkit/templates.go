@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+)
+
+// runTemplatesCommand implements the templates subcommand
+func runTemplatesCommand(args []string) error {
+	var templatesDir, name, output, keyFile string
+	var force bool
+
+	fs := newFlagSet("templates", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s templates [-templates-dir DIR] [list]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s templates [-templates-dir DIR] [-n NAME] [-f] clone URL\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s templates [-templates-dir DIR] lint NAME\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s templates [-templates-dir DIR] [-o FILE] [-k KEY] pack NAME\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s templates [-templates-dir DIR] [-k PUBKEY] [-f] install FILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s templates [-o PREFIX] keygen\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "actions:\n")
+		fmt.Fprintf(os.Stderr, "  list                  List the templates built into the binary and found in\n")
+		fmt.Fprintf(os.Stderr, "                        the templates directory, with the details from their\n")
+		fmt.Fprintf(os.Stderr, "                        template.yaml manifests.\n")
+		fmt.Fprintf(os.Stderr, "  clone URL             Fetch a login page and turn it into a template.\n")
+		fmt.Fprintf(os.Stderr, "  lint NAME             Render a template with dummy data and report problems.\n")
+		fmt.Fprintf(os.Stderr, "  pack NAME             Bundle a template and its assets into a .tar.gz with\n")
+		fmt.Fprintf(os.Stderr, "                        checksums, signed when -k names a private key.\n")
+		fmt.Fprintf(os.Stderr, "  install FILE          Verify a bundle and install it into the templates\n")
+		fmt.Fprintf(os.Stderr, "                        directory. With -k the bundle must be signed by the\n")
+		fmt.Fprintf(os.Stderr, "                        matching private key.\n")
+		fmt.Fprintf(os.Stderr, "  keygen                Write a signing key pair to PREFIX.key and PREFIX.pub.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -templates-dir DIR    Templates directory. Defaults to \"templates\".\n")
+		fmt.Fprintf(os.Stderr, "  -n NAME, --name NAME  Name of the cloned template. Defaults to the host name.\n")
+		fmt.Fprintf(os.Stderr, "  -f, --force           Overwrite an existing template when cloning or\n")
+		fmt.Fprintf(os.Stderr, "                        installing.\n")
+		fmt.Fprintf(os.Stderr, "  -o FILE, --output FILE\n")
+		fmt.Fprintf(os.Stderr, "                        Bundle written by pack (defaults to NAME.tar.gz), or\n")
+		fmt.Fprintf(os.Stderr, "                        key file prefix for keygen (defaults to \"template\").\n")
+		fmt.Fprintf(os.Stderr, "  -k KEY, --key KEY     Private key for pack, public key for install.\n")
+	})
+	fs.StringVar(&templatesDir, "templates-dir", templatesDir, "")
+	fs.StringVar(&name, "n", name, "")
+	fs.StringVar(&name, "name", name, "")
+	fs.BoolVar(&force, "f", force, "")
+	fs.BoolVar(&force, "force", force, "")
+	fs.StringVar(&output, "o", output, "")
+	fs.StringVar(&output, "output", output, "")
+	fs.StringVar(&keyFile, "k", keyFile, "")
+	fs.StringVar(&keyFile, "key", keyFile, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	action := "list"
+	if len(positional) > 0 {
+		action = positional[0]
+	}
+
+	switch action {
+	case "list":
+		return listTemplates(templatesDir)
+	case "clone":
+		if len(positional) != 2 {
+			fs.Usage()
+			return fmt.Errorf("clone requires a URL")
+		}
+		return cloneTemplate(positional[1], templatesDir, name, force)
+	case "lint":
+		if len(positional) != 2 {
+			fs.Usage()
+			return fmt.Errorf("lint requires a template name")
+		}
+		return lintTemplate(positional[1], templatesDir)
+	case "pack":
+		if len(positional) != 2 {
+			fs.Usage()
+			return fmt.Errorf("pack requires a template name")
+		}
+		return packTemplate(positional[1], templatesDir, output, keyFile)
+	case "install":
+		if len(positional) != 2 {
+			fs.Usage()
+			return fmt.Errorf("install requires a bundle file")
+		}
+		return installTemplate(positional[1], templatesDir, keyFile, force)
+	case "keygen":
+		return generateBundleKey(output, force)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown templates action: %s", action)
+	}
+}
+
+// listTemplates prints every available template with its manifest details
+func listTemplates(templatesDir string) error {
+	templatesFS, err := openTemplates(templatesDir)
+	if err != nil {
+		return err
+	}
+	templates, err := template.ListTemplates(templatesFS)
+	if err != nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tSMB\tXXE\tVARIABLES\tDESCRIPTION")
+	for _, dir := range templates {
+		manifest, err := template.LoadManifest(templatesFS, dir)
+		if err != nil {
+			fmt.Fprintf(table, "%s\t-\t-\t-\t%v\n", dir, err)
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", dir, yesNo(manifest.SMB), yesNo(manifest.XXE),
+			strings.Join(manifest.Variables, ","), manifest.Description)
+	}
+	return table.Flush()
+}
+
+// yesNo formats a flag for table output
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// lintTemplate prints the problems found in a template, and fails if any
+// of them would break it
+func lintTemplate(name, templatesDir string) error {
+	templatesFS, err := openTemplates(templatesDir)
+	if err != nil {
+		return err
+	}
+
+	issues, err := template.Lint(templatesFS, name)
+	if err != nil {
+		return err
+	}
+
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.Severity == template.SeverityError {
+			errorCount++
+		}
+		fmt.Printf("%s%s\n", ssdp.WarnBox, issue)
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("%s: %d error(s), %d warning(s)", name, errorCount, len(issues)-errorCount)
+	}
+	fmt.Printf("%s%s: %d warning(s)\n", ssdp.OkBox, name, len(issues))
+	return nil
+}
+
+// cloneTemplate turns the page at pageURL into a template on disk
+func cloneTemplate(pageURL, templatesDir, name string, force bool) error {
+	if templatesDir == "" {
+		templatesDir = defaultTemplatesDir
+	}
+	if name == "" {
+		name = template.TemplateNameFromURL(pageURL)
+	}
+
+	templateDir := filepath.Join(templatesDir, name)
+	if _, err := os.Stat(templateDir); err == nil && !force {
+		return fmt.Errorf("template %s already exists (use -f to overwrite)", templateDir)
+	}
+
+	fmt.Printf("%sCloning %s into %s...\n", ssdp.OkBox, pageURL, templateDir)
+
+	opts := template.CloneOptions{
+		Name:         name,
+		TemplatesDir: templatesDir,
+	}
+	if err := template.Clone(pageURL, opts); err != nil {
+		return err
+	}
+
+	fmt.Printf("%sTemplate written. Run it with: -t %s", ssdp.NoteBox, name)
+	if templatesDir != defaultTemplatesDir {
+		fmt.Printf(" -templates-dir %s", templatesDir)
+	}
+	fmt.Println()
+	return nil
+}
+
+// packTemplate writes a template and its assets to a bundle file
+func packTemplate(name, templatesDir, output, keyFile string) error {
+	templatesFS, err := openTemplates(templatesDir)
+	if err != nil {
+		return err
+	}
+
+	var key ed25519.PrivateKey
+	if keyFile != "" {
+		if key, err = template.ReadBundleKey(keyFile, ed25519.PrivateKeySize); err != nil {
+			return err
+		}
+	}
+
+	if output == "" {
+		output = name + ".tar.gz"
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	defer file.Close()
+
+	if err := template.Pack(templatesFS, name, file, key); err != nil {
+		os.Remove(output)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+
+	signed := "unsigned"
+	if key != nil {
+		signed = "signed"
+	}
+	fmt.Printf("%sPacked %s into %s (%s)\n", ssdp.OkBox, name, output, signed)
+	return nil
+}
+
+// installTemplate verifies a bundle file and unpacks it into the templates
+// directory
+func installTemplate(bundle, templatesDir, keyFile string, force bool) error {
+	if templatesDir == "" {
+		templatesDir = defaultTemplatesDir
+	}
+
+	var pub ed25519.PublicKey
+	if keyFile != "" {
+		key, err := template.ReadBundleKey(keyFile, ed25519.PublicKeySize)
+		if err != nil {
+			return err
+		}
+		pub = key
+	}
+
+	file, err := os.Open(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", bundle, err)
+	}
+	defer file.Close()
+
+	name, err := template.Install(file, templatesDir, pub, force)
+	if err != nil {
+		return fmt.Errorf("failed to install %s: %w", bundle, err)
+	}
+
+	if pub == nil {
+		fmt.Printf("%sSignature not checked (no -k public key given)\n", ssdp.WarnBox)
+	}
+	fmt.Printf("%sInstalled %s into %s\n", ssdp.OkBox, name, filepath.Join(templatesDir, name))
+	return nil
+}
+
+// generateBundleKey writes a new signing key pair to prefix.key and prefix.pub
+func generateBundleKey(prefix string, force bool) error {
+	if prefix == "" {
+		prefix = "template"
+	}
+	privateFile, publicFile := prefix+".key", prefix+".pub"
+
+	if _, err := os.Stat(privateFile); err == nil && !force {
+		return fmt.Errorf("%s already exists (use -f to overwrite)", privateFile)
+	}
+
+	pub, priv, err := template.GenerateBundleKey()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(privateFile, []byte(priv+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", privateFile, err)
+	}
+	if err := os.WriteFile(publicFile, []byte(pub+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", publicFile, err)
+	}
+
+	fmt.Printf("%sWrote %s and %s\n", ssdp.OkBox, privateFile, publicFile)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// checkTLS checks the TLS settings of config, loading the certificate and
+// key if given
+func checkTLS(config *Config) error {
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if config.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey); err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		config.TLS = true
+	}
+	return nil
+}
+
+// serverTLS returns the TLS settings of the HTTP servers: the certificate
+// and key of config, or a certificate made up at start for the addresses
+// of bindings. Nil without TLS.
+func serverTLS(config *Config, bindings []ssdp.Binding) (*tls.Config, error) {
+	if !config.TLS {
+		return nil, nil
+	}
+	var cert tls.Certificate
+	var err error
+	if config.TLSCert != "" {
+		cert, err = tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	} else {
+		cert, err = selfSigned(bindings, config.AdvertiseIP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	// Old clients are served too: their fingerprints are worth having
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS10}, nil
+}
+
+// selfSigned makes a certificate valid for a year for the addresses of
+// bindings and advertiseIP
+func selfSigned(bindings []ssdp.Binding, advertiseIP string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var ips []net.IP
+	for _, ip := range append([]string{advertiseIP}, bindingIPs(bindings)...) {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ips = append(ips, parsed)
+		}
+	}
+	name := "localhost"
+	if len(ips) > 0 {
+		name = ips[0].String()
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// bindingIPs returns the addresses bindings listen on, without the zones of
+// link-local IPv6 ones
+func bindingIPs(bindings []ssdp.Binding) []string {
+	var ips []string
+	for _, b := range bindings {
+		ips = append(ips, b.LocalIP)
+		if b.LocalIP6 != "" {
+			ip, _, _ := strings.Cut(b.LocalIP6, "%")
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
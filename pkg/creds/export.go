@@ -0,0 +1,118 @@
+package creds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/negotiate"
+)
+
+// Hash is captured material in a form password crackers accept
+type Hash struct {
+	Username    string
+	Value       string
+	HashcatMode int    // hashcat -m
+	JohnFormat  string // john --format
+}
+
+// Hashcat modes and john formats of the material captured
+const (
+	HashcatPlaintext = 99999
+	JohnPlaintext    = "plaintext"
+)
+
+// Hashes returns the passwords of entries as hashes, one per distinct
+// username and password. Form and Basic auth passwords are plaintext, which
+// both tools take as username:password lines, hashcat with --username.
+// Device code tokens and education mode submissions have nothing to crack
+// and are left out, as are passwords redacted from the log because the loot
+// was encrypted.
+func Hashes(entries []Entry) []Hash {
+	seen := make(map[string]bool)
+	var hashes []Hash
+	for _, e := range entries {
+		if e.Source == events.SourceDevice || e.Source == events.SourceExercise {
+			continue
+		}
+		for _, password := range e.Passwords {
+			k := e.Username + "\x00" + password
+			if seen[k] || strings.HasSuffix(password, events.Redacted) {
+				continue
+			}
+			seen[k] = true
+			hashes = append(hashes, HashOf(e.Source, e.Username, password))
+		}
+	}
+	return hashes
+}
+
+// HashOf returns a password captured from source as a hash. NetNTLM
+// responses and Kerberos tickets are captured in hashcat's format, which
+// carries the username; John has no format for AES tickets.
+func HashOf(source, username, password string) Hash {
+	h := Hash{Username: username, Value: password, HashcatMode: HashcatPlaintext, JohnFormat: JohnPlaintext}
+	switch source {
+	case events.SourceNTLM:
+		// NetNTLMv1 is user::domain:lm:nt:challenge, v2 starts the same and
+		// has the 8 byte challenge fourth
+		h.HashcatMode, h.JohnFormat = negotiate.HashcatNetNTLMv2, negotiate.JohnNetNTLMv2
+		if fields := strings.Split(password, ":"); len(fields) == 6 && len(fields[3]) == 48 {
+			h.HashcatMode, h.JohnFormat = negotiate.HashcatNetNTLMv1, negotiate.JohnNetNTLMv1
+		}
+	case events.SourceKerberos:
+		// $krb5tgs$ETYPE$...
+		etype, _ := strconv.Atoi(strings.Split(strings.TrimPrefix(password, "$krb5tgs$"), "$")[0])
+		h.HashcatMode, h.JohnFormat = negotiate.HashcatTicket[etype], ""
+		if etype == negotiate.ETypeRC4 {
+			h.JohnFormat = negotiate.JohnTicket
+		}
+	}
+	return h
+}
+
+// Line returns h as its tools take it
+func (h Hash) Line() string {
+	if h.HashcatMode == HashcatPlaintext {
+		return h.Username + ":" + h.Value
+	}
+	return h.Value
+}
+
+// Export writes hashes to dir/engagement, in one file per hashcat mode
+// (hashcat-<mode>.txt) and one per john format (john-<format>.txt), so each
+// can be fed to its tool as is. It returns the files written.
+func Export(dir, engagement string, hashes []Hash) ([]string, error) {
+	if engagement == "" || strings.ContainsAny(engagement, `/\`) || engagement == "." || engagement == ".." {
+		return nil, fmt.Errorf("invalid engagement name: %q", engagement)
+	}
+	dir = filepath.Join(dir, engagement)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	files := make(map[string][]string)
+	for _, h := range hashes {
+		line := h.Line()
+		hashcat := filepath.Join(dir, fmt.Sprintf("hashcat-%d.txt", h.HashcatMode))
+		files[hashcat] = append(files[hashcat], line)
+		if h.JohnFormat != "" {
+			john := filepath.Join(dir, "john-"+h.JohnFormat+".txt")
+			files[john] = append(files[john], line)
+		}
+	}
+
+	var written []string
+	for path, lines := range files {
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	return written, nil
+}
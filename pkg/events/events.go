@@ -0,0 +1,287 @@
+// Package events defines the callbacks the SSDP listener and UPnP server
+// raise as hosts interact with the spoofed device, so programs embedding
+// them can act on each interaction (store it, alert on it, task a C2)
+// without changing the handlers.
+package events
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// MSearch is an SSDP search answered (or, in analyze mode, observed) by the
+// listener
+type MSearch struct {
+	Time      time.Time
+	Label     string // interface name, empty when only one interface is bound
+	Host      string
+	ST        string
+	UserAgent string // USER-AGENT header, if the searcher sent one
+	New       bool   // first search for ST from Host
+
+	// ID of the response sent, when responses are tracked
+	ResponseID string
+}
+
+// Request is an HTTP request to the spoofed device
+type Request struct {
+	Time      time.Time
+	Label     string // interface name, empty when only one interface is bound
+	Host      string
+	UserAgent string
+	Method    string
+	Path      string
+	Variant   string // A/B test page the host is shown, when the template runs a test
+	Target    string // training target the request is attributed to, in education mode
+
+	// ID of the SSDP response that led the host here, when responses are
+	// tracked
+	ResponseID string
+
+	// JA3 and JA4 fingerprints of the TLS ClientHello of the connection,
+	// empty for plain HTTP
+	JA3 string
+	JA4 string
+
+	// Body of a POST or SOAP call, up to the server's limit, base64
+	// encoded when it is binary
+	Body          string
+	BodyBinary    bool
+	BodyTruncated bool
+}
+
+// Credential sources
+const (
+	SourceForm     = "form"
+	SourceBasic    = "basic"
+	SourceNTLM     = "ntlm"        // NetNTLM response to Negotiate or NTLM auth
+	SourceKerberos = "kerberos"    // service ticket presented to Negotiate auth
+	SourceDevice   = "device-code" // tokens issued for a device code the victim entered
+	SourceExercise = "exercise"    // login form submitted in education mode, without the password
+)
+
+// Verdicts of credentials tried against a real service
+const (
+	Valid   = "valid"
+	Invalid = "invalid"
+)
+
+// Credentials are credentials submitted to a login form or through basic
+// authentication, the crackable material of Negotiate authentication, or
+// the tokens a device code phish obtained
+type Credentials struct {
+	Request
+	Source   string     // SourceForm, SourceBasic, SourceNTLM, SourceKerberos or SourceDevice
+	Username string     // DOMAIN\user for NTLM, the service principal for Kerberos
+	Password string     // the hash, in hashcat's format, for NTLM and Kerberos; the refresh token for device codes
+	Extra    url.Values // other form fields, e.g. an OTP code, or the other tokens issued
+	New      bool       // first capture of Username from Host
+
+	// Valid or Invalid when the server validates captures, "" when it does
+	// not or could not
+	Validation string
+}
+
+// Exfil kinds
+const (
+	ExfilXXE  = "xxe"  // XML parser fetched the XXE canary
+	ExfilDTD  = "dtd"  // XML parser fetched the exfiltration DTD
+	ExfilData = "data" // exfiltrated data arrived in the request path
+	ExfilFTP  = "ftp"  // exfiltrated data arrived over FTP
+	ExfilDNS  = "dns"  // exfiltrated data arrived in DNS queries
+)
+
+// Exfil is an out-of-band request triggered by an XML parser processing a
+// malicious descriptor
+type Exfil struct {
+	Request
+	Kind string // ExfilXXE, ExfilDTD, ExfilData, ExfilFTP or ExfilDNS
+	Data string // data reassembled by an out-of-band listener, if any
+}
+
+// Interaction is what a victim did on a phishing page before submitting
+// or leaving it, as reported by the analytics beacon injected into pages.
+// Path is the page reported on.
+type Interaction struct {
+	Request
+	FirstInput time.Duration // from load to the first focus, key press or click; 0 for none
+	Focus      []string      // form fields, in the order first focused
+	LastField  string        // field focused last: where an abandoned page was left
+	Duration   time.Duration // time spent on the page
+	Submitted  bool          // left by submitting a form
+}
+
+// Screenshot is a picture of a phishing page as the victim saw it on
+// submitting it, taken by the script injected into pages. Path is the page
+// pictured.
+type Screenshot struct {
+	Request
+	Image []byte // PNG
+}
+
+// Download is a file other than a page resource a host fetched from the
+// template's assets, such as a hosted payload, reported once however many
+// range requests it took. Path is the file.
+type Download struct {
+	Request
+	Size int64 // size of the file
+}
+
+// Alert kinds
+const (
+	AlertScanner = "scanner" // traffic matches a known scanner or detection tool
+	AlertOddST   = "odd-st"  // search for a malformed service type
+	AlertUnicast = "unicast" // search sent straight to this host, not multicast
+	AlertRate    = "rate"    // host is searching far faster than normal clients
+	AlertSpoofed = "spoofed" // search a real control point would not send
+	AlertRogue   = "rogue"   // host looks like a rogue SSDP responder (monitor mode)
+)
+
+// Alert warns that a host looks like a scanner or a tool hunting for
+// spoofed SSDP devices, or, in monitor mode, like a spoofed device itself
+type Alert struct {
+	Time   time.Time
+	Label  string // interface name, empty when only one interface is bound
+	Host   string
+	Kind   string // AlertScanner, AlertOddST, AlertUnicast, AlertRate, AlertSpoofed or AlertRogue
+	Detail string
+}
+
+// Events receives the interactions of hosts with the spoofed device.
+// Callbacks run on the goroutine handling the packet or request, so slow
+// work should be handed off.
+type Events interface {
+	OnMSearch(MSearch)
+	OnDescriptorFetch(Request)
+	OnPhishHook(Request)
+	OnCredentials(Credentials)
+	OnExfil(Exfil)
+	OnAlert(Alert)
+	OnInteraction(Interaction)
+	OnScreenshot(Screenshot)
+	OnDownload(Download)
+}
+
+// Nop implements Events by ignoring every event. Embed it to handle only
+// some of them.
+type Nop struct{}
+
+func (Nop) OnMSearch(MSearch)         {}
+func (Nop) OnDescriptorFetch(Request) {}
+func (Nop) OnPhishHook(Request)       {}
+func (Nop) OnCredentials(Credentials) {}
+func (Nop) OnExfil(Exfil)             {}
+func (Nop) OnAlert(Alert)             {}
+func (Nop) OnInteraction(Interaction) {}
+func (Nop) OnScreenshot(Screenshot)   {}
+func (Nop) OnDownload(Download)       {}
+
+// multi fans events out to several subscribers in order
+type multi []Events
+
+// Multi returns Events that passes every event to each subscriber in turn.
+// Nil subscribers are skipped.
+func Multi(subscribers ...Events) Events {
+	var m multi
+	for _, sub := range subscribers {
+		if sub != nil {
+			m = append(m, sub)
+		}
+	}
+	return m
+}
+
+func (m multi) OnMSearch(e MSearch) {
+	for _, sub := range m {
+		sub.OnMSearch(e)
+	}
+}
+
+func (m multi) OnDescriptorFetch(e Request) {
+	for _, sub := range m {
+		sub.OnDescriptorFetch(e)
+	}
+}
+
+func (m multi) OnPhishHook(e Request) {
+	for _, sub := range m {
+		sub.OnPhishHook(e)
+	}
+}
+
+func (m multi) OnCredentials(e Credentials) {
+	for _, sub := range m {
+		sub.OnCredentials(e)
+	}
+}
+
+func (m multi) OnExfil(e Exfil) {
+	for _, sub := range m {
+		sub.OnExfil(e)
+	}
+}
+
+func (m multi) OnAlert(e Alert) {
+	for _, sub := range m {
+		sub.OnAlert(e)
+	}
+}
+
+func (m multi) OnInteraction(e Interaction) {
+	for _, sub := range m {
+		sub.OnInteraction(e)
+	}
+}
+
+func (m multi) OnScreenshot(e Screenshot) {
+	for _, sub := range m {
+		sub.OnScreenshot(e)
+	}
+}
+
+func (m multi) OnDownload(e Download) {
+	for _, sub := range m {
+		sub.OnDownload(e)
+	}
+}
+
+// Switch passes events to a subscriber that can be replaced while events
+// are being raised, e.g. when notification targets are reloaded. The zero
+// value drops events until Set is called.
+type Switch struct {
+	current atomic.Pointer[holder]
+}
+
+// holder wraps the subscriber, as atomic.Pointer needs a concrete type
+type holder struct {
+	Events
+}
+
+// Set replaces the subscriber; nil drops events. It returns the previous
+// one so it can be closed.
+func (s *Switch) Set(e Events) Events {
+	old := s.current.Swap(&holder{e})
+	if old == nil {
+		return nil
+	}
+	return old.Events
+}
+
+// get returns the current subscriber, or Nop
+func (s *Switch) get() Events {
+	if h := s.current.Load(); h != nil && h.Events != nil {
+		return h.Events
+	}
+	return Nop{}
+}
+
+func (s *Switch) OnMSearch(e MSearch)         { s.get().OnMSearch(e) }
+func (s *Switch) OnDescriptorFetch(e Request) { s.get().OnDescriptorFetch(e) }
+func (s *Switch) OnPhishHook(e Request)       { s.get().OnPhishHook(e) }
+func (s *Switch) OnCredentials(e Credentials) { s.get().OnCredentials(e) }
+func (s *Switch) OnExfil(e Exfil)             { s.get().OnExfil(e) }
+func (s *Switch) OnAlert(e Alert)             { s.get().OnAlert(e) }
+func (s *Switch) OnInteraction(e Interaction) { s.get().OnInteraction(e) }
+func (s *Switch) OnScreenshot(e Screenshot)   { s.get().OnScreenshot(e) }
+func (s *Switch) OnDownload(e Download)       { s.get().OnDownload(e) }
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces secrets that were kept out of a record
+const Redacted = "[encrypted]"
+
+// redact masks secrets before passing events on
+type redact struct {
+	Events
+}
+
+// Redact returns Events that passes every event to sub with passwords,
+// hashes, tokens, the bodies of phishing requests and exfiltrated data
+// replaced by Redacted, and screenshots, which may show them, left out, for subscribers writing in plaintext when the
+// secrets are kept encrypted elsewhere. Usernames and the identifying part
+// of hashes are kept.
+func Redact(sub Events) Events {
+	return redact{sub}
+}
+
+func (r redact) OnPhishHook(e Request) {
+	r.Events.OnPhishHook(redactBody(e))
+}
+
+func (r redact) OnCredentials(e Credentials) {
+	e.Request = redactBody(e.Request)
+	switch e.Source {
+	case SourceNTLM:
+		// user::domain: names the account
+		if i := nthIndex(e.Password, ':', 3); i >= 0 {
+			e.Password = e.Password[:i+1] + Redacted
+		} else {
+			e.Password = Redacted
+		}
+	case SourceKerberos:
+		// $krb5tgs$etype$user$realm$spn*$ names the account and service
+		if i := strings.Index(e.Password, "*$"); i >= 0 {
+			e.Password = e.Password[:i+2] + Redacted
+		} else {
+			e.Password = Redacted
+		}
+	default:
+		if e.Password != "" {
+			e.Password = Redacted
+		}
+	}
+	if len(e.Extra) > 0 {
+		extra := make(url.Values, len(e.Extra))
+		for key := range e.Extra {
+			extra.Set(key, Redacted)
+		}
+		e.Extra = extra
+	}
+	r.Events.OnCredentials(e)
+}
+
+func (r redact) OnExfil(e Exfil) {
+	e.Request = redactBody(e.Request)
+	if e.Data != "" {
+		e.Data = Redacted
+	}
+	if e.Kind == ExfilData {
+		// The data arrived in the path
+		e.Path = Redacted
+	}
+	r.Events.OnExfil(e)
+}
+
+func (r redact) OnScreenshot(e Screenshot) {
+	e.Image = nil
+	r.Events.OnScreenshot(e)
+}
+
+// redactBody masks the body of e
+func redactBody(e Request) Request {
+	if e.Body != "" {
+		e.Body, e.BodyBinary, e.BodyTruncated = Redacted, false, false
+	}
+	return e
+}
+
+// nthIndex returns the index of the nth c in s, or -1
+func nthIndex(s string, c byte, n int) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			if n--; n == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,242 @@
+// Package fingerprint labels hosts on the local segment from their MAC
+// vendor and the SSDP/HTTP traffic they send, for the analyze mode report.
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	// neighborIP and neighborMAC find the addresses on one line of the
+	// system neighbor table, in /proc/net/arp and "arp -a" formats
+	neighborIP  = regexp.MustCompile(`\b(\d{1,3}(?:\.\d{1,3}){3})\b`)
+	neighborMAC = regexp.MustCompile(`\b([0-9A-Fa-f]{1,2}(?:[:-][0-9A-Fa-f]{1,2}){5})\b`)
+
+	// ouiLine matches the IEEE oui.txt ("00-0E-58   (hex)  Sonos, Inc.") and
+	// Wireshark manuf ("00:0E:58<TAB>Sonos<TAB>Sonos, Inc.") formats
+	ouiLine = regexp.MustCompile(`^([0-9A-Fa-f]{2})[:-]([0-9A-Fa-f]{2})[:-]([0-9A-Fa-f]{2})\s+(?:\(hex\)\s+)?(.+)$`)
+)
+
+// Fingerprinter resolves MAC addresses and vendors for hosts
+type Fingerprinter struct {
+	ouis map[string]string // "000E58" -> vendor
+
+	// neighbors returns the system neighbor table as IP -> MAC
+	neighbors func() (map[string]string, error)
+}
+
+// New creates a Fingerprinter using the built-in OUI table and the system
+// neighbor (ARP) cache
+func New() *Fingerprinter {
+	ouis := make(map[string]string, len(builtinOUIs))
+	for prefix, vendor := range builtinOUIs {
+		ouis[prefix] = vendor
+	}
+	return &Fingerprinter{ouis: ouis, neighbors: systemNeighbors}
+}
+
+// LoadOUIFile adds the vendors in an IEEE oui.txt or Wireshark manuf file,
+// overriding built-in entries
+func (f *Fingerprinter) LoadOUIFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open OUI file: %w", err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		m := ouiLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		vendor := strings.TrimSpace(m[4])
+		// Wireshark lists a short and a long name; keep the long one
+		if fields := strings.Split(vendor, "\t"); len(fields) > 1 {
+			vendor = strings.TrimSpace(fields[len(fields)-1])
+		}
+		f.ouis[strings.ToUpper(m[1]+m[2]+m[3])] = vendor
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read OUI file: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("no OUI entries found in %s", path)
+	}
+	return nil
+}
+
+// Neighbors returns the MAC addresses the system currently knows for hosts
+// on the local segment, keyed by IP. Hosts only appear once something has
+// been exchanged with them.
+func (f *Fingerprinter) Neighbors() map[string]string {
+	table, err := f.neighbors()
+	if err != nil {
+		return nil
+	}
+	return table
+}
+
+// Vendor returns the vendor registered for mac's OUI, or ""
+func (f *Fingerprinter) Vendor(mac string) string {
+	hex := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if len(hex) < 6 {
+		return ""
+	}
+	return f.ouis[hex[:6]]
+}
+
+// systemNeighbors reads the neighbor table from /proc on Linux and from
+// "arp -a" elsewhere
+func systemNeighbors() (map[string]string, error) {
+	var output []byte
+	var err error
+	if runtime.GOOS == "linux" {
+		output, err = os.ReadFile("/proc/net/arp")
+	} else {
+		output, err = exec.Command("arp", "-a").Output()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbor table: %w", err)
+	}
+	return parseNeighbors(string(output)), nil
+}
+
+// parseNeighbors extracts IP -> MAC pairs from neighbor table output,
+// skipping incomplete entries
+func parseNeighbors(output string) map[string]string {
+	table := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		ip := neighborIP.FindString(line)
+		mac := neighborMAC.FindString(line)
+		if ip == "" || mac == "" {
+			continue
+		}
+		mac = normalizeMAC(mac)
+		if mac == "00:00:00:00:00:00" || mac == "ff:ff:ff:ff:ff:ff" {
+			continue
+		}
+		table[ip] = mac
+	}
+	return table
+}
+
+// normalizeMAC formats mac as lower case, colon separated, zero padded
+// octets
+func normalizeMAC(mac string) string {
+	octets := strings.FieldsFunc(mac, func(r rune) bool { return r == ':' || r == '-' })
+	for i, octet := range octets {
+		if len(octet) == 1 {
+			octets[i] = "0" + octet
+		}
+	}
+	return strings.ToLower(strings.Join(octets, ":"))
+}
+
+// labelRules map what a host sent, and who made its network card, to a
+// description. The first matching rule wins, so specific rules come first.
+var labelRules = []struct {
+	vendor, st, ua string
+	label          string
+}{
+	{st: "ZonePlayer", label: "Sonos speaker"},
+	{vendor: "Sonos", label: "Sonos speaker"},
+	{st: "roku:ecp", label: "Roku streamer"},
+	{vendor: "Roku", label: "Roku streamer"},
+	{ua: "Xbox", label: "Xbox console"},
+	{ua: "Microsoft-Windows", label: "Windows workstation"},
+	{ua: "Windows NT", label: "Windows workstation"},
+	{ua: "Android", label: "Android device"},
+	{ua: "Darwin", label: "Apple device"},
+	{ua: "iPhone", label: "Apple device"},
+	{vendor: "Apple", label: "Apple device"},
+	{vendor: "Philips Lighting", label: "Philips Hue bridge"},
+	{vendor: "Nest", label: "Google Nest device"},
+	{st: "dial-multiscreen-org", label: "casting client (DIAL)"},
+	{vendor: "Google", label: "Google device"},
+	{vendor: "Amazon", label: "Amazon Echo/Fire device"},
+	{vendor: "Synology", label: "Synology NAS"},
+	{vendor: "QNAP", label: "QNAP NAS"},
+	{vendor: "Brother", label: "Brother printer"},
+	{vendor: "Epson", label: "Epson printer"},
+	{vendor: "Canon", label: "Canon printer"},
+	{vendor: "Raspberry Pi", label: "Raspberry Pi"},
+	{vendor: "Espressif", label: "ESP32/ESP8266 IoT device"},
+	{vendor: "VMware", label: "VMware virtual machine"},
+	{vendor: "PCS Systemtechnik", label: "VirtualBox virtual machine"},
+	{st: "MediaRenderer", label: "media player"},
+	{st: "InternetGatewayDevice", label: "host looking for a UPnP router"},
+	{ua: "Linux", label: "Linux host"},
+}
+
+// Label describes a host from its MAC vendor and the service types and user
+// agents it sent, or returns "" if nothing matches
+func Label(vendor string, sts, userAgents []string) string {
+	for _, rule := range labelRules {
+		switch {
+		case rule.vendor != "" && containsFold(vendor, rule.vendor):
+			return rule.label
+		case rule.st != "" && anyContains(sts, rule.st):
+			return rule.label
+		case rule.ua != "" && anyContains(userAgents, rule.ua):
+			return rule.label
+		}
+	}
+	if vendor != "" {
+		return vendor + " device"
+	}
+	return ""
+}
+
+// osRules map User-Agent fragments to operating systems. The first match
+// wins, so iOS and Android come before the desktop systems their user
+// agents also mention.
+var osRules = []struct {
+	ua, os string
+}{
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Windows", "Windows"},
+	{"Macintosh", "macOS"},
+	{"Mac OS X", "macOS"},
+	{"Darwin", "macOS"},
+	{"Linux", "Linux"},
+	{"X11", "Linux"},
+}
+
+// OS guesses the operating system of a client from its User-Agent, or
+// returns "" if nothing matches
+func OS(userAgent string) string {
+	for _, rule := range osRules {
+		if containsFold(userAgent, rule.ua) {
+			return rule.os
+		}
+	}
+	return ""
+}
+
+// anyContains reports whether any value contains substr, ignoring case
+func anyContains(values []string, substr string) bool {
+	for _, v := range values {
+		if containsFold(v, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
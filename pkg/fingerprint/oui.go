@@ -0,0 +1,99 @@
+package fingerprint
+
+// builtinOUIs covers vendors commonly found answering or searching over
+// SSDP. Load the full IEEE list with LoadOUIFile for anything else.
+var builtinOUIs = map[string]string{
+	// Sonos
+	"000E58": "Sonos, Inc.",
+	"5CAAFD": "Sonos, Inc.",
+	"949F3E": "Sonos, Inc.",
+	"7828CA": "Sonos, Inc.",
+	"B8E937": "Sonos, Inc.",
+	"48A6B8": "Sonos, Inc.",
+	"542A1B": "Sonos, Inc.",
+
+	// Roku
+	"B0A737": "Roku, Inc.",
+	"DC3A5E": "Roku, Inc.",
+	"CC6DA0": "Roku, Inc.",
+	"AC3A7A": "Roku, Inc.",
+	"000D4B": "Roku, Inc.",
+
+	// Apple
+	"000393": "Apple, Inc.",
+	"001EC2": "Apple, Inc.",
+	"001FF3": "Apple, Inc.",
+	"002500": "Apple, Inc.",
+	"0026BB": "Apple, Inc.",
+	"3C0754": "Apple, Inc.",
+	"A45E60": "Apple, Inc.",
+	"F01898": "Apple, Inc.",
+	"28CFE9": "Apple, Inc.",
+
+	// Microsoft (including Hyper-V virtual NICs)
+	"0050F2": "Microsoft Corporation",
+	"00125A": "Microsoft Corporation",
+	"001DD8": "Microsoft Corporation",
+	"281878": "Microsoft Corporation",
+	"7C1E52": "Microsoft Corporation",
+	"00155D": "Microsoft Corporation",
+
+	// Google and Nest
+	"001A11": "Google, Inc.",
+	"F4F5D8": "Google, Inc.",
+	"F4F5E8": "Google, Inc.",
+	"546009": "Google, Inc.",
+	"3C5AB4": "Google, Inc.",
+	"18B430": "Nest Labs Inc.",
+	"641666": "Nest Labs Inc.",
+
+	// Amazon
+	"44650D": "Amazon Technologies Inc.",
+	"F0272D": "Amazon Technologies Inc.",
+	"74C246": "Amazon Technologies Inc.",
+	"FC65DE": "Amazon Technologies Inc.",
+	"6837E9": "Amazon Technologies Inc.",
+
+	// Philips Hue
+	"001788": "Philips Lighting BV",
+	"ECB5FA": "Philips Lighting BV",
+
+	// NAS
+	"001132": "Synology Incorporated",
+	"245EBE": "QNAP Systems, Inc.",
+	"00089B": "QNAP Systems, Inc.",
+
+	// Printers
+	"001BA9": "Brother Industries, Ltd.",
+	"008077": "Brother Industries, Ltd.",
+	"000048": "Seiko Epson Corporation",
+	"64EB8C": "Seiko Epson Corporation",
+	"000085": "Canon Inc.",
+	"001E8F": "Canon Inc.",
+	"3CD92B": "Hewlett Packard",
+	"001B78": "Hewlett Packard",
+
+	// Single board computers and IoT modules
+	"B827EB": "Raspberry Pi Foundation",
+	"DCA632": "Raspberry Pi Trading Ltd",
+	"E45F01": "Raspberry Pi Trading Ltd",
+	"28CDC1": "Raspberry Pi Trading Ltd",
+	"D83ADD": "Raspberry Pi Trading Ltd",
+	"240AC4": "Espressif Inc.",
+	"30AEA4": "Espressif Inc.",
+	"A4CF12": "Espressif Inc.",
+
+	// Network gear
+	"FCECDA": "Ubiquiti Networks Inc.",
+	"24A43C": "Ubiquiti Networks Inc.",
+	"EC1A59": "Belkin International Inc.",
+	"C05627": "Belkin International Inc.",
+	"00044B": "NVIDIA",
+
+	// Virtual machines
+	"000C29": "VMware, Inc.",
+	"005056": "VMware, Inc.",
+	"000569": "VMware, Inc.",
+	"080027": "PCS Systemtechnik GmbH",
+	"525400": "QEMU virtual NIC",
+}
@@ -0,0 +1,291 @@
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TLS extensions read from a ClientHello
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extPointFormats        = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// ClientHello is what a TLS client offered, as far as JA3 and JA4 need it.
+// Lists are in the order the client sent them, GREASE values included.
+type ClientHello struct {
+	Version             uint16 // legacy_version
+	Ciphers             []uint16
+	Extensions          []uint16
+	Groups              []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+var errHello = errors.New("malformed ClientHello")
+
+// reader reads the fields of a handshake message, remembering whether any
+// ran past its end
+type reader struct {
+	b   []byte
+	bad bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.bad || n > len(r.b) {
+		r.bad = true
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) u8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *reader) u16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) u24() int {
+	if b := r.bytes(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// sub splits off a field of n bytes to be read on its own
+func (r *reader) sub(n int) *reader {
+	b := r.bytes(n)
+	return &reader{b: b, bad: r.bad}
+}
+
+// u16s reads a list of 16-bit values filling the reader
+func (r *reader) u16s() []uint16 {
+	var values []uint16
+	for len(r.b) >= 2 && !r.bad {
+		values = append(values, uint16(r.u16()))
+	}
+	if len(r.b) != 0 {
+		r.bad = true
+	}
+	return values
+}
+
+// ParseClientHello parses a ClientHello handshake message, as carried in
+// one or more TLS records
+func ParseClientHello(msg []byte) (*ClientHello, error) {
+	r := &reader{b: msg}
+	if r.u8() != 1 {
+		return nil, errors.New("not a ClientHello")
+	}
+	body := r.sub(r.u24())
+	h := &ClientHello{Version: uint16(body.u16())}
+	body.bytes(32)        // random
+	body.bytes(body.u8()) // session ID
+	h.Ciphers = body.sub(body.u16()).u16s()
+	body.bytes(body.u8()) // compression methods
+	if body.bad {
+		return nil, errHello
+	}
+	if len(body.b) == 0 {
+		return h, nil // no extensions
+	}
+	exts := body.sub(body.u16())
+	for len(exts.b) > 0 && !exts.bad {
+		typ := uint16(exts.u16())
+		data := exts.sub(exts.u16())
+		h.Extensions = append(h.Extensions, typ)
+		switch typ {
+		case extServerName:
+			names := data.sub(data.u16())
+			for len(names.b) > 0 && !names.bad {
+				kind := names.u8()
+				name := names.bytes(names.u16())
+				if kind == 0 && h.ServerName == "" {
+					h.ServerName = string(name)
+				}
+			}
+			data.bad = data.bad || names.bad
+		case extSupportedGroups:
+			h.Groups = data.sub(data.u16()).u16s()
+		case extPointFormats:
+			h.PointFormats = append([]uint8(nil), data.bytes(data.u8())...)
+		case extSignatureAlgorithms:
+			h.SignatureAlgorithms = data.sub(data.u16()).u16s()
+		case extALPN:
+			protos := data.sub(data.u16())
+			for len(protos.b) > 0 && !protos.bad {
+				h.ALPN = append(h.ALPN, string(protos.bytes(protos.u8())))
+			}
+			data.bad = data.bad || protos.bad
+		case extSupportedVersions:
+			h.SupportedVersions = data.sub(data.u8()).u16s()
+		}
+		if data.bad {
+			return nil, errHello
+		}
+	}
+	if exts.bad {
+		return nil, errHello
+	}
+	return h, nil
+}
+
+// grease reports whether v is one of the GREASE values clients send to keep
+// servers tolerant of unknown ones (RFC 8701)
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGrease returns values without the GREASE ones
+func withoutGrease(values []uint16) []uint16 {
+	kept := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !grease(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// join returns values in decimal, or lower-case hex for JA4, joined by sep
+func join(values []uint16, hexadecimal bool, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		if hexadecimal {
+			parts[i] = fmt.Sprintf("%04x", v)
+		} else {
+			parts[i] = strconv.Itoa(int(v))
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// JA3String returns the fields the JA3 fingerprint is the MD5 of: version,
+// ciphers, extensions, groups and point formats, without GREASE values
+func (h *ClientHello) JA3String() string {
+	formats := make([]uint16, len(h.PointFormats))
+	for i, f := range h.PointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.Version)),
+		join(withoutGrease(h.Ciphers), false, "-"),
+		join(withoutGrease(h.Extensions), false, "-"),
+		join(withoutGrease(h.Groups), false, "-"),
+		join(formats, false, "-"),
+	}, ",")
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello
+func (h *ClientHello) JA3() string {
+	sum := md5.Sum([]byte(h.JA3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, as received over TCP
+func (h *ClientHello) JA4() string {
+	// The highest version offered, from supported_versions if the client
+	// sent it
+	version := h.Version
+	if offered := withoutGrease(h.SupportedVersions); len(offered) > 0 {
+		version = 0
+		for _, v := range offered {
+			version = max(version, v)
+		}
+	}
+	sni := "i"
+	if h.ServerName != "" {
+		sni = "d"
+	}
+	ciphers := withoutGrease(h.Ciphers)
+	extensions := withoutGrease(h.Extensions)
+
+	// The extensions hashed leave out the server name and ALPN, which the
+	// first part already shows
+	hashed := make([]uint16, 0, len(extensions))
+	for _, e := range extensions {
+		if e != extServerName && e != extALPN {
+			hashed = append(hashed, e)
+		}
+	}
+	sortedCiphers := append([]uint16(nil), ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+	sort.Slice(hashed, func(i, j int) bool { return hashed[i] < hashed[j] })
+	extPart := join(hashed, true, ",")
+	if algs := withoutGrease(h.SignatureAlgorithms); len(algs) > 0 {
+		extPart += "_" + join(algs, true, ",")
+	}
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s",
+		tlsVersion(version), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn(h.ALPN),
+		truncatedHash(join(sortedCiphers, true, ","), len(ciphers) == 0),
+		truncatedHash(extPart, len(hashed) == 0))
+}
+
+// tlsVersion returns the JA4 code of a protocol version
+func tlsVersion(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// alpn returns the first and last characters of the first protocol
+// offered, or of its hex when either is not alphanumeric, "00" for none
+func alpn(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+	p := protos[0]
+	if !alphanumeric(p[0]) || !alphanumeric(p[len(p)-1]) {
+		p = hex.EncodeToString([]byte(p))
+	}
+	return string([]byte{p[0], p[len(p)-1]})
+}
+
+func alphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// truncatedHash returns the first 12 hex digits of the SHA-256 of s, or
+// zeros when there was nothing to hash
+func truncatedHash(s string, none bool) string {
+	if none {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
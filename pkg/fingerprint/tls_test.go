@@ -0,0 +1,105 @@
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// extension is a TLS extension of a ClientHello built for a test
+type extension struct {
+	typ  uint16
+	data []byte
+}
+
+// u16s encodes values as a list with a length of lenBytes bytes
+func u16s(lenBytes int, values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return withLength(lenBytes, b)
+}
+
+func withLength(lenBytes int, b []byte) []byte {
+	if lenBytes == 1 {
+		return append([]byte{byte(len(b))}, b...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
+
+// clientHello builds a ClientHello handshake message
+func clientHello(ciphers []uint16, exts ...extension) []byte {
+	body := []byte{3, 3}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0)
+	body = append(body, u16s(2, ciphers...)...)
+	body = append(body, 1, 0)
+	var e []byte
+	for _, ext := range exts {
+		e = binary.BigEndian.AppendUint16(e, ext.typ)
+		e = append(e, withLength(2, ext.data)...)
+	}
+	body = append(body, withLength(2, e)...)
+	return append([]byte{1, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+func hash12(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func TestClientHello(t *testing.T) {
+	sni := withLength(2, append([]byte{0}, withLength(2, []byte("example.com"))...))
+	alpn := withLength(2, append(withLength(1, []byte("h2")), withLength(1, []byte("http/1.1"))...))
+	msg := clientHello([]uint16{0x0a0a, 0x1301, 0xc02b, 0x002f},
+		extension{0x1a1a, nil},
+		extension{extServerName, sni},
+		extension{extSupportedGroups, u16s(2, 0x2a2a, 0x001d, 0x0017)},
+		extension{extPointFormats, []byte{1, 0}},
+		extension{extSignatureAlgorithms, u16s(2, 0x0403, 0x0804)},
+		extension{extALPN, alpn},
+		extension{extSupportedVersions, u16s(1, 0x3a3a, 0x0304, 0x0303)},
+	)
+	h, err := ParseClientHello(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ServerName != "example.com" || len(h.ALPN) != 2 || h.ALPN[1] != "http/1.1" {
+		t.Errorf("parsed %+v", h)
+	}
+	if got, want := h.JA3String(), "771,4865-49195-47,0-10-11-13-16-43,29-23,0"; got != want {
+		t.Errorf("JA3 string %s, want %s", got, want)
+	}
+	want := "t13d0306h2_" + hash12("002f,1301,c02b") + "_" + hash12("000a,000b,000d,002b_0403,0804")
+	if got := h.JA4(); got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+
+	// Every truncation is refused, without panicking
+	for n := 0; n < len(msg); n++ {
+		if _, err := ParseClientHello(msg[:n]); err == nil {
+			t.Errorf("parsed the first %d of %d bytes", n, len(msg))
+		}
+	}
+}
+
+func TestJA4Bare(t *testing.T) {
+	// TLS 1.2 without SNI, ALPN or extensions
+	h, err := ParseClientHello(clientHello([]uint16{0x002f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.JA4(), "t12i010000_"+hash12("002f")+"_000000000000"; got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+	for _, tt := range []struct{ proto, want string }{
+		{"http/1.1", "h1"},
+		{"\x00ab\xff", "0f"},
+	} {
+		if got := alpn([]string{tt.proto}); got != tt.want {
+			t.Errorf("ALPN %q gave %s, want %s", tt.proto, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package inventory accumulates the hosts seen searching for and fetching
+// from the spoofed device, for the analyze mode report.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/fingerprint"
+)
+
+// Host is everything observed from one address
+type Host struct {
+	Address      string    `json:"address"`
+	Interface    string    `json:"interface,omitempty"`
+	MAC          string    `json:"mac,omitempty"`
+	Vendor       string    `json:"vendor,omitempty"`
+	Label        string    `json:"label,omitempty"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Searches     int       `json:"searches"`
+	HTTPRequests int       `json:"http_requests"`
+	STs          []string  `json:"search_targets"`
+	UserAgents   []string  `json:"user_agents"`
+
+	// MeanInterval is the average time between searches, zero with fewer
+	// than two searches
+	MeanInterval time.Duration `json:"mean_interval_ns"`
+}
+
+// Inventory is an events subscriber that records every host it hears from
+type Inventory struct {
+	events.Nop
+	mu    sync.Mutex
+	hosts map[string]*Host
+	fp    *fingerprint.Fingerprinter
+}
+
+// New creates an empty inventory. When fp is not nil, hosts are labelled
+// with their MAC address, vendor and a guess at what they are.
+func New(fp *fingerprint.Fingerprinter) *Inventory {
+	return &Inventory{hosts: make(map[string]*Host), fp: fp}
+}
+
+// OnMSearch records a search
+func (inv *Inventory) OnMSearch(e events.MSearch) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	h := inv.host(e.Host, e.Label, e.Time)
+	h.Searches++
+	h.STs = addUnique(h.STs, e.ST)
+	h.UserAgents = addUnique(h.UserAgents, e.UserAgent)
+	if h.Searches > 1 {
+		h.MeanInterval = e.Time.Sub(h.FirstSeen) / time.Duration(h.Searches-1)
+	}
+}
+
+// OnDescriptorFetch records a descriptor request
+func (inv *Inventory) OnDescriptorFetch(e events.Request) {
+	inv.request(e)
+}
+
+// OnPhishHook records a phishing page request
+func (inv *Inventory) OnPhishHook(e events.Request) {
+	inv.request(e)
+}
+
+// request records an HTTP request
+func (inv *Inventory) request(e events.Request) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	h := inv.host(e.Host, e.Label, e.Time)
+	h.HTTPRequests++
+	h.UserAgents = addUnique(h.UserAgents, e.UserAgent)
+}
+
+// host returns the entry for address, creating it if needed, with its last
+// seen time updated to t
+func (inv *Inventory) host(address, label string, t time.Time) *Host {
+	h, ok := inv.hosts[address]
+	if !ok {
+		h = &Host{Address: address, Interface: label, FirstSeen: t}
+		inv.hosts[address] = h
+	}
+	h.LastSeen = t
+	return h
+}
+
+// addUnique appends value to list unless it is empty or already present
+func addUnique(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Hosts returns a copy of every recorded host, sorted by first seen
+func (inv *Inventory) Hosts() []Host {
+	var neighbors map[string]string
+	if inv.fp != nil {
+		neighbors = inv.fp.Neighbors()
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	hosts := make([]Host, 0, len(inv.hosts))
+	for _, h := range inv.hosts {
+		if inv.fp != nil {
+			inv.fingerprint(h, neighbors)
+		}
+		c := *h
+		c.STs = append([]string(nil), h.STs...)
+		c.UserAgents = append([]string(nil), h.UserAgents...)
+		hosts = append(hosts, c)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].FirstSeen.Before(hosts[j].FirstSeen)
+	})
+	return hosts
+}
+
+// fingerprint fills in the MAC address, vendor and label of h. A MAC, once
+// found, is kept even after the neighbor entry expires.
+func (inv *Inventory) fingerprint(h *Host, neighbors map[string]string) {
+	if mac, ok := neighbors[h.Address]; ok {
+		h.MAC = mac
+	}
+	if h.MAC != "" {
+		h.Vendor = inv.fp.Vendor(h.MAC)
+	}
+	h.Label = fingerprint.Label(h.Vendor, h.STs, h.UserAgents)
+}
+
+// WriteJSON writes the inventory as an indented JSON array
+func (inv *Inventory) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(inv.Hosts())
+}
+
+// WriteTable writes the inventory as a human-readable table
+func (inv *Inventory) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HOST\tMAC\tLABEL\tFIRST SEEN\tLAST SEEN\tSEARCHES\tINTERVAL\tHTTP\tSERVICE TYPES\tUSER AGENTS")
+	for _, h := range inv.Hosts() {
+		interval := "-"
+		if h.MeanInterval > 0 {
+			interval = h.MeanInterval.Round(time.Second).String()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\t%s\n",
+			h.Address,
+			dash(h.MAC),
+			dash(h.Label),
+			h.FirstSeen.Format(time.RFC3339),
+			h.LastSeen.Format(time.RFC3339),
+			h.Searches,
+			interval,
+			h.HTTPRequests,
+			strings.Join(h.STs, ", "),
+			strings.Join(h.UserAgents, " | "))
+	}
+	return table.Flush()
+}
+
+// dash returns s, or "-" if it is empty
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Save writes the inventory to prefix.json and prefix.txt
+func (inv *Inventory) Save(prefix string) error {
+	if err := os.MkdirAll(filepath.Dir(prefix), 0755); err != nil {
+		return fmt.Errorf("failed to create inventory directory: %w", err)
+	}
+
+	for _, out := range []struct {
+		ext   string
+		write func(io.Writer) error
+	}{
+		{".json", inv.WriteJSON},
+		{".txt", inv.WriteTable},
+	} {
+		file, err := os.Create(prefix + out.ext)
+		if err != nil {
+			return fmt.Errorf("failed to create inventory: %w", err)
+		}
+		if err := out.write(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write inventory: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to write inventory: %w", err)
+		}
+	}
+	return nil
+}
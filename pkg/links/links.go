@@ -0,0 +1,172 @@
+// Package links keeps the short links, /l/TOKEN on the server, that lures
+// delivered over e-mail or chat point at. Each redirects to a page of the
+// template, or anywhere else, so every click is logged on the way to the
+// phishing page. Links are kept in a file the links command edits and a
+// running server reads again whenever it changes.
+package links
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is the file links are kept in, relative to the working directory
+const Path = "links.json"
+
+// Prefix is the path short links are served under
+const Prefix = "/l/"
+
+// DefaultTarget is where a link redirects unless it says otherwise: the
+// template's phishing page
+const DefaultTarget = "/present.html"
+
+// tokenPattern is what a token may look like, to be usable in a path
+var tokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Link is a short link and where it redirects
+type Link struct {
+	Token   string    `json:"token"`
+	Target  string    `json:"target"`         // path on the server or absolute http(s) URL
+	Name    string    `json:"name,omitempty"` // what the operator sent it as, e.g. "newsletter"
+	Created time.Time `json:"created"`
+}
+
+// ValidToken reports whether token can name a link
+func ValidToken(token string) bool {
+	return tokenPattern.MatchString(token)
+}
+
+// NewToken returns a random token, short enough to type
+func NewToken() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(b)), nil
+}
+
+// CheckTarget checks a link can redirect to target: a path on the server
+// or an absolute http or https URL
+func CheckTarget(target string) error {
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid link target %q: give a path such as %s or an http(s) URL", target, DefaultTarget)
+	}
+	return nil
+}
+
+// Load reads the links kept at path, none if there is no file yet
+func Load(path string) ([]Link, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read links file: %w", err)
+	}
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse links file %s: %w", path, err)
+	}
+	return links, nil
+}
+
+// Save writes links to path, replacing the file whole so a running server
+// never reads it half written
+func Save(path string, links []Link) error {
+	if links == nil {
+		links = []Link{}
+	}
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode links: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create links directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save links: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save links: %w", err)
+	}
+	return nil
+}
+
+// Table is the links of a file as a running server sees them, read again
+// whenever the file changes
+type Table struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	links   map[string]Link
+}
+
+// NewTable returns the table of the links kept at path
+func NewTable(path string) *Table {
+	return &Table{path: path}
+}
+
+// Path returns the file the table reads
+func (t *Table) Path() string {
+	return t.path
+}
+
+// Lookup returns the link named token. A file that cannot be read is
+// reported, and the links read from it before are kept.
+func (t *Table) Lookup(token string) (Link, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.refresh()
+	link, ok := t.links[token]
+	return link, ok, err
+}
+
+// refresh reads the file again if it changed since it was last read
+func (t *Table) refresh() error {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.links, t.modTime, t.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read links file: %w", err)
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return nil
+	}
+	links, err := Load(t.path)
+	if err != nil {
+		return err
+	}
+	t.links = make(map[string]Link, len(links))
+	for _, link := range links {
+		t.links[link.Token] = link
+	}
+	t.modTime, t.size = info.ModTime(), info.Size()
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package logging provides the logger the listener and server write their
+// console and log file output through.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Logger receives console output. Log writes one line, LogRaw writes message
+// as is.
+type Logger interface {
+	Log(format string, args ...interface{})
+	LogRaw(message string)
+}
+
+// Level orders console output by importance. Each line is logged at a level
+// and printed when the logger's level is at least as high.
+type Level int
+
+const (
+	LevelQuiet   Level = iota - 1 // warnings, alerts and captures only
+	LevelNormal                   // plus hosts, requests and status, the default
+	LevelVerbose                  // plus every search, not only new ones
+	LevelDebug                    // plus per-packet and per-asset detail
+)
+
+// LevelLogger is a Logger that filters lines by level
+type LevelLogger interface {
+	Logger
+	LogLevel(level Level, format string, args ...interface{})
+}
+
+// LogAt logs a line at level through l. Loggers that do not filter by level
+// get every line.
+func LogAt(l Logger, level Level, format string, args ...interface{}) {
+	if leveled, ok := l.(LevelLogger); ok {
+		leveled.LogLevel(level, format, args...)
+		return
+	}
+	l.Log(format, args...)
+}
+
+// Notice logs a line that is printed even in quiet mode
+func Notice(l Logger, format string, args ...interface{}) {
+	LogAt(l, LevelQuiet, format, args...)
+}
+
+// Verbose logs a line that is only printed with -v
+func Verbose(l Logger, format string, args ...interface{}) {
+	LogAt(l, LevelVerbose, format, args...)
+}
+
+// Debug logs a line that is only printed with -vv
+func Debug(l Logger, format string, args ...interface{}) {
+	LogAt(l, LevelDebug, format, args...)
+}
+
+// Recorder is a Logger that can write a line to its log file without
+// printing it, for repeats that would only clutter the console
+type Recorder interface {
+	Record(format string, args ...interface{})
+}
+
+// Record writes a line to the log file of l, printing it only with -v.
+// Loggers without a log file print it as a verbose line.
+func Record(l Logger, format string, args ...interface{}) {
+	if recorder, ok := l.(Recorder); ok {
+		recorder.Record(format, args...)
+		return
+	}
+	Verbose(l, format, args...)
+}
+
+// Discard is a Logger that drops everything
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Log(string, ...interface{}) {}
+func (discard) LogRaw(string)              {}
+
+// UTCLogger provides comprehensive logging with UTC timestamps. The zero
+// value logs to stdout only, at LevelNormal.
+type UTCLogger struct {
+	console io.Writer
+	logFile *os.File
+	level   Level
+	mutex   sync.Mutex
+}
+
+var (
+	_ LevelLogger = (*UTCLogger)(nil)
+	_ Recorder    = (*UTCLogger)(nil)
+)
+
+// NewConsoleLogger creates a logger that writes to w only
+func NewConsoleLogger(w io.Writer) *UTCLogger {
+	return &UTCLogger{console: w}
+}
+
+// NewUTCLogger creates a logger that writes to the console and appends to
+// the log file at path, creating its directory if needed
+func NewUTCLogger(path string) (*UTCLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	return &UTCLogger{logFile: logFile}, nil
+}
+
+// SetLevel sets how much is printed to the console. The log file records
+// every line up to LevelNormal whatever the level, and more when it is
+// raised above that.
+func (l *UTCLogger) SetLevel(level Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+}
+
+// Log logs a message with UTC timestamp to both console and file
+func (l *UTCLogger) Log(format string, args ...interface{}) {
+	l.LogLevel(LevelNormal, format, args...)
+}
+
+// LogLevel logs a message at level, printing it to the console if the
+// logger's level allows and writing it to the log file
+func (l *UTCLogger) LogLevel(level Level, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if level > l.level && level > LevelNormal {
+		return
+	}
+	l.write(level <= l.level, fmt.Sprintf(format, args...))
+}
+
+// Record writes a message to the log file, printing it to the console only
+// when verbose
+func (l *UTCLogger) Record(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(l.level >= LevelVerbose, fmt.Sprintf(format, args...))
+}
+
+// write writes message to the log file, and to the console if print is set.
+// The caller holds the mutex.
+func (l *UTCLogger) write(print bool, message string) {
+	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
+
+	// Print to console (no timestamp)
+	if print {
+		fmt.Fprintf(l.writer(), "%s\n", message)
+	}
+
+	// Write to log file with timestamp and stripped ANSI codes
+	if l.logFile != nil {
+		cleanMessage := StripANSI(message)
+		logLine := fmt.Sprintf("[%s] %s\n", timestamp, cleanMessage)
+		l.logFile.WriteString(logLine)
+		l.logFile.Sync()
+	}
+}
+
+// LogRaw logs a raw message with UTC timestamp (no extra formatting)
+func (l *UTCLogger) LogRaw(message string) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
+
+	// Print to console (raw, no timestamp)
+	if l.level >= LevelNormal {
+		fmt.Fprint(l.writer(), message)
+	}
+
+	// Write to log file with timestamp and stripped ANSI codes
+	if l.logFile != nil {
+		cleanMessage := StripANSI(message)
+		logLine := fmt.Sprintf("[%s] %s", timestamp, cleanMessage)
+		l.logFile.WriteString(logLine)
+		l.logFile.Sync()
+	}
+}
+
+// writer returns the console writer, stdout unless set
+func (l *UTCLogger) writer() io.Writer {
+	if l.console != nil {
+		return l.console
+	}
+	return os.Stdout
+}
+
+// Close closes the logger resources
+func (l *UTCLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.logFile != nil {
+		err := l.logFile.Close()
+		l.logFile = nil
+		return err
+	}
+	return nil
+}
+
+// IsTerminal reports whether f is attached to a terminal rather than a
+// pipe, file or container log driver
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ansiRegex matches ANSI color codes and control sequences
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[mGKHF]`)
+
+// StripANSI removes ANSI escape sequences from text
+func StripANSI(text string) string {
+	return ansiRegex.ReplaceAllString(text, "")
+}
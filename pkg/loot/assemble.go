@@ -0,0 +1,106 @@
+package loot
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// maxChunks caps the chunks kept per transfer, so a runaway client cannot
+// fill memory
+const maxChunks = 4096
+
+// Transfer is data a host sent in numbered chunks under an ID
+type Transfer struct {
+	Request events.Request // the request carrying the first chunk
+	ID      string
+	Chunks  map[int]string
+	last    time.Time
+	timer   *time.Timer
+}
+
+// Data returns the chunks in order, concatenated, and how many are missing
+// between the first and the last
+func (t *Transfer) Data() (string, int) {
+	seqs := make([]int, 0, len(t.Chunks))
+	for seq := range t.Chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	var data strings.Builder
+	for _, seq := range seqs {
+		data.WriteString(t.Chunks[seq])
+	}
+	missing := 0
+	if len(seqs) > 0 {
+		missing = seqs[len(seqs)-1] - seqs[0] + 1 - len(seqs)
+	}
+	return data.String(), missing
+}
+
+// Assembler collects chunked transfers and hands each to done once no chunk
+// of it has arrived for the idle time. Chunks seen before, as sent again
+// by retrying clients and resolvers, are ignored.
+type Assembler struct {
+	idle      time.Duration
+	done      func(*Transfer)
+	mu        sync.Mutex
+	transfers map[string]*Transfer
+}
+
+// NewAssembler creates an Assembler calling done for each finished transfer
+func NewAssembler(idle time.Duration, done func(*Transfer)) *Assembler {
+	return &Assembler{idle: idle, done: done, transfers: make(map[string]*Transfer)}
+}
+
+// Add adds chunk seq of transfer id, carried by request r, reporting
+// whether it started the transfer. Transfers are told apart by ID and host.
+func (a *Assembler) Add(r events.Request, id string, seq int, data string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := r.Host + " " + id
+	t, ok := a.transfers[key]
+	if !ok {
+		t = &Transfer{Request: r, ID: id, Chunks: make(map[int]string)}
+		t.timer = time.AfterFunc(a.idle, func() { a.finish(key, t) })
+		a.transfers[key] = t
+	} else {
+		t.timer.Reset(a.idle)
+	}
+	t.last = time.Now()
+	if _, seen := t.Chunks[seq]; !seen && len(t.Chunks) < maxChunks {
+		t.Chunks[seq] = data
+	}
+	return !ok
+}
+
+// finish hands t to done unless it was already, or a chunk came in since
+// its timer fired
+func (a *Assembler) finish(key string, t *Transfer) {
+	a.mu.Lock()
+	if a.transfers[key] != t || time.Since(t.last) < a.idle {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.transfers, key)
+	a.mu.Unlock()
+	a.done(t)
+}
+
+// Flush hands every transfer in progress to done at once, for shutdown
+func (a *Assembler) Flush() {
+	a.mu.Lock()
+	pending := a.transfers
+	a.transfers = make(map[string]*Transfer)
+	a.mu.Unlock()
+
+	for _, t := range pending {
+		t.timer.Stop()
+		a.done(t)
+	}
+}
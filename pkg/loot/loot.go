@@ -0,0 +1,316 @@
+// Package loot keeps exfiltrated data on disk, one file per transfer under
+// a directory per host, so it can be read whole instead of out of the log,
+// along with the hashes hosts hand over, ready for cracking. Given an
+// OpenPGP key, it encrypts every file to it.
+package loot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"goSSDPkit/pkg/creds"
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/pgp"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Dir is where loot is kept, relative to the working directory
+const Dir = "loot"
+
+// Gap is how long a host may go quiet before the next data it sends starts
+// a new file. Parsers that split a file over several requests send them
+// back to back.
+const Gap = 5 * time.Second
+
+// previewLength is how many characters of the data are shown in the log
+const previewLength = 64
+
+// Saver is an events subscriber that writes the data of exfiltration
+// events to DIR/HOST/TIMESTAMP.txt, appending data the same host sends to
+// the same path within Gap to the same file on a line of its own, the
+// hashes of Negotiate authentication to DIR/HOST/hashcat-MODE.txt, and
+// screenshots of submitted pages to DIR/HOST/screenshot-TIMESTAMP.png.
+//
+// With a key, every file is an OpenPGP message ending in .gpg, rewritten
+// whole when data is appended, and each hash gets a file of its own, as
+// encrypted files cannot be appended to. Form and Basic auth credentials,
+// which the log then leaves out, are saved too.
+type Saver struct {
+	events.Nop
+	dir    string
+	key    *pgp.Key
+	logger logging.Logger
+	mu     sync.Mutex
+	recent map[string]*transfer
+}
+
+// transfer is the file a host's data is going to
+type transfer struct {
+	path string
+	last time.Time
+	size int
+	data []byte // what the file holds, when it is encrypted
+}
+
+var _ events.Events = (*Saver)(nil)
+
+// NewSaver creates a Saver writing under dir and reporting to logger,
+// encrypting to key unless it is nil
+func NewSaver(dir string, key *pgp.Key, logger logging.Logger) *Saver {
+	return &Saver{dir: dir, key: key, logger: logger, recent: make(map[string]*transfer)}
+}
+
+// OnExfil saves the data of e, if it carries any
+func (s *Saver) OnExfil(e events.Exfil) {
+	if e.Data == "" {
+		return
+	}
+	path, size, appended, err := s.save(e.Host, e.Kind+" "+e.Path, e.Data, e.Time)
+	if err != nil {
+		logging.Notice(s.logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	if appended {
+		s.logger.Log("%sAppended %d bytes from %s to %s", ssdp.OkBox, len(e.Data), e.Host, path)
+		return
+	}
+	s.logger.Log("%sSaved %d bytes from %s to %s", ssdp.OkBox, size, e.Host, path)
+}
+
+// OnScreenshot saves the picture of a submitted page to
+// DIR/HOST/screenshot-TIMESTAMP.png
+func (s *Saver) OnScreenshot(e events.Screenshot) {
+	if len(e.Image) == 0 {
+		return
+	}
+	path, err := s.saveFile(e.Host, "screenshot-"+e.Time.UTC().Format(stamp), ".png", e.Image)
+	if err != nil {
+		logging.Notice(s.logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	s.logger.Log("%sSaved screenshot of %s from %s to %s", ssdp.OkBox, e.Path, e.Host, path)
+}
+
+// OnCredentials saves NetNTLM and Kerberos hashes to DIR/HOST/hashcat-MODE.txt,
+// one per line, ready for cracking, and device code tokens as JSON to
+// DIR/HOST/TIMESTAMP.txt. Plaintext credentials are in the log, unless
+// the loot is encrypted.
+func (s *Saver) OnCredentials(c events.Credentials) {
+	switch c.Source {
+	case events.SourceDevice:
+		s.saveTokens(c)
+		return
+	case events.SourceForm, events.SourceBasic:
+		if s.key != nil {
+			s.saveCredentials(c)
+		}
+		return
+	case events.SourceExercise:
+		return
+	}
+	h := creds.HashOf(c.Source, c.Username, c.Password)
+	path, err := s.saveHash(c.Host, h, c.Time)
+	if err != nil {
+		logging.Notice(s.logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	s.logger.Log("%sSaved %s hash of %s to %s", ssdp.OkBox, c.Source, c.Username, path)
+}
+
+// saveTokens saves the tokens of a device code phish where tools replaying
+// them can read them
+func (s *Saver) saveTokens(c events.Credentials) {
+	tokens := map[string]string{"username": c.Username, "refresh_token": c.Password}
+	for key := range c.Extra {
+		tokens[key] = c.Extra.Get(key)
+	}
+	data, _ := json.MarshalIndent(tokens, "", "  ")
+	path, _, _, err := s.save(c.Host, c.Source, string(data), c.Time)
+	if err != nil {
+		logging.Notice(s.logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	s.logger.Log("%sSaved device code tokens of %s to %s", ssdp.OkBox, c.Username, path)
+}
+
+// saveCredentials saves form and Basic auth credentials as JSON, for when
+// the log leaves out their passwords
+func (s *Saver) saveCredentials(c events.Credentials) {
+	fields := map[string]string{"source": c.Source, "username": c.Username, "password": c.Password}
+	for key := range c.Extra {
+		fields[key] = c.Extra.Get(key)
+	}
+	data, _ := json.MarshalIndent(fields, "", "  ")
+	path, _, _, err := s.save(c.Host, c.Source, string(data), c.Time)
+	if err != nil {
+		logging.Notice(s.logger, "%s%v", ssdp.WarnBox, err)
+		return
+	}
+	s.logger.Log("%sSaved %s credentials of %s to %s", ssdp.OkBox, c.Source, c.Username, path)
+}
+
+// saveHash appends h to host's file for its hashcat mode, or saves it to
+// a file of its own when encrypting
+func (s *Saver) saveHash(host string, h creds.Hash, at time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, safeName(host))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create loot directory: %w", err)
+	}
+	name := fmt.Sprintf("hashcat-%d", h.HashcatMode)
+	if s.key != nil {
+		path := newFile(dir, name+"-"+at.UTC().Format(stamp), ".txt.gpg")
+		return path, s.writeEncrypted(path, []byte(h.Line()+"\n"))
+	}
+	path := filepath.Join(dir, name+".txt")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to save loot: %w", err)
+	}
+	_, err = file.WriteString(h.Line() + "\n")
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to save loot: %w", err)
+	}
+	return path, nil
+}
+
+// saveFile writes data from host to a new file named name, encrypted when
+// the loot is
+func (s *Saver) saveFile(host, name, ext string, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, safeName(host))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create loot directory: %w", err)
+	}
+	if s.key != nil {
+		path := newFile(dir, name, ext+".gpg")
+		return path, s.writeEncrypted(path, data)
+	}
+	path := newFile(dir, name, ext)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to save loot: %w", err)
+	}
+	return path, nil
+}
+
+// save appends data from host to its current file for source, or a new
+// one, returning the file, its size and whether it already held data
+func (s *Saver) save(host, source, data string, at time.Time) (string, int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := host + " " + source
+	t, ok := s.recent[key]
+	if !ok || at.Sub(t.last) > Gap {
+		dir := filepath.Join(s.dir, safeName(host))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", 0, false, fmt.Errorf("failed to create loot directory: %w", err)
+		}
+		ext := ".txt"
+		if s.key != nil {
+			ext = ".txt.gpg"
+		}
+		t = &transfer{path: newFile(dir, at.UTC().Format(stamp), ext)}
+		s.recent[key] = t
+	}
+	for k, other := range s.recent {
+		if at.Sub(other.last) > Gap && other != t {
+			delete(s.recent, k)
+		}
+	}
+
+	if t.size > 0 {
+		data = "\n" + data
+	}
+	n := len(data)
+	if s.key != nil {
+		t.data = append(t.data, data...)
+		if err := s.writeEncrypted(t.path, t.data); err != nil {
+			return "", 0, false, err
+		}
+	} else {
+		file, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("failed to save loot: %w", err)
+		}
+		_, err = file.WriteString(data)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", 0, false, fmt.Errorf("failed to save loot: %w", err)
+		}
+	}
+	appended := t.size > 0
+	t.last = at
+	t.size += n
+	return t.path, t.size, appended, nil
+}
+
+// writeEncrypted replaces the file at path with data encrypted to the key
+func (s *Saver) writeEncrypted(path string, data []byte) error {
+	msg, err := s.key.Encrypt(strings.TrimSuffix(filepath.Base(path), ".gpg"), data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt loot: %w", err)
+	}
+	if err := os.WriteFile(path, msg, 0600); err != nil {
+		return fmt.Errorf("failed to save loot: %w", err)
+	}
+	return nil
+}
+
+// stamp is the time format of loot file names
+const stamp = "20060102T150405.000Z"
+
+// newFile returns an unused file name in dir starting with name and ending
+// with ext
+func newFile(dir, name, ext string) string {
+	path := filepath.Join(dir, name+ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, name+"-"+strconv.Itoa(i)+ext)
+	}
+}
+
+// safeName turns a host, which may come from a forged header, into a
+// directory name
+func safeName(host string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, host)
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// Preview returns the start of data quoted for the log, with its length
+// when it is cut short
+func Preview(data string) string {
+	if utf8.RuneCountInString(data) <= previewLength {
+		return strconv.Quote(data)
+	}
+	runes := []rune(data)
+	return fmt.Sprintf("%s... (%d bytes)", strconv.Quote(string(runes[:previewLength])), len(data))
+}
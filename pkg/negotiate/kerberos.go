@@ -0,0 +1,135 @@
+package negotiate
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kerberos encryption types a service ticket can be cracked under
+const (
+	ETypeAES128 = 17
+	ETypeAES256 = 18
+	ETypeRC4    = 23
+)
+
+// Hashcat modes of Kerberos service tickets, by encryption type
+var HashcatTicket = map[int]int{
+	ETypeRC4:    13100,
+	ETypeAES128: 19600,
+	ETypeAES256: 19700,
+}
+
+// JohnTicket is the John the Ripper format of RC4 service tickets; John
+// has none for AES ones
+const JohnTicket = "krb5tgs"
+
+// TicketUser stands in for the client in ticket hashes, as its name is in
+// the encrypted part
+const TicketUser = "user"
+
+// apReq is a Kerberos AP-REQ, inside its APPLICATION 14 tag.
+// encoding/asn1 leaves the explicit tag around raw values, so the ticket is
+// unwrapped by hand.
+type apReq struct {
+	PVNO          int            `asn1:"explicit,tag:0"`
+	MsgType       int            `asn1:"explicit,tag:1"`
+	APOptions     asn1.BitString `asn1:"explicit,tag:2"`
+	Ticket        asn1.RawValue
+	Authenticator asn1.RawValue
+}
+
+// ticket is a Kerberos ticket, inside its APPLICATION 1 tag. Realms and
+// names are GeneralStrings, which encoding/asn1 cannot decode, so they are
+// kept raw.
+type ticket struct {
+	TktVNO  int `asn1:"explicit,tag:0"`
+	Realm   asn1.RawValue
+	SName   principalName `asn1:"explicit,tag:2"`
+	EncPart encryptedData `asn1:"explicit,tag:3"`
+}
+
+type principalName struct {
+	NameType   int             `asn1:"explicit,tag:0"`
+	NameString []asn1.RawValue `asn1:"explicit,tag:1"`
+}
+
+type encryptedData struct {
+	EType  int    `asn1:"explicit,tag:0"`
+	KVNO   int    `asn1:"explicit,optional,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+// Ticket is the service ticket a client presented
+type Ticket struct {
+	Realm string
+	// Service principal name, as HTTP/host
+	SPN   string
+	EType int
+	// Hash in the format hashcat takes, empty when the encryption type
+	// cannot be cracked
+	Hash string
+}
+
+// Principal returns the service principal with its realm
+func (t Ticket) Principal() string {
+	return t.SPN + "@" + t.Realm
+}
+
+// ParseAPReq reads the service ticket out of a Kerberos AP-REQ. Its
+// encrypted part is under the key of the service account, whose password
+// it cracks to.
+func ParseAPReq(data []byte) (Ticket, error) {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &outer); err != nil || outer.Class != asn1.ClassApplication || outer.Tag != 14 {
+		return Ticket{}, errors.New("not a Kerberos AP-REQ")
+	}
+	var req apReq
+	if _, err := asn1.Unmarshal(outer.Bytes, &req); err != nil {
+		return Ticket{}, fmt.Errorf("invalid Kerberos AP-REQ: %w", err)
+	}
+	raw, err := explicit(req.Ticket, 3)
+	if err != nil || raw.Class != asn1.ClassApplication || raw.Tag != 1 {
+		return Ticket{}, errors.New("invalid Kerberos ticket")
+	}
+	var tkt ticket
+	if _, err := asn1.Unmarshal(raw.Bytes, &tkt); err != nil {
+		return Ticket{}, fmt.Errorf("invalid Kerberos ticket: %w", err)
+	}
+	realm, err := explicit(tkt.Realm, 1)
+	if err != nil {
+		return Ticket{}, errors.New("invalid Kerberos ticket realm")
+	}
+
+	names := make([]string, len(tkt.SName.NameString))
+	for i, name := range tkt.SName.NameString {
+		names[i] = string(name.Bytes)
+	}
+	t := Ticket{Realm: string(realm.Bytes), SPN: strings.Join(names, "/"), EType: tkt.EncPart.EType}
+	cipher := tkt.EncPart.Cipher
+	switch t.EType {
+	case ETypeRC4:
+		if len(cipher) > 16 {
+			t.Hash = fmt.Sprintf("$krb5tgs$%d$*%s$%s$%s*$%s$%s", t.EType, TicketUser, t.Realm, t.SPN,
+				hex.EncodeToString(cipher[:16]), hex.EncodeToString(cipher[16:]))
+		}
+	case ETypeAES128, ETypeAES256:
+		if len(cipher) > 12 {
+			t.Hash = fmt.Sprintf("$krb5tgs$%d$%s$%s$*%s*$%s$%s", t.EType, TicketUser, t.Realm, t.SPN,
+				hex.EncodeToString(cipher[len(cipher)-12:]), hex.EncodeToString(cipher[:len(cipher)-12]))
+		}
+	}
+	return t, nil
+}
+
+// explicit returns the value inside raw, which must carry the context tag
+func explicit(raw asn1.RawValue, tag int) (asn1.RawValue, error) {
+	var inner asn1.RawValue
+	if raw.Class != asn1.ClassContextSpecific || raw.Tag != tag {
+		return inner, fmt.Errorf("expected tag [%d]", tag)
+	}
+	_, err := asn1.Unmarshal(raw.Bytes, &inner)
+	return inner, err
+}
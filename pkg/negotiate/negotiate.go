@@ -0,0 +1,141 @@
+// Package negotiate reads the tokens Windows clients send in HTTP
+// Negotiate and NTLM authentication, turning what they give away into
+// material password crackers take: NetNTLM challenge responses and the
+// service tickets of Kerberos AP-REQs.
+package negotiate
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Mechanism OIDs
+var (
+	oidSPNEGO  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidKerb5   = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	oidMSKerb5 = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+	oidNTLM    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+// ntlmSignature starts every NTLM message
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// Token is what a client sent, unwrapped from SPNEGO
+type Token struct {
+	// SPNEGO reports whether it came wrapped in SPNEGO, as answers to it
+	// must be
+	SPNEGO bool
+	// NTLM message, if it is one
+	NTLM []byte
+	// Kerberos AP-REQ, if it is one
+	APReq []byte
+}
+
+// negTokenInit is the client's first SPNEGO message
+type negTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"explicit,optional,tag:1"`
+	MechToken   []byte                  `asn1:"explicit,optional,tag:2"`
+	MechListMIC []byte                  `asn1:"explicit,optional,tag:3"`
+}
+
+// negTokenResp is every later SPNEGO message, either way
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// acceptIncomplete is the SPNEGO negotiation state asking for more
+const acceptIncomplete = 1
+
+// ParseToken unwraps the token of an Authorization: Negotiate or NTLM
+// header, already base64 decoded
+func ParseToken(data []byte) (Token, error) {
+	if bytes.HasPrefix(data, ntlmSignature) {
+		return Token{NTLM: data}, nil
+	}
+
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &outer); err != nil {
+		return Token{}, fmt.Errorf("invalid token: %w", err)
+	}
+	switch {
+	case outer.Class == asn1.ClassApplication && outer.Tag == 0:
+		// GSS-API InitialContextToken: a mechanism OID, then its token
+		var oid asn1.ObjectIdentifier
+		inner, err := asn1.Unmarshal(outer.Bytes, &oid)
+		if err != nil {
+			return Token{}, fmt.Errorf("invalid token: %w", err)
+		}
+		if oid.Equal(oidKerb5) || oid.Equal(oidMSKerb5) {
+			return kerberosToken(inner, false)
+		}
+		if !oid.Equal(oidSPNEGO) {
+			return Token{}, fmt.Errorf("unsupported mechanism %s", oid)
+		}
+		var wrapper asn1.RawValue
+		if _, err := asn1.Unmarshal(inner, &wrapper); err != nil || wrapper.Tag != 0 {
+			return Token{}, errors.New("invalid SPNEGO NegTokenInit")
+		}
+		var init negTokenInit
+		if _, err := asn1.Unmarshal(wrapper.Bytes, &init); err != nil {
+			return Token{}, fmt.Errorf("invalid SPNEGO NegTokenInit: %w", err)
+		}
+		return mechToken(init.MechToken)
+
+	case outer.Class == asn1.ClassContextSpecific && outer.Tag == 1:
+		var resp negTokenResp
+		if _, err := asn1.Unmarshal(outer.Bytes, &resp); err != nil {
+			return Token{}, fmt.Errorf("invalid SPNEGO NegTokenResp: %w", err)
+		}
+		return mechToken(resp.ResponseToken)
+	}
+	return Token{}, errors.New("unrecognised token")
+}
+
+// mechToken identifies the mechanism token carried in SPNEGO
+func mechToken(data []byte) (Token, error) {
+	if len(data) == 0 {
+		return Token{}, errors.New("SPNEGO message carries no token")
+	}
+	if bytes.HasPrefix(data, ntlmSignature) {
+		return Token{SPNEGO: true, NTLM: data}, nil
+	}
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &outer); err != nil || outer.Class != asn1.ClassApplication || outer.Tag != 0 {
+		return Token{}, errors.New("unsupported SPNEGO mechanism token")
+	}
+	var oid asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(outer.Bytes, &oid)
+	if err != nil || !(oid.Equal(oidKerb5) || oid.Equal(oidMSKerb5)) {
+		return Token{}, errors.New("unsupported SPNEGO mechanism token")
+	}
+	return kerberosToken(inner, true)
+}
+
+// kerberosToken takes the AP-REQ out of a Kerberos GSS-API token, which
+// follows a two byte token ID
+func kerberosToken(data []byte, spnego bool) (Token, error) {
+	if len(data) < 2 || data[0] != 0x01 || data[1] != 0x00 {
+		return Token{}, errors.New("Kerberos token is not an AP-REQ")
+	}
+	return Token{SPNEGO: spnego, APReq: data[2:]}, nil
+}
+
+// WrapChallenge wraps an NTLM challenge in the SPNEGO NegTokenResp a
+// client that started with SPNEGO expects
+func WrapChallenge(challenge []byte) ([]byte, error) {
+	resp, err := asn1.Marshal(negTokenResp{
+		NegState:      acceptIncomplete,
+		SupportedMech: oidNTLM,
+		ResponseToken: challenge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: resp})
+}
@@ -0,0 +1,218 @@
+package negotiate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM message types
+const (
+	NTLMNegotiate    = 1
+	NTLMChallenge    = 2
+	NTLMAuthenticate = 3
+)
+
+// NTLM negotiate flags
+const (
+	flagUnicode        = 0x00000001
+	flagRequestTarget  = 0x00000004
+	flagNTLM           = 0x00000200
+	flagAlwaysSign     = 0x00008000
+	flagTargetDomain   = 0x00010000
+	flagExtendedSecure = 0x00080000
+	flagTargetInfo     = 0x00800000
+	flagVersion        = 0x02000000
+	flag128            = 0x20000000
+	flag56             = 0x80000000
+)
+
+// challengeFlags are offered in every challenge
+const challengeFlags = flagUnicode | flagRequestTarget | flagNTLM | flagAlwaysSign | flagTargetDomain |
+	flagExtendedSecure | flagTargetInfo | flagVersion | flag128 | flag56
+
+// ntlmVersion claims Windows 10 build 17763, NTLM revision 15
+var ntlmVersion = []byte{0x0a, 0x00, 0x63, 0x45, 0x00, 0x00, 0x00, 0x0f}
+
+// NTLM AV pair IDs of the challenge's target info
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+	avTimestamp       = 7
+)
+
+// Hashcat modes and John the Ripper formats of NetNTLM responses
+const (
+	HashcatNetNTLMv1 = 5500
+	HashcatNetNTLMv2 = 5600
+	JohnNetNTLMv1    = "netntlm"
+	JohnNetNTLMv2    = "netntlmv2"
+)
+
+// MessageType returns the type of an NTLM message, 0 if it is not one
+func MessageType(msg []byte) int {
+	if len(msg) < 12 || string(msg[:8]) != string(ntlmSignature) {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(msg[8:12]))
+}
+
+// Challenge builds an NTLM challenge message in the name of a computer in
+// domain, returning it and the server challenge in it
+func Challenge(domain, computer string) ([]byte, [8]byte, error) {
+	var server [8]byte
+	if _, err := rand.Read(server[:]); err != nil {
+		return nil, server, err
+	}
+
+	domain, computer = strings.ToUpper(domain), strings.ToUpper(computer)
+	dnsDomain := strings.ToLower(domain) + ".local"
+	var info []byte
+	info = appendAV(info, avNbDomainName, utf16le(domain))
+	info = appendAV(info, avNbComputerName, utf16le(computer))
+	info = appendAV(info, avDNSDomainName, utf16le(dnsDomain))
+	info = appendAV(info, avDNSComputerName, utf16le(strings.ToLower(computer)+"."+dnsDomain))
+	info = appendAV(info, avTimestamp, binary.LittleEndian.AppendUint64(nil, filetime(time.Now())))
+	info = appendAV(info, avEOL, nil)
+
+	target := utf16le(domain)
+	const header = 56
+	msg := make([]byte, header, header+len(target)+len(info))
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], NTLMChallenge)
+	putField(msg[12:], len(target), header)
+	binary.LittleEndian.PutUint32(msg[20:], challengeFlags)
+	copy(msg[24:], server[:])
+	putField(msg[40:], len(info), header+len(target))
+	copy(msg[48:], ntlmVersion)
+	msg = append(msg, target...)
+	msg = append(msg, info...)
+	return msg, server, nil
+}
+
+// Response is the NetNTLM challenge response of an authenticate message
+type Response struct {
+	User        string
+	Domain      string
+	Workstation string
+	// Version 2 of NetNTLM, rather than 1
+	V2 bool
+	// Hash in the format hashcat and John the Ripper take
+	Hash string
+}
+
+// Username returns DOMAIN\user, or just user without a domain
+func (r Response) Username() string {
+	if r.Domain == "" {
+		return r.User
+	}
+	return r.Domain + `\` + r.User
+}
+
+// HashcatMode returns the hashcat mode cracking the response
+func (r Response) HashcatMode() int {
+	if r.V2 {
+		return HashcatNetNTLMv2
+	}
+	return HashcatNetNTLMv1
+}
+
+// ParseAuthenticate reads the response to server, the challenge sent, out
+// of an NTLM authenticate message. Anonymous logins, which carry nothing
+// to crack, are an error.
+func ParseAuthenticate(msg []byte, server [8]byte) (Response, error) {
+	if MessageType(msg) != NTLMAuthenticate || len(msg) < 64 {
+		return Response{}, errors.New("not an NTLM authenticate message")
+	}
+	flags := binary.LittleEndian.Uint32(msg[60:])
+	lm, err1 := field(msg, 12)
+	nt, err2 := field(msg, 20)
+	domain, err3 := field(msg, 28)
+	user, err4 := field(msg, 36)
+	workstation, err5 := field(msg, 44)
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+		return Response{}, err
+	}
+	text := func(b []byte) string {
+		if flags&flagUnicode != 0 {
+			return fromUTF16le(b)
+		}
+		return string(b)
+	}
+
+	r := Response{User: text(user), Domain: text(domain), Workstation: text(workstation)}
+	if r.User == "" || len(nt) == 0 {
+		return Response{}, errors.New("anonymous NTLM login")
+	}
+	challenge := hex.EncodeToString(server[:])
+	switch {
+	case len(nt) > 24:
+		r.V2 = true
+		r.Hash = fmt.Sprintf("%s::%s:%s:%s:%s", r.User, r.Domain, challenge, hex.EncodeToString(nt[:16]), hex.EncodeToString(nt[16:]))
+	case len(nt) == 24:
+		r.Hash = fmt.Sprintf("%s::%s:%s:%s:%s", r.User, r.Domain, hex.EncodeToString(lm), hex.EncodeToString(nt), challenge)
+	default:
+		return Response{}, fmt.Errorf("NTLM response of unexpected length %d", len(nt))
+	}
+	return r, nil
+}
+
+// field returns the payload an NTLM message's length and offset pair at
+// offset points to
+func field(msg []byte, offset int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[offset:]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+	if length == 0 {
+		return nil, nil
+	}
+	if start < 0 || start > len(msg)-length {
+		return nil, errors.New("NTLM message field out of bounds")
+	}
+	return msg[start : start+length], nil
+}
+
+// putField writes an NTLM message's length and offset pair
+func putField(b []byte, length, offset int) {
+	binary.LittleEndian.PutUint16(b, uint16(length))
+	binary.LittleEndian.PutUint16(b[2:], uint16(length))
+	binary.LittleEndian.PutUint32(b[4:], uint32(offset))
+}
+
+// appendAV appends an AV pair to target info
+func appendAV(info []byte, id uint16, value []byte) []byte {
+	info = binary.LittleEndian.AppendUint16(info, id)
+	info = binary.LittleEndian.AppendUint16(info, uint16(len(value)))
+	return append(info, value...)
+}
+
+// utf16le encodes s as NTLM's Unicode strings are
+func utf16le(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+// fromUTF16le decodes an NTLM Unicode string
+func fromUTF16le(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// filetime returns t as a Windows FILETIME, in 100ns since 1601
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
@@ -0,0 +1,194 @@
+package negotiate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testServer is the server challenge the test messages answer
+var testServer = [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+// authenticate builds an NTLM authenticate message carrying the fields,
+// in the order of the header's length and offset pairs: LM response, NT
+// response, domain, user and workstation
+func authenticate(lm, nt []byte, domain, user, workstation string) []byte {
+	const header = 64
+	msg := make([]byte, header)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], NTLMAuthenticate)
+	binary.LittleEndian.PutUint32(msg[60:], flagUnicode)
+	for i, payload := range [][]byte{lm, nt, utf16le(domain), utf16le(user), utf16le(workstation)} {
+		putField(msg[12+8*i:], len(payload), len(msg))
+		msg = append(msg, payload...)
+	}
+	return msg
+}
+
+// withField returns a copy of msg with the length and offset pair at
+// offset replaced
+func withField(msg []byte, offset, length, start int) []byte {
+	msg = bytes.Clone(msg)
+	binary.LittleEndian.PutUint16(msg[offset:], uint16(length))
+	binary.LittleEndian.PutUint32(msg[offset+4:], uint32(start))
+	return msg
+}
+
+func TestParseAuthenticate(t *testing.T) {
+	v2 := authenticate(make([]byte, 24), bytes.Repeat([]byte{0xab}, 40), "CORP", "alice", "WS1")
+	r, err := ParseAuthenticate(v2, testServer)
+	if err != nil {
+		t.Fatalf("v2: %v", err)
+	}
+	want := "alice::CORP:0102030405060708:" + strings.Repeat("ab", 16) + ":" + strings.Repeat("ab", 24)
+	if !r.V2 || r.Hash != want || r.Username() != `CORP\alice` || r.Workstation != "WS1" {
+		t.Errorf("v2: got %+v, want hash %s", r, want)
+	}
+
+	v1 := authenticate(bytes.Repeat([]byte{0x11}, 24), bytes.Repeat([]byte{0x22}, 24), "", "bob", "")
+	r, err = ParseAuthenticate(v1, testServer)
+	if err != nil {
+		t.Fatalf("v1: %v", err)
+	}
+	want = "bob:::" + strings.Repeat("11", 24) + ":" + strings.Repeat("22", 24) + ":0102030405060708"
+	if r.V2 || r.Hash != want || r.Username() != "bob" {
+		t.Errorf("v1: got %+v, want hash %s", r, want)
+	}
+}
+
+func TestParseAuthenticateHostile(t *testing.T) {
+	valid := authenticate(make([]byte, 24), bytes.Repeat([]byte{0xab}, 40), "CORP", "alice", "WS1")
+	end := len(valid)
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"signature only", ntlmSignature},
+		{"header cut short", valid[:63]},
+		{"payload cut short", valid[:end-1]},
+		{"negotiate message", withField(valid, 8, NTLMNegotiate, 0)[:64]},
+		{"NT length past the end", withField(valid, 20, 0xffff, 64)},
+		{"NT offset past the end", withField(valid, 20, 40, end)},
+		{"NT offset one byte over", withField(valid, 20, 40, end-39)},
+		{"NT offset at 4 GiB", withField(valid, 20, 40, 0xffffffff)},
+		{"NT offset wrapping to negative", withField(valid, 20, 0xffff, 0x7fffffff)},
+		{"user length past the end", withField(valid, 36, 0xffff, 0)},
+		{"user offset past the end", withField(valid, 36, 10, 0xfffffff0)},
+		{"LM offset past the end", withField(valid, 12, 24, end)},
+		{"workstation offset past the end", withField(valid, 44, 6, end)},
+		{"no NT response", withField(valid, 20, 0, 0)},
+		{"no user", withField(valid, 36, 0, 0)},
+		{"NT response too short", withField(valid, 20, 16, 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if r, err := ParseAuthenticate(tt.msg, testServer); err == nil {
+				t.Errorf("parsed %+v, want an error", r)
+			}
+		})
+	}
+
+	// A field ending on the last byte is still in bounds
+	last := withField(valid, 44, 6, end-6)
+	if _, err := ParseAuthenticate(last, testServer); err != nil {
+		t.Errorf("field ending the message: %v", err)
+	}
+	// An odd length Unicode string is not an error
+	odd := withField(valid, 44, 5, end-6)
+	if _, err := ParseAuthenticate(odd, testServer); err != nil {
+		t.Errorf("odd length workstation: %v", err)
+	}
+}
+
+func TestParseAuthenticateTruncated(t *testing.T) {
+	valid := authenticate(make([]byte, 24), bytes.Repeat([]byte{0xab}, 40), "CORP", "alice", "WS1")
+	for n := 0; n < len(valid); n++ {
+		if _, err := ParseAuthenticate(valid[:n], testServer); err == nil {
+			t.Errorf("%d of %d bytes: no error", n, len(valid))
+		}
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want int
+	}{
+		{nil, 0},
+		{ntlmSignature, 0},
+		{append(bytes.Clone(ntlmSignature), 3, 0, 0), 0},
+		{append(bytes.Clone(ntlmSignature), 3, 0, 0, 0), NTLMAuthenticate},
+		{[]byte("NTLMSSP!\x01\x00\x00\x00"), 0},
+	}
+	for _, tt := range tests {
+		if got := MessageType(tt.msg); got != tt.want {
+			t.Errorf("MessageType(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	msg, server, err := Challenge("corp", "ws1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if MessageType(msg) != NTLMChallenge {
+		t.Fatalf("message type %d, want %d", MessageType(msg), NTLMChallenge)
+	}
+	if !bytes.Equal(msg[24:32], server[:]) {
+		t.Errorf("server challenge %x, returned %x", msg[24:32], server)
+	}
+	target, err := field(msg, 12)
+	if err != nil || fromUTF16le(target) != "CORP" {
+		t.Errorf("target name %q, %v", fromUTF16le(target), err)
+	}
+	info, err := field(msg, 40)
+	if err != nil {
+		t.Fatalf("target info: %v", err)
+	}
+	// AV pairs run to an EOL pair, each within the info
+	for len(info) >= 4 {
+		id, length := binary.LittleEndian.Uint16(info), int(binary.LittleEndian.Uint16(info[2:]))
+		if id == avEOL {
+			return
+		}
+		if 4+length > len(info) {
+			t.Fatalf("AV pair %d of %d bytes overruns the target info", id, length)
+		}
+		info = info[4+length:]
+	}
+	t.Error("target info has no EOL pair")
+}
+
+func TestParseTokenTruncated(t *testing.T) {
+	challenge, _, err := Challenge("corp", "ws1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := WrapChallenge(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ParseToken(wrapped)
+	if err != nil || !token.SPNEGO || !bytes.Equal(token.NTLM, challenge) {
+		t.Fatalf("ParseToken = %+v, %v", token, err)
+	}
+	for n := 0; n < len(wrapped); n++ {
+		if _, err := ParseToken(wrapped[:n]); err == nil {
+			t.Errorf("%d of %d bytes: no error", n, len(wrapped))
+		}
+	}
+
+	// Lengths claiming more than the token holds
+	for _, data := range [][]byte{
+		{0x60, 0x84, 0xff, 0xff, 0xff, 0xff},
+		{0xa1, 0x83, 0xff, 0xff, 0xff, 0x30},
+		{0x60, 0x0a, 0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02, 0xa0, 0x7f},
+	} {
+		if _, err := ParseToken(data); err == nil {
+			t.Errorf("%x: no error", data)
+		}
+	}
+}
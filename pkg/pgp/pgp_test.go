@@ -0,0 +1,159 @@
+package pgp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newEntity generates a certificate with the key algorithm of config
+func newEntity(t *testing.T, config *packet.Config) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Operator", "", "operator@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// writeKey writes the public part of entity to a file, ASCII armored or
+// binary, and returns its path
+func writeKey(t *testing.T, entity *openpgp.Entity, armored bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := io.WriteCloser(nopCloser{&buf})
+	if armored {
+		var err error
+		if w, err = armor.Encode(&buf, openpgp.PublicKeyType, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return writeFile(t, buf.Bytes())
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config *packet.Config
+	}{
+		{"rsa", &packet.Config{Algorithm: packet.PubKeyAlgoRSA, RSABits: 2048}},
+		{"curve25519", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519}},
+	} {
+		entity := newEntity(t, tt.config)
+		for _, armored := range []bool{false, true} {
+			key, err := ReadKey(writeKey(t, entity, armored))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if want := strings.ToUpper(entity.PrimaryKey.KeyIdString()); !strings.HasSuffix(key.Fingerprint(), want) {
+				t.Errorf("%s: fingerprint %s, want one ending %s", tt.name, key.Fingerprint(), want)
+			}
+			if key.UserID() != "Operator <operator@example.com>" {
+				t.Errorf("%s: user ID %q", tt.name, key.UserID())
+			}
+
+			plaintext := []byte("user::CORP:1122334455667788:hash")
+			msg, err := key.Encrypt("hashcat-5600.txt", plaintext)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			md, err := openpgp.ReadMessage(bytes.NewReader(msg), openpgp.EntityList{entity}, nil, nil)
+			if err != nil {
+				t.Fatalf("%s: decrypting: %v", tt.name, err)
+			}
+			got, err := io.ReadAll(md.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("%s: decrypting: %v", tt.name, err)
+			}
+			if !bytes.Equal(got, plaintext) || md.LiteralData.FileName != "hashcat-5600.txt" {
+				t.Errorf("%s: decrypted %q as %q", tt.name, got, md.LiteralData.FileName)
+			}
+			if !md.IsEncrypted || md.DecryptedWith.PublicKey.KeyId != entity.Subkeys[0].PublicKey.KeyId {
+				t.Errorf("%s: not encrypted to the encryption subkey", tt.name)
+			}
+		}
+	}
+}
+
+// TestGPGKey reads a key exported by gpg --export --armor, an Ed25519
+// primary key with a Curve25519 encryption subkey
+func TestGPGKey(t *testing.T) {
+	key, err := ReadKey("testdata/operator.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Fingerprint() != "24E861DD6BACBB01AC3A0475827B5A14BB297AA1" || key.UserID() != "Operator <operator@example.com>" {
+		t.Errorf("read %s %q", key.Fingerprint(), key.UserID())
+	}
+	if _, err := key.Encrypt("loot.txt", []byte("secret")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	signOnly := newEntity(t, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519})
+	signOnly.Subkeys = nil
+
+	private := newEntity(t, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519})
+	var privateKey bytes.Buffer
+	w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := private.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	valid, err := os.ReadFile("testdata/operator.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := writeKey(t, private, false)
+	whole, err := os.ReadFile(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "none.asc")},
+		{"empty", writeFile(t, nil)},
+		{"garbage", writeFile(t, []byte("not a key at all"))},
+		{"bad armor", writeFile(t, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n!!!!\n-----END PGP PUBLIC KEY BLOCK-----\n"))},
+		{"truncated armor", writeFile(t, valid[:len(valid)/2])},
+		{"truncated packet", writeFile(t, whole[:len(whole)/3])},
+		{"private key", writeFile(t, privateKey.Bytes())},
+		{"signing only", writeKey(t, signOnly, true)},
+	} {
+		if key, err := ReadKey(tt.path); err == nil {
+			t.Errorf("%s: read key %s", tt.name, key.Fingerprint())
+		}
+	}
+}
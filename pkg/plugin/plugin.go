@@ -0,0 +1,354 @@
+// Package plugin runs third-party plugins as subprocesses speaking JSON-RPC
+// over their standard input and output, so engagement logic can add HTTP
+// routes, rewrite SSDP responses and consume events without forking
+// goSSDPkit. Plugins are programs in any language; those written in Go can
+// implement Server and call Serve from main.
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// Dir is the directory plugins are started from by default
+const Dir = "plugins"
+
+// Methods plugins answer, as JSON-RPC 1.0 method names
+const (
+	MethodDescribe       = "Plugin.Describe"
+	MethodServeHTTP      = "Plugin.ServeHTTP"
+	MethodMutateResponse = "Plugin.MutateResponse"
+	MethodEvent          = "Plugin.Event"
+)
+
+// callTimeout bounds each call, so a stuck plugin fails requests rather
+// than holding them
+const callTimeout = 5 * time.Second
+
+// queueSize is how many events may wait for a plugin before new ones are
+// dropped
+const queueSize = 1024
+
+// maxBody caps the request body handed to a plugin
+const maxBody = 1 << 20
+
+// Info is what a plugin says it does, in answer to Plugin.Describe
+type Info struct {
+	// Name the plugin is logged as; defaults to its file name
+	Name string `json:"name"`
+	// Paths the plugin answers HTTP requests for, a trailing slash
+	// standing for everything under the path
+	Routes []string `json:"routes,omitempty"`
+	// Whether SSDP responses are passed through Plugin.MutateResponse
+	Mutator bool `json:"mutator,omitempty"`
+	// Whether every event is sent to Plugin.Event
+	Events bool `json:"events,omitempty"`
+}
+
+// HTTPRequest is a request for one of the plugin's routes
+type HTTPRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Host   string      `json:"host"` // client address
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// HTTPResponse is the plugin's answer to an HTTPRequest. Status defaults to
+// 200.
+type HTTPResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// SearchResponse is an SSDP response about to be sent to Host for a
+// search for ST, as the raw message
+type SearchResponse struct {
+	Host     string `json:"host"`
+	ST       string `json:"st"`
+	Response string `json:"response"`
+}
+
+// MutatedResponse is the response to send instead, or none if Drop is set
+type MutatedResponse struct {
+	Response string `json:"response"`
+	Drop     bool   `json:"drop,omitempty"`
+}
+
+// Plugin is a running plugin
+type Plugin struct {
+	Info
+	path    string
+	cmd     *exec.Cmd
+	client  *rpc.Client
+	logger  logging.Logger
+	records chan store.Record
+	dropped atomic.Bool // an event was dropped since the queue last had room
+	failed  atomic.Bool // a call failed since the last one that worked
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	once    sync.Once
+}
+
+// Load starts every executable file in dir, in name order. A missing
+// directory holds no plugins.
+func Load(dir string, logger logging.Logger) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var plugins []*Plugin
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !executable(entry.Name(), info.Mode()) {
+			continue
+		}
+		p, err := Start(filepath.Join(dir, entry.Name()), logger)
+		if err != nil {
+			for _, started := range plugins {
+				started.Close()
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// executable reports whether a file is a program to start
+func executable(name string, mode os.FileMode) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(name), ".exe")
+	}
+	return mode&0111 != 0 && !strings.HasPrefix(name, ".")
+}
+
+// Start runs the plugin at path and asks it what it does. Its standard
+// error is logged.
+func Start(path string, logger logging.Logger) (*Plugin, error) {
+	cmd := exec.Command(path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+	p := &Plugin{
+		Info:   Info{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))},
+		path:   path,
+		cmd:    cmd,
+		client: jsonrpc.NewClient(pipe{ReadCloser: stdout, WriteCloser: stdin}),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	go p.logStderr(stderr)
+
+	var info Info
+	if err := p.call(MethodDescribe, struct{}{}, &info); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	if info.Name != "" {
+		p.Name = info.Name
+	}
+	for _, route := range info.Routes {
+		if !strings.HasPrefix(route, "/") {
+			p.stop()
+			return nil, fmt.Errorf("plugin %s: route %q must start with /", p.Name, route)
+		}
+	}
+	p.Routes, p.Mutator, p.Events = info.Routes, info.Mutator, info.Events
+	if p.Events {
+		p.records = make(chan store.Record, queueSize)
+		go p.deliver()
+	} else {
+		close(p.done)
+	}
+	return p, nil
+}
+
+// pipe joins the plugin's standard output and input into one connection
+type pipe struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p pipe) Close() error {
+	err := p.WriteCloser.Close()
+	p.ReadCloser.Close()
+	return err
+}
+
+// logStderr logs what the plugin writes to its standard error, line by line
+func (p *Plugin) logStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.logger.Log("%s[%s] %s", ssdp.NoteBox, p.Name, scanner.Text())
+	}
+}
+
+// call calls method, giving up after callTimeout. The first failure after
+// a success is logged.
+func (p *Plugin) call(method string, args, reply interface{}) error {
+	call := p.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	var err error
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(callTimeout):
+		err = fmt.Errorf("%s timed out", method)
+	}
+	if err != nil {
+		if !p.failed.Swap(true) {
+			logging.Notice(p.logger, "%sPlugin %s: %v", ssdp.WarnBox, p.Name, err)
+		}
+		return err
+	}
+	p.failed.Store(false)
+	return nil
+}
+
+// ServeHTTP passes a request for one of the plugin's routes to it
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	req := HTTPRequest{
+		Method: r.Method,
+		URL:    r.URL.RequestURI(),
+		Host:   host,
+		Header: r.Header,
+		Body:   body,
+	}
+	var resp HTTPResponse
+	if err := p.call(MethodServeHTTP, req, &resp); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// MutateResponse passes an SSDP response through the plugin, sending it
+// unchanged if the plugin fails. It is an ssdp.ResponseMutator.
+func (p *Plugin) MutateResponse(host, st, response string) (string, bool) {
+	var mutated MutatedResponse
+	if err := p.call(MethodMutateResponse, SearchResponse{Host: host, ST: st, Response: response}, &mutated); err != nil {
+		return response, true
+	}
+	if mutated.Drop {
+		return "", false
+	}
+	if mutated.Response == "" {
+		return response, true
+	}
+	return mutated.Response, true
+}
+
+// Subscriber returns the events subscriber sending events to the plugin,
+// as event store records, without waiting for it
+func (p *Plugin) Subscriber() events.Events {
+	return store.Records(p.add)
+}
+
+// add queues r without blocking, dropping it if the queue is full or closed
+func (p *Plugin) add(r store.Record) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed || p.records == nil {
+		return
+	}
+	select {
+	case p.records <- r:
+		p.dropped.Store(false)
+	default:
+		if !p.dropped.Swap(true) {
+			logging.Notice(p.logger, "%sPlugin %s is not keeping up, dropping events until it does", ssdp.WarnBox, p.Name)
+		}
+	}
+}
+
+// deliver sends queued events to the plugin until the queue is closed
+func (p *Plugin) deliver() {
+	defer close(p.done)
+	for r := range p.records {
+		p.call(MethodEvent, r, &struct{}{})
+	}
+}
+
+// Close delivers the events already queued, then stops the plugin
+func (p *Plugin) Close() error {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		if p.records != nil {
+			close(p.records)
+		}
+		p.mu.Unlock()
+		select {
+		case <-p.done:
+		case <-time.After(callTimeout):
+		}
+		p.stop()
+	})
+	return nil
+}
+
+// stop closes the plugin's standard input and waits for it to exit,
+// killing it if it has not a few seconds later
+func (p *Plugin) stop() {
+	p.client.Close()
+	exited := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(callTimeout):
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
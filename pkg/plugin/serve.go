@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"goSSDPkit/pkg/store"
+)
+
+// Server is implemented by plugins written in Go. Methods for what Describe
+// does not claim are never called.
+type Server interface {
+	Describe() Info
+	ServeHTTP(HTTPRequest) HTTPResponse
+	MutateResponse(SearchResponse) MutatedResponse
+	Event(store.Record)
+}
+
+// Serve answers goSSDPkit's calls to s over standard input and output
+// until goSSDPkit closes them. Plugins log to standard error.
+func Serve(s Server) {
+	server := rpc.NewServer()
+	server.RegisterName("Plugin", &receiver{s})
+	server.ServeCodec(jsonrpc.NewServerCodec(stdio{}))
+}
+
+// receiver adapts a Server to net/rpc's method signatures
+type receiver struct {
+	s Server
+}
+
+func (r *receiver) Describe(_ struct{}, info *Info) error {
+	*info = r.s.Describe()
+	return nil
+}
+
+func (r *receiver) ServeHTTP(req HTTPRequest, resp *HTTPResponse) error {
+	*resp = r.s.ServeHTTP(req)
+	return nil
+}
+
+func (r *receiver) MutateResponse(req SearchResponse, resp *MutatedResponse) error {
+	*resp = r.s.MutateResponse(req)
+	return nil
+}
+
+func (r *receiver) Event(record store.Record, _ *struct{}) error {
+	r.s.Event(record)
+	return nil
+}
+
+// stdio is the process's standard input and output as one connection
+type stdio struct{}
+
+func (stdio) Read(b []byte) (int, error)  { return os.Stdin.Read(b) }
+func (stdio) Write(b []byte) (int, error) { return os.Stdout.Write(b) }
+func (stdio) Close() error                { return os.Stdout.Close() }
+
+var _ io.ReadWriteCloser = stdio{}
@@ -0,0 +1,339 @@
+// Package replay reads recorded M-SEARCH traffic from packet captures or
+// JSONL files and sends it to a running listener, so handlers, templates and
+// alerts can be exercised without a live network.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ssdpPort is the port M-SEARCH requests are sent to
+const ssdpPort = 1900
+
+// maxBlockSize bounds the records read from a capture, well above any real
+// snap length
+const maxBlockSize = 16 << 20
+
+// File magic numbers
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngSHB      = 0x0a0d0d0a
+)
+
+// Link types understood by the capture readers
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkSLL2     = 276
+)
+
+// Packet is one recorded M-SEARCH
+type Packet struct {
+	Time    time.Time
+	Source  string // address of the host that sent it, if known
+	Payload []byte
+}
+
+// ReadFile reads the M-SEARCH requests in a pcap, pcapng or JSONL file, in
+// the order they were recorded
+func ReadFile(path string) ([]Packet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture: %w", err)
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Read reads the M-SEARCH requests in a pcap, pcapng or JSONL stream,
+// telling the formats apart by their first bytes
+func Read(r io.Reader) ([]Packet, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil && len(head) == 0 {
+		return nil, fmt.Errorf("failed to read capture: %w", err)
+	}
+
+	var packets []Packet
+	if len(head) == 4 {
+		le, be := binary.LittleEndian.Uint32(head), binary.BigEndian.Uint32(head)
+		switch {
+		case le == pcapngSHB:
+			packets, err = readPcapng(br)
+		case le == pcapMagicMicro || le == pcapMagicNano || be == pcapMagicMicro || be == pcapMagicNano:
+			packets, err = readPcap(br)
+		default:
+			packets, err = readJSONL(br)
+		}
+	} else {
+		packets, err = readJSONL(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) == 0 {
+		return nil, errors.New("no M-SEARCH requests found")
+	}
+	return packets, nil
+}
+
+// readPcap reads a classic libpcap file
+func readPcap(r io.Reader) ([]Packet, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(header[0:4])
+	if magic != pcapMagicMicro && magic != pcapMagicNano {
+		order = binary.BigEndian
+		magic = order.Uint32(header[0:4])
+	}
+	unit := time.Microsecond
+	if magic == pcapMagicNano {
+		unit = time.Nanosecond
+	}
+	linkType := order.Uint32(header[20:24]) & 0x0fffffff
+
+	var packets []Packet
+	var record [16]byte
+	for {
+		if _, err := io.ReadFull(r, record[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return packets, nil
+			}
+			return nil, fmt.Errorf("failed to read pcap record: %w", err)
+		}
+		length := order.Uint32(record[8:12])
+		if length > maxBlockSize {
+			return nil, fmt.Errorf("pcap record too large: %d bytes", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read pcap record: %w", err)
+		}
+
+		t := time.Unix(int64(order.Uint32(record[0:4])), int64(order.Uint32(record[4:8]))*int64(unit))
+		if p, ok := decode(linkType, data, order); ok {
+			p.Time = t
+			packets = append(packets, p)
+		}
+	}
+}
+
+// readPcapng reads a pcapng file. Timestamps are assumed to use the default
+// microsecond resolution.
+func readPcapng(r io.Reader) ([]Packet, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var linkTypes []uint32
+	var packets []Packet
+
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return packets, nil
+			}
+			return nil, fmt.Errorf("failed to read pcapng block: %w", err)
+		}
+
+		blockType := order.Uint32(head[0:4])
+		if binary.LittleEndian.Uint32(head[0:4]) == pcapngSHB {
+			// Each section declares its own byte order and interfaces
+			var bom [4]byte
+			if _, err := io.ReadFull(r, bom[:]); err != nil {
+				return nil, fmt.Errorf("failed to read pcapng section: %w", err)
+			}
+			order = binary.LittleEndian
+			if binary.BigEndian.Uint32(bom[:]) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			}
+			blockType = pcapngSHB
+			linkTypes = nil
+		}
+
+		length := order.Uint32(head[4:8])
+		if length < 12 || length > maxBlockSize {
+			return nil, fmt.Errorf("invalid pcapng block length: %d", length)
+		}
+		read := uint32(8)
+		if blockType == pcapngSHB {
+			read += 4
+		}
+		body := make([]byte, length-read)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("failed to read pcapng block: %w", err)
+		}
+
+		switch blockType {
+		case 1: // interface description
+			if len(body) >= 2 {
+				linkTypes = append(linkTypes, uint32(order.Uint16(body[0:2])))
+			}
+		case 6: // enhanced packet
+			if len(body) < 20 {
+				continue
+			}
+			iface := order.Uint32(body[0:4])
+			if int(iface) >= len(linkTypes) {
+				continue
+			}
+			ts := uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			captured := order.Uint32(body[12:16])
+			if uint64(captured) > uint64(len(body)-20) {
+				continue
+			}
+			if p, ok := decode(linkTypes[iface], body[20:20+captured], order); ok {
+				p.Time = time.UnixMicro(int64(ts))
+				packets = append(packets, p)
+			}
+		}
+	}
+}
+
+// decode extracts an M-SEARCH sent to the SSDP port from a captured frame.
+// order is the capture's byte order, used by the null link type.
+func decode(linkType uint32, frame []byte, order binary.ByteOrder) (Packet, bool) {
+	var ip []byte
+	switch linkType {
+	case linkEthernet:
+		if len(frame) < 14 {
+			return Packet{}, false
+		}
+		etherType, offset := binary.BigEndian.Uint16(frame[12:14]), 14
+		// Skip VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(frame) >= offset+4 {
+			etherType = binary.BigEndian.Uint16(frame[offset+2 : offset+4])
+			offset += 4
+		}
+		if etherType != 0x0800 {
+			return Packet{}, false
+		}
+		ip = frame[offset:]
+	case linkSLL:
+		if len(frame) < 16 || binary.BigEndian.Uint16(frame[14:16]) != 0x0800 {
+			return Packet{}, false
+		}
+		ip = frame[16:]
+	case linkSLL2:
+		if len(frame) < 20 || binary.BigEndian.Uint16(frame[0:2]) != 0x0800 {
+			return Packet{}, false
+		}
+		ip = frame[20:]
+	case linkNull, linkLoop:
+		if len(frame) < 4 {
+			return Packet{}, false
+		}
+		family := order.Uint32(frame[0:4])
+		if linkType == linkLoop {
+			family = binary.BigEndian.Uint32(frame[0:4])
+		}
+		if family != 2 {
+			return Packet{}, false
+		}
+		ip = frame[4:]
+	case linkRaw, linkIPv4:
+		ip = frame
+	default:
+		return Packet{}, false
+	}
+
+	// IPv4 header, unfragmented UDP only
+	if len(ip) < 20 || ip[0]>>4 != 4 || ip[9] != 17 {
+		return Packet{}, false
+	}
+	if binary.BigEndian.Uint16(ip[6:8])&0x1fff != 0 {
+		return Packet{}, false
+	}
+	headerLen := int(ip[0]&0x0f) * 4
+	if len(ip) < headerLen+8 {
+		return Packet{}, false
+	}
+	udp := ip[headerLen:]
+	if binary.BigEndian.Uint16(udp[2:4]) != ssdpPort {
+		return Packet{}, false
+	}
+	end := int(binary.BigEndian.Uint16(udp[4:6]))
+	if end < 8 || end > len(udp) {
+		end = len(udp)
+	}
+	payload := udp[8:end]
+	if !bytes.HasPrefix(payload, []byte("M-SEARCH")) {
+		return Packet{}, false
+	}
+
+	source := fmt.Sprintf("%d.%d.%d.%d", ip[12], ip[13], ip[14], ip[15])
+	return Packet{Source: source, Payload: append([]byte(nil), payload...)}, true
+}
+
+// record is one line of a JSONL file. Payload holds a raw M-SEARCH; without
+// it one is built from ST and UserAgent.
+type record struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	ST        string    `json:"st"`
+	UserAgent string    `json:"user_agent"`
+	Payload   string    `json:"payload"`
+}
+
+// readJSONL reads one record per line, skipping blank lines and lines that
+// describe something other than a search
+func readJSONL(r io.Reader) ([]Packet, error) {
+	var packets []Packet
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBlockSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return nil, fmt.Errorf("line %d is not a pcap, pcapng or JSON record: %w", line, err)
+		}
+
+		payload := rec.Payload
+		if payload == "" && rec.ST != "" {
+			payload = searchMessage(rec.ST, rec.UserAgent)
+		}
+		if !strings.HasPrefix(payload, "M-SEARCH") {
+			continue
+		}
+		packets = append(packets, Packet{Time: rec.Time, Source: rec.Host, Payload: []byte(payload)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read JSONL: %w", err)
+	}
+	return packets, nil
+}
+
+// searchMessage builds an M-SEARCH for st, the way a Windows control point
+// sends it
+func searchMessage(st, userAgent string) string {
+	message := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + st + "\r\n"
+	if userAgent != "" {
+		message += "USER-AGENT: " + userAgent + "\r\n"
+	}
+	return message + "\r\n"
+}
@@ -0,0 +1,117 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTarget is where replayed searches are sent: the listener's SSDP
+// socket, reached over loopback so nothing goes out on the network
+const DefaultTarget = "127.0.0.1:1900"
+
+// Result sums up a replay
+type Result struct {
+	Sent     int
+	Answered int // responses received from the listener
+}
+
+// config holds the replay options
+type config struct {
+	speed float64
+	wait  time.Duration
+}
+
+// Option configures a replay
+type Option func(*config)
+
+// WithSpeed scales the recorded gaps between searches: 2 replays twice as
+// fast, 0 sends them back to back
+func WithSpeed(speed float64) Option {
+	return func(c *config) {
+		c.speed = speed
+	}
+}
+
+// WithWait sets how long to keep listening for responses after the last
+// search is sent
+func WithWait(wait time.Duration) Option {
+	return func(c *config) {
+		c.wait = wait
+	}
+}
+
+// Send replays packets to target from one UDP socket, keeping their recorded
+// timing, and counts the responses. It stops early if ctx is cancelled.
+func Send(ctx context.Context, packets []Packet, target string, opts ...Option) (Result, error) {
+	c := config{speed: 1, wait: 2 * time.Second}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to resolve replay target: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	var result Result
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buffer := make([]byte, 2048)
+		for {
+			if _, _, err := conn.ReadFrom(buffer); err != nil {
+				if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+					return
+				}
+				continue
+			}
+			result.Answered++
+		}
+	}()
+
+	var sendErr error
+	for i, p := range packets {
+		if i > 0 && c.speed > 0 && !p.Time.IsZero() && !packets[i-1].Time.IsZero() {
+			if gap := p.Time.Sub(packets[i-1].Time); gap > 0 {
+				if !sleep(ctx, time.Duration(float64(gap)/c.speed)) {
+					break
+				}
+			}
+		}
+		if _, err := conn.WriteTo(p.Payload, addr); err != nil {
+			sendErr = fmt.Errorf("failed to send search: %w", err)
+			break
+		}
+		result.Sent++
+	}
+
+	if sendErr == nil {
+		sleep(ctx, c.wait)
+	}
+	conn.SetReadDeadline(time.Now())
+	wg.Wait()
+	return result, sendErr
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+// Package scope holds the addresses an engagement is allowed to touch. With
+// a scope file loaded, hosts outside it are never answered, phished or
+// captured from, and every contact they make is written to a violations
+// log for the engagement record.
+package scope
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogPath is the default violations log, relative to the working directory
+const LogPath = "logs/scope-violations.log"
+
+// Scope is the set of addresses in scope. A nil Scope contains everything.
+type Scope struct {
+	networks []*net.IPNet
+}
+
+// Load reads the IP addresses and CIDR ranges in scope from a file, one per
+// line, ignoring blank lines and # comments
+func Load(path string) (*Scope, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scope file: %w", err)
+	}
+	defer file.Close()
+
+	s := &Scope{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("scope file line %d: %w", line, err)
+		}
+		s.networks = append(s.networks, network)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read scope file: %w", err)
+	}
+	if len(s.networks) == 0 {
+		return nil, fmt.Errorf("scope file %s lists no addresses", path)
+	}
+	return s, nil
+}
+
+// Parse returns the scope of entries, IP addresses and CIDR ranges
+func Parse(entries []string) (*Scope, error) {
+	s := &Scope{}
+	for _, entry := range entries {
+		network, err := parseNetwork(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		s.networks = append(s.networks, network)
+	}
+	return s, nil
+}
+
+// parseNetwork parses a CIDR, taking a bare address as a single host
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address or range %q", entry)
+	}
+	return network, nil
+}
+
+// Contains reports whether host, an IP address, is in scope. Anything that
+// is not an address is out of scope.
+func (s *Scope) Contains(host string) bool {
+	if s == nil {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns how many addresses and ranges are in scope
+func (s *Scope) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.networks)
+}
+
+// Violations is the log of contacts from out-of-scope hosts. One may be
+// shared by the listener and several servers.
+type Violations struct {
+	mu    sync.Mutex
+	file  *os.File
+	path  string // opened on the first contact when file is nil
+	hosts map[string]bool
+}
+
+// NewViolations returns the violations log at path without opening it,
+// for runs that may only get a scope on reload. It is created on the
+// first contact recorded.
+func NewViolations(path string) *Violations {
+	return &Violations{path: path, hosts: make(map[string]bool)}
+}
+
+// OpenViolations appends to the violations log at path, creating its
+// directory if needed
+func OpenViolations(path string) (*Violations, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create violations log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open violations log: %w", err)
+	}
+	return &Violations{file: file, hosts: make(map[string]bool)}, nil
+}
+
+// Record writes a contact from host, described by what, and reports
+// whether it is the first from host this run. A nil Violations records
+// nothing.
+func (v *Violations) Record(host, what string) bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.file == nil && v.path != "" {
+		if opened, err := OpenViolations(v.path); err == nil {
+			v.file = opened.file
+		}
+		v.path = ""
+	}
+	if v.file != nil {
+		fmt.Fprintf(v.file, "[%s] %s %s\n", time.Now().UTC().Format("2006-01-02 15:04:05 UTC"), host, what)
+	}
+	first := !v.hosts[host]
+	v.hosts[host] = true
+	return first
+}
+
+// Close closes the violations log
+func (v *Violations) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.path = ""
+	if v.file == nil {
+		return nil
+	}
+	err := v.file.Close()
+	v.file = nil
+	return err
+}
@@ -0,0 +1,524 @@
+// Package script runs operator scripts at hooks of the spoofer: deciding
+// whether to answer a search, rewriting HTTP response headers and
+// transforming captured credentials. Scripts are written in Lua and run by
+// gopher-lua, each defining a function of its hook's name that is called
+// with a table describing what is about to happen.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/upnp"
+)
+
+// Dir is the directory scripts are loaded from by default
+const Dir = "scripts"
+
+// Script files, one per hook, each defining the function of the same name
+const (
+	MSearchFile  = "msearch.lua"  // msearch(search), for each SSDP response
+	ResponseFile = "response.lua" // response(request, response), for each HTTP response header
+	CredsFile    = "creds.lua"    // creds(capture), for each capture, before it is stored
+)
+
+// Limits on what a script may take, so a runaway one cannot stall the
+// spoofer or exhaust its memory
+const (
+	timeout        = 100 * time.Millisecond // for loading a script, and for each call
+	maxCallDepth   = 200                    // nested Lua calls
+	maxStackSize   = 256 * 1024             // values on the Lua stack
+	maxStringBytes = 64 << 20               // of a string built by string.rep
+)
+
+// Scripts are the hook scripts loaded from a directory
+type Scripts struct {
+	msearch  *hook
+	response *hook
+	creds    *hook
+	logger   logging.Logger
+}
+
+// hook is a loaded script and the function it defines. Each script has an
+// interpreter of its own, so its globals last from one call to the next,
+// and calls to it take turns.
+type hook struct {
+	file   string
+	mu     sync.Mutex
+	state  *lua.LState
+	fn     lua.LValue
+	failed bool // a call failed since the last that worked
+}
+
+// Load runs the scripts in dir, which must define their hook functions. No
+// directory, a missing one or one without any of the script files gives
+// nil.
+func Load(dir string, logger logging.Logger) (*Scripts, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	s := &Scripts{logger: logger}
+	found := false
+	for _, h := range []struct {
+		file string
+		name string
+		hook **hook
+	}{
+		{MSearchFile, "msearch", &s.msearch},
+		{ResponseFile, "response", &s.response},
+		{CredsFile, "creds", &s.creds},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, h.file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script: %w", err)
+		}
+		state := newState(h.file, logger)
+		if err := run(state, h.file, string(data)); err != nil {
+			state.Close()
+			return nil, fmt.Errorf("failed to load script: %w", err)
+		}
+		fn := state.GetGlobal(h.name)
+		if fn.Type() != lua.LTFunction {
+			state.Close()
+			return nil, fmt.Errorf("script %s defines no %s function", h.file, h.name)
+		}
+		*h.hook = &hook{file: h.file, state: state, fn: fn}
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// newState returns an interpreter with the libraries scripts may use: the
+// base library without the functions loading files, string, table and
+// math, the clock functions of os, and in_cidr. print writes to the log.
+func newState(file string, logger logging.Logger) *lua.LState {
+	l := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   maxCallDepth,
+		RegistryMaxSize: maxStackSize,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.StringLibName, lua.OpenString},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.OsLibName, lua.OpenOs},
+	} {
+		l.Push(l.NewFunction(lib.open))
+		l.Push(lua.LString(lib.name))
+		l.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "_printregs"} {
+		l.SetGlobal(name, lua.LNil)
+	}
+	os := l.GetGlobal(lua.OsLibName).(*lua.LTable)
+	clock := l.NewTable()
+	for _, name := range []string{"time", "date", "clock"} {
+		clock.RawSetString(name, os.RawGetString(name))
+	}
+	l.SetGlobal(lua.OsLibName, clock)
+	str := l.GetGlobal(lua.StringLibName).(*lua.LTable)
+	str.RawSetString("rep", l.NewFunction(stringRep(str.RawGetString("rep"))))
+
+	l.SetGlobal("print", l.NewFunction(func(l *lua.LState) int {
+		parts := make([]string, l.GetTop())
+		for i := range parts {
+			parts[i] = l.ToStringMeta(l.Get(i + 1)).String()
+		}
+		logger.Log("%sScript %s: %s", ssdp.OkBox, file, strings.Join(parts, "\t"))
+		return 0
+	}))
+	l.SetGlobal("in_cidr", l.NewFunction(inCIDR))
+	return l
+}
+
+// stringRep wraps string.rep, refusing strings longer than maxStringBytes
+func stringRep(rep lua.LValue) lua.LGFunction {
+	return func(l *lua.LState) int {
+		if n := l.CheckInt(2); n > 0 && int64(len(l.CheckString(1)))*int64(n) > maxStringBytes {
+			l.RaiseError("string.rep result too large")
+		}
+		l.Insert(rep, 1)
+		l.Call(l.GetTop()-1, lua.MultRet)
+		return l.GetTop()
+	}
+}
+
+// inCIDR reports whether an address is in a network, as in_cidr(ip, cidr)
+func inCIDR(l *lua.LState) int {
+	ip := l.CheckString(1)
+	_, network, err := net.ParseCIDR(l.CheckString(2))
+	if err != nil {
+		l.RaiseError("%v", err)
+	}
+	parsed := net.ParseIP(ip)
+	l.Push(lua.LBool(parsed != nil && network.Contains(parsed)))
+	return 1
+}
+
+// run runs a script's source, within the time a call has
+func run(l *lua.LState, file, src string) error {
+	fn, err := l.Load(strings.NewReader(src), file)
+	if err != nil {
+		return scriptError(err)
+	}
+	_, err = protectedCall(l, fn)
+	return err
+}
+
+// protectedCall calls fn with args within the time a call has, returning
+// its first result
+func protectedCall(l *lua.LState, fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	l.SetContext(ctx)
+	defer l.RemoveContext()
+	if err := l.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		return nil, scriptError(err)
+	}
+	result := l.Get(-1)
+	l.Pop(1)
+	return result, nil
+}
+
+// scriptError returns the message of a Lua error without its stack trace
+func scriptError(err error) error {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) && apiErr.Object != nil {
+		return errors.New(strings.TrimSpace(apiErr.Object.String()))
+	}
+	return err
+}
+
+// Files returns the names of the scripts loaded
+func (s *Scripts) Files() []string {
+	var files []string
+	for _, h := range []*hook{s.msearch, s.response, s.creds} {
+		if h != nil {
+			files = append(files, h.file)
+		}
+	}
+	return files
+}
+
+// call calls the hook's function with args, returning its first result.
+// Failures are logged once until a call works again, and give false.
+func (s *Scripts) call(h *hook, args ...lua.LValue) (lua.LValue, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result, err := protectedCall(h.state, h.fn, args...)
+	if err != nil {
+		if !h.failed {
+			logging.Notice(s.logger, "%sScript %s failed: %v", ssdp.WarnBox, h.file, err)
+		}
+		h.failed = true
+		return nil, false
+	}
+	h.failed = false
+	return result, true
+}
+
+// invalid logs a value a script set that cannot be used
+func (s *Scripts) invalid(h *hook, what string, v lua.LValue) {
+	logging.Notice(s.logger, "%sScript %s: invalid %s %q", ssdp.WarnBox, h.file, what, v.String())
+}
+
+// dropped reports whether a hook's result asks for what it was called on
+// to be dropped, by being false
+func dropped(result lua.LValue) bool {
+	return result == lua.LFalse
+}
+
+// headerTable returns a table of header values by name, a string for one
+// value and an array for more
+func headerTable(l *lua.LState, header map[string][]string) *lua.LTable {
+	t := l.NewTable()
+	for name, values := range header {
+		if len(values) == 1 {
+			t.RawSetString(name, lua.LString(values[0]))
+			continue
+		}
+		list := l.NewTable()
+		for _, v := range values {
+			list.Append(lua.LString(v))
+		}
+		t.RawSetString(name, list)
+	}
+	return t
+}
+
+// stringsOf returns the strings a script set a header or field to: a
+// string or number for one, an array of them for more
+func stringsOf(v lua.LValue) ([]string, bool) {
+	switch x := v.(type) {
+	case lua.LString, lua.LNumber:
+		return []string{x.String()}, true
+	case *lua.LTable:
+		values := make([]string, 0, x.Len())
+		for i := 1; i <= x.Len(); i++ {
+			switch item := x.RawGetInt(i).(type) {
+			case lua.LString, lua.LNumber:
+				values = append(values, item.String())
+			default:
+				return nil, false
+			}
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// changes compares the table a script was given for header, as keyed by
+// name, with what it holds now. It returns the names set to new values,
+// and those removed.
+func changes(header map[string][]string, t *lua.LTable) (set map[string][]string, removed []string, bad []lua.LValue) {
+	set = make(map[string][]string)
+	t.ForEach(func(k, v lua.LValue) {
+		key, ok := k.(lua.LString)
+		name := string(key)
+		values, ok2 := stringsOf(v)
+		if !ok || !ok2 || strings.ContainsAny(name, "\r\n:") {
+			bad = append(bad, k)
+			return
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				bad = append(bad, lua.LString(value))
+				return
+			}
+		}
+		if old, ok := header[name]; ok && strings.Join(old, "\x00") == strings.Join(values, "\x00") {
+			return
+		}
+		set[name] = values
+	})
+	for name := range header {
+		if t.RawGetString(name) == lua.LNil {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return set, removed, bad
+}
+
+// MSearchHook returns the response mutator calling msearch in
+// msearch.lua, nil without one. It is passed a table of the searching
+// host, the search target st and the headers of the response, keyed by
+// upper-case name. Returning false sends no response; headers set,
+// changed or removed in the table are in the response sent.
+func (s *Scripts) MSearchHook() ssdp.ResponseMutator {
+	if s == nil || s.msearch == nil {
+		return nil
+	}
+	return func(host, st, response string) (string, bool) {
+		l := s.msearch.state
+		header := ssdpHeaders(response)
+		headers := headerTable(l, header)
+		search := l.NewTable()
+		search.RawSetString("host", lua.LString(host))
+		search.RawSetString("st", lua.LString(st))
+		search.RawSetString("headers", headers)
+		result, ok := s.call(s.msearch, search)
+		if !ok {
+			return response, true
+		}
+		if dropped(result) {
+			return "", false
+		}
+		set, removed, bad := changes(header, headers)
+		for _, v := range bad {
+			s.invalid(s.msearch, "header", v)
+		}
+		names := make([]string, 0, len(set))
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			response = setSSDPHeader(response, name, strings.Join(set[name], ", "))
+		}
+		for _, name := range removed {
+			response = removeSSDPHeader(response, name)
+		}
+		return response, true
+	}
+}
+
+// ssdpHeaders returns the headers of an SSDP message by upper-case name
+func ssdpHeaders(message string) map[string][]string {
+	head, _, _ := strings.Cut(message, "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	header := make(map[string][]string)
+	for _, line := range lines[1:] {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			name := strings.ToUpper(strings.TrimSpace(key))
+			header[name] = append(header[name], strings.TrimSpace(value))
+		}
+	}
+	return header
+}
+
+// setSSDPHeader sets a header of an SSDP message, in place if it has one
+// of that name, whatever its case, or at the end otherwise
+func setSSDPHeader(message, name, value string) string {
+	head, _, _ := strings.Cut(message, "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	replaced := false
+	for i, line := range lines[1:] {
+		if key, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			lines[i+1] = name + ": " + value
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, name+": "+value)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n\r\n"
+}
+
+// removeSSDPHeader removes every header of an SSDP message of a name,
+// whatever its case
+func removeSSDPHeader(message, name string) string {
+	head, _, _ := strings.Cut(message, "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	kept := lines[:1]
+	for _, line := range lines[1:] {
+		if key, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\r\n") + "\r\n\r\n"
+}
+
+// ResponseHook returns the HTTP response hook calling response in
+// response.lua, nil without one. It is passed a table of the request's
+// host, method, path, user_agent and headers, and one of the response's
+// status and headers, keyed by canonical name. Headers set, changed or
+// removed in the response's table are in the response sent.
+func (s *Scripts) ResponseHook() upnp.ResponseHook {
+	if s == nil || s.response == nil {
+		return nil
+	}
+	return func(r *http.Request, status int, header http.Header) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		l := s.response.state
+		request := l.NewTable()
+		request.RawSetString("host", lua.LString(host))
+		request.RawSetString("method", lua.LString(r.Method))
+		request.RawSetString("path", lua.LString(r.URL.Path))
+		request.RawSetString("user_agent", lua.LString(r.UserAgent()))
+		request.RawSetString("headers", headerTable(l, r.Header))
+		headers := headerTable(l, header)
+		response := l.NewTable()
+		response.RawSetString("status", lua.LNumber(status))
+		response.RawSetString("headers", headers)
+		if _, ok := s.call(s.response, request, response); !ok {
+			return
+		}
+		set, removed, bad := changes(header, headers)
+		for _, v := range bad {
+			s.invalid(s.response, "header", v)
+		}
+		for _, name := range removed {
+			header.Del(name)
+		}
+		for name, values := range set {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+}
+
+// Credentials returns Events passing every capture through creds in
+// creds.lua before handing it to sub, or sub itself without one. It is
+// passed a table of the capture's host, user_agent, source, username,
+// password and extra fields. Returning false keeps the capture from sub;
+// the username, password and extra fields as the table then holds them
+// are passed on.
+func (s *Scripts) Credentials(sub events.Events) events.Events {
+	if s == nil || s.creds == nil {
+		return sub
+	}
+	return transformCreds{Events: sub, s: s}
+}
+
+// transformCreds runs the creds script on captures before passing them on
+type transformCreds struct {
+	events.Events
+	s *Scripts
+}
+
+func (t transformCreds) OnCredentials(e events.Credentials) {
+	l := t.s.creds.state
+	extra := headerTable(l, e.Extra)
+	capture := l.NewTable()
+	capture.RawSetString("host", lua.LString(e.Host))
+	capture.RawSetString("user_agent", lua.LString(e.UserAgent))
+	capture.RawSetString("source", lua.LString(e.Source))
+	capture.RawSetString("username", lua.LString(e.Username))
+	capture.RawSetString("password", lua.LString(e.Password))
+	capture.RawSetString("extra", extra)
+	result, ok := t.s.call(t.s.creds, capture)
+	if ok {
+		if dropped(result) {
+			return
+		}
+		e.Username = t.field(capture, "username", e.Username)
+		e.Password = t.field(capture, "password", e.Password)
+		if set, removed, bad := changes(e.Extra, extra); len(set) > 0 || len(removed) > 0 || len(bad) > 0 {
+			for _, v := range bad {
+				t.s.invalid(t.s.creds, "extra field", v)
+			}
+			fields := make(url.Values, len(e.Extra)+len(set))
+			for name, values := range e.Extra {
+				fields[name] = values
+			}
+			for _, name := range removed {
+				delete(fields, name)
+			}
+			for name, values := range set {
+				fields[name] = values
+			}
+			e.Extra = fields
+		}
+	}
+	t.Events.OnCredentials(e)
+}
+
+// field returns the string the script left in a field of capture, empty
+// for nil, or old if it is not a string
+func (t transformCreds) field(capture *lua.LTable, name, old string) string {
+	switch v := capture.RawGetString(name).(type) {
+	case *lua.LNilType:
+		return ""
+	case lua.LString, lua.LNumber:
+		return v.String()
+	default:
+		t.s.invalid(t.s.creds, name, v)
+		return old
+	}
+}
@@ -0,0 +1,197 @@
+package script
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+)
+
+// load writes the scripts given by file name to a directory and loads it
+func load(t *testing.T, files map[string]string) *Scripts {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Load(dir, logging.NewConsoleLogger(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+const ssdpResponse = "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nServer: Linux UPnP/1.0\r\nST: upnp:rootdevice\r\nEXT:\r\n\r\n"
+
+func TestMSearchHook(t *testing.T) {
+	s := load(t, map[string]string{MSearchFile: `
+seen = 0
+function msearch(search)
+  seen = seen + 1
+  if in_cidr(search.host, "10.20.0.0/16") or search.st:find("printer", 1, true) then
+    return false
+  end
+  search.headers.SERVER = "Sonos/70.3 " .. search.headers.SERVER
+  search.headers.EXT = nil
+  search.headers["X-SEEN"] = seen
+end`})
+	hook := s.MSearchHook()
+
+	if _, ok := hook("10.20.1.1", "upnp:rootdevice", ssdpResponse); ok {
+		t.Error("search from the excluded subnet answered")
+	}
+	if _, ok := hook("192.0.2.10", "urn:schemas-upnp-org:device:printer:1", ssdpResponse); ok {
+		t.Error("printer search answered")
+	}
+	got, ok := hook("192.0.2.10", "upnp:rootdevice", ssdpResponse)
+	want := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nSERVER: Sonos/70.3 Linux UPnP/1.0\r\nST: upnp:rootdevice\r\nX-SEEN: 3\r\n\r\n"
+	if !ok || got != want {
+		t.Errorf("response %q, want %q", got, want)
+	}
+}
+
+func TestResponseHook(t *testing.T) {
+	s := load(t, map[string]string{ResponseFile: `
+function response(request, response)
+  if request.path == "/present.html" and response.status == 200 then
+    response.headers["Server"] = "Microsoft-IIS/10.0"
+    response.headers["X-Powered-By"] = nil
+    response.headers["set-cookie"] = {"a=1", "b=2"}
+  end
+  response.headers["X-Agent"] = request.user_agent
+end`})
+	hook := s.ResponseHook()
+
+	r := httptest.NewRequest(http.MethodGet, "/present.html", nil)
+	r.Header.Set("User-Agent", "Test/1.0")
+	header := http.Header{"Server": {"nginx"}, "X-Powered-By": {"PHP"}, "Content-Type": {"text/html"}}
+	hook(r, http.StatusOK, header)
+	want := http.Header{
+		"Server":       {"Microsoft-IIS/10.0"},
+		"Set-Cookie":   {"a=1", "b=2"},
+		"Content-Type": {"text/html"},
+		"X-Agent":      {"Test/1.0"},
+	}
+	if len(header) != len(want) {
+		t.Errorf("header %v, want %v", header, want)
+	}
+	for name, values := range want {
+		if strings.Join(header[name], "|") != strings.Join(values, "|") {
+			t.Errorf("%s: %q, want %q", name, header[name], values)
+		}
+	}
+}
+
+// captured collects the credentials passed on
+type captured struct {
+	events.Nop
+	creds []events.Credentials
+}
+
+func (c *captured) OnCredentials(e events.Credentials) {
+	c.creds = append(c.creds, e)
+}
+
+func TestCredentials(t *testing.T) {
+	s := load(t, map[string]string{CredsFile: `
+function creds(capture)
+  if capture.password == "" then
+    return false
+  end
+  capture.username = capture.username:lower()
+  capture.extra.domain = capture.username:match("^([^\\]+)\\") or "local"
+  capture.extra.otp = nil
+end`})
+	sub := &captured{}
+	e := s.Credentials(sub)
+
+	e.OnCredentials(events.Credentials{Username: "alice"})
+	e.OnCredentials(events.Credentials{Username: `CORP\Bob`, Password: "pw", Extra: url.Values{"otp": {"123"}, "remember": {"on"}}})
+	if len(sub.creds) != 1 {
+		t.Fatalf("passed on %d captures, want 1", len(sub.creds))
+	}
+	got := sub.creds[0]
+	if got.Username != `corp\bob` || got.Password != "pw" || got.Extra.Encode() != "domain=corp&remember=on" {
+		t.Errorf("passed on %s %s %v", got.Username, got.Password, got.Extra)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct{ src, want string }{
+		{"function msearch(", "failed to load script: msearch.lua at EOF:"},
+		{"error('at load')", "failed to load script: msearch.lua:1: at load"},
+		{"function other() end", "script msearch.lua defines no msearch function"},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, MSearchFile), []byte(tt.src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir, logging.Discard); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %s", tt.src, err, tt.want)
+		}
+	}
+
+	// A call that fails or runs away leaves the response as it was
+	s := load(t, map[string]string{MSearchFile: `
+function msearch(search)
+  if search.st == "loop" then
+    while true do end
+  end
+  search.headers.SERVER = search.missing.field
+end`})
+	hook := s.MSearchHook()
+	for _, st := range []string{"loop", "upnp:rootdevice"} {
+		if got, ok := hook("192.0.2.10", st, ssdpResponse); !ok || got != ssdpResponse {
+			t.Errorf("%s: response %q, %v", st, got, ok)
+		}
+	}
+
+	if s, err := Load(t.TempDir(), logging.Discard); s != nil || err != nil {
+		t.Errorf("empty directory gave %v, %v", s, err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	s := load(t, map[string]string{MSearchFile: `
+function deep(n) return deep(n + 1) + 1 end
+function msearch(search)
+  local missing = {}
+  for _, name in ipairs({"io", "dofile", "loadfile", "require", "package", "debug", "coroutine"}) do
+    if _G[name] ~= nil then missing[#missing + 1] = name end
+  end
+  for _, name in ipairs({"execute", "exit", "getenv", "remove", "rename"}) do
+    if os[name] ~= nil then missing[#missing + 1] = "os." .. name end
+  end
+  search.headers["X-EXPOSED"] = table.concat(missing, ",")
+  search.headers["X-TIME"] = type(os.time()) .. "," .. type(os.date("%Y")) .. "," .. type(os.clock())
+  if search.st == "rep" then
+    search.headers.BIG = string.rep("x", 1e9)
+  elseif search.st == "deep" then
+    deep(1)
+  end
+end`})
+	hook := s.MSearchHook()
+
+	got, ok := hook("192.0.2.10", "upnp:rootdevice", ssdpResponse)
+	headers := ssdpHeaders(got)
+	if !ok || len(headers["X-EXPOSED"]) != 1 || headers["X-EXPOSED"][0] != "" {
+		t.Errorf("script can reach %q", headers["X-EXPOSED"])
+	}
+	if strings.Join(headers["X-TIME"], "") != "number,string,number" {
+		t.Errorf("clock functions gave %q", headers["X-TIME"])
+	}
+	for _, st := range []string{"rep", "deep"} {
+		if got, ok := hook("192.0.2.10", st, ssdpResponse); !ok || got != ssdpResponse {
+			t.Errorf("%s: response %q, %v", st, got, ok)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package ship uploads the logs, event store and loot of a sensor to
+// S3-compatible object storage as they change, so a fleet of sensors ends
+// up in one bucket without anyone collecting from each box.
+package ship
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// DefaultInterval is how often changed files are shipped
+const DefaultInterval = 5 * time.Minute
+
+// DefaultRegion is the region requests are signed for when none is set
+const DefaultRegion = "us-east-1"
+
+// Server-side encryption modes
+const (
+	SSES3  = "AES256"  // keys managed by the storage service
+	SSEKMS = "aws:kms" // a KMS key, the default one or aws:kms:KEY-ID
+)
+
+// Shipper uploads every file under some directories that is new or changed
+// since it last shipped it, each as an object named PREFIX/PATH
+type Shipper struct {
+	endpoint *url.URL // scheme and host requests go to
+	bucket   string   // leading path of requests, empty for virtual-hosted buckets
+	prefix   string
+	region   string
+	sse      string
+	kmsKey   string
+	creds    Credentials
+	dirs     []string
+	logger   logging.Logger
+	client   *http.Client
+	shipped  map[string]shippedFile
+}
+
+// shippedFile is the state of a file when it was last shipped
+type shippedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// Option configures a Shipper
+type Option func(*Shipper)
+
+// WithRegion signs requests for region instead of DefaultRegion, and names
+// the AWS endpoint of s3:// targets
+func WithRegion(region string) Option {
+	return func(s *Shipper) {
+		if region != "" {
+			s.region = region
+		}
+	}
+}
+
+// WithEncryption asks the storage to encrypt each object at rest, with
+// SSES3, SSEKMS or aws:kms:KEY-ID
+func WithEncryption(mode string) Option {
+	return func(s *Shipper) {
+		s.sse = mode
+	}
+}
+
+// WithLogger reports shipping and its failures to logger
+func WithLogger(logger logging.Logger) Option {
+	return func(s *Shipper) {
+		s.logger = logger
+	}
+}
+
+// New creates a Shipper uploading the files under dirs to target, signed
+// with creds. target is s3://BUCKET[/PREFIX] for AWS, or
+// http(s)://ENDPOINT/BUCKET[/PREFIX] for other S3-compatible storage, such
+// as MinIO or Ceph, addressed path-style. Objects go under the local host
+// name when target has no prefix.
+func New(target string, dirs []string, creds Credentials, opts ...Option) (*Shipper, error) {
+	s := &Shipper{
+		region:  DefaultRegion,
+		creds:   creds,
+		dirs:    dirs,
+		logger:  &logging.UTCLogger{},
+		client:  &http.Client{Timeout: 5 * time.Minute},
+		shipped: make(map[string]shippedFile),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid shipping target %q, expected s3://BUCKET[/PREFIX] or https://ENDPOINT/BUCKET[/PREFIX]", target)
+	}
+	rest := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		s.endpoint = &url.URL{Scheme: "https", Host: u.Host + ".s3." + s.region + ".amazonaws.com"}
+		s.prefix = rest
+	case "http", "https":
+		s.endpoint = &url.URL{Scheme: u.Scheme, Host: u.Host}
+		s.bucket, s.prefix, _ = strings.Cut(rest, "/")
+		if s.bucket == "" {
+			return nil, fmt.Errorf("shipping target %q names no bucket", target)
+		}
+	default:
+		return nil, fmt.Errorf("invalid shipping target %q, expected s3://, http:// or https://", target)
+	}
+	if s.prefix == "" {
+		if s.prefix, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("shipping target has no prefix and the host name is unknown: %w", err)
+		}
+	}
+
+	switch {
+	case s.sse == "", s.sse == SSES3, s.sse == SSEKMS:
+	case strings.HasPrefix(s.sse, SSEKMS+":"):
+		s.sse, s.kmsKey = SSEKMS, strings.TrimPrefix(s.sse, SSEKMS+":")
+	default:
+		return nil, fmt.Errorf("invalid server-side encryption %q, expected %s, %s or %s:KEY-ID", s.sse, SSES3, SSEKMS, SSEKMS)
+	}
+	return s, nil
+}
+
+// String returns where objects go, for the banner
+func (s *Shipper) String() string {
+	where := s.endpoint.String()
+	if s.bucket != "" {
+		where += "/" + s.bucket
+	}
+	return where + "/" + s.prefix + "/"
+}
+
+// Run ships at once and then every interval until ctx is cancelled. Call
+// Flush after it returns to ship what was written while stopping.
+func (s *Shipper) Run(ctx context.Context, interval time.Duration) {
+	s.shipAndLog(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.shipAndLog(ctx)
+		}
+	}
+}
+
+// Flush ships what changed since the last run, waiting at most timeout
+func (s *Shipper) Flush(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s.shipAndLog(ctx)
+}
+
+// shipAndLog ships and reports the outcome
+func (s *Shipper) shipAndLog(ctx context.Context) {
+	n, err := s.Ship(ctx)
+	if err != nil {
+		logging.Notice(s.logger, "%sShipping to %s: %v", ssdp.WarnBox, s, err)
+	}
+	// Only with -v, or the line would change the log and ship it again
+	if n > 0 {
+		logging.Verbose(s.logger, "%sShipped %d file(s) to %s", ssdp.OkBox, n, s)
+	}
+}
+
+// Ship uploads the files that are new or changed since they were last
+// shipped, returning how many were and the first error. Files that fail
+// are tried again on the next call.
+func (s *Shipper) Ship(ctx context.Context) (int, error) {
+	var shipped int
+	var firstErr error
+	for _, dir := range s.dirs {
+		err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			state := shippedFile{size: info.Size(), modTime: info.ModTime()}
+			if s.shipped[name] == state {
+				return nil
+			}
+			if err := s.upload(ctx, name); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return nil
+			}
+			s.shipped[name] = state
+			shipped++
+			return nil
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return shipped, firstErr
+}
+
+// upload puts the file at name as the object PREFIX/name
+func (s *Shipper) upload(ctx context.Context, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The payload is signed by its hash, so the file is read twice
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := path.Join(s.prefix, filepath.ToSlash(name))
+	objectPath := "/" + key
+	if s.bucket != "" {
+		objectPath = "/" + s.bucket + objectPath
+	}
+	u := *s.endpoint
+	u.Path, u.RawPath = objectPath, escapePath(objectPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), io.LimitReader(file, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if s.sse != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.sse)
+	}
+	if s.kmsKey != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.kmsKey)
+	}
+	sign(req, s.creds, s.region, hex.EncodeToString(h.Sum(nil)), time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to upload %s: %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
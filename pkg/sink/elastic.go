@@ -0,0 +1,234 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// DefaultIndex is the index events go to
+const DefaultIndex = "gossdpkit-events"
+
+// Bulk requests are sent once this many events wait, or this often
+const (
+	elasticBatch    = 500
+	elasticInterval = 5 * time.Second
+)
+
+// IndexTemplate is the index template installed for the events index:
+// keyword fields for filtering and aggregation, time as the date field
+//
+//go:embed index-template.json
+var IndexTemplate []byte
+
+// Elastic is an events subscriber that bulk-indexes events into
+// Elasticsearch or OpenSearch. Batches that fail are retried with the next
+// one, keeping up to queueSize events.
+type Elastic struct {
+	store.Records
+	base     *url.URL
+	index    string
+	apiKey   string
+	client   *http.Client
+	logger   logging.Logger
+	queue    *queue
+	retrying []store.Record
+	failing  bool // the last request failed
+}
+
+var _ events.Events = (*Elastic)(nil)
+
+// ElasticOption configures an Elastic sink
+type ElasticOption func(*Elastic)
+
+// WithElasticIndex indexes into index instead of DefaultIndex
+func WithElasticIndex(index string) ElasticOption {
+	return func(e *Elastic) {
+		if index != "" {
+			e.index = index
+		}
+	}
+}
+
+// WithElasticAPIKey authenticates with an API key, as base64 of ID:KEY,
+// instead of the user and password in the URL
+func WithElasticAPIKey(key string) ElasticOption {
+	return func(e *Elastic) {
+		e.apiKey = key
+	}
+}
+
+// WithElasticInsecure skips verifying the cluster's certificate, for
+// clusters with self-signed ones
+func WithElasticInsecure() ElasticOption {
+	return func(e *Elastic) {
+		e.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+}
+
+// WithElasticLogger reports failures to logger instead of stdout
+func WithElasticLogger(logger logging.Logger) ElasticOption {
+	return func(e *Elastic) {
+		e.logger = logger
+	}
+}
+
+// NewElastic creates a sink indexing into the cluster at rawURL, which may
+// carry a user and password, and starts delivering
+func NewElastic(rawURL string, opts ...ElasticOption) (*Elastic, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid Elasticsearch URL, expected http(s)://[USER:PASSWORD@]HOST:PORT")
+	}
+	e := &Elastic{
+		base:   base,
+		index:  DefaultIndex,
+		client: &http.Client{Timeout: 30 * time.Second},
+		logger: &logging.UTCLogger{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if strings.ToLower(e.index) != e.index || strings.ContainsAny(e.index, `\/*?"<>| ,#:`) {
+		return nil, fmt.Errorf("invalid index name %q", e.index)
+	}
+	e.queue = newQueue("Elasticsearch", e.logger)
+	e.Records = e.queue.add
+	go e.queue.run(elasticBatch, elasticInterval, e.deliver)
+	return e, nil
+}
+
+// Index returns the index events go to
+func (e *Elastic) Index() string {
+	return e.index
+}
+
+// InstallTemplate installs IndexTemplate for the index, so it is created
+// with the right mappings. A template already there is replaced.
+func (e *Elastic) InstallTemplate() error {
+	var template map[string]interface{}
+	if err := json.Unmarshal(IndexTemplate, &template); err != nil {
+		return err
+	}
+	template["index_patterns"] = []string{e.index + "*"}
+	body, _ := json.Marshal(template)
+	resp, err := e.do(http.MethodPut, "/_index_template/"+e.index, "application/json", body)
+	if err != nil {
+		return fmt.Errorf("failed to install the Elasticsearch index template: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Close indexes the events still queued and stops
+func (e *Elastic) Close() {
+	e.queue.close()
+}
+
+// deliver bulk-indexes batch, behind any events a failed request left
+func (e *Elastic) deliver(batch []store.Record) {
+	records := append(e.retrying, batch...)
+	if len(records) > queueSize {
+		records = records[len(records)-queueSize:]
+	}
+	e.retrying = nil
+	if len(records) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	action, _ := json.Marshal(map[string]interface{}{"create": map[string]string{"_index": e.index}})
+	enc := json.NewEncoder(&body)
+	for _, r := range records {
+		body.Write(action)
+		body.WriteByte('\n')
+		enc.Encode(r)
+	}
+
+	resp, err := e.do(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		// Said once, not every batch, while the cluster is down
+		if !e.failing {
+			logging.Notice(e.logger, "%sElasticsearch: %v, retrying with the next batch", ssdp.WarnBox, err)
+		}
+		e.failing, e.retrying = true, records
+		return
+	}
+	defer resp.Body.Close()
+	if e.failing {
+		logging.Notice(e.logger, "%sElasticsearch reachable again, indexed %d event(s)", ssdp.OkBox, len(records))
+		e.failing = false
+	}
+
+	// Events the cluster rejected, say for a mapping conflict, would be
+	// rejected again, so they are reported and dropped
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+		return
+	}
+	rejected := 0
+	var first string
+	for _, item := range result.Items {
+		for _, outcome := range item {
+			if outcome.Error != nil {
+				if rejected == 0 {
+					first = outcome.Error.Type + ": " + outcome.Error.Reason
+				}
+				rejected++
+			}
+		}
+	}
+	if rejected > 0 {
+		logging.Notice(e.logger, "%sElasticsearch rejected %d event(s): %s", ssdp.WarnBox, rejected, first)
+	}
+}
+
+// do sends a request to the cluster, failing on any status but 2xx
+func (e *Elastic) do(method, path, contentType string, body []byte) (*http.Response, error) {
+	u := *e.base
+	u.User = nil
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case e.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	case e.base.User != nil:
+		password, _ := e.base.User.Password()
+		req.SetBasicAuth(e.base.User.Username(), password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,627 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// DefaultKafkaTopic is the topic events are produced to
+const DefaultKafkaTopic = "gossdpkit-events"
+
+// Records are produced once this many wait, or this often
+const (
+	kafkaBatch    = 500
+	kafkaInterval = time.Second
+)
+
+// Kafka API keys and the versions used, the oldest still served by
+// current brokers
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 4
+)
+
+// kafkaRetriable are the error codes of a partition that a later attempt,
+// with fresh metadata, may get past: the leader moved or is not available,
+// a timeout or too few in-sync replicas
+var kafkaRetriable = map[int16]bool{3: true, 5: true, 6: true, 7: true, 13: true, 19: true, 20: true, 56: true}
+
+// kafkaTopicName is what brokers accept as a topic name
+var kafkaTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// Kafka is an events subscriber that produces each event as JSON to a
+// Kafka topic, keyed by the source address so a host's events stay in one
+// partition and in order. Records are only dropped once every in-sync
+// replica has them (acks=all), or when the cluster rejects them for good,
+// so delivery is at least once.
+type Kafka struct {
+	store.Records
+	brokers  []string
+	topic    string
+	tls      *tls.Config
+	username string
+	password string
+	clientID string
+	logger   logging.Logger
+	queue    *queue
+
+	mu          sync.Mutex
+	conns       map[string]*kafkaConn // by address
+	nodes       map[int32]string      // broker addresses by node ID
+	leaders     []int32               // leader node of each partition
+	correlation int32
+	retrying    []store.Record
+	failing     bool // the last attempt to produce failed
+}
+
+var _ events.Events = (*Kafka)(nil)
+
+// KafkaOption configures a Kafka sink
+type KafkaOption func(*Kafka)
+
+// WithKafkaTopic produces to topic instead of DefaultKafkaTopic
+func WithKafkaTopic(topic string) KafkaOption {
+	return func(k *Kafka) {
+		if topic != "" {
+			k.topic = topic
+		}
+	}
+}
+
+// WithKafkaTLS connects to the brokers over TLS, skipping verification of
+// their certificates when insecure
+func WithKafkaTLS(insecure bool) KafkaOption {
+	return func(k *Kafka) {
+		k.tls = &tls.Config{InsecureSkipVerify: insecure}
+	}
+}
+
+// WithKafkaSASL authenticates with SASL/PLAIN
+func WithKafkaSASL(username, password string) KafkaOption {
+	return func(k *Kafka) {
+		k.username, k.password = username, password
+	}
+}
+
+// WithKafkaLogger reports failures to logger instead of stdout
+func WithKafkaLogger(logger logging.Logger) KafkaOption {
+	return func(k *Kafka) {
+		k.logger = logger
+	}
+}
+
+// NewKafka creates a sink producing to the cluster reached through brokers,
+// a comma-separated list of HOST[:PORT], and starts delivering. It
+// connects with the first events, or on Connect.
+func NewKafka(brokers string, opts ...KafkaOption) (*Kafka, error) {
+	k := &Kafka{
+		topic:  DefaultKafkaTopic,
+		logger: &logging.UTCLogger{},
+		conns:  make(map[string]*kafkaConn),
+	}
+	for _, broker := range strings.Split(brokers, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, "9092")
+		}
+		k.brokers = append(k.brokers, broker)
+	}
+	if len(k.brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers, expected HOST[:PORT][,HOST[:PORT]...]")
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if !kafkaTopicName.MatchString(k.topic) || k.topic == "." || k.topic == ".." {
+		return nil, fmt.Errorf("invalid Kafka topic %q", k.topic)
+	}
+
+	k.clientID = "gossdpkit"
+	if host, err := os.Hostname(); err == nil {
+		k.clientID += "-" + host
+	}
+
+	k.queue = newQueue("Kafka", k.logger)
+	k.Records = k.queue.add
+	go k.queue.run(kafkaBatch, kafkaInterval, k.deliver)
+	return k, nil
+}
+
+// Topic returns the topic events are produced to
+func (k *Kafka) Topic() string {
+	return k.topic
+}
+
+// Brokers returns the bootstrap brokers
+func (k *Kafka) Brokers() []string {
+	return k.brokers
+}
+
+// Connect fetches the partitions of the topic and their leaders. Events
+// are retried without another warning if it fails.
+func (k *Kafka) Connect() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	err := k.refresh()
+	k.failing = err != nil
+	return err
+}
+
+// Close produces the events still queued and disconnects
+func (k *Kafka) Close() {
+	k.queue.close()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.disconnect()
+}
+
+// deliver produces batch, behind any events a failed attempt left. Only
+// events the cluster acknowledged or rejected for good leave the queue.
+func (k *Kafka) deliver(batch []store.Record) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	records := append(k.retrying, batch...)
+	if len(records) > queueSize {
+		records = records[len(records)-queueSize:]
+	}
+	k.retrying = nil
+	if len(records) == 0 {
+		return
+	}
+
+	var err error
+	if k.leaders == nil {
+		err = k.refresh()
+	}
+	if err == nil {
+		k.retrying, err = k.produce(records)
+	}
+	if err == nil && len(k.retrying) > 0 {
+		// Leadership moved: ask again before the next batch
+		k.leaders = nil
+		err = fmt.Errorf("%d event(s) not acknowledged", len(k.retrying))
+	}
+	if err != nil {
+		if k.retrying == nil {
+			k.retrying = records
+		}
+		// Said once, not every batch, while the cluster is down
+		if !k.failing {
+			logging.Notice(k.logger, "%sKafka: %v, retrying with the next batch", ssdp.WarnBox, err)
+		}
+		k.failing = true
+		return
+	}
+	if k.failing {
+		logging.Notice(k.logger, "%sKafka reachable again, produced %d event(s)", ssdp.OkBox, len(records))
+		k.failing = false
+	}
+}
+
+// produce sends records to the leaders of their partitions, returning the
+// ones to try again. A failed connection is returned as an error, with
+// every record to try again.
+func (k *Kafka) produce(records []store.Record) ([]store.Record, error) {
+	// Partitions are chosen like the Java client's default partitioner
+	// does for keyed records, so other producers keying by address agree
+	byPartition := make(map[int32][]store.Record)
+	for _, r := range records {
+		partition := (murmur2([]byte(r.Host)) & 0x7fffffff) % int32(len(k.leaders))
+		byPartition[partition] = append(byPartition[partition], r)
+	}
+	byLeader := make(map[int32][]int32)
+	for partition := range byPartition {
+		leader := k.leaders[partition]
+		byLeader[leader] = append(byLeader[leader], partition)
+	}
+
+	var retry []store.Record
+	for leader, partitions := range byLeader {
+		addr, ok := k.nodes[leader]
+		if !ok {
+			for _, partition := range partitions {
+				retry = append(retry, byPartition[partition]...)
+			}
+			continue
+		}
+
+		var body kafkaWriter
+		body.nullableString(nil) // transactional ID
+		body.int16(-1)           // acks from every in-sync replica
+		body.int32(30000)        // timeout in ms
+		body.int32(1)
+		body.string(k.topic)
+		body.int32(int32(len(partitions)))
+		for _, partition := range partitions {
+			body.int32(partition)
+			body.bytes(recordBatch(byPartition[partition]))
+		}
+
+		resp, err := k.request(addr, kafkaProduce, kafkaProduceVersion, body.Bytes())
+		if err != nil {
+			k.leaders = nil
+			return records, err
+		}
+		acknowledged := make(map[int32]bool)
+		for topics := resp.int32(); topics > 0 && resp.err == nil; topics-- {
+			resp.string()
+			for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+				partition, code := resp.int32(), resp.int16()
+				resp.int64() // base offset
+				resp.int64() // log append time
+				switch {
+				case code == 0:
+					acknowledged[partition] = true
+				case kafkaRetriable[code]:
+				default:
+					logging.Notice(k.logger, "%sKafka rejected %d event(s) for partition %d with error code %d", ssdp.WarnBox, len(byPartition[partition]), partition, code)
+					acknowledged[partition] = true
+				}
+			}
+		}
+		if resp.err != nil {
+			k.leaders = nil
+			return records, fmt.Errorf("malformed produce response from %s", addr)
+		}
+		for _, partition := range partitions {
+			if !acknowledged[partition] {
+				retry = append(retry, byPartition[partition]...)
+			}
+		}
+	}
+	return retry, nil
+}
+
+// refresh asks the bootstrap brokers, in turn, for the brokers of the
+// cluster and the leaders of the topic's partitions
+func (k *Kafka) refresh() error {
+	var body kafkaWriter
+	body.int32(1)
+	body.string(k.topic)
+	body.int8(1) // let the broker create the topic if it is configured to
+
+	var lastErr error
+	for _, broker := range k.brokers {
+		resp, err := k.request(broker, kafkaMetadata, kafkaMetadataVersion, body.Bytes())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.int32() // throttle time
+		nodes := make(map[int32]string)
+		for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+			id, host, port := resp.int32(), resp.string(), resp.int32()
+			resp.nullableString() // rack
+			nodes[id] = net.JoinHostPort(host, fmt.Sprint(port))
+		}
+		resp.nullableString() // cluster ID
+		resp.int32()          // controller ID
+		var leaders []int32
+		var topicErr int16
+		for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+			topicErr = resp.int16()
+			resp.string()
+			resp.int8() // internal
+			partitions := resp.int32()
+			if partitions < 0 || int(partitions) > len(resp.data) {
+				resp.err = io.ErrUnexpectedEOF
+				break
+			}
+			leaders = make([]int32, partitions)
+			for ; partitions > 0 && resp.err == nil; partitions-- {
+				resp.int16()
+				index, leader := resp.int32(), resp.int32()
+				resp.int32s() // replicas
+				resp.int32s() // in-sync replicas
+				if index >= 0 && int(index) < len(leaders) {
+					leaders[index] = leader
+				}
+			}
+		}
+		if resp.err != nil {
+			lastErr = fmt.Errorf("malformed metadata response from %s", broker)
+			continue
+		}
+		if topicErr != 0 || len(leaders) == 0 {
+			return fmt.Errorf("topic %s is not available (error code %d)", k.topic, topicErr)
+		}
+		k.nodes, k.leaders = nodes, leaders
+		return nil
+	}
+	return lastErr
+}
+
+// request sends a request to the broker at addr and returns the body of
+// its response, connecting first if needed
+func (k *Kafka) request(addr string, apiKey, version int16, body []byte) (*kafkaReader, error) {
+	conn, ok := k.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = k.dial(addr); err != nil {
+			return nil, err
+		}
+		k.conns[addr] = conn
+	}
+	resp, err := conn.roundTrip(k.nextCorrelation(), k.clientID, apiKey, version, body)
+	if err != nil {
+		conn.Close()
+		delete(k.conns, addr)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// dial connects to the broker at addr, authenticating if a user is set
+func (k *Kafka) dial(addr string) (*kafkaConn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if k.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, k.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConn{Conn: conn}
+	if k.username == "" {
+		return c, nil
+	}
+
+	var mechanism kafkaWriter
+	mechanism.string("PLAIN")
+	resp, err := c.roundTrip(k.nextCorrelation(), k.clientID, kafkaSaslHandshake, 1, mechanism.Bytes())
+	if err == nil && resp.int16() != 0 {
+		err = fmt.Errorf("%s does not allow SASL/PLAIN", addr)
+	}
+	if err == nil {
+		var auth kafkaWriter
+		auth.bytes([]byte("\x00" + k.username + "\x00" + k.password))
+		resp, err = c.roundTrip(k.nextCorrelation(), k.clientID, kafkaSaslAuthenticate, 0, auth.Bytes())
+		if err == nil {
+			if code := resp.int16(); code != 0 {
+				message := "authentication failed"
+				if m := resp.nullableString(); m != nil && *m != "" {
+					message = *m
+				}
+				err = fmt.Errorf("%s: %s", addr, message)
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// disconnect closes every broker connection
+func (k *Kafka) disconnect() {
+	for addr, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, addr)
+	}
+}
+
+func (k *Kafka) nextCorrelation() int32 {
+	k.correlation++
+	return k.correlation
+}
+
+// kafkaConn is a connection to one broker, used for one request at a time
+type kafkaConn struct {
+	net.Conn
+}
+
+// roundTrip sends a request and reads the response to it
+func (c *kafkaConn) roundTrip(correlation int32, clientID string, apiKey, version int16, body []byte) (*kafkaReader, error) {
+	var header kafkaWriter
+	header.int32(0) // size, set below
+	header.int16(apiKey)
+	header.int16(version)
+	header.int32(correlation)
+	header.string(clientID)
+	header.Write(body)
+	request := header.Bytes()
+	binary.BigEndian.PutUint32(request, uint32(len(request)-4))
+
+	c.SetDeadline(time.Now().Add(45 * time.Second))
+	defer c.SetDeadline(time.Time{})
+	if _, err := c.Write(request); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 64<<20 {
+		return nil, fmt.Errorf("%s sent a response of %d bytes", c.RemoteAddr(), n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return nil, err
+	}
+	resp := &kafkaReader{data: data}
+	if resp.int32() != correlation {
+		return nil, errors.New("response out of order")
+	}
+	return resp, nil
+}
+
+// recordBatch encodes records as an uncompressed v2 record batch, keyed by
+// source address and timestamped with the event time
+func recordBatch(records []store.Record) []byte {
+	base := records[0].Time.UnixMilli()
+	last := base
+	var body kafkaWriter
+	for i, r := range records {
+		value, _ := json.Marshal(r)
+		ts := r.Time.UnixMilli()
+		if ts > last {
+			last = ts
+		}
+		var record kafkaWriter
+		record.int8(0) // attributes
+		record.varint(ts - base)
+		record.varint(int64(i))
+		record.varint(int64(len(r.Host)))
+		record.WriteString(r.Host)
+		record.varint(int64(len(value)))
+		record.Write(value)
+		record.varint(0) // headers
+		body.varint(int64(record.Len()))
+		body.Write(record.Bytes())
+	}
+
+	// The CRC covers everything from the attributes on
+	var crcd kafkaWriter
+	crcd.int16(0) // attributes: no compression, create time
+	crcd.int32(int32(len(records) - 1))
+	crcd.int64(base)
+	crcd.int64(last)
+	crcd.int64(-1) // producer ID
+	crcd.int16(-1) // producer epoch
+	crcd.int32(-1) // base sequence
+	crcd.int32(int32(len(records)))
+	crcd.Write(body.Bytes())
+
+	var batch kafkaWriter
+	batch.int64(0)                             // base offset
+	batch.int32(int32(4 + 1 + 4 + crcd.Len())) // length from here on
+	batch.int32(-1)                            // partition leader epoch
+	batch.int8(2)                              // magic
+	batch.int32(int32(crc32.Checksum(crcd.Bytes(), crc32.MakeTable(crc32.Castagnoli))))
+	batch.Write(crcd.Bytes())
+	return batch.Bytes()
+}
+
+// murmur2 is the hash the Java client partitions keyed records by
+func murmur2(data []byte) int32 {
+	const m, r = 0x5bd1e995, 24
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaWriter encodes the big-endian fields of the Kafka protocol
+type kafkaWriter struct {
+	bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(v int8)   { w.WriteByte(byte(v)) }
+func (w *kafkaWriter) int16(v int16) { w.Buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(v))) }
+func (w *kafkaWriter) int32(v int32) { w.Buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(v))) }
+func (w *kafkaWriter) int64(v int64) { w.Buffer.Write(binary.BigEndian.AppendUint64(nil, uint64(v))) }
+
+// varint writes a zigzag varint, as record fields are
+func (w *kafkaWriter) varint(v int64) { w.Buffer.Write(binary.AppendVarint(nil, v)) }
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *kafkaWriter) nullableString(s *string) {
+	if s == nil {
+		w.int16(-1)
+		return
+	}
+	w.string(*s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.Write(b)
+}
+
+// kafkaReader decodes a response, keeping the first error
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes, or once the data runs out zeros enough
+// for any fixed-size field, whatever length a hostile count asked for
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, 8)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8   { return int8(r.next(1)[0]) }
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaReader) int64() int64 { return int64(binary.BigEndian.Uint64(r.next(8))) }
+
+func (r *kafkaReader) string() string {
+	return string(r.next(int(r.int16())))
+}
+
+func (r *kafkaReader) nullableString() *string {
+	n := r.int16()
+	if n < 0 {
+		return nil
+	}
+	s := string(r.next(int(n)))
+	return &s
+}
+
+func (r *kafkaReader) int32s() {
+	n := r.int32()
+	r.next(int(n) * 4)
+}
@@ -0,0 +1,339 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/store"
+)
+
+// testBroker is the address the test sinks are connected to
+const testBroker = "broker:9092"
+
+// crc32c is a bitwise CRC-32C, independent of the table the sink uses
+func crc32c(data []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range data {
+		crc ^= uint32(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x82f63b78
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+func TestCRC32C(t *testing.T) {
+	// Check value of the CRC-32C catalogue entry
+	if got := crc32c([]byte("123456789")); got != 0xe3069283 {
+		t.Fatalf("crc32c = %#x, want 0xe3069283", got)
+	}
+}
+
+// batchRecords are two events 1.5s apart, from different hosts
+var batchRecords = []store.Record{
+	{Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Type: store.TypeHook, Host: "10.0.0.5"},
+	{Time: time.Date(2024, 5, 1, 10, 0, 1, 500e6, time.UTC), Type: store.TypeCreds, Host: "10.0.0.6"},
+}
+
+// batchFrame is batchRecords as a v2 record batch, encoded by hand from the
+// protocol documentation
+const batchFrame = "" +
+	"0000000000000000" + // base offset
+	"000000d4" + // batch length
+	"ffffffff" + // partition leader epoch
+	"02" + // magic
+	"9a48baea" + // CRC-32C of the rest
+	"0000" + // attributes
+	"00000001" + // last offset delta
+	"0000018f3398c100" + // base timestamp, 2024-05-01T10:00:00Z
+	"0000018f3398c6dc" + // max timestamp, 1.5s later
+	"ffffffffffffffff" + // producer ID
+	"ffff" + // producer epoch
+	"ffffffff" + // base sequence
+	"00000002" + // records
+	// record 0: length 77, attributes, timestamp delta 0, offset delta 0,
+	// key 10.0.0.5, value of 63 bytes, no headers
+	"9a01" + "00" + "00" + "00" + "10" + hex10005 +
+	"7e" + "7b2274696d65223a22323032342d30352d30315431303a30303a30305a222c2274797065223a22686f6f6b222c22686f7374223a2231302e302e302e35227d" +
+	"00" +
+	// record 1: length 82, attributes, timestamp delta 1500, offset delta
+	// 1, key 10.0.0.6, value of 66 bytes, no headers
+	"a401" + "00" + "b817" + "02" + "10" + hex10006 +
+	"8401" + "7b2274696d65223a22323032342d30352d30315431303a30303a30312e355a222c2274797065223a226372656473222c22686f7374223a2231302e302e302e36227d" +
+	"00"
+
+const (
+	hex10005 = "31302e302e302e35"
+	hex10006 = "31302e302e302e36"
+)
+
+func TestRecordBatch(t *testing.T) {
+	want, err := hex.DecodeString(batchFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := recordBatch(batchRecords)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("recordBatch =\n%x\nwant\n%x", got, want)
+	}
+	// The CRC covers everything after it
+	if crc := binary.BigEndian.Uint32(got[17:21]); crc != crc32c(got[21:]) {
+		t.Fatalf("CRC field %#x, computed %#x", crc, crc32c(got[21:]))
+	}
+	if length := binary.BigEndian.Uint32(got[8:12]); int(length) != len(got)-12 {
+		t.Fatalf("batch length %d, frame holds %d", length, len(got)-12)
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// Values of the Java client's Utils.murmur2
+	tests := []struct {
+		in   string
+		want int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+	for _, tt := range tests {
+		if got := murmur2([]byte(tt.in)); got != tt.want {
+			t.Errorf("murmur2(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// response frames body as the broker's answer to the request with the
+// given correlation ID
+func response(correlation int32, body []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(correlation))
+	return append(frame, body...)
+}
+
+// testKafka returns a sink connected to a broker that reads each request
+// and answers it with the next of frames, hanging up after the last
+func testKafka(t *testing.T, frames ...[]byte) *Kafka {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		for _, frame := range frames {
+			var size [4]byte
+			if _, err := io.ReadFull(server, size[:]); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, server, int64(binary.BigEndian.Uint32(size[:]))); err != nil {
+				return
+			}
+			if _, err := server.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	return &Kafka{
+		brokers: []string{testBroker},
+		topic:   "events",
+		logger:  logging.NewConsoleLogger(io.Discard),
+		conns:   map[string]*kafkaConn{testBroker: {Conn: client}},
+		nodes:   map[int32]string{1: testBroker},
+		leaders: []int32{1},
+	}
+}
+
+// produceResponse is a v3 produce response for partition 0 of events
+func produceResponse(code int16) []byte {
+	var w kafkaWriter
+	w.int32(1) // topics
+	w.string("events")
+	w.int32(1) // partitions
+	w.int32(0)
+	w.int16(code)
+	w.int64(42) // base offset
+	w.int64(-1) // log append time
+	w.int32(0)  // throttle time
+	return w.Bytes()
+}
+
+func TestProduce(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int16
+		wantRetry bool
+	}{
+		{"acknowledged", 0, false},
+		{"leader moved", 6, true},
+		{"not enough replicas", 19, true},
+		{"rejected for good", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := testKafka(t, response(1, produceResponse(tt.code)))
+			retry, err := k.produce(batchRecords)
+			if err != nil {
+				t.Fatalf("produce: %v", err)
+			}
+			if got := len(retry) > 0; got != tt.wantRetry {
+				t.Fatalf("retry = %v, want retry %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestProduceMalformed(t *testing.T) {
+	valid := produceResponse(0)
+	// The trailing throttle time is not read, so it may be cut
+	for n := 0; n < len(valid)-4; n++ {
+		k := testKafka(t, response(1, valid[:n]))
+		retry, err := k.produce(batchRecords)
+		if err == nil {
+			t.Errorf("%d of %d bytes: no error", n, len(valid))
+		}
+		if len(retry) != len(batchRecords) {
+			t.Errorf("%d of %d bytes: %d records to retry, want all %d", n, len(valid), len(retry), len(batchRecords))
+		}
+		if k.leaders != nil {
+			t.Errorf("%d of %d bytes: leaders kept", n, len(valid))
+		}
+	}
+
+	// Counts far beyond the data
+	var hostile kafkaWriter
+	hostile.int32(1)
+	hostile.string("events")
+	hostile.int32(0x7fffffff)
+	for _, body := range [][]byte{{0x7f, 0xff, 0xff, 0xff}, hostile.Bytes()} {
+		k := testKafka(t, response(1, body))
+		if _, err := k.produce(batchRecords); err == nil {
+			t.Errorf("%x: no error", body)
+		}
+	}
+}
+
+// metadataResponse is a v4 metadata response listing broker 1 as the
+// leader of partitions, with topic error code
+func metadataResponse(code int16, partitions ...int32) []byte {
+	var w kafkaWriter
+	w.int32(0) // throttle time
+	w.int32(1) // brokers
+	w.int32(1)
+	w.string("kafka1")
+	w.int32(9092)
+	w.nullableString(nil) // rack
+	w.nullableString(nil) // cluster ID
+	w.int32(1)            // controller ID
+	w.int32(1)            // topics
+	w.int16(code)
+	w.string("events")
+	w.int8(0) // internal
+	w.int32(int32(len(partitions)))
+	for _, partition := range partitions {
+		w.int16(0)
+		w.int32(partition)
+		w.int32(1) // leader
+		w.int32(1) // replicas
+		w.int32(1)
+		w.int32(1) // in-sync replicas
+		w.int32(1)
+	}
+	return w.Bytes()
+}
+
+func TestRefresh(t *testing.T) {
+	k := testKafka(t, response(1, metadataResponse(0, 0, 1)))
+	k.nodes, k.leaders = nil, nil
+	if err := k.refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if len(k.leaders) != 2 || k.leaders[0] != 1 || k.leaders[1] != 1 {
+		t.Errorf("leaders = %v, want [1 1]", k.leaders)
+	}
+	if got := k.nodes[1]; got != "kafka1:9092" {
+		t.Errorf("node 1 = %q, want kafka1:9092", got)
+	}
+
+	// A partition index past the count is ignored
+	k = testKafka(t, response(1, metadataResponse(0, 5)))
+	k.nodes, k.leaders = nil, nil
+	if err := k.refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if len(k.leaders) != 1 {
+		t.Errorf("leaders = %v, want one partition", k.leaders)
+	}
+
+	k = testKafka(t, response(1, metadataResponse(3)))
+	if err := k.refresh(); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("unknown topic: err = %v", err)
+	}
+}
+
+func TestRefreshMalformed(t *testing.T) {
+	valid := metadataResponse(0, 0, 1)
+	for n := 0; n < len(valid); n++ {
+		k := testKafka(t, response(1, valid[:n]))
+		k.nodes, k.leaders = nil, nil
+		if err := k.refresh(); err == nil {
+			t.Errorf("%d of %d bytes: no error", n, len(valid))
+		}
+		if k.leaders != nil {
+			t.Errorf("%d of %d bytes: leaders set to %v", n, len(valid), k.leaders)
+		}
+	}
+
+	// Partition counts the data cannot hold, and a replica list far longer
+	// than the data
+	for _, count := range []int32{-1, 0x7fffffff} {
+		body := metadataResponse(0)
+		binary.BigEndian.PutUint32(body[len(body)-4:], uint32(count))
+		k := testKafka(t, response(1, body))
+		if err := k.refresh(); err == nil {
+			t.Errorf("%d partitions: no error", count)
+		}
+	}
+	body := metadataResponse(0, 0)
+	binary.BigEndian.PutUint32(body[len(body)-16:], 0x7fffffff)
+	k := testKafka(t, response(1, body))
+	if err := k.refresh(); err == nil {
+		t.Errorf("oversized replica list: no error")
+	}
+}
+
+func TestRoundTripMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"empty", nil},
+		{"short size", []byte{0, 0}},
+		{"too small", []byte{0, 0, 0, 2, 0, 0}},
+		{"too large", binary.BigEndian.AppendUint32(nil, 64<<20+1)},
+		{"cut short", response(1, make([]byte, 16))[:12]},
+		{"out of order", response(7, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := testKafka(t, tt.frame)
+			if _, err := k.request(testBroker, kafkaMetadata, kafkaMetadataVersion, nil); err == nil {
+				t.Fatal("no error")
+			}
+			if _, ok := k.conns[testBroker]; ok {
+				t.Error("failed connection kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// DefaultTopic is the prefix of the topics events are published to
+const DefaultTopic = "gossdpkit"
+
+// Messages are published in batches of up to this many, at least this often
+const (
+	mqttBatch    = 100
+	mqttInterval = time.Second
+)
+
+// mqttKeepAlive is how often the broker hears from the sink when there are
+// no events, so it notices a sensor that went away
+const mqttKeepAlive = 60 * time.Second
+
+// MQTT packet types, as the high nibble of the fixed header
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPingReq    = 0xc0
+	mqttDisconnect = 0xe0
+)
+
+// mqttRefused explains the CONNACK return codes of MQTT 3.1.1
+var mqttRefused = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// MQTT is an events subscriber that publishes each event as JSON to
+// TOPIC/TYPE on an MQTT 3.1.1 broker, TYPE being the event store record
+// type, at QoS 0. TOPIC/status is a retained "online" while connected and
+// "offline" once the sensor stops or drops off.
+type MQTT struct {
+	store.Records
+	addr     string
+	useTLS   bool
+	tls      *tls.Config
+	user     *url.Userinfo
+	topic    string
+	clientID string
+	logger   logging.Logger
+	queue    *queue
+
+	mu       sync.Mutex
+	conn     net.Conn
+	retrying []store.Record
+	failing  bool // the last attempt to publish failed
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+var _ events.Events = (*MQTT)(nil)
+
+// MQTTOption configures an MQTT sink
+type MQTTOption func(*MQTT)
+
+// WithMQTTTopic publishes under topic instead of DefaultTopic
+func WithMQTTTopic(topic string) MQTTOption {
+	return func(m *MQTT) {
+		if topic != "" {
+			m.topic = strings.TrimRight(topic, "/")
+		}
+	}
+}
+
+// WithMQTTInsecure skips verifying the broker's certificate, for brokers
+// with self-signed ones
+func WithMQTTInsecure() MQTTOption {
+	return func(m *MQTT) {
+		m.tls.InsecureSkipVerify = true
+	}
+}
+
+// WithMQTTLogger reports failures to logger instead of stdout
+func WithMQTTLogger(logger logging.Logger) MQTTOption {
+	return func(m *MQTT) {
+		m.logger = logger
+	}
+}
+
+// NewMQTT creates a sink publishing to the broker at rawURL,
+// mqtt://[USER:PASSWORD@]HOST[:PORT] or mqtts:// for TLS, and starts
+// delivering. It connects with the first events, or on Connect.
+func NewMQTT(rawURL string, opts ...MQTTOption) (*MQTT, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid MQTT URL, expected mqtt(s)://[USER:PASSWORD@]HOST[:PORT]")
+	}
+	m := &MQTT{
+		addr:    u.Host,
+		useTLS:  u.Scheme == "mqtts",
+		tls:     &tls.Config{ServerName: u.Hostname()},
+		user:    u.User,
+		topic:   DefaultTopic,
+		logger:  &logging.UTCLogger{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if u.Port() == "" {
+		port := "1883"
+		if m.useTLS {
+			port = "8883"
+		}
+		m.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.topic == "" || strings.ContainsAny(m.topic, "+#\x00") {
+		return nil, fmt.Errorf("invalid MQTT topic %q", m.topic)
+	}
+
+	// Brokers must accept client identifiers of up to 23 characters
+	m.clientID = "gossdpkit"
+	if host, err := os.Hostname(); err == nil {
+		m.clientID += "-" + host
+	}
+	if len(m.clientID) > 23 {
+		m.clientID = m.clientID[:23]
+	}
+
+	m.queue = newQueue("MQTT", m.logger)
+	m.Records = m.queue.add
+	go m.queue.run(mqttBatch, mqttInterval, m.deliver)
+	go m.keepAlive()
+	return m, nil
+}
+
+// Topic returns the prefix of the topics events are published to
+func (m *MQTT) Topic() string {
+	return m.topic
+}
+
+// Connect connects to the broker unless already connected. Events are
+// retried without another warning if it fails.
+func (m *MQTT) Connect() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.connect()
+	m.failing = err != nil
+	return err
+}
+
+// Close publishes the events still queued, marks the sensor offline and
+// disconnects
+func (m *MQTT) Close() {
+	m.queue.close()
+	close(m.stop)
+	<-m.stopped
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return
+	}
+	var packet bytes.Buffer
+	writePublish(&packet, m.topic+"/status", []byte("offline"), true)
+	packet.Write([]byte{mqttDisconnect, 0})
+	m.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	m.conn.Write(packet.Bytes())
+	m.conn.Close()
+	m.conn = nil
+}
+
+// deliver publishes batch, behind any events a failed attempt left
+func (m *MQTT) deliver(batch []store.Record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := append(m.retrying, batch...)
+	if len(records) > queueSize {
+		records = records[len(records)-queueSize:]
+	}
+	m.retrying = nil
+	if len(records) == 0 {
+		return
+	}
+
+	var packet bytes.Buffer
+	for _, r := range records {
+		payload, _ := json.Marshal(r)
+		writePublish(&packet, m.topic+"/"+r.Type, payload, false)
+	}
+
+	err := m.connect()
+	if err == nil {
+		err = m.write(packet.Bytes())
+	}
+	if err != nil {
+		// Said once, not every batch, while the broker is down
+		if !m.failing {
+			logging.Notice(m.logger, "%sMQTT: %v, retrying with the next batch", ssdp.WarnBox, err)
+		}
+		m.failing, m.retrying = true, records
+		return
+	}
+	if m.failing {
+		logging.Notice(m.logger, "%sMQTT broker reachable again, published %d event(s)", ssdp.OkBox, len(records))
+		m.failing = false
+	}
+}
+
+// keepAlive pings the broker while no events are published, so neither
+// end drops the connection as idle
+func (m *MQTT) keepAlive() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			if m.conn != nil {
+				m.write([]byte{mqttPingReq, 0})
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// connect opens the connection, with the status topic as the will so the
+// broker marks the sensor offline if it drops off. Callers hold mu.
+func (m *MQTT) connect() error {
+	if m.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if m.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, m.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writeString(&body, "MQTT")
+	body.WriteByte(4)                 // protocol level 3.1.1
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, will, retained will
+	if m.user != nil {
+		flags |= 0x80
+		if _, ok := m.user.Password(); ok {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{byte(mqttKeepAlive / time.Second >> 8), byte(mqttKeepAlive / time.Second)})
+	writeString(&body, m.clientID)
+	writeString(&body, m.topic+"/status")
+	writeString(&body, "offline")
+	if m.user != nil {
+		writeString(&body, m.user.Username())
+		if password, ok := m.user.Password(); ok {
+			writeString(&body, password)
+		}
+	}
+	var packet bytes.Buffer
+	packet.WriteByte(mqttConnect)
+	writeLength(&packet, body.Len())
+	packet.Write(body.Bytes())
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		conn.Close()
+		return err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return fmt.Errorf("no answer to CONNECT from %s: %w", m.addr, err)
+	}
+	if ack[0] != mqttConnAck || ack[1] != 2 {
+		conn.Close()
+		return fmt.Errorf("%s is not an MQTT broker", m.addr)
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		reason := mqttRefused[ack[3]]
+		if reason == "" {
+			reason = fmt.Sprintf("code %d", ack[3])
+		}
+		return fmt.Errorf("%s refused the connection: %s", m.addr, reason)
+	}
+	conn.SetDeadline(time.Time{})
+	m.conn = conn
+
+	var online bytes.Buffer
+	writePublish(&online, m.topic+"/status", []byte("online"), true)
+	if err := m.write(online.Bytes()); err != nil {
+		return err
+	}
+	go m.drain(conn)
+	return nil
+}
+
+// drain reads what the broker sends, only ping responses at QoS 0, and
+// forgets the connection once the broker closes it
+func (m *MQTT) drain(conn net.Conn) {
+	io.Copy(io.Discard, conn)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == conn {
+		conn.Close()
+		m.conn = nil
+	}
+}
+
+// write sends packets, dropping the connection if that fails. Callers
+// hold mu.
+func (m *MQTT) write(packets []byte) error {
+	if m.conn == nil {
+		return errors.New("not connected")
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := m.conn.Write(packets); err != nil {
+		m.conn.Close()
+		m.conn = nil
+		return err
+	}
+	return nil
+}
+
+// writePublish appends a QoS 0 PUBLISH packet
+func writePublish(b *bytes.Buffer, topic string, payload []byte, retain bool) {
+	header := byte(mqttPublish)
+	if retain {
+		header |= 0x01
+	}
+	b.WriteByte(header)
+	writeLength(b, 2+len(topic)+len(payload))
+	writeString(b, topic)
+	b.Write(payload)
+}
+
+// writeString appends s with its 16-bit length
+func writeString(b *bytes.Buffer, s string) {
+	b.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+	b.WriteString(s)
+}
+
+// writeLength appends the variable-length remaining length of a packet
+func writeLength(b *bytes.Buffer, n int) {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b.WriteByte(digit)
+		if n == 0 {
+			return
+		}
+	}
+}
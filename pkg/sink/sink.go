@@ -0,0 +1,96 @@
+// Package sink forwards every event, in the event store's record format,
+// to systems other tools read from, so dashboards and pipelines get them
+// as they happen without tailing the store.
+package sink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/store"
+)
+
+// queueSize is how many records may wait for delivery before new ones are
+// dropped
+const queueSize = 4096
+
+// queue hands records to a delivery goroutine in batches, so a slow or
+// unreachable sink never stalls the listener
+type queue struct {
+	name    string
+	logger  logging.Logger
+	records chan store.Record
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	full    atomic.Bool // a record was dropped since the queue last had room
+	once    sync.Once
+}
+
+// newQueue creates the queue of the sink called name, reporting drops to
+// logger
+func newQueue(name string, logger logging.Logger) *queue {
+	return &queue{
+		name:    name,
+		logger:  logger,
+		records: make(chan store.Record, queueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// add queues r without blocking, dropping it if the queue is full or closed
+func (q *queue) add(r store.Record) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.records <- r:
+		q.full.Store(false)
+	default:
+		if !q.full.Swap(true) {
+			logging.Notice(q.logger, "%s%s queue full, dropping events until it drains", ssdp.WarnBox, q.name)
+		}
+	}
+}
+
+// run calls deliver with up to size queued records at a time, and every
+// interval with what is queued, even nothing, so sinks can retry records a
+// failed delivery left. It returns once the queue is closed and drained.
+func (q *queue) run(size int, interval time.Duration, deliver func([]store.Record)) {
+	defer close(q.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var batch []store.Record
+	for {
+		select {
+		case r, ok := <-q.records:
+			if !ok {
+				deliver(batch)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) < size {
+				continue
+			}
+		case <-ticker.C:
+		}
+		deliver(batch)
+		batch = nil
+	}
+}
+
+// close delivers the records still queued and stops
+func (q *queue) close() {
+	q.once.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		close(q.records)
+		q.mu.Unlock()
+		<-q.done
+	})
+}
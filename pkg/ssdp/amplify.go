@@ -0,0 +1,175 @@
+package ssdp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxAmplifyTargets caps how many addresses one measurement probes, so a
+// mistyped range does not sweep a whole network
+const MaxAmplifyTargets = 4096
+
+// Amplification is how much SSDP traffic one device sent back for a single
+// unicast M-SEARCH. Devices that answer unicast searches at all answer any
+// source that can reach them, including off-link ones, and so can be used to
+// reflect and amplify spoofed traffic.
+type Amplification struct {
+	Addr      string
+	Responses int     // responses received
+	Bytes     int     // UDP payload bytes received
+	Request   int     // UDP payload bytes of the M-SEARCH sent
+	Factor    float64 // Bytes / Request
+	OffLink   bool    // the device is not on the interface's subnets
+}
+
+// Amplify sends one unicast M-SEARCH for searchTarget from localIP to port
+// 1900 of each target, and measures what each sends back within wait.
+// Targets that did not answer are left out; the result is sorted by factor,
+// highest first.
+func Amplify(localIP string, targets []string, searchTarget string, wait time.Duration) ([]Amplification, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(localIP)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+
+	var networks []*net.IPNet
+	if iface, err := getInterfaceByIP(localIP); err == nil {
+		networks = interfaceNetworks(iface)
+	}
+
+	measured := make(map[string]*Amplification)
+	for _, target := range targets {
+		ip := net.ParseIP(target).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 target %q", target)
+		}
+		// A unicast search names the device itself in HOST. MX is not
+		// required but some stacks drop searches without one.
+		msearch := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ip.String() + ":1900\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 1\r\n" +
+			"ST: " + searchTarget + "\r\n" +
+			"\r\n"
+		if _, err := conn.WriteToUDP([]byte(msearch), &net.UDPAddr{IP: ip, Port: 1900}); err != nil {
+			return nil, fmt.Errorf("failed to send M-SEARCH to %s: %w", ip, err)
+		}
+		measured[ip.String()] = &Amplification{
+			Addr:    ip.String(),
+			Request: len(msearch),
+			OffLink: !onLink(networks, ip),
+		}
+		// Pace the searches so a range does not arrive as one burst
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("error reading UDP data: %w", err)
+		}
+		// Only count responses from the address searched, as reflection
+		// attacks would see them
+		a, ok := measured[addr.IP.String()]
+		if !ok || !strings.HasPrefix(string(buffer[:n]), "HTTP/") {
+			continue
+		}
+		a.Responses++
+		a.Bytes += n
+	}
+
+	var results []Amplification
+	for _, a := range measured {
+		if a.Responses == 0 {
+			continue
+		}
+		a.Factor = float64(a.Bytes) / float64(a.Request)
+		results = append(results, *a)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Factor != results[j].Factor {
+			return results[i].Factor > results[j].Factor
+		}
+		return results[i].Addr < results[j].Addr
+	})
+	return results, nil
+}
+
+// ExpandTargets turns IPv4 addresses and CIDR ranges into the addresses to
+// probe, leaving out the network and broadcast addresses of ranges
+func ExpandTargets(specs []string) ([]string, error) {
+	var targets []string
+	seen := make(map[string]bool)
+	add := func(ip net.IP) error {
+		if seen[ip.String()] {
+			return nil
+		}
+		if len(targets) == MaxAmplifyTargets {
+			return fmt.Errorf("more than %d targets", MaxAmplifyTargets)
+		}
+		seen[ip.String()] = true
+		targets = append(targets, ip.String())
+		return nil
+	}
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IPv4 target %q", spec)
+			}
+			if err := add(ip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 range %q", spec)
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones > 16 {
+			return nil, fmt.Errorf("range %s is larger than a /16", spec)
+		}
+		first := binary.BigEndian.Uint32(network.IP.To4())
+		last := first | (1<<uint(bits-ones) - 1)
+		if last-first > 1 {
+			first, last = first+1, last-1
+		}
+		for n := first; n <= last; n++ {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, n)
+			if err := add(ip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return targets, nil
+}
+
+// onLink reports whether ip is in one of networks
+func onLink(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
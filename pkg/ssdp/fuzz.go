@@ -0,0 +1,167 @@
+package ssdp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mutation alters an SSDP response to see how a control point copes with it
+type Mutation struct {
+	Name        string
+	Description string
+	apply       func(reply string) string
+}
+
+// Mutations are the response mutations the fuzzer cycles through, in order
+var Mutations = []Mutation{
+	{"long-location", "LOCATION URL of 8 KiB", func(r string) string {
+		return replaceHeader(r, "LOCATION", headerOf(r, "LOCATION")+"?"+strings.Repeat("A", 8192))
+	}},
+	{"long-server", "SERVER header of 32 KiB", func(r string) string {
+		return replaceHeader(r, "SERVER", "UPnP/1.0 "+strings.Repeat("B", 32768))
+	}},
+	{"long-usn", "USN of 4 KiB", func(r string) string {
+		return replaceHeader(r, "USN", "uuid:"+strings.Repeat("c", 4096))
+	}},
+	{"many-headers", "2000 extra headers", func(r string) string {
+		var extra strings.Builder
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(&extra, "X-FUZZ-%d: %d\r\n", i, i)
+		}
+		return insertHeaders(r, extra.String())
+	}},
+	{"invalid-utf8", "invalid UTF-8 in SERVER and ST", func(r string) string {
+		r = replaceHeader(r, "SERVER", "UPnP/1.0 \xff\xfe\xc3\x28 \xed\xa0\x80")
+		return replaceHeader(r, "ST", headerOf(r, "ST")+"\xc0\xaf")
+	}},
+	{"nul-byte", "NUL byte inside LOCATION", func(r string) string {
+		location := headerOf(r, "LOCATION")
+		return replaceHeader(r, "LOCATION", location[:len(location)/2]+"\x00"+location[len(location)/2:])
+	}},
+	{"duplicate-location", "two conflicting LOCATION headers", func(r string) string {
+		return insertHeaders(r, "LOCATION: http://127.0.0.1:1/ssdp/device-desc.xml\r\n")
+	}},
+	{"bare-lf", "LF instead of CRLF line endings", func(r string) string {
+		return strings.ReplaceAll(r, "\r\n", "\n")
+	}},
+	{"bare-cr", "CR inside a header value, smuggling a LOCATION", func(r string) string {
+		return replaceHeader(r, "SERVER", "UPnP/1.0\rLOCATION: http://127.0.0.1:1/")
+	}},
+	{"obs-fold", "LOCATION folded onto a continuation line", func(r string) string {
+		location := headerOf(r, "LOCATION")
+		return replaceHeader(r, "LOCATION", location[:len(location)/2]+"\r\n "+location[len(location)/2:])
+	}},
+	{"no-colon", "header line without a colon", func(r string) string {
+		return insertHeaders(r, "THIS HEADER HAS NO COLON\r\n")
+	}},
+	{"space-before-colon", "whitespace between header names and colons", func(r string) string {
+		lines := strings.Split(r, "\r\n")
+		for i, line := range lines {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				lines[i] = name + " \t:" + value
+			}
+		}
+		return strings.Join(lines, "\r\n")
+	}},
+	{"lowercase", "lower case header names and status line", func(r string) string {
+		lines := strings.Split(r, "\r\n")
+		for i, line := range lines {
+			if name, value, ok := strings.Cut(line, ":"); ok && i > 0 {
+				lines[i] = strings.ToLower(name) + ":" + value
+			}
+		}
+		lines[0] = strings.ToLower(lines[0])
+		return strings.Join(lines, "\r\n")
+	}},
+	{"negative-max-age", "CACHE-CONTROL max-age of -1", func(r string) string {
+		return replaceHeader(r, "CACHE-CONTROL", "max-age=-1")
+	}},
+	{"huge-max-age", "CACHE-CONTROL max-age overflowing 64 bits", func(r string) string {
+		return replaceHeader(r, "CACHE-CONTROL", "max-age=99999999999999999999999999")
+	}},
+	{"text-max-age", "CACHE-CONTROL max-age that is not a number", func(r string) string {
+		return replaceHeader(r, "CACHE-CONTROL", "max-age=forever, no-cache, max-age=0")
+	}},
+	{"missing-location", "no LOCATION header", func(r string) string {
+		return removeHeader(r, "LOCATION")
+	}},
+	{"missing-usn", "no USN or ST header", func(r string) string {
+		return removeHeader(removeHeader(r, "USN"), "ST")
+	}},
+	{"bad-status", "status line with an unknown version and code", func(r string) string {
+		return strings.Replace(r, "HTTP/1.1 200 OK", "HTTP/9.9 999 Fuzzed", 1)
+	}},
+	{"bad-location-scheme", "LOCATION with a non-HTTP scheme", func(r string) string {
+		return replaceHeader(r, "LOCATION", "file:///etc/passwd")
+	}},
+	{"truncated", "response cut off in the middle of a header", func(r string) string {
+		return r[:strings.Index(r, "LOCATION:")+14]
+	}},
+	{"empty", "empty datagram", func(r string) string {
+		return ""
+	}},
+}
+
+// findMutations returns the named mutations, or all of them when names is
+// empty
+func findMutations(names []string) ([]Mutation, error) {
+	if len(names) == 0 {
+		return Mutations, nil
+	}
+	var found []Mutation
+	for _, name := range names {
+		ok := false
+		for _, m := range Mutations {
+			if m.Name == name {
+				found = append(found, m)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown fuzz mutation: %s", name)
+		}
+	}
+	return found, nil
+}
+
+// headerOf returns the value of the named header in reply, or ""
+func headerOf(reply, name string) string {
+	for _, line := range strings.Split(reply, "\r\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// replaceHeader sets the value of the named header in reply
+func replaceHeader(reply, name, value string) string {
+	lines := strings.Split(reply, "\r\n")
+	for i, line := range lines {
+		if key, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+			lines[i] = key + ": " + value
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// removeHeader drops the named header from reply
+func removeHeader(reply, name string) string {
+	lines := strings.Split(reply, "\r\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if key, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\r\n")
+}
+
+// insertHeaders adds raw header lines, each ending in CRLF, after the status
+// line of reply
+func insertHeaders(reply, headers string) string {
+	status, rest, _ := strings.Cut(reply, "\r\n")
+	return status + "\r\n" + headers + rest
+}
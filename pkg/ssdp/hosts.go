@@ -0,0 +1,91 @@
+package ssdp
+
+import (
+	"container/list"
+	"strings"
+	"time"
+)
+
+// Defaults for how long and how many host/ST pairs the listener remembers
+const (
+	DefaultHostTTL  = 30 * time.Minute
+	DefaultMaxHosts = 10000
+)
+
+// hostCache remembers which host/ST pairs have been seen, so only the first
+// search is reported as new. Entries expire ttl after the host was last
+// seen, and the least recently seen entry is evicted once max is reached.
+type hostCache struct {
+	ttl     time.Duration
+	max     int
+	entries map[string]*list.Element
+	order   *list.List // front is most recently seen
+}
+
+// hostEntry is one remembered host/ST pair
+type hostEntry struct {
+	key      string
+	lastSeen time.Time
+}
+
+// hostKey returns the cache key of a host/ST pair
+func hostKey(host, st string) string {
+	return host + "_" + st
+}
+
+// splitHostKey returns the host and ST of a cache key
+func splitHostKey(key string) (string, string) {
+	host, st, _ := strings.Cut(key, "_")
+	return host, st
+}
+
+// newHostCache creates a cache. A ttl or max of zero or less disables
+// expiry or the size cap respectively.
+func newHostCache(ttl time.Duration, max int) *hostCache {
+	return &hostCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// seen records key as seen at now and reports whether it was new, either
+// never seen or expired since it was last seen
+func (c *hostCache) seen(key string, now time.Time) bool {
+	c.expire(now)
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*hostEntry).lastSeen = now
+		c.order.MoveToFront(elem)
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&hostEntry{key: key, lastSeen: now})
+	if c.max > 0 {
+		for c.order.Len() > c.max {
+			c.remove(c.order.Back())
+		}
+	}
+	return true
+}
+
+// expire drops the entries not seen within the TTL. They sit at the back of
+// the list, so it stops at the first entry still fresh.
+func (c *hostCache) expire(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	for elem := c.order.Back(); elem != nil; elem = c.order.Back() {
+		if now.Sub(elem.Value.(*hostEntry).lastSeen) < c.ttl {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+// remove drops elem from the cache
+func (c *hostCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*hostEntry).key)
+}
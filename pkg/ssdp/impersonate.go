@@ -0,0 +1,145 @@
+package ssdp
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DeviceDescPath is where the HTTP server serves the device descriptor
+const DeviceDescPath = "/ssdp/device-desc.xml"
+
+// TemplateDescPath is where the HTTP server serves the device descriptor of
+// another template than the one running, for impersonations using it
+func TemplateDescPath(template string) string {
+	return "/ssdp/" + template + "/device-desc.xml"
+}
+
+// Impersonation answers searches for one search target as a particular
+// device or service type, pointing at a particular descriptor. Once any are
+// configured, searches none of them matches go unanswered, so the device
+// only claims to be a MediaRenderer to clients asking for one.
+type Impersonation struct {
+	// Search target answered, or * for any
+	ST string `yaml:"st"`
+	// Type advertised in the ST and USN headers. Defaults to the search
+	// target searched for.
+	Type string `yaml:"type"`
+	// Descriptor advertised in LOCATION: a path on the HTTP server, such as
+	// one of the template's routes, or a full URL. Defaults to
+	// DeviceDescPath.
+	Location string `yaml:"location"`
+	// Template whose device.xml is advertised instead, served at
+	// TemplateDescPath, so printers and players can each be shown a
+	// device of their own kind
+	Template string `yaml:"template"`
+}
+
+// RootDevice answers only upnp:rootdevice and ssdp:all searches, both as
+// the root device under a single USN, as most benign devices do. It makes
+// less noise than answering every search target.
+var RootDevice = []Impersonation{
+	{ST: "upnp:rootdevice"},
+	{ST: "ssdp:all", Type: "upnp:rootdevice"},
+}
+
+// Validate checks that the impersonation can be used
+func (i Impersonation) Validate() error {
+	if i.ST == "" {
+		return fmt.Errorf("impersonation needs an st")
+	}
+	for _, value := range []string{i.ST, i.Type, i.Location} {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("impersonation of %s contains a line break", i.ST)
+		}
+	}
+	if i.Template != "" {
+		if i.Location != "" {
+			return fmt.Errorf("impersonation of %s: set location or template, not both", i.ST)
+		}
+		if path.Base(i.Template) != i.Template || strings.HasPrefix(i.Template, ".") {
+			return fmt.Errorf("impersonation of %s: invalid template name %q", i.ST, i.Template)
+		}
+	}
+	if i.Location == "" || strings.HasPrefix(i.Location, "/") {
+		return nil
+	}
+	if err := CheckLocation(i.Location); err != nil {
+		return fmt.Errorf("impersonation of %s: %w", i.ST, err)
+	}
+	return nil
+}
+
+// CheckLocation checks that location can be advertised as a LOCATION: an
+// http or https URL with a host, on one line
+func CheckLocation(location string) error {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		strings.ContainsAny(location, "\r\n ") {
+		return fmt.Errorf("location %q must be an http(s) URL", location)
+	}
+	return nil
+}
+
+// WithLocation advertises location, a URL that may be on another host or
+// port or use HTTPS, instead of the device descriptor on this host's HTTP
+// server, for setups where a redirector or another server hands out the
+// payload. Impersonations with a location or template of their own keep it.
+func WithLocation(location string) Option {
+	return func(l *Listener) {
+		l.location = location
+	}
+}
+
+// locationFor returns the LOCATION advertised on b for imp
+func (l *Listener) locationFor(b *binding, ipv6 bool, imp Impersonation) string {
+	descPath, local := imp.DescPath()
+	switch {
+	case !local:
+		return imp.Location
+	case l.location != "" && imp.Location == "" && imp.Template == "":
+		return l.location
+	}
+	return l.descURL(b, ipv6, descPath)
+}
+
+// WithImpersonations answers searches only for the given search targets,
+// each as its type and descriptor. The first match wins.
+func WithImpersonations(impersonations ...Impersonation) Option {
+	return func(l *Listener) {
+		l.impersonations = append(l.impersonations, impersonations...)
+	}
+}
+
+// DescPath returns the path of the descriptor advertised, and false when it
+// is a URL elsewhere
+func (i Impersonation) DescPath() (string, bool) {
+	switch {
+	case i.Template != "":
+		return TemplateDescPath(i.Template), true
+	case i.Location == "":
+		return DeviceDescPath, true
+	case strings.HasPrefix(i.Location, "/"):
+		return i.Location, true
+	}
+	return "", false
+}
+
+// impersonation returns how to answer a search for st, and false if it
+// should go unanswered. Without impersonations every search target is
+// answered as itself.
+func (l *Listener) impersonation(st string) (Impersonation, bool) {
+	if len(l.impersonations) == 0 {
+		return Impersonation{ST: st, Type: st}, true
+	}
+	for _, i := range l.impersonations {
+		if i.ST == st || i.ST == "*" {
+			if i.Type == "" {
+				i.Type = st
+			}
+			return i, true
+		}
+	}
+	return Impersonation{}, false
+}
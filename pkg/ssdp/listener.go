@@ -1,15 +1,26 @@
 package ssdp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"goSSDPkit/pkg/detect"
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/scope"
 )
 
 // Colors for console output
@@ -33,88 +44,546 @@ var (
 	XXEBox     = ColorRed + "[XXE VULN!!!!] " + ColorReset
 	ExfilBox   = ColorRed + "[EXFILTRATION] " + ColorReset
 	DetectBox  = ColorYellow + "[DETECTION]    " + ColorReset
+	AlertBox   = ColorRed + "[ALERT]        " + ColorReset
+	FuzzBox    = ColorYellow + "[FUZZ]         " + ColorReset
+	ProxyBox   = ColorGreen + "[PROXIED]      " + ColorReset
+	TamperBox  = ColorRed + "[TAMPERED]     " + ColorReset
 )
 
+// DisableColors drops the ANSI colors from the console output prefixes, for
+// output that is not going to a terminal
+func DisableColors() {
+	for _, box := range []*string{&OkBox, &NoteBox, &WarnBox, &MSearchBox, &XMLBox, &PhishBox,
+		&CredsBox, &XXEBox, &ExfilBox, &DetectBox, &AlertBox, &FuzzBox, &ProxyBox, &TamperBox} {
+		*box = logging.StripANSI(*box)
+	}
+}
+
+// Binding ties a network interface to the address advertised to hosts that
+// search from it. AdvertiseIP and AdvertisePort override the LOCATION address
+// when the HTTP server is reached through NAT or a redirector. LocalIP6, if
+// set, is advertised to hosts that search over IPv6.
+type Binding struct {
+	Name          string
+	LocalIP       string
+	LocalIP6      string
+	AdvertiseIP   string
+	AdvertisePort int
+}
+
+// advertiseIP returns the IP to put in LOCATION for b
+func (b *binding) advertiseIP() string {
+	if b.AdvertiseIP != "" {
+		return b.AdvertiseIP
+	}
+	return b.LocalIP
+}
+
+// advertiseIP6 returns the IPv6 address to put in LOCATION for b, without
+// the zone of a link-local address, which means nothing to the requester
+func (b *binding) advertiseIP6() string {
+	if ip := net.ParseIP(b.AdvertiseIP); ip != nil && ip.To4() == nil {
+		return b.AdvertiseIP
+	}
+	ip, _, _ := strings.Cut(b.LocalIP6, "%")
+	return ip
+}
+
+// binding is a Binding resolved to its interface and subnets
+type binding struct {
+	Binding
+	iface    *net.Interface
+	networks []*net.IPNet
+}
+
+// Responder is the SSDP side of the spoofer, for programs that embed it
+type Responder interface {
+	Listen(ctx context.Context) error
+	Close() error
+	GetSessionUSN() string
+}
+
+var _ Responder = (*Listener)(nil)
+
 // Listener represents an SSDP multicast listener
 type Listener struct {
-	sock         *net.UDPConn
-	knownHosts   map[string]bool
-	localIP      string
-	localPort    int
-	analyzeMode  bool
-	sessionUSN   string
-	validST      *regexp.Regexp
-	mu           sync.RWMutex
-}
-
-// NewListener creates a new SSDP listener
-func NewListener(localIP string, localPort int, analyzeMode bool) (*Listener, error) {
+	sock           *net.UDPConn
+	replySock      *net.UDPConn // responses are sent from sock when nil
+	replySocket    *ReplySocket
+	pconn          *ipv4.PacketConn
+	sock6          *net.UDPConn // nil when no binding has an IPv6 address
+	pconn6         *ipv6.PacketConn
+	knownHosts     *hostCache
+	restoredHosts  []KnownHost // from WithState, until the cache exists
+	hostTTL        time.Duration
+	maxHosts       int
+	bindings       []*binding
+	localPort      int
+	analyzeMode    bool
+	schedule       *Schedule // hours searches are answered, always when nil
+	scope          atomic.Pointer[scope.Scope]
+	violations     *scope.Violations
+	sessionUSN     string
+	restored       bool // identity taken from a previous run's state
+	bootID         int
+	configID       int
+	descriptorHash string
+	descriptorsSet bool
+	validST        *regexp.Regexp
+	detector       *detect.Detector
+	fuzzClients    map[string]bool
+	fuzzNames      []string
+	mutations      []Mutation
+	mutators       []ResponseMutator
+	fuzzNext       map[string]int // client -> index of its next mutation
+	stealth        bool
+	labels         bool // label with the binding name even if it is the only one
+	refuseSpoofed  bool
+	maxAge         time.Duration
+	advertise      bool
+	location       string // LOCATION advertised instead of the local descriptor
+	tracking       bool   // give each response an ID in its LOCATION
+	impersonations []Impersonation
+	servers        []string // SERVER header personalities
+	dateSkew       time.Duration
+	response       atomic.Value // responseTemplate
+	logger         logging.Logger
+	subscribers    []events.Events
+	events         events.Events
+	owner          *Listener     // whose sockets searches arrive on, nil for its own
+	shared         []*Listener   // listeners answering the searches on our sockets
+	done           chan struct{} // closed when a listener without sockets closes
+	mu             sync.RWMutex
+	closeOnce      sync.Once
+	closeErr       error
+}
+
+// Option configures a Listener
+type Option func(*Listener)
+
+// WithAnalyzeMode logs searches without answering them
+func WithAnalyzeMode(analyze bool) Option {
+	return func(l *Listener) {
+		l.analyzeMode = analyze
+	}
+}
+
+// WithLogger sends the listener's output to logger instead of stdout
+func WithLogger(logger logging.Logger) Option {
+	return func(l *Listener) {
+		l.logger = logger
+	}
+}
+
+// WithEvents adds a subscriber to the searches the listener sees, alongside
+// the console output
+func WithEvents(e events.Events) Option {
+	return func(l *Listener) {
+		l.subscribers = append(l.subscribers, e)
+	}
+}
+
+// WithDetector inspects searches with d, so it can be shared with the HTTP
+// server. By default the listener uses its own.
+func WithDetector(d *detect.Detector) Option {
+	return func(l *Listener) {
+		l.detector = d
+	}
+}
+
+// WithFuzz answers searches from the given client addresses with malformed
+// responses, cycling through the named mutations (all of them if none are
+// named), to test how their discovery stacks handle them. Other hosts get
+// normal responses.
+func WithFuzz(clients []string, mutations ...string) Option {
+	return func(l *Listener) {
+		if l.fuzzClients == nil {
+			l.fuzzClients = make(map[string]bool)
+		}
+		for _, client := range clients {
+			l.fuzzClients[client] = true
+		}
+		l.fuzzNames = append(l.fuzzNames, mutations...)
+	}
+}
+
+// WithRefuseSpoofed leaves searches unanswered when their source port or
+// headers are inconsistent with a real control point, as probes from
+// detection tooling often are. They are reported either way.
+func WithRefuseSpoofed(refuse bool) Option {
+	return func(l *Listener) {
+		l.refuseSpoofed = refuse
+	}
+}
+
+// WithServerHeaders answers with one of the given SERVER headers instead of
+// "UPnP/1.0". Each host is always given the same one, so different victims
+// see different devices while each sees a consistent one.
+func WithServerHeaders(servers ...string) Option {
+	return func(l *Listener) {
+		l.servers = append(l.servers, servers...)
+	}
+}
+
+// WithStealth varies the shape of every response: header order and case,
+// a skewed DATE, and a random delay within the searcher's MX, so detectors
+// keyed on the fixed evil-ssdp response have nothing to match
+func WithStealth(stealth bool) Option {
+	return func(l *Listener) {
+		l.stealth = stealth
+	}
+}
+
+// WithHostTTL sets how long a host that stops searching is remembered
+// before its next search is reported as new again. Zero or less never
+// forgets.
+func WithHostTTL(ttl time.Duration) Option {
+	return func(l *Listener) {
+		l.hostTTL = ttl
+	}
+}
+
+// WithMaxHosts caps how many host/ST pairs are remembered, forgetting the
+// least recently seen first. Zero or less removes the cap.
+func WithMaxHosts(max int) Option {
+	return func(l *Listener) {
+		l.maxHosts = max
+	}
+}
+
+// WithLabels labels log lines and events with the binding name even when
+// there is only one, for listeners sharing a process with others
+func WithLabels(labels bool) Option {
+	return func(l *Listener) {
+		l.labels = labels
+	}
+}
+
+// ResponseMutator rewrites the response about to be sent to host for a
+// search for st, returning false to send none
+type ResponseMutator func(host, st, response string) (string, bool)
+
+// WithResponseMutator passes every response through m before it is sent,
+// after any fuzzing. Mutators run in the order they were added.
+func WithResponseMutator(m ResponseMutator) Option {
+	return func(l *Listener) {
+		l.mutators = append(l.mutators, m)
+	}
+}
+
+// WithSessionUSN advertises usn instead of a random one
+func WithSessionUSN(usn string) Option {
+	return func(l *Listener) {
+		l.sessionUSN = usn
+	}
+}
+
+// NewListener creates a new SSDP listener that joins the multicast group on
+// every bound interface and answers each host with the address of the
+// interface it searched from
+func NewListener(bindings []Binding, localPort int, opts ...Option) (*Listener, error) {
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("no interfaces to listen on")
+	}
+
+	l := &Listener{
+		localPort:  localPort,
+		hostTTL:    DefaultHostTTL,
+		maxHosts:   DefaultMaxHosts,
+		sessionUSN: generateSessionUSN(),
+		maxAge:     DefaultMaxAge,
+		logger:     &logging.UTCLogger{},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.bootID = initialBootID(l.bootID, l.restored)
+	if !l.restored {
+		l.configID = 1
+	}
+	l.knownHosts = newHostCache(l.hostTTL, l.maxHosts)
+	for _, h := range l.restoredHosts {
+		l.knownHosts.seen(hostKey(h.Host, h.ST), h.LastSeen)
+	}
+	l.restoredHosts = nil
+	if l.detector == nil {
+		l.detector = detect.New()
+	}
+	if l.stealth {
+		l.dateSkew = randomSkew()
+	}
+	if len(l.fuzzClients) > 0 {
+		mutations, err := findMutations(l.fuzzNames)
+		if err != nil {
+			return nil, err
+		}
+		l.mutations = mutations
+		l.fuzzNext = make(map[string]int)
+	}
+	l.events = events.Multi(append([]events.Events{consoleEvents{l: l}}, l.subscribers...)...)
+
+	// Regex for validating ST headers (same pattern as Python version)
+	l.validST = regexp.MustCompile(`^[a-zA-Z0-9.\-_]+:[a-zA-Z0-9.\-_:]+$`)
+
+	if l.owner != nil {
+		if err := l.share(bindings); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
 	// SSDP multicast address and port as defined by the spec
 	ssdpPort := 1900
 	mcastGroup := "239.255.255.250"
-	
+
 	// Create UDP address for multicast group
 	mcastAddr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", mcastGroup, ssdpPort))
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve multicast address: %w", err)
 	}
-	
-	// Create listener address (bind to all interfaces on SSDP port)
-	listenAddr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf(":%d", ssdpPort))
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve listen address: %w", err)
-	}
-	
-	// Create UDP connection
-	conn, err := net.ListenUDP("udp4", listenAddr)
+
+	// Bind to all interfaces on the SSDP port, sharing it with any other
+	// SSDP service on the host
+	conn, err := ListenShared("udp4", ssdpPort)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
 	}
-	
-	// Get the interface for the local IP
-	iface, err := getInterfaceByIP(localIP)
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to get interface for IP %s: %w", localIP, err)
-	}
-	
+
 	// Create IPv4 packet connection for multicast operations
 	pconn := ipv4.NewPacketConn(conn)
-	
-	// Join multicast group on the specific interface
-	if err := pconn.JoinGroup(iface, mcastAddr); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to join multicast group on interface %s: %w", iface.Name, err)
+
+	resolved := make([]*binding, 0, len(bindings))
+	for _, b := range bindings {
+		// Get the interface for the local IP
+		iface, err := getInterfaceByIP(b.LocalIP)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to get interface for IP %s: %w", b.LocalIP, err)
+		}
+
+		// Join multicast group on the specific interface
+		if err := pconn.JoinGroup(iface, mcastAddr); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to join multicast group on interface %s: %w", iface.Name, err)
+		}
+
+		if b.Name == "" {
+			b.Name = iface.Name
+		}
+		resolved = append(resolved, &binding{
+			Binding:  b,
+			iface:    iface,
+			networks: interfaceNetworks(iface),
+		})
 	}
-	
+
 	// Set control message to receive destination info (not supported on Windows)
 	if runtime.GOOS != "windows" {
-		if err := pconn.SetControlMessage(ipv4.FlagDst, true); err != nil {
-			fmt.Printf("%sWarning: failed to set control message (non-fatal): %v\n", WarnBox, err)
+		if err := pconn.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true); err != nil {
+			logging.Notice(l.logger, "%sWarning: failed to set control message (non-fatal): %v", WarnBox, err)
 		}
 	}
-	
-	// Enable SO_REUSEADDR to allow multiple processes to bind to same port
+
 	if err := conn.SetReadBuffer(65536); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to set read buffer: %w", err)
 	}
-	
-	fmt.Printf("%sSSDP listener bound to interface %s (%s) on port %d\n", 
-		OkBox, iface.Name, localIP, ssdpPort)
-	
-	// Regex for validating ST headers (same pattern as Python version)
-	validST := regexp.MustCompile(`^[a-zA-Z0-9.\-_]+:[a-zA-Z0-9.\-_:]+$`)
-	
-	return &Listener{
-		sock:        conn,
-		knownHosts:  make(map[string]bool),
-		localIP:     localIP,
-		localPort:   localPort,
-		analyzeMode: analyzeMode,
-		sessionUSN:  generateSessionUSN(),
-		validST:     validST,
-	}, nil
+
+	for _, b := range resolved {
+		l.logger.Log("%sSSDP listener bound to interface %s (%s) on port %d",
+			OkBox, b.iface.Name, b.LocalIP, ssdpPort)
+	}
+
+	if l.replySocket != nil {
+		replySock, err := openReplySocket(*l.replySocket)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		l.replySock = replySock
+		l.logger.Log("%sSSDP responses sent from port %d", OkBox, replySock.LocalAddr().(*net.UDPAddr).Port)
+	}
+
+	l.sock = conn
+	l.pconn = pconn
+	l.bindings = resolved
+
+	// IPv6 is best effort: the IPv4 listener is enough to run
+	if err := l.listen6(); err != nil {
+		logging.Notice(l.logger, "%sNot listening on IPv6: %v", WarnBox, err)
+	}
+	return l, nil
+}
+
+// listen6 joins the IPv6 SSDP multicast groups on every binding with an IPv6
+// address, sharing the rest of the listener with IPv4
+func (l *Listener) listen6() error {
+	var bound []*binding
+	for _, b := range l.bindings {
+		if b.LocalIP6 != "" {
+			bound = append(bound, b)
+		}
+	}
+	if len(bound) == 0 {
+		return nil
+	}
+
+	conn, err := ListenShared("udp6", 1900)
+	if err != nil {
+		return fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	pconn := ipv6.NewPacketConn(conn)
+
+	// Link-local and site-local scoped SSDP groups
+	groups := []net.IP{net.ParseIP("ff02::c"), net.ParseIP("ff05::c")}
+	for _, b := range bound {
+		for _, group := range groups {
+			if err := pconn.JoinGroup(b.iface, &net.UDPAddr{IP: group}); err != nil {
+				conn.Close()
+				return fmt.Errorf("failed to join multicast group %s on interface %s: %w", group, b.iface.Name, err)
+			}
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := pconn.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
+			logging.Notice(l.logger, "%sWarning: failed to set IPv6 control message (non-fatal): %v", WarnBox, err)
+		}
+	}
+
+	for _, b := range bound {
+		l.logger.Log("%sSSDP listener bound to interface %s (%s) on port %d",
+			OkBox, b.iface.Name, b.LocalIP6, 1900)
+	}
+	l.sock6 = conn
+	l.pconn6 = pconn
+	return nil
+}
+
+// IPv6 reports whether the listener is answering searches over IPv6
+func (l *Listener) IPv6() bool {
+	return l.sock6 != nil
+}
+
+// interfaceNetworks returns the IPv4 subnets configured on iface
+func interfaceNetworks(iface *net.Interface) []*net.IPNet {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			networks = append(networks, ipNet)
+		}
+	}
+	return networks
+}
+
+// bindingFor picks the binding a request arrived on. The control message, when
+// the platform provides one, identifies it by destination address (unicast
+// searches) or receiving interface (multicast searches). Otherwise the
+// binding whose subnet contains remoteIP is used, falling back to the first
+// binding for off-link requesters.
+func (l *Listener) bindingFor(remoteIP net.IP, cm *ipv4.ControlMessage) *binding {
+	if cm != nil {
+		if cm.Dst != nil && !cm.Dst.IsMulticast() {
+			for _, b := range l.bindings {
+				if b.LocalIP == cm.Dst.String() {
+					return b
+				}
+			}
+		}
+		if cm.IfIndex > 0 {
+			for _, b := range l.bindings {
+				if b.iface.Index == cm.IfIndex {
+					return b
+				}
+			}
+		}
+	}
+
+	for _, b := range l.bindings {
+		for _, network := range b.networks {
+			if network.Contains(remoteIP) {
+				return b
+			}
+		}
+	}
+	return l.bindings[0]
+}
+
+// bindingFor6 picks the binding an IPv6 request arrived on, like bindingFor.
+// Without a control message, the zone of a link-local requester names the
+// interface.
+func (l *Listener) bindingFor6(addr *net.UDPAddr, cm *ipv6.ControlMessage) *binding {
+	for _, b := range l.bindings {
+		if b.LocalIP6 == "" {
+			continue
+		}
+		localIP, _, _ := strings.Cut(b.LocalIP6, "%")
+		switch {
+		case cm != nil && cm.Dst != nil && !cm.Dst.IsMulticast() && net.ParseIP(localIP).Equal(cm.Dst):
+			return b
+		case cm != nil && cm.IfIndex > 0 && b.iface.Index == cm.IfIndex:
+			return b
+		case addr.Zone != "" && b.iface.Name == addr.Zone:
+			return b
+		}
+	}
+	for _, b := range l.bindings {
+		if b.LocalIP6 != "" {
+			return b
+		}
+	}
+	return l.bindings[0]
+}
+
+// bindingForAddr picks the binding for a request from addr when nothing is
+// known about where it arrived
+func (l *Listener) bindingForAddr(addr net.Addr) *binding {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr.IP.To4() == nil {
+		return l.bindingFor6(udpAddr, nil)
+	}
+	return l.bindingFor(net.ParseIP(hostOf(addr)), nil)
+}
+
+// hostOf returns the IP address of addr as a string
+func hostOf(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// portOf returns the port of addr, or 0 if it has none
+func portOf(addr net.Addr) int {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.Port
+	}
+	if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+		n, _ := strconv.Atoi(port)
+		return n
+	}
+	return 0
+}
+
+// isIPv6 reports whether addr is an IPv6 address
+func isIPv6(addr net.Addr) bool {
+	ip := net.ParseIP(hostOf(addr))
+	return ip != nil && ip.To4() == nil
+}
+
+// label returns the log prefix for b, empty when only one interface is bound
+func (l *Listener) label(b *binding) string {
+	if len(l.bindings) < 2 && !l.labels {
+		return ""
+	}
+	return "[" + b.Name + "] "
 }
 
 // generateSessionUSN creates a random USN for this session
@@ -146,18 +615,18 @@ func getInterfaceByIP(targetIP string) (*net.Interface, error) {
 		}
 		// If none found, fall through to search by IP
 	}
-	
+
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
-	
+
 	for _, iface := range interfaces {
 		addrs, err := iface.Addrs()
 		if err != nil {
 			continue
 		}
-		
+
 		for _, addr := range addrs {
 			if ipNet, ok := addr.(*net.IPNet); ok {
 				if ipNet.IP.String() == targetIP {
@@ -166,100 +635,400 @@ func getInterfaceByIP(targetIP string) (*net.Interface, error) {
 			}
 		}
 	}
-	
+
 	return nil, fmt.Errorf("interface not found for IP %s", targetIP)
 }
 
-// SendLocation sends an SSDP response to the requester
+// SendLocation sends an SSDP response to the requester, advertising the
+// address of the interface the requester is reachable on
 func (l *Listener) SendLocation(addr net.Addr, requestedST string) error {
-	url := fmt.Sprintf("http://%s:%d/ssdp/device-desc.xml", l.localIP, l.localPort)
-	dateFormat := time.Now().UTC().Format(time.RFC1123)
-	
-	ssdpReply := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
-		"CACHE-CONTROL: max-age=1800\r\n"+
-		"DATE: %s\r\n"+
-		"EXT:\r\n"+
-		"LOCATION: %s\r\n"+
-		"OPT: \"http://schemas.upnp.org/upnp/1/0/\"; ns=01\r\n"+
-		"01-NLS: %s\r\n"+
-		"SERVER: UPnP/1.0\r\n"+
-		"ST: %s\r\n"+
-		"USN: %s::%s\r\n"+
-		"BOOTID.UPNP.ORG: 0\r\n"+
-		"CONFIGID.UPNP.ORG: 1\r\n"+
-		"\r\n\r\n",
-		dateFormat, url, l.sessionUSN, requestedST, l.sessionUSN, requestedST)
-	
-	_, err := l.sock.WriteTo([]byte(ssdpReply), addr)
+	return l.sendLocation(l.bindingForAddr(addr), addr, requestedST, "")
+}
+
+// sendLocation sends an SSDP response advertising the address of b, as the
+// type and descriptor impersonated for requestedST
+func (l *Listener) sendLocation(b *binding, addr net.Addr, requestedST, responseID string) error {
+	imp, ok := l.impersonation(requestedST)
+	if !ok {
+		imp = Impersonation{ST: requestedST, Type: requestedST}
+	}
+	sock := l.sock
+	if l.replySock != nil {
+		sock = l.replySock
+	}
+	if isIPv6(addr) {
+		if l.sock6 == nil || b.LocalIP6 == "" {
+			return fmt.Errorf("no IPv6 address to advertise on interface %s", b.Name)
+		}
+		sock = l.sock6
+	}
+	url := WithResponseID(l.locationFor(b, isIPv6(addr), imp), responseID)
+	bootID, configID := l.identity()
+	date := time.Now().UTC().Add(l.dateSkew)
+	response := Response{
+		Location:     url,
+		ST:           imp.Type,
+		USN:          l.sessionUSN + "::" + imp.Type,
+		SessionUSN:   l.sessionUSN,
+		CacheControl: l.cacheControl(),
+		Server:       l.serverHeader(addr),
+		Date:         date.Format(time.RFC1123),
+		BootID:       strconv.Itoa(bootID),
+		ConfigID:     strconv.Itoa(configID),
+	}
+
+	// A template's own response replaces the built-in one, which is still
+	// sent if the template fails
+	ssdpReply, ok, err := l.renderResponse(response)
+	if err != nil {
+		logging.Notice(l.logger, "%s%sFailed to render SSDP response template: %v", l.label(b), WarnBox, err)
+	}
+	if !ok || err != nil {
+		ssdpReply = renderReply([]header{
+			{"CACHE-CONTROL", response.CacheControl},
+			{"DATE", response.Date},
+			{"EXT", ""},
+			{"LOCATION", response.Location},
+			{"OPT", "\"http://schemas.upnp.org/upnp/1/0/\"; ns=01"},
+			{"01-NLS", response.SessionUSN},
+			{"SERVER", response.Server},
+			{"ST", response.ST},
+			{"USN", response.USN},
+			{"BOOTID.UPNP.ORG", response.BootID},
+			{"CONFIGID.UPNP.ORG", response.ConfigID},
+		}, l.stealth)
+	}
+
+	if mutation, ok := l.nextMutation(addr); ok {
+		ssdpReply = mutation.apply(ssdpReply)
+		l.logger.Log("%s%sSent %s response (%s) to %s", l.label(b), FuzzBox, mutation.Name,
+			mutation.Description, addr.String())
+	}
+	for _, mutate := range l.mutators {
+		if ssdpReply, ok = mutate(hostOf(addr), requestedST, ssdpReply); !ok {
+			logging.LogAt(l.logger, logging.LevelVerbose, "%s%sNot answering search for %s from %s, dropped by a response mutator",
+				l.label(b), NoteBox, requestedST, hostOf(addr))
+			return nil
+		}
+	}
+
+	_, err = sock.WriteTo([]byte(ssdpReply), addr)
 	return err
 }
 
+// descURL returns the URL of the descriptor at path on the HTTP server, as
+// advertised on b over IPv4 or IPv6
+func (l *Listener) descURL(b *binding, ipv6 bool, path string) string {
+	port := l.localPort
+	if b.AdvertisePort != 0 {
+		port = b.AdvertisePort
+	}
+	if ipv6 {
+		return fmt.Sprintf("http://[%s]:%d%s", b.advertiseIP6(), port, path)
+	}
+	return fmt.Sprintf("http://%s:%d%s", b.advertiseIP(), port, path)
+}
+
+// defaultServer returns the SERVER header for messages to no host in
+// particular, such as advertisements
+func (l *Listener) defaultServer() string {
+	if len(l.servers) == 0 {
+		return "UPnP/1.0"
+	}
+	return l.servers[0]
+}
+
+// serverHeader returns the SERVER header for responses to addr, picked from
+// the personalities by a hash of the host so it never changes for a host
+func (l *Listener) serverHeader(addr net.Addr) string {
+	if len(l.servers) == 0 {
+		return "UPnP/1.0"
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(hostOf(addr)))
+	return l.servers[hash.Sum32()%uint32(len(l.servers))]
+}
+
+// nextMutation returns the mutation to apply to the next response to addr,
+// if it is a fuzzed client. Each client works through the mutations in turn.
+func (l *Listener) nextMutation(addr net.Addr) (Mutation, bool) {
+	host := hostOf(addr)
+	if !l.fuzzClients[host] {
+		return Mutation{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := l.fuzzNext[host]
+	l.fuzzNext[host] = (i + 1) % len(l.mutations)
+	return l.mutations[i], true
+}
+
 // ProcessData processes received SSDP data
 func (l *Listener) ProcessData(data []byte, addr net.Addr) {
-	remoteIP := strings.Split(addr.String(), ":")[0]
+	l.processData(data, addr, l.bindingForAddr(addr), false)
+}
+
+// processData processes received SSDP data that arrived on b. Unicast
+// searches were sent to our own address rather than the multicast group.
+func (l *Listener) processData(data []byte, addr net.Addr, b *binding, unicast bool) {
+	remoteIP := hostOf(addr)
 	dataStr := string(data)
-	
+	if !l.scope.Load().Contains(remoteIP) {
+		l.outOfScope(remoteIP, dataStr)
+		return
+	}
+
 	// Look for ST header in M-SEARCH request
 	re := regexp.MustCompile(`(?i)\r\nST:(.*?)\r\n`)
 	matches := re.FindStringSubmatch(dataStr)
-	
+
 	if strings.Contains(dataStr, "M-SEARCH") && len(matches) > 1 {
 		requestedST := strings.TrimSpace(matches[1])
-		
+		label := l.label(b)
+
+		alerts := l.detector.Search(remoteIP, dataStr, unicast, time.Now())
+		reasons := detect.Inconsistent(portOf(addr), dataStr, unicast)
+		alerts = append(alerts, l.detector.Spoofed(remoteIP, reasons, time.Now())...)
+		for _, alert := range alerts {
+			if label != "" {
+				alert.Label = b.Name
+			}
+			l.events.OnAlert(alert)
+		}
+
 		if l.validST.MatchString(requestedST) {
-			// Create unique key for this host/ST combination
-			hostKey := fmt.Sprintf("%s_%s", remoteIP, requestedST)
-			
+			// Remember each host/ST combination
 			l.mu.Lock()
-			if !l.knownHosts[hostKey] {
-				fmt.Printf("%sNew Host %s, Service Type: %s\n", 
-					MSearchBox, remoteIP, requestedST)
-				l.knownHosts[hostKey] = true
-			}
+			isNew := l.knownHosts.seen(hostKey(remoteIP, requestedST), time.Now())
 			l.mu.Unlock()
-			
-			// Send response if not in analyze mode
-			if !l.analyzeMode {
-				if err := l.SendLocation(addr, requestedST); err != nil {
-					fmt.Printf("%sError sending SSDP response: %v\n", WarnBox, err)
+
+			search := events.MSearch{
+				Time:      time.Now().UTC(),
+				Host:      remoteIP,
+				ST:        requestedST,
+				UserAgent: headerValue(dataStr, "USER-AGENT"),
+				New:       isNew,
+			}
+			if label != "" {
+				search.Label = b.Name
+			}
+			_, impersonated := l.impersonation(requestedST)
+			active := l.schedule.Active(time.Now())
+			answer := !l.analyzeMode && active && !(l.refuseSpoofed && len(reasons) > 0) && impersonated
+			if l.tracking && answer {
+				search.ResponseID = newResponseID()
+			}
+			l.events.OnMSearch(search)
+
+			// Send response if not in analyze mode or outside active hours
+			if l.analyzeMode {
+				// Observe only
+			} else if !active {
+				logging.LogAt(l.logger, logging.LevelVerbose, "%s%sNot answering search for %s from %s outside active hours",
+					label, NoteBox, requestedST, remoteIP)
+			} else if l.refuseSpoofed && len(reasons) > 0 {
+				logging.LogAt(l.logger, logging.LevelVerbose, "%s%sNot answering spoofed-looking search from %s",
+					label, DetectBox, remoteIP)
+			} else if !impersonated {
+				logging.LogAt(l.logger, logging.LevelVerbose, "%s%sNot answering search for %s from %s, which is not impersonated",
+					label, NoteBox, requestedST, remoteIP)
+			} else {
+				respond := func() {
+					// A delayed response may find the listener closed
+					if err := l.sendLocation(b, addr, requestedST, search.ResponseID); err != nil && !errors.Is(err, net.ErrClosed) {
+						logging.Notice(l.logger, "%s%sError sending SSDP response: %v", label, WarnBox, err)
+					}
+				}
+				if l.stealth {
+					time.AfterFunc(stealthDelay(dataStr), respond)
+				} else {
+					respond()
 				}
 			}
 		} else {
-			fmt.Printf("%sOdd ST (%s) from %s. Possible detection tool!\n", 
-				DetectBox, requestedST, remoteIP)
+			alert := events.Alert{
+				Time:   time.Now().UTC(),
+				Host:   remoteIP,
+				Kind:   events.AlertOddST,
+				Detail: fmt.Sprintf("Odd ST (%s)", requestedST),
+			}
+			if label != "" {
+				alert.Label = b.Name
+			}
+			l.events.OnAlert(alert)
 		}
 	}
 }
 
-// Listen starts listening for SSDP multicast messages
-func (l *Listener) Listen() error {
+// headerValue returns the value of the named header in an SSDP message, or
+// "" if it is missing
+func headerValue(message, name string) string {
+	for _, line := range strings.Split(message, "\r\n")[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Listen answers SSDP multicast searches over IPv4 and, if bound, IPv6 until
+// ctx is cancelled or the listener is closed, in which case it returns nil
+func (l *Listener) Listen(ctx context.Context) error {
+	// Closing the sockets is the only way to interrupt a blocked read
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+	defer stop()
+
+	if l.owner == nil {
+		l.logger.Log("%sSSDP listener started, waiting for M-SEARCH requests...", OkBox)
+	}
+	if l.advertise && !l.analyzeMode {
+		advertiseCtx, stopAdvertising := context.WithCancel(ctx)
+		defer stopAdvertising()
+		go l.advertiseLoop(advertiseCtx)
+	}
+	if l.schedule != nil && !l.analyzeMode {
+		scheduleCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		go l.watchSchedule(scheduleCtx)
+	}
+
+	// The owner reads the searches and passes them on
+	if l.owner != nil {
+		<-l.done
+		return nil
+	}
+
+	readers := []packetReader{l.read4}
+	if l.pconn6 != nil {
+		readers = append(readers, l.read6)
+	}
+	errCh := make(chan error, len(readers))
+	for _, read := range readers {
+		go func(read packetReader) {
+			errCh <- l.serve(read)
+		}(read)
+	}
+
+	// Either family failing stops both
+	var err error
+	for range readers {
+		if readErr := <-errCh; readErr != nil && err == nil {
+			err = readErr
+			l.Close()
+		}
+	}
+	return err
+}
+
+// packetReader reads a packet from one of the listener's sockets, returning
+// the binding it arrived on and whether it was sent to our own address
+type packetReader func([]byte) (int, net.Addr, *binding, bool, error)
+
+// serve processes the searches returned by read until its socket is closed
+func (l *Listener) serve(read packetReader) error {
 	buffer := make([]byte, 1024)
-	
-	fmt.Printf("%sSSDP listener started, waiting for M-SEARCH requests...\n", OkBox)
-	
 	for {
-		n, addr, err := l.sock.ReadFromUDP(buffer)
+		n, addr, b, unicast, err := read(buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			return fmt.Errorf("error reading UDP data: %w", err)
 		}
-		
+
 		// Debug: log all received UDP packets
 		dataStr := string(buffer[:n])
 		if strings.Contains(dataStr, "M-SEARCH") {
-			fmt.Printf("%sReceived M-SEARCH from %s (length: %d)\n", NoteBox, addr.String(), n)
+			logging.Debug(l.logger, "%sReceived M-SEARCH from %s (length: %d)", NoteBox, addr.String(), n)
 		}
-		
+
 		// Process the received data
-		l.ProcessData(buffer[:n], addr)
+		l.processData(buffer[:n], addr, b, unicast)
+		l.dispatch(buffer[:n], addr, b, unicast)
+	}
+}
+
+// read4 reads a packet from the IPv4 socket. Searches sent to our own
+// address are aimed at us specifically, unless they came over loopback,
+// e.g. from the replay command.
+func (l *Listener) read4(buffer []byte) (int, net.Addr, *binding, bool, error) {
+	n, cm, addr, err := l.pconn.ReadFrom(buffer)
+	if err != nil {
+		return 0, nil, nil, false, err
 	}
+	unicast := cm != nil && cm.Dst != nil && !cm.Dst.IsMulticast() && !cm.Dst.IsLoopback()
+	return n, addr, l.bindingFor(net.ParseIP(hostOf(addr)), cm), unicast, nil
 }
 
-// Close closes the SSDP listener
+// read6 reads a packet from the IPv6 socket, like read4
+func (l *Listener) read6(buffer []byte) (int, net.Addr, *binding, bool, error) {
+	n, cm, addr, err := l.pconn6.ReadFrom(buffer)
+	if err != nil {
+		return 0, nil, nil, false, err
+	}
+	unicast := cm != nil && cm.Dst != nil && !cm.Dst.IsMulticast() && !cm.Dst.IsLoopback()
+	udpAddr, _ := addr.(*net.UDPAddr)
+	if udpAddr == nil {
+		udpAddr = &net.UDPAddr{}
+	}
+	return n, addr, l.bindingFor6(udpAddr, cm), unicast, nil
+}
+
+// Close closes the SSDP listener. It is safe to call more than once.
 func (l *Listener) Close() error {
-	return l.sock.Close()
+	l.closeOnce.Do(func() {
+		// Shared sockets are the owner's to close
+		if l.owner != nil {
+			close(l.done)
+			return
+		}
+		l.closeErr = l.sock.Close()
+		for _, sock := range []*net.UDPConn{l.sock6, l.replySock} {
+			if sock == nil {
+				continue
+			}
+			if err := sock.Close(); err != nil && l.closeErr == nil {
+				l.closeErr = err
+			}
+		}
+	})
+	return l.closeErr
 }
 
 // GetSessionUSN returns the session USN
 func (l *Listener) GetSessionUSN() string {
 	return l.sessionUSN
-}
\ No newline at end of file
+}
+
+// consoleEvents is the listener's own subscriber, printing new hosts
+type consoleEvents struct {
+	events.Nop
+	l *Listener
+}
+
+// OnMSearch prints the first search for each service type from a host, and
+// the repeats when verbose
+func (c consoleEvents) OnMSearch(e events.MSearch) {
+	label := ""
+	if e.Label != "" {
+		label = "[" + e.Label + "] "
+	}
+	if !e.New {
+		logging.Verbose(c.l.logger, "%s%sHost %s, Service Type: %s", label, MSearchBox, e.Host, e.ST)
+		return
+	}
+	c.l.logger.Log("%s%sNew Host %s, Service Type: %s", label, MSearchBox, e.Host, e.ST)
+}
+
+// OnAlert prints an alert about a host that may be hunting for us
+func (c consoleEvents) OnAlert(e events.Alert) {
+	label := ""
+	if e.Label != "" {
+		label = "[" + e.Label + "] "
+	}
+	logging.Notice(c.l.logger, "%s%s%s from %s. Possible detection tool!", label, AlertBox, e.Detail, e.Host)
+}
@@ -0,0 +1,321 @@
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+)
+
+// DefaultMonitorInterval is how often the monitor searches the segment
+const DefaultMonitorInterval = time.Minute
+
+// Monitor watches a segment for rogue SSDP responders, such as another
+// evil-ssdp: anything answering a search for a service type that cannot
+// exist, pointing LOCATION at a host other than itself, announcing a device
+// UUID already owned by another host, or, given an allowlist, not on it
+type Monitor struct {
+	localIP  string
+	iface    *net.Interface
+	interval time.Duration
+	allow    []*net.IPNet
+	logger   logging.Logger
+	subs     []events.Events
+	events   events.Events
+
+	mu       sync.Mutex
+	owners   map[string]string // device UUID -> host that first announced it
+	known    map[string]bool   // hosts seen answering or announcing
+	alerted  map[string]bool   // host/detail pairs already alerted on
+	baseline bool              // hosts seen before the first sweep ended are trusted
+}
+
+// MonitorOption configures a Monitor
+type MonitorOption func(*Monitor)
+
+// WithMonitorInterval sets how often the segment is searched
+func WithMonitorInterval(interval time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		m.interval = interval
+	}
+}
+
+// WithAllowlist alerts on every responder outside allowed instead of
+// learning the responders present when the monitor starts
+func WithAllowlist(allowed []*net.IPNet) MonitorOption {
+	return func(m *Monitor) {
+		m.allow = allowed
+	}
+}
+
+// WithMonitorLogger sends the monitor's output to logger instead of stdout
+func WithMonitorLogger(logger logging.Logger) MonitorOption {
+	return func(m *Monitor) {
+		m.logger = logger
+	}
+}
+
+// WithMonitorEvents adds a subscriber to the monitor's alerts, alongside
+// the console output
+func WithMonitorEvents(e events.Events) MonitorOption {
+	return func(m *Monitor) {
+		m.subs = append(m.subs, e)
+	}
+}
+
+// NewMonitor creates a monitor for the segment of the interface that owns
+// localIP
+func NewMonitor(localIP string, opts ...MonitorOption) (*Monitor, error) {
+	iface, err := getInterfaceByIP(localIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface for IP %s: %w", localIP, err)
+	}
+	m := &Monitor{
+		localIP:  localIP,
+		iface:    iface,
+		interval: DefaultMonitorInterval,
+		logger:   &logging.UTCLogger{},
+		owners:   make(map[string]string),
+		known:    make(map[string]bool),
+		alerted:  make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.events = events.Multi(append([]events.Events{monitorConsole{m: m}}, m.subs...)...)
+	return m, nil
+}
+
+// ReadAllowlist reads the IP addresses and CIDR ranges of known SSDP
+// devices from a file, one per line, ignoring blank lines and # comments
+func ReadAllowlist(path string) ([]*net.IPNet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open allowlist: %w", err)
+	}
+	defer file.Close()
+
+	var allowed []*net.IPNet
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			entry += "/32"
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("allowlist line %d: %w", line, err)
+		}
+		allowed = append(allowed, network)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	return allowed, nil
+}
+
+// Run watches until ctx is cancelled. NOTIFY announcements are only seen if
+// port 1900 can be bound; searches are sent either way.
+func (m *Monitor) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	if conn, err := m.listenNotify(); err != nil {
+		logging.Notice(m.logger, "%sNot watching NOTIFY announcements: %v", WarnBox, err)
+	} else {
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.readNotify(conn)
+		}()
+	}
+
+	m.logger.Log("%sMonitoring %s (%s) for rogue SSDP responders every %s", OkBox, m.iface.Name, m.localIP, m.interval)
+	err := m.search(ctx)
+	wg.Wait()
+	return err
+}
+
+// listenNotify joins the SSDP multicast group on the monitored interface
+func (m *Monitor) listenNotify() (*net.UDPConn, error) {
+	conn, err := ListenShared("udp4", 1900)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind port 1900: %w", err)
+	}
+	pconn := ipv4.NewPacketConn(conn)
+	if err := pconn.JoinGroup(m.iface, &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250)}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to join multicast group on interface %s: %w", m.iface.Name, err)
+	}
+	return conn, nil
+}
+
+// readNotify inspects NOTIFY announcements until conn is closed
+func (m *Monitor) readNotify(conn *net.UDPConn) {
+	buffer := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logging.Notice(m.logger, "%sError reading NOTIFY: %v", WarnBox, err)
+			}
+			return
+		}
+		message := string(buffer[:n])
+		if !strings.HasPrefix(message, "NOTIFY") || headerValue(message, "NTS") == "ssdp:byebye" {
+			continue
+		}
+		m.inspect(addr.IP.String(), headerValue(message, "LOCATION"), headerValue(message, "USN"), false)
+	}
+}
+
+// search sends an ssdp:all search and a canary search for a random service
+// type every interval, inspecting the answers, until ctx is cancelled
+func (m *Monitor) search(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(m.localIP)})
+	if err != nil {
+		return fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := ipv4.NewPacketConn(conn).SetMulticastInterface(m.iface); err != nil {
+		return fmt.Errorf("failed to set multicast interface %s: %w", m.iface.Name, err)
+	}
+	mcastAddr := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+	buffer := make([]byte, 4096)
+	for sweep := 0; ; sweep++ {
+		canary := fmt.Sprintf("urn:schemas-upnp-org:device:%016x:1", rand.Uint64())
+		for _, st := range []string{"ssdp:all", canary} {
+			msearch := "M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"MX: 3\r\n" +
+				"ST: " + st + "\r\n" +
+				"\r\n"
+			if _, err := conn.WriteTo([]byte(msearch), mcastAddr); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("failed to send M-SEARCH: %w", err)
+			}
+		}
+
+		conn.SetReadDeadline(time.Now().Add(m.interval))
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return fmt.Errorf("error reading UDP data: %w", err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+			if err != nil {
+				continue
+			}
+			resp.Body.Close()
+			m.inspect(addr.IP.String(), resp.Header.Get("Location"), resp.Header.Get("USN"), resp.Header.Get("ST") == canary)
+		}
+
+		if sweep == 0 {
+			m.mu.Lock()
+			m.baseline = true
+			m.mu.Unlock()
+		}
+	}
+}
+
+// inspect checks one response or announcement from host
+func (m *Monitor) inspect(host, location, usn string, canary bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.known[host] {
+		m.known[host] = true
+		m.logger.Log("%sSSDP responder %s, LOCATION: %s", NoteBox, host, location)
+		switch {
+		case m.allow != nil && !m.allowed(host):
+			m.alert(host, "responder not on the allowlist")
+		case m.allow == nil && m.baseline:
+			m.alert(host, "new responder appeared")
+		}
+	}
+
+	if canary {
+		m.alert(host, "answered a search for a service type that does not exist")
+	}
+
+	if u, err := url.Parse(location); err == nil && u.Hostname() != "" && u.Hostname() != host {
+		m.alert(host, "LOCATION points at another host ("+u.Hostname()+")")
+	}
+
+	uuid, _, _ := strings.Cut(usn, "::")
+	if strings.HasPrefix(uuid, "uuid:") {
+		if owner, ok := m.owners[uuid]; !ok {
+			m.owners[uuid] = host
+		} else if owner != host {
+			m.alert(host, "announces "+uuid+", already owned by "+owner)
+		}
+	}
+}
+
+// allowed reports whether host is on the allowlist
+func (m *Monitor) allowed(host string) bool {
+	ip := net.ParseIP(host)
+	for _, network := range m.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// alert raises an alert about host, once per detail
+func (m *Monitor) alert(host, detail string) {
+	key := host + "|" + detail
+	if m.alerted[key] {
+		return
+	}
+	m.alerted[key] = true
+	m.events.OnAlert(events.Alert{
+		Time:   time.Now().UTC(),
+		Host:   host,
+		Kind:   events.AlertRogue,
+		Detail: detail,
+	})
+}
+
+// monitorConsole is the monitor's own subscriber, printing alerts
+type monitorConsole struct {
+	events.Nop
+	m *Monitor
+}
+
+// OnAlert prints an alert about a rogue responder
+func (c monitorConsole) OnAlert(e events.Alert) {
+	logging.Notice(c.m.logger, "%sRogue SSDP responder %s: %s", AlertBox, e.Host, e.Detail)
+}
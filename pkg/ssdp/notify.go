@@ -0,0 +1,194 @@
+package ssdp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"goSSDPkit/pkg/logging"
+)
+
+// maxBootID and maxConfigID bound BOOTID.UPNP.ORG and CONFIGID.UPNP.ORG.
+// BOOTID is a 31 bit value; CONFIGID values above 2^24-1 are reserved.
+const (
+	maxBootID   = 1<<31 - 1
+	maxConfigID = 1<<24 - 1
+)
+
+// DefaultMaxAge is how long control points may cache the device before
+// they must see it again, as sent in CACHE-CONTROL
+const DefaultMaxAge = 30 * time.Minute
+
+// WithMaxAge sets how long control points may cache the device. The longer
+// it is, the longer victims keep the device listed after the tool stops
+// answering; advertisements are sent more often the shorter it is.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(l *Listener) {
+		l.maxAge = maxAge
+	}
+}
+
+// WithAdvertise multicasts ssdp:alive NOTIFYs for the device when the
+// listener starts and again at a random point between a third and a half
+// of the max-age, as UPnP requires, so control points that never search
+// still list it. Analyze mode sends none.
+func WithAdvertise(advertise bool) Option {
+	return func(l *Listener) {
+		l.advertise = advertise
+	}
+}
+
+// cacheControl returns the CACHE-CONTROL header value
+func (l *Listener) cacheControl() string {
+	return "max-age=" + strconv.Itoa(int(l.maxAge/time.Second))
+}
+
+// advertiseInterval returns how long to wait before the next ssdp:alive:
+// a random time between a third and a half of the max-age, so listeners on
+// the segment never see the device expire and do not all hear from it at
+// once
+func (l *Listener) advertiseInterval() time.Duration {
+	return l.maxAge/3 + time.Duration(rand.Int63n(int64(l.maxAge/6)+1))
+}
+
+// advertiseLoop sends ssdp:alive NOTIFYs during active hours until ctx is
+// cancelled
+func (l *Listener) advertiseLoop(ctx context.Context) {
+	for {
+		if l.schedule.Active(time.Now()) {
+			bootID, configID := l.identity()
+			err := l.notify("ssdp:alive",
+				header{"CACHE-CONTROL", l.cacheControl()},
+				header{"SERVER", l.defaultServer()},
+				header{"BOOTID.UPNP.ORG", strconv.Itoa(bootID)},
+				header{"CONFIGID.UPNP.ORG", strconv.Itoa(configID)},
+			)
+			if err != nil && ctx.Err() == nil {
+				logging.Notice(l.logger, "%sError sending SSDP advertisement: %v", WarnBox, err)
+			} else if err == nil {
+				logging.LogAt(l.logger, logging.LevelVerbose, "%sSent ssdp:alive", OkBox)
+			}
+		}
+
+		timer := time.NewTimer(l.advertiseInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// initialBootID returns the BOOTID for a listener starting up: one more
+// than the previous run's when it was restored, and otherwise the time,
+// which UPnP 1.1 suggests for devices that cannot remember it and which
+// also increases from one run to the next
+func initialBootID(previous int, restored bool) int {
+	if restored {
+		return (previous + 1) & maxBootID
+	}
+	return int(time.Now().Unix() & maxBootID)
+}
+
+// identity returns the BOOTID and CONFIGID currently advertised
+func (l *Listener) identity() (int, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.bootID, l.configID
+}
+
+// SetDescriptors tells the listener the hash of the descriptors it
+// advertises. CONFIGID is bumped whenever the hash changes, between runs
+// sharing a state file as well as on reload. A change while running is
+// announced with ssdp:update NOTIFYs carrying NEXTBOOTID, after which the
+// BOOTID moves on to it, so control points fetch the descriptors again.
+func (l *Listener) SetDescriptors(hash string) {
+	l.mu.Lock()
+	changed := l.descriptorHash != "" && l.descriptorHash != hash
+	running := l.descriptorsSet
+	l.descriptorHash, l.descriptorsSet = hash, true
+	if !changed {
+		l.mu.Unlock()
+		return
+	}
+	l.configID = (l.configID + 1) & maxConfigID
+	bootID, configID := l.bootID, l.configID
+	if running {
+		l.bootID = (l.bootID + 1) & maxBootID
+	}
+	l.mu.Unlock()
+
+	l.logger.Log("%sDescriptors changed, CONFIGID is now %d", OkBox, configID)
+	if !running || l.analyzeMode {
+		return
+	}
+	nextBootID := (bootID + 1) & maxBootID
+	if err := l.notify("ssdp:update",
+		header{"BOOTID.UPNP.ORG", strconv.Itoa(bootID)},
+		header{"CONFIGID.UPNP.ORG", strconv.Itoa(configID)},
+		header{"NEXTBOOTID.UPNP.ORG", strconv.Itoa(nextBootID)},
+	); err != nil {
+		logging.Notice(l.logger, "%sError sending SSDP update: %v", WarnBox, err)
+		return
+	}
+	logging.LogAt(l.logger, logging.LevelVerbose, "%sSent ssdp:update, BOOTID %d to %d", OkBox, bootID, nextBootID)
+}
+
+// notify multicasts NOTIFY messages of kind nts for the root device out of
+// every binding, over IPv6 as well where it has an address, each followed
+// by the extra headers
+func (l *Listener) notify(nts string, extra ...header) error {
+	targets := []string{"upnp:rootdevice", l.sessionUSN}
+	v4 := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	v6 := &net.UDPAddr{IP: net.ParseIP("ff02::c"), Port: 1900}
+
+	for _, b := range l.bindings {
+		for _, nt := range targets {
+			usn := l.sessionUSN
+			if nt != l.sessionUSN {
+				usn += "::" + nt
+			}
+			headers := func(host, location string) []header {
+				return append([]header{
+					{"HOST", host},
+					{"LOCATION", location},
+					{"NT", nt},
+					{"NTS", nts},
+					{"USN", usn},
+				}, extra...)
+			}
+
+			message := notifyMessage(headers("239.255.255.250:1900", l.locationFor(b, false, Impersonation{})))
+			if _, err := l.pconn.WriteTo([]byte(message), &ipv4.ControlMessage{IfIndex: b.iface.Index}, v4); err != nil {
+				return fmt.Errorf("failed to send NOTIFY on %s: %w", b.Name, err)
+			}
+			if l.pconn6 == nil || b.LocalIP6 == "" {
+				continue
+			}
+			message = notifyMessage(headers("[FF02::C]:1900", l.locationFor(b, true, Impersonation{})))
+			if _, err := l.pconn6.WriteTo([]byte(message), &ipv6.ControlMessage{IfIndex: b.iface.Index}, v6); err != nil {
+				return fmt.Errorf("failed to send IPv6 NOTIFY on %s: %w", b.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// notifyMessage formats a NOTIFY request
+func notifyMessage(headers []header) string {
+	var message strings.Builder
+	message.WriteString("NOTIFY * HTTP/1.1\r\n")
+	for _, h := range headers {
+		message.WriteString(h.name + ": " + h.value + "\r\n")
+	}
+	message.WriteString("\r\n")
+	return message.String()
+}
@@ -0,0 +1,57 @@
+package ssdp
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ReplySocket configures a socket for sending responses, separate from the
+// one bound to port 1900. Several UPnP stacks answer from an ephemeral port,
+// and a separate socket avoids trouble on hosts where 1900 is shared.
+type ReplySocket struct {
+	Port int // source port, 0 for an ephemeral one
+	TTL  int // IP time to live, 0 for the system default
+	DSCP int // differentiated services code point, 0 for none
+}
+
+// WithReplySocket sends responses from a separate socket configured by rs
+func WithReplySocket(rs ReplySocket) Option {
+	return func(l *Listener) {
+		l.replySocket = &rs
+	}
+}
+
+// openReplySocket creates the socket described by rs
+func openReplySocket(rs ReplySocket) (*net.UDPConn, error) {
+	if rs.Port < 0 || rs.Port > 65535 {
+		return nil, fmt.Errorf("invalid reply port: %d", rs.Port)
+	}
+	if rs.TTL < 0 || rs.TTL > 255 {
+		return nil, fmt.Errorf("invalid reply TTL: %d", rs.TTL)
+	}
+	if rs.DSCP < 0 || rs.DSCP > 63 {
+		return nil, fmt.Errorf("invalid reply DSCP: %d", rs.DSCP)
+	}
+
+	conn, err := ListenShared("udp4", rs.Port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply socket: %w", err)
+	}
+	ipConn := ipv4.NewConn(conn)
+	if rs.TTL > 0 {
+		if err := ipConn.SetTTL(rs.TTL); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set reply TTL: %w", err)
+		}
+	}
+	if rs.DSCP > 0 {
+		// DSCP is the top six bits of the TOS byte
+		if err := ipConn.SetTOS(rs.DSCP << 2); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set reply DSCP: %w", err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,59 @@
+package ssdp
+
+import (
+	"io"
+	"strings"
+)
+
+// Response holds the values of an SSDP response, for templates that write
+// the whole response themselves
+type Response struct {
+	Location     string // URL of the device descriptor
+	ST           string // type advertised for the search target
+	USN          string // unique service name for ST
+	SessionUSN   string // device UUID of this session
+	CacheControl string // CACHE-CONTROL value, max-age=SECONDS
+	Server       string // SERVER header the built-in response would send
+	Date         string // RFC 1123 date, skewed as configured
+	BootID       string
+	ConfigID     string
+}
+
+// ResponseTemplate renders a Response, as a text/template does
+type ResponseTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+// responseTemplate wraps a ResponseTemplate so none can be stored
+type responseTemplate struct {
+	ResponseTemplate
+}
+
+// SetResponseTemplate makes the listener answer searches with t rendered
+// instead of its built-in response, or with the built-in one again when t
+// is nil. It may be called while the listener runs.
+func (l *Listener) SetResponseTemplate(t ResponseTemplate) {
+	l.response.Store(responseTemplate{t})
+}
+
+// renderResponse renders r with the response template, if one is set. The
+// lines of the result end in CRLF whatever the template file uses, a
+// status line is added if the template has none, and the headers end with
+// an empty line.
+func (l *Listener) renderResponse(r Response) (string, bool, error) {
+	t, _ := l.response.Load().(responseTemplate)
+	if t.ResponseTemplate == nil {
+		return "", false, nil
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, r); err != nil {
+		return "", true, err
+	}
+
+	text := strings.ReplaceAll(out.String(), "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(strings.TrimLeft(text, "\n"), "\n"), "\n")
+	if !strings.HasPrefix(lines[0], "HTTP/") {
+		lines = append([]string{"HTTP/1.1 200 OK"}, lines...)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n\r\n", true, nil
+}
@@ -0,0 +1,19 @@
+package ssdp
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// ListenShared binds a UDP socket to port on every address with
+// SO_REUSEADDR, and SO_REUSEPORT where the platform has it, so it can share
+// the port with the operating system's SSDP service or another instance
+func ListenShared(network string, port int) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: reuseControl}
+	conn, err := lc.ListenPacket(context.Background(), network, fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package ssdp
+
+import "syscall"
+
+// reuseControl leaves sockets as they are on platforms without a known way
+// to share a port
+func reuseControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
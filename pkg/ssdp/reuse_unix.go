@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package ssdp
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reuseControl sets SO_REUSEADDR and SO_REUSEPORT on a socket before it is
+// bound. SO_REUSEPORT is best effort, as older kernels lack it.
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if sockErr == nil {
+			unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package ssdp
+
+import "syscall"
+
+// reuseControl sets SO_REUSEADDR on a socket before it is bound. Windows has
+// no SO_REUSEPORT; SO_REUSEADDR alone lets sockets share the port.
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
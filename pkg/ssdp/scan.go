@@ -0,0 +1,100 @@
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ScanResult describes a device that answered an M-SEARCH sent by Scan
+type ScanResult struct {
+	Addr     string
+	ST       string
+	USN      string
+	Location string
+	Server   string
+}
+
+// Scan sends an M-SEARCH for the given search target out of the interface
+// that owns localIP, and collects the unique responses received within wait
+func Scan(localIP, searchTarget string, wait time.Duration) ([]ScanResult, error) {
+	mcastAddr, err := net.ResolveUDPAddr("udp4", "239.255.255.250:1900")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve multicast address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(localIP)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+
+	iface, err := getInterfaceByIP(localIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface for IP %s: %w", localIP, err)
+	}
+
+	pconn := ipv4.NewPacketConn(conn)
+	if err := pconn.SetMulticastInterface(iface); err != nil {
+		return nil, fmt.Errorf("failed to set multicast interface %s: %w", iface.Name, err)
+	}
+
+	mx := int(wait / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	msearch := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: 239.255.255.250:1900\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: %d\r\n"+
+		"ST: %s\r\n"+
+		"\r\n", mx, searchTarget)
+
+	if _, err := conn.WriteTo([]byte(msearch), mcastAddr); err != nil {
+		return nil, fmt.Errorf("failed to send M-SEARCH: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	var results []ScanResult
+	seen := make(map[string]bool)
+	buffer := make([]byte, 4096)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return results, nil
+			}
+			return results, fmt.Errorf("error reading UDP data: %w", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		result := ScanResult{
+			Addr:     addr.IP.String(),
+			ST:       resp.Header.Get("ST"),
+			USN:      resp.Header.Get("USN"),
+			Location: resp.Header.Get("Location"),
+			Server:   resp.Header.Get("Server"),
+		}
+
+		key := result.Addr + "_" + result.USN
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		results = append(results, result)
+	}
+}
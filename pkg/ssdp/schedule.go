@@ -0,0 +1,176 @@
+package ssdp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/logging"
+)
+
+// scheduleCheck is how often the listener checks whether it has entered or
+// left its active hours, to log the change
+const scheduleCheck = 30 * time.Second
+
+// weekdays maps day names to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Schedule is the weekly hours during which searches are answered. A nil
+// Schedule is always active.
+type Schedule struct {
+	windows []window
+	specs   []string
+}
+
+// window is one daily span on some days of the week. A span ending before
+// it starts runs past midnight into the next day.
+type window struct {
+	days       [7]bool
+	start, end int // minutes since midnight
+}
+
+// ParseSchedule parses windows like "Mon-Fri 09:00-17:00", "Sat,Sun
+// 10:00-14:00" or "22:00-06:00" (every day) into a Schedule, in local time
+func ParseSchedule(specs []string) (*Schedule, error) {
+	s := &Schedule{specs: specs}
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid active hours %q: %w", spec, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// parseWindow parses "[DAYS] HH:MM-HH:MM"
+func parseWindow(spec string) (window, error) {
+	var w window
+	fields := strings.Fields(spec)
+	var hours string
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		hours = fields[1]
+		for _, part := range strings.Split(fields[0], ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			from, ok := weekdays[strings.ToLower(first)]
+			if !ok {
+				return w, fmt.Errorf("unknown day %q", first)
+			}
+			to := from
+			if isRange {
+				if to, ok = weekdays[strings.ToLower(last)]; !ok {
+					return w, fmt.Errorf("unknown day %q", last)
+				}
+			}
+			for d := from; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == to {
+					break
+				}
+			}
+		}
+	default:
+		return w, fmt.Errorf("expected [DAYS] HH:MM-HH:MM")
+	}
+
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return w, fmt.Errorf("expected HH:MM-HH:MM, got %q", hours)
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("window starts and ends at %s", start)
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 is the end of
+// the day
+func parseClock(clock string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute); err != nil || n != 2 || len(clock) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+// Active reports whether t falls in one of the windows
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the windows as they were given
+func (s *Schedule) String() string {
+	return strings.Join(s.specs, ", ")
+}
+
+// WithSchedule only answers searches, and advertises, during the hours of
+// s. Outside them the listener observes like in analyze mode.
+func WithSchedule(s *Schedule) Option {
+	return func(l *Listener) {
+		l.schedule = s
+	}
+}
+
+// watchSchedule logs each time the listener enters or leaves its active
+// hours, until ctx is cancelled
+func (l *Listener) watchSchedule(ctx context.Context) {
+	active := l.schedule.Active(time.Now())
+	if !active {
+		logging.Notice(l.logger, "%sOutside active hours (%s), only observing searches", NoteBox, l.schedule)
+	}
+	ticker := time.NewTicker(scheduleCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if l.schedule.Active(now) == active {
+				continue
+			}
+			active = !active
+			if active {
+				logging.Notice(l.logger, "%sActive hours started, answering searches", OkBox)
+			} else {
+				logging.Notice(l.logger, "%sActive hours ended, only observing searches", NoteBox)
+			}
+		}
+	}
+}
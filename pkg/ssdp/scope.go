@@ -0,0 +1,42 @@
+package ssdp
+
+import (
+	"fmt"
+	"strings"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/scope"
+)
+
+// WithScope ignores every packet from hosts outside s, writing them to
+// violations instead: they are not answered, logged, alerted on or raised
+// as events
+func WithScope(s *scope.Scope, violations *scope.Violations) Option {
+	return func(l *Listener) {
+		l.scope.Store(s)
+		l.violations = violations
+	}
+}
+
+// SetScope replaces the scope searches are checked against while the
+// listener runs, nil for none. Contacts from outside it go to the
+// violations log given to WithScope.
+func (l *Listener) SetScope(s *scope.Scope) {
+	l.scope.Store(s)
+}
+
+// outOfScope records a packet from a host outside the engagement scope,
+// noting the first one from each host on the console
+func (l *Listener) outOfScope(host, data string) {
+	requestLine, _, _ := strings.Cut(data, "\r\n")
+	what := fmt.Sprintf("SSDP %q", requestLine)
+	if st := headerValue(data, "ST"); st != "" {
+		what += fmt.Sprintf(" ST=%q", st)
+	}
+	if userAgent := headerValue(data, "USER-AGENT"); userAgent != "" {
+		what += fmt.Sprintf(" User-Agent=%q", userAgent)
+	}
+	if l.violations.Record(host, what) {
+		logging.Notice(l.logger, "%sIgnoring %s, which is out of scope; its contacts go to the violations log only", WarnBox, host)
+	}
+}
@@ -0,0 +1,66 @@
+package ssdp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+// WithShared answers the searches received on owner's sockets instead of
+// opening sockets of its own, so several devices can be served on the same
+// interfaces: however many listeners share port 1900, unicast searches only
+// reach one socket per address family. The listener must be given owner's
+// bindings, in the same order, under names of its own if wanted. It keeps
+// its own scope, response, identity and known hosts.
+func WithShared(owner *Listener) Option {
+	return func(l *Listener) {
+		l.owner = owner
+	}
+}
+
+// share sets up l to answer the searches its owner receives on bindings
+func (l *Listener) share(bindings []Binding) error {
+	owner := l.owner
+	if len(bindings) != len(owner.bindings) {
+		return fmt.Errorf("cannot share a listener on %d interfaces with %d", len(owner.bindings), len(bindings))
+	}
+	for i, b := range bindings {
+		shared := owner.bindings[i]
+		if b.LocalIP != shared.LocalIP {
+			return fmt.Errorf("cannot share a listener on %s with %s", shared.LocalIP, b.LocalIP)
+		}
+		if b.Name == "" {
+			b.Name = shared.iface.Name
+		}
+		l.bindings = append(l.bindings, &binding{
+			Binding:  b,
+			iface:    shared.iface,
+			networks: shared.networks,
+		})
+	}
+	l.sock, l.replySock, l.pconn = owner.sock, owner.replySock, owner.pconn
+	l.sock6, l.pconn6 = owner.sock6, owner.pconn6
+	l.done = make(chan struct{})
+
+	owner.mu.Lock()
+	owner.shared = append(owner.shared, l)
+	owner.mu.Unlock()
+	return nil
+}
+
+// dispatch passes a packet received on b to the listeners sharing l's
+// sockets, each on its own binding for the same interface
+func (l *Listener) dispatch(data []byte, addr net.Addr, b *binding, unicast bool) {
+	l.mu.RLock()
+	shared := l.shared
+	l.mu.RUnlock()
+	i := slices.Index(l.bindings, b)
+	for _, s := range shared {
+		select {
+		case <-s.done:
+			continue
+		default:
+		}
+		s.processData(data, addr, s.bindings[i], unicast)
+	}
+}
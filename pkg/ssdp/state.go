@@ -0,0 +1,114 @@
+package ssdp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is what a listener must remember across restarts to present the
+// same device: victims cache the USN, and a new one mid-engagement shows up
+// as a second device while the first goes stale
+type State struct {
+	SessionUSN string `json:"session_usn"`
+	BootID     int    `json:"boot_id"`
+	ConfigID   int    `json:"config_id"`
+	// Hash of the descriptors CONFIGID was last bumped for
+	DescriptorHash string      `json:"descriptor_hash,omitempty"`
+	KnownHosts     []KnownHost `json:"known_hosts,omitempty"`
+}
+
+// KnownHost is a host/ST pair the listener has seen, so it is not reported
+// as new again after a restart
+type KnownHost struct {
+	Host     string    `json:"host"`
+	ST       string    `json:"st"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// LoadState reads the state saved at path, returning nil if there is none
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// SaveState writes state to path, replacing the file whole so a crash
+// cannot leave it half written
+func SaveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// WithState takes up the identity and known hosts of a previous run, with
+// the BOOTID one more than it was. A nil state is ignored.
+func WithState(state *State) Option {
+	return func(l *Listener) {
+		if state == nil {
+			return
+		}
+		if state.SessionUSN != "" {
+			l.sessionUSN = state.SessionUSN
+		}
+		l.restored = true
+		l.bootID = state.BootID
+		l.configID = state.ConfigID
+		l.descriptorHash = state.DescriptorHash
+		l.restoredHosts = state.KnownHosts
+	}
+}
+
+// State returns the listener's identity and the hosts it has seen, for
+// saving with SaveState
+func (l *Listener) State() *State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.knownHosts.expire(time.Now())
+
+	state := &State{
+		SessionUSN:     l.sessionUSN,
+		BootID:         l.bootID,
+		ConfigID:       l.configID,
+		DescriptorHash: l.descriptorHash,
+	}
+	for elem := l.knownHosts.order.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*hostEntry)
+		host, st := splitHostKey(entry.key)
+		state.KnownHosts = append(state.KnownHosts, KnownHost{Host: host, ST: st, LastSeen: entry.lastSeen})
+	}
+	return state
+}
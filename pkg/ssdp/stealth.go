@@ -0,0 +1,95 @@
+package ssdp
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on the variation added to responses in stealth mode
+const (
+	maxDateSkew     = 30 * time.Second
+	maxStealthDelay = 5 * time.Second // the largest MX the spec allows
+)
+
+// header is one SSDP response header
+type header struct {
+	name, value string
+}
+
+// renderReply formats an SSDP response. In stealth mode the headers are
+// shuffled and their names cased the way one of several real stacks does,
+// so the response has no fixed shape for a signature to key on.
+func renderReply(headers []header, stealth bool) string {
+	if stealth {
+		headers = append([]header(nil), headers...)
+		rand.Shuffle(len(headers), func(i, j int) {
+			headers[i], headers[j] = headers[j], headers[i]
+		})
+	}
+
+	caseName := strings.ToUpper
+	if stealth {
+		switch rand.Intn(3) {
+		case 1:
+			caseName = titleCase
+		case 2:
+			caseName = strings.ToLower
+		}
+	}
+
+	var reply strings.Builder
+	reply.WriteString("HTTP/1.1 200 OK\r\n")
+	for _, h := range headers {
+		if h.value == "" {
+			reply.WriteString(caseName(h.name) + ":\r\n")
+		} else {
+			reply.WriteString(caseName(h.name) + ": " + h.value + "\r\n")
+		}
+	}
+	// The stray extra line ending evil-ssdp always sent is a giveaway
+	if stealth {
+		reply.WriteString("\r\n")
+	} else {
+		reply.WriteString("\r\n\r\n")
+	}
+	return reply.String()
+}
+
+// titleCase capitalises each dash separated word of a header name, as in
+// "Cache-Control", keeping UPnP's dotted extension names upper case
+func titleCase(name string) string {
+	if strings.Contains(name, ".") {
+		return strings.ToUpper(name)
+	}
+	words := strings.Split(strings.ToLower(name), "-")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// randomSkew picks a clock offset of up to maxDateSkew either way. It is
+// chosen once per run, as a device whose clock is not quite in sync would
+// be off by the same amount in every response.
+func randomSkew() time.Duration {
+	return time.Duration(rand.Int63n(int64(2*maxDateSkew))) - maxDateSkew
+}
+
+// stealthDelay picks a random delay before answering a search, within the
+// MX seconds the searcher said it would wait, as UPnP devices are required
+// to do
+func stealthDelay(message string) time.Duration {
+	mx, err := strconv.Atoi(headerValue(message, "MX"))
+	if err != nil || mx <= 0 {
+		mx = 1
+	}
+	limit := time.Duration(mx) * time.Second
+	if limit > maxStealthDelay {
+		limit = maxStealthDelay
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
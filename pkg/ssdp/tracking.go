@@ -0,0 +1,39 @@
+package ssdp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// ResponseIDParam is the query parameter of LOCATION URLs that carries the
+// ID of the response, with tracking on
+const ResponseIDParam = "rid"
+
+// WithTracking gives every response a unique ID, added to its LOCATION as
+// ResponseIDParam and raised with the search answered, so the HTTP
+// requests that follow can be tied to the exact search that led to them
+func WithTracking(track bool) Option {
+	return func(l *Listener) {
+		l.tracking = track
+	}
+}
+
+// newResponseID returns a random response ID
+func newResponseID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// WithResponseID returns url with the response ID id added to its query
+func WithResponseID(url, id string) string {
+	if id == "" {
+		return url
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + ResponseIDParam + "=" + id
+}
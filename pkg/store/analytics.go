@@ -0,0 +1,103 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// PageAnalytics summarises how victims used one page, or one page of an A/B
+// test, from the interactions the analytics beacon reported
+type PageAnalytics struct {
+	Page       string `json:"page"`
+	Variant    string `json:"variant,omitempty"`
+	Sessions   int    `json:"sessions"`   // page loads reported
+	Interacted int    `json:"interacted"` // sessions with any focus, key press or click
+	Submitted  int    `json:"submitted"`
+	Abandoned  int    `json:"abandoned"` // left without submitting
+
+	// Medians over the sessions with input, and over all of them
+	MedianFirstInput int64 `json:"median_first_input_ms"`
+	MedianDuration   int64 `json:"median_duration_ms"`
+
+	// Most common order fields were focused in, e.g. "username>password"
+	FocusOrder string `json:"focus_order,omitempty"`
+
+	// Fields abandoned sessions were last in, by how many were
+	AbandonedAt map[string]int `json:"abandoned_at,omitempty"`
+}
+
+// Analytics summarises interaction records per page and A/B test page,
+// sorted by page
+func Analytics(records []Record) []PageAnalytics {
+	type key struct{ page, variant string }
+	type tally struct {
+		PageAnalytics
+		firstInputs, durations []int64
+		orders                 map[string]int
+	}
+	tallies := make(map[key]*tally)
+	for _, r := range records {
+		if r.Type != TypeInteraction {
+			continue
+		}
+		k := key{r.Path, r.Variant}
+		t, ok := tallies[k]
+		if !ok {
+			t = &tally{PageAnalytics: PageAnalytics{Page: r.Path, Variant: r.Variant}, orders: make(map[string]int)}
+			tallies[k] = t
+		}
+		t.Sessions++
+		t.durations = append(t.durations, r.Duration)
+		if r.FirstInput > 0 {
+			t.Interacted++
+			t.firstInputs = append(t.firstInputs, r.FirstInput)
+		}
+		if len(r.Focus) > 0 {
+			t.orders[strings.Join(r.Focus, ">")]++
+		}
+		if r.Submitted {
+			t.Submitted++
+			continue
+		}
+		t.Abandoned++
+		if r.LastField != "" {
+			if t.AbandonedAt == nil {
+				t.AbandonedAt = make(map[string]int)
+			}
+			t.AbandonedAt[r.LastField]++
+		}
+	}
+
+	pages := make([]PageAnalytics, 0, len(tallies))
+	for _, t := range tallies {
+		t.MedianFirstInput = median(t.firstInputs)
+		t.MedianDuration = median(t.durations)
+		best := 0
+		for order, n := range t.orders {
+			if n > best || (n == best && order < t.FocusOrder) {
+				t.FocusOrder, best = order, n
+			}
+		}
+		pages = append(pages, t.PageAnalytics)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Page != pages[j].Page {
+			return pages[i].Page < pages[j].Page
+		}
+		return pages[i].Variant < pages[j].Variant
+	})
+	return pages
+}
+
+// median returns the median of values, 0 for none
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
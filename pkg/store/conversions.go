@@ -0,0 +1,53 @@
+package store
+
+import "sort"
+
+// Conversion is how many hosts were shown a page of a template's A/B test
+// and how many of them went on to submit credentials
+type Conversion struct {
+	Variant   string  `json:"variant"`
+	Hooked    int     `json:"hooked"`
+	Submitted int     `json:"submitted"`
+	Rate      float64 `json:"rate"` // Submitted / Hooked
+}
+
+// Conversions counts the distinct hosts hooked by and submitting to each
+// A/B test page among records, sorted by page
+func Conversions(records []Record) []Conversion {
+	hooked := make(map[string]map[string]bool)
+	submitted := make(map[string]map[string]bool)
+	for _, r := range records {
+		if r.Variant == "" {
+			continue
+		}
+		var hosts map[string]map[string]bool
+		switch r.Type {
+		case TypeHook:
+			hosts = hooked
+		case TypeCreds:
+			hosts = submitted
+		default:
+			continue
+		}
+		if hosts[r.Variant] == nil {
+			hosts[r.Variant] = make(map[string]bool)
+		}
+		hosts[r.Variant][r.Host] = true
+	}
+
+	var conversions []Conversion
+	for variant, hosts := range hooked {
+		c := Conversion{Variant: variant, Hooked: len(hosts), Submitted: len(submitted[variant])}
+		c.Rate = float64(c.Submitted) / float64(c.Hooked)
+		conversions = append(conversions, c)
+	}
+	for variant, hosts := range submitted {
+		if hooked[variant] == nil {
+			conversions = append(conversions, Conversion{Variant: variant, Submitted: len(hosts)})
+		}
+	}
+	sort.Slice(conversions, func(i, j int) bool {
+		return conversions[i].Variant < conversions[j].Variant
+	})
+	return conversions
+}
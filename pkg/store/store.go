@@ -0,0 +1,246 @@
+// Package store keeps every event as a line of JSON, so findings can be
+// exported after the fact without scraping the text log.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// Path is the file events are stored in, relative to the working directory
+const Path = "logs/events.jsonl"
+
+// Record types
+const (
+	TypeMSearch     = "msearch"
+	TypeDescriptor  = "descriptor"
+	TypeHook        = "hook"
+	TypeCreds       = "creds"
+	TypeExfil       = "exfil"
+	TypeAlert       = "alert"
+	TypeInteraction = "interaction"
+	TypeScreenshot  = "screenshot"
+	TypeDownload    = "download"
+	TypeTag         = "tag" // tags and note an operator attached to a host
+)
+
+// Record is one stored event. Only the fields of its type are set.
+type Record struct {
+	Time      time.Time           `json:"time"`
+	Type      string              `json:"type"`
+	Label     string              `json:"interface,omitempty"`
+	Host      string              `json:"host"`
+	ST        string              `json:"st,omitempty"`
+	UserAgent string              `json:"user_agent,omitempty"`
+	Method    string              `json:"method,omitempty"`
+	Path      string              `json:"path,omitempty"`
+	Variant   string              `json:"variant,omitempty"`
+	Target    string              `json:"target,omitempty"`
+	Body      string              `json:"body,omitempty"`
+	Binary    bool                `json:"body_binary,omitempty"`
+	Truncated bool                `json:"body_truncated,omitempty"`
+	Source    string              `json:"source,omitempty"`
+	Username  string              `json:"username,omitempty"`
+	Password  string              `json:"password,omitempty"`
+	Extra     map[string][]string `json:"extra,omitempty"`
+	Verdict   string              `json:"validation,omitempty"`
+	Kind      string              `json:"kind,omitempty"`
+	Data      string              `json:"data,omitempty"`
+	Detail    string              `json:"detail,omitempty"`
+	New       bool                `json:"new,omitempty"`
+
+	// Interactions: milliseconds from load to the first input and on the
+	// page, the fields focused in order, the last of them, and whether
+	// the page was submitted
+	FirstInput int64    `json:"first_input_ms,omitempty"`
+	Focus      []string `json:"focus,omitempty"`
+	LastField  string   `json:"last_field,omitempty"`
+	Duration   int64    `json:"duration_ms,omitempty"`
+	Submitted  bool     `json:"submitted,omitempty"`
+
+	// Size of a downloaded file
+	Size int64 `json:"size,omitempty"`
+
+	// ID of the SSDP response answering a search, or of the one that led
+	// the host to a request, when responses are tracked
+	ResponseID string `json:"response_id,omitempty"`
+
+	// TLS fingerprints of the client of an HTTP request made over TLS
+	JA3 string `json:"ja3,omitempty"`
+	JA4 string `json:"ja4,omitempty"`
+
+	// Tags and note of the host, set by tag records and added to the
+	// records of the host on export
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// Records is an events subscriber handing each event to a function as a
+// Record, for subscribers that keep or forward events in this format
+type Records func(Record)
+
+var _ events.Events = Records(nil)
+
+// Store is an events subscriber that appends every event to a file
+type Store struct {
+	Records
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	err  error
+}
+
+// Open opens the store at path for appending, creating it if needed
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create event store directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event store: %w", err)
+	}
+	s := &Store{file: file, enc: json.NewEncoder(file)}
+	s.Records = s.write
+	return s, nil
+}
+
+// Close closes the store and returns the first error writing to it, if any
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// write appends r, keeping the first error for Close
+func (s *Store) write(r Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(r); err != nil && s.err == nil {
+		s.err = fmt.Errorf("failed to write event store: %w", err)
+	}
+}
+
+// request returns the record of an HTTP request
+func request(t string, e events.Request) Record {
+	return Record{
+		Time:      e.Time,
+		Type:      t,
+		Label:     e.Label,
+		Host:      e.Host,
+		UserAgent: e.UserAgent,
+		Method:    e.Method,
+		Path:      e.Path,
+		Variant:   e.Variant,
+		Target:    e.Target,
+		Body:      e.Body,
+		Binary:    e.BodyBinary,
+		Truncated: e.BodyTruncated,
+
+		ResponseID: e.ResponseID,
+		JA3:        e.JA3,
+		JA4:        e.JA4,
+	}
+}
+
+func (f Records) OnMSearch(e events.MSearch) {
+	f(Record{
+		Time:      e.Time,
+		Type:      TypeMSearch,
+		Label:     e.Label,
+		Host:      e.Host,
+		ST:        e.ST,
+		UserAgent: e.UserAgent,
+		New:       e.New,
+
+		ResponseID: e.ResponseID,
+	})
+}
+
+func (f Records) OnDescriptorFetch(e events.Request) {
+	f(request(TypeDescriptor, e))
+}
+
+func (f Records) OnPhishHook(e events.Request) {
+	f(request(TypeHook, e))
+}
+
+func (f Records) OnCredentials(e events.Credentials) {
+	r := request(TypeCreds, e.Request)
+	r.Source = e.Source
+	r.Username = e.Username
+	r.Password = e.Password
+	r.Extra = e.Extra
+	r.Verdict = e.Validation
+	r.New = e.New
+	f(r)
+}
+
+func (f Records) OnExfil(e events.Exfil) {
+	r := request(TypeExfil, e.Request)
+	r.Kind = e.Kind
+	r.Data = e.Data
+	f(r)
+}
+
+func (f Records) OnAlert(e events.Alert) {
+	f(Record{
+		Time:   e.Time,
+		Type:   TypeAlert,
+		Label:  e.Label,
+		Host:   e.Host,
+		Kind:   e.Kind,
+		Detail: e.Detail,
+	})
+}
+
+func (f Records) OnInteraction(e events.Interaction) {
+	r := request(TypeInteraction, e.Request)
+	r.FirstInput = e.FirstInput.Milliseconds()
+	r.Focus = e.Focus
+	r.LastField = e.LastField
+	r.Duration = e.Duration.Milliseconds()
+	r.Submitted = e.Submitted
+	f(r)
+}
+
+// OnScreenshot records that a page was pictured; the image itself is loot
+func (f Records) OnScreenshot(e events.Screenshot) {
+	f(request(TypeScreenshot, e.Request))
+}
+
+func (f Records) OnDownload(e events.Download) {
+	r := request(TypeDownload, e.Request)
+	r.Size = e.Size
+	f(r)
+}
+
+// Read returns the records in r of type t (every type when t is empty)
+// stored at or after since. Lines that are not records, such as one cut
+// short by a crash, are skipped.
+func Read(r io.Reader, t string, since time.Time) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if (t != "" && record.Type != t) || record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// HostTags are the tags and note an operator attached to a host, such as
+// "dc" and "out of scope - reported"
+type HostTags struct {
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// Empty reports whether t holds neither tags nor a note
+func (t HostTags) Empty() bool {
+	return len(t.Tags) == 0 && t.Note == ""
+}
+
+// Add returns t with tags added, keeping them sorted and distinct
+func (t HostTags) Add(tags ...string) HostTags {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, tag := range append(append([]string(nil), t.Tags...), tags...) {
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	sort.Strings(merged)
+	t.Tags = merged
+	return t
+}
+
+// Remove returns t without tags
+func (t HostTags) Remove(tags ...string) HostTags {
+	drop := make(map[string]bool)
+	for _, tag := range tags {
+		drop[tag] = true
+	}
+	var kept []string
+	for _, tag := range t.Tags {
+		if !drop[tag] {
+			kept = append(kept, tag)
+		}
+	}
+	t.Tags = kept
+	return t
+}
+
+// Tag returns the record setting host's tags and note to t, replacing
+// those set before
+func Tag(host string, t HostTags, at time.Time) Record {
+	return Record{Time: at.UTC(), Type: TypeTag, Host: host, Tags: t.Tags, Note: t.Note}
+}
+
+// Tags returns the tags and note of each host tagged among records, the
+// latest tag record of a host winning
+func Tags(records []Record) map[string]HostTags {
+	tags := make(map[string]HostTags)
+	for _, r := range records {
+		if r.Type != TypeTag {
+			continue
+		}
+		t := HostTags{Tags: r.Tags, Note: r.Note}
+		if t.Empty() {
+			delete(tags, r.Host)
+			continue
+		}
+		tags[r.Host] = t
+	}
+	return tags
+}
+
+// Annotate sets the tags and note of each record from those of its host
+func Annotate(records []Record, tags map[string]HostTags) {
+	for i := range records {
+		t := tags[records[i].Host]
+		records[i].Tags, records[i].Note = t.Tags, t.Note
+	}
+}
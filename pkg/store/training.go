@@ -0,0 +1,68 @@
+package store
+
+import "sort"
+
+// TrainingResult is how far one target of an awareness exercise went: the
+// hosts attributed to them, whether the spoofed device reached any of
+// those hosts, and how many pages they opened and forms they submitted
+type TrainingResult struct {
+	Target      string   `json:"target"`
+	Hosts       []string `json:"hosts"`
+	Lured       bool     `json:"lured"`
+	Clicks      int      `json:"clicks"`
+	Submissions int      `json:"submissions"`
+}
+
+// Training reports the result of each target among records, in the order
+// of targets, then those records name that targets lacks. A host is lured
+// when it searched for or fetched the descriptor of the spoofed device.
+func Training(records []Record, targets []string) []TrainingResult {
+	results := make(map[string]*TrainingResult)
+	order := append([]string(nil), targets...)
+	for _, target := range targets {
+		results[target] = &TrainingResult{Target: target}
+	}
+	hosts := make(map[string]map[string]bool)
+	lured := make(map[string]bool)
+	for _, r := range records {
+		switch r.Type {
+		case TypeMSearch, TypeDescriptor:
+			lured[r.Host] = true
+			continue
+		case TypeHook, TypeCreds:
+		default:
+			continue
+		}
+		if r.Target == "" {
+			continue
+		}
+		result, ok := results[r.Target]
+		if !ok {
+			result = &TrainingResult{Target: r.Target}
+			results[r.Target] = result
+			order = append(order, r.Target)
+		}
+		if hosts[r.Target] == nil {
+			hosts[r.Target] = make(map[string]bool)
+		}
+		hosts[r.Target][r.Host] = true
+		if r.Type == TypeHook {
+			result.Clicks++
+		} else {
+			result.Submissions++
+		}
+	}
+
+	list := make([]TrainingResult, 0, len(order))
+	for _, target := range order {
+		result := results[target]
+		result.Hosts = []string{}
+		for host := range hosts[target] {
+			result.Hosts = append(result.Hosts, host)
+			result.Lured = result.Lured || lured[host]
+		}
+		sort.Strings(result.Hosts)
+		list = append(list, *result)
+	}
+	return list
+}
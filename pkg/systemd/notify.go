@@ -0,0 +1,61 @@
+// Package systemd implements the parts of the sd_notify protocol a
+// Type=notify service needs: readiness, reloading, stopping and watchdog
+// messages. Outside systemd every call is a no-op.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Messages understood by systemd
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager. It reports false, with no
+// error, when not started by systemd with a notification socket.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notification socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a watchdog message, or
+// zero if the watchdog is not enabled for this process. Pinging at half the
+// interval leaves room for delays.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %s", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
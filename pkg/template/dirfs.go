@@ -0,0 +1,39 @@
+package template
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirFS is a directory on disk that, unlike os.DirFS, refuses to follow
+// symbolic links out of it, so a link in a template cannot expose the rest
+// of the filesystem
+type dirFS struct {
+	dir string
+}
+
+// DirFS returns the files under dir, confined to it
+func DirFS(dir string) fs.FS {
+	return &dirFS{dir: dir}
+}
+
+// Open implements fs.FS
+func (d *dirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || strings.ContainsAny(name, `\`+"\x00") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := filepath.EvalSymlinks(d.dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return os.Open(resolved)
+}
@@ -0,0 +1,181 @@
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// XMLMutation alters a device descriptor to see how a control point's XML
+// parser copes with it
+type XMLMutation struct {
+	Name        string
+	Description string
+	apply       func(xml string) string
+}
+
+// Apply returns xml with the mutation applied
+func (m XMLMutation) Apply(xml string) string {
+	return m.apply(xml)
+}
+
+var (
+	// xmlDecl matches the XML declaration, if any
+	xmlDecl = regexp.MustCompile(`^\s*<\?xml[^>]*\?>`)
+
+	// friendlyName matches the friendlyName element
+	friendlyName = regexp.MustCompile(`<friendlyName>[^<]*</friendlyName>`)
+
+	// urlBase matches the URLBase element
+	urlBase = regexp.MustCompile(`<URLBase>([^<]*)</URLBase>`)
+)
+
+// laughs is the entity expansion bomb declared by the entity-bomb mutations
+const laughs = `<!DOCTYPE root [
+<!ENTITY lol "lol">
+<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+<!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;">
+<!ENTITY lol6 "&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;">
+<!ENTITY lol7 "&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;">
+<!ENTITY lol8 "&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;">
+<!ENTITY lol9 "&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;">
+]>
+`
+
+// XMLMutations are the descriptor mutations the fuzzer cycles through, in
+// order
+var XMLMutations = []XMLMutation{
+	{"deep-nesting", "10000 nested elements inside device", func(x string) string {
+		return insertInDevice(x, strings.Repeat("<n>", 10000)+strings.Repeat("</n>", 10000))
+	}},
+	{"huge-friendlyname", "friendlyName of 1 MiB", func(x string) string {
+		return setFriendlyName(x, strings.Repeat("A", 1<<20))
+	}},
+	{"many-services", "5000 entries in serviceList", func(x string) string {
+		var services strings.Builder
+		services.WriteString("<serviceList>")
+		for i := 0; i < 5000; i++ {
+			fmt.Fprintf(&services, "<service><serviceType>urn:schemas-upnp-org:service:Fuzz:%d</serviceType>"+
+				"<serviceId>urn:upnp-org:serviceId:Fuzz%d</serviceId><SCPDURL>/ssdp/service-desc.xml</SCPDURL>"+
+				"<controlURL>/ssdp/service-desc.xml</controlURL><eventSubURL>/ssdp/service-desc.xml</eventSubURL></service>", i, i)
+		}
+		services.WriteString("</serviceList>")
+		return insertInDevice(x, services.String())
+	}},
+	{"many-attributes", "10000 attributes on the root element", func(x string) string {
+		var attrs strings.Builder
+		for i := 0; i < 10000; i++ {
+			fmt.Fprintf(&attrs, ` a%d="%d"`, i, i)
+		}
+		return strings.Replace(x, "<root", "<root"+attrs.String(), 1)
+	}},
+	{"entity-bomb", "billion laughs entity expansion referenced in friendlyName", func(x string) string {
+		return setFriendlyName(withDoctype(x, laughs), "&lol9;")
+	}},
+	{"entity-bomb-unused", "billion laughs entities declared but never referenced", func(x string) string {
+		return withDoctype(x, laughs)
+	}},
+	{"undefined-entity", "reference to an undeclared entity", func(x string) string {
+		return setFriendlyName(x, "&fuzz;")
+	}},
+	{"invalid-utf8", "invalid UTF-8 bytes in friendlyName", func(x string) string {
+		return setFriendlyName(x, "Fuzz \xff\xfe\xc3\x28 \xed\xa0\x80")
+	}},
+	{"control-chars", "control characters forbidden by XML 1.0 in friendlyName", func(x string) string {
+		return setFriendlyName(x, "Fuzz\x00\x01\x08\x1b[31m\x7f")
+	}},
+	{"utf16-declared", "UTF-16 declared but UTF-8 sent", func(x string) string {
+		return withDeclaration(x, `<?xml version="1.0" encoding="UTF-16"?>`)
+	}},
+	{"unknown-encoding", "encoding no parser supports", func(x string) string {
+		return withDeclaration(x, `<?xml version="1.0" encoding="x-fuzz-8"?>`)
+	}},
+	{"bom-mismatch", "UTF-16 byte order mark before a UTF-8 document", func(x string) string {
+		return "\xff\xfe" + x
+	}},
+	{"xml-11", "XML 1.1 declaration with a C1 control in friendlyName", func(x string) string {
+		return setFriendlyName(withDeclaration(x, `<?xml version="1.1" encoding="UTF-8"?>`), "Fuzz\u0085")
+	}},
+	{"duplicate-device", "second device element with another UDN", func(x string) string {
+		return strings.Replace(x, "</root>", "<device><deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>"+
+			"<friendlyName>Fuzz</friendlyName><UDN>uuid:00000000-0000-0000-0000-000000000000</UDN></device></root>", 1)
+	}},
+	{"wrong-namespace", "root element in an unknown namespace", func(x string) string {
+		return strings.Replace(x, "urn:schemas-upnp-org:device-1-0", "urn:fuzz:device-9-9", 1)
+	}},
+	{"unclosed", "document cut off inside the device element", func(x string) string {
+		if i := strings.Index(x, "<UDN>"); i >= 0 {
+			return x[:i+5]
+		}
+		return x[:len(x)/2]
+	}},
+	{"mismatched-tags", "closing tags that do not match their opening tags", func(x string) string {
+		return strings.Replace(strings.Replace(x, "</device>", "</devise>", 1), "</root>", "</root></root>", 1)
+	}},
+	{"cdata-in-url", "URLBase wrapped in CDATA with an embedded NUL", func(x string) string {
+		return urlBase.ReplaceAllString(x, "<URLBase><![CDATA[$1\x00]]></URLBase>")
+	}},
+	{"empty", "empty body", func(x string) string {
+		return ""
+	}},
+}
+
+// FindXMLMutations returns the named descriptor mutations, or all of them
+// when names is empty
+func FindXMLMutations(names []string) ([]XMLMutation, error) {
+	if len(names) == 0 {
+		return XMLMutations, nil
+	}
+	var found []XMLMutation
+	for _, name := range names {
+		ok := false
+		for _, m := range XMLMutations {
+			if m.Name == name {
+				found = append(found, m)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor fuzz mutation: %s", name)
+		}
+	}
+	return found, nil
+}
+
+// insertInDevice adds content at the start of the device element, or before
+// the end of the document if there is none
+func insertInDevice(x, content string) string {
+	if strings.Contains(x, "<device>") {
+		return strings.Replace(x, "<device>", "<device>"+content, 1)
+	}
+	return x + content
+}
+
+// setFriendlyName replaces the friendlyName value, adding the element if the
+// template has none
+func setFriendlyName(x, name string) string {
+	if friendlyName.MatchString(x) {
+		return friendlyName.ReplaceAllLiteralString(x, "<friendlyName>"+name+"</friendlyName>")
+	}
+	return insertInDevice(x, "<friendlyName>"+name+"</friendlyName>")
+}
+
+// withDeclaration replaces the XML declaration, or adds one
+func withDeclaration(x, declaration string) string {
+	if xmlDecl.MatchString(x) {
+		return xmlDecl.ReplaceAllLiteralString(x, declaration)
+	}
+	return declaration + "\n" + x
+}
+
+// withDoctype inserts a document type declaration after the XML declaration
+func withDoctype(x, doctype string) string {
+	if loc := xmlDecl.FindStringIndex(x); loc != nil {
+		return x[:loc[1]] + "\n" + doctype + x[loc[1]:]
+	}
+	return doctype + x
+}
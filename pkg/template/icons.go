@@ -0,0 +1,66 @@
+package template
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+)
+
+// DefaultFavicon is the asset served as /favicon.ico unless the manifest
+// names another
+const DefaultFavicon = "favicon.ico"
+
+// Icon is an entry of the iconList of a device descriptor. Windows fetches
+// these to draw the device in Explorer's network view.
+type Icon struct {
+	MIMEType string `xml:"mimetype"`
+	Width    int    `xml:"width"`
+	Height   int    `xml:"height"`
+	Depth    int    `xml:"depth"`
+	URL      string `xml:"url"`
+}
+
+// Path returns the URL path the icon is fetched from
+func (i Icon) Path() string {
+	u, err := url.Parse(strings.TrimSpace(i.URL))
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return "/" + u.Path
+	}
+	return u.Path
+}
+
+// AssetPath returns the asset the icon is served from: its path without
+// the leading / or /assets/
+func (i Icon) AssetPath() string {
+	p := i.Path()
+	if rest, ok := strings.CutPrefix(p, "/assets/"); ok {
+		return rest
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// Icons returns the icons the device descriptor lists, in order. A
+// descriptor that cannot be built or parsed lists none.
+func (m *Manager) Icons() []Icon {
+	content, err := m.BuildDeviceXML()
+	if err != nil {
+		return nil
+	}
+	return parseIcons(content)
+}
+
+// parseIcons returns the icons listed in a device descriptor
+func parseIcons(content string) []Icon {
+	var root struct {
+		Icons []Icon `xml:"device>iconList>icon"`
+	}
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	if err := decoder.Decode(&root); err != nil {
+		return nil
+	}
+	return root.Icons
+}
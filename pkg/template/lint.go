@@ -0,0 +1,287 @@
+package template
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Severity ranks lint findings
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a single problem found by Lint
+type Issue struct {
+	File     string
+	Severity Severity
+	Message  string
+}
+
+// String formats the issue for console output
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.File, i.Severity, i.Message)
+}
+
+// lintData is the dummy data templates are rendered with while linting
+var lintData = TemplateData{
+	LocalIP:     "192.0.2.1",
+	LocalPort:   8888,
+	SMBServer:   "192.0.2.2",
+	SessionUSN:  "uuid:00000000-0000-0000-0000-000000000000",
+	RedirectURL: "https://example.com/",
+	FTPPort:     2121,
+	DNSDomain:   "x.example.com",
+	XXEFile:     "file:///etc/passwd",
+	Victim: Victim{
+		IP:        "192.0.2.10",
+		Hostname:  "ws01.example.com",
+		Locale:    "en-US",
+		Language:  "en",
+		OS:        "Windows",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+	},
+	DeviceCode: DeviceCode{
+		UserCode:        "ABCD1234",
+		VerificationURI: "https://microsoft.com/devicelogin",
+		Message:         "To sign in, use a web browser to open the page https://microsoft.com/devicelogin and enter the code ABCD1234 to authenticate.",
+	},
+}
+
+var (
+	// variableRef matches an escaped "$$" or a $name variable reference
+	variableRef = regexp.MustCompile(`\$\$|\$[A-Za-z_][A-Za-z0-9_]*`)
+
+	// assetRef matches links to files under /assets/
+	assetRef = regexp.MustCompile(`/assets/[^"'\s<>()?#]+`)
+)
+
+// Lint renders every file of the template in templateDir with dummy data and
+// reports unknown variables, malformed descriptors, links to missing assets
+// and forms that do not POST to the capture endpoint
+func Lint(fsys fs.FS, templateDir string) ([]Issue, error) {
+	if err := ValidateTemplateDir(fsys, templateDir); err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(fsys, templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory %s: %w", templateDir, err)
+	}
+
+	assets, err := AssetsFS(fsys, templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open assets: %w", err)
+	}
+
+	m := NewManager(fsys, templateDir, lintData)
+	var issues []Issue
+
+	manifest, err := LoadManifest(fsys, templateDir)
+	if err != nil {
+		issues = append(issues, Issue{ManifestFile, SeverityError, err.Error()})
+	} else {
+		for route, file := range manifest.Routes {
+			if _, err := fs.Stat(fsys, path.Join(templateDir, file)); err != nil {
+				issues = append(issues, Issue{ManifestFile, SeverityError,
+					fmt.Sprintf("route %s points at missing file %s", route, file)})
+			}
+		}
+		if manifest.Favicon != DefaultFavicon {
+			if _, err := fs.Stat(assets, manifest.Favicon); err != nil {
+				issues = append(issues, Issue{ManifestFile, SeverityWarning, "missing favicon " + manifest.Favicon})
+			}
+		}
+		for _, variant := range manifest.Variants {
+			if _, err := fs.Stat(fsys, path.Join(templateDir, variant.File)); err != nil {
+				issues = append(issues, Issue{ManifestFile, SeverityError,
+					fmt.Sprintf("variant points at missing file %s", variant.File)})
+			}
+		}
+		for _, file := range manifest.ABTest {
+			if _, err := fs.Stat(fsys, path.Join(templateDir, file)); err != nil {
+				issues = append(issues, Issue{ManifestFile, SeverityError,
+					fmt.Sprintf("ab_test points at missing file %s", file)})
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == ManifestFile {
+			continue
+		}
+		if name == SSDPResponseFile {
+			issues = append(issues, m.lintSSDPResponse()...)
+			continue
+		}
+
+		raw, err := fs.ReadFile(fsys, path.Join(templateDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		issues = append(issues, lintVariables(name, string(raw))...)
+
+		rendered, err := m.processTemplate(name)
+		if err != nil {
+			issues = append(issues, Issue{name, SeverityError, err.Error()})
+			continue
+		}
+
+		if name == "device.xml" || name == "service.xml" {
+			if err := checkXML(rendered); err != nil {
+				issues = append(issues, Issue{name, SeverityError, "malformed XML: " + err.Error()})
+			}
+		}
+		if name == "device.xml" {
+			issues = append(issues, lintIcons(name, rendered, assets)...)
+		}
+
+		issues = append(issues, lintAssets(name, rendered, assets)...)
+
+		if strings.HasSuffix(name, ".html") {
+			issues = append(issues, lintForms(name, rendered)...)
+		}
+	}
+
+	return issues, nil
+}
+
+// lintVariables reports $name references that are not template variables
+func lintVariables(file, content string) []Issue {
+	var issues []Issue
+	seen := make(map[string]bool)
+
+	for _, ref := range variableRef.FindAllString(content, -1) {
+		if ref == "$$" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		if _, ok := templateVars[ref]; !ok {
+			issues = append(issues, Issue{file, SeverityWarning,
+				fmt.Sprintf("unknown variable %s (escape a literal dollar sign as $$)", ref)})
+		}
+	}
+	return issues
+}
+
+// checkXML reports the first well-formedness error in content. Documents
+// with a DOCTYPE, as XXE templates have, may reference entities declared in
+// an external DTD, so unknown entities are let through for them.
+func checkXML(content string) error {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	if strings.Contains(content, "<!DOCTYPE") {
+		decoder.Strict = false
+	}
+	for {
+		if _, err := decoder.Token(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// lintAssets reports links to /assets/ files that do not exist
+func lintAssets(file, content string, assets fs.FS) []Issue {
+	var issues []Issue
+	seen := make(map[string]bool)
+
+	for _, ref := range assetRef.FindAllString(content, -1) {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		assetPath := strings.TrimPrefix(ref, "/assets/")
+		if unescaped, err := url.PathUnescape(assetPath); err == nil {
+			assetPath = unescaped
+		}
+		if _, err := fs.Stat(assets, assetPath); err != nil {
+			issues = append(issues, Issue{file, SeverityWarning, "missing asset " + ref})
+		}
+	}
+	return issues
+}
+
+// lintIcons reports icons in the device descriptor's iconList that are
+// missing or whose declared type or size does not match the image
+func lintIcons(file, content string, assets fs.FS) []Issue {
+	var issues []Issue
+	for _, icon := range parseIcons(content) {
+		if icon.Path() == "" {
+			issues = append(issues, Issue{file, SeverityError, "icon without a url"})
+			continue
+		}
+		if icon.MIMEType == "" {
+			issues = append(issues, Issue{file, SeverityWarning, "icon " + icon.URL + " has no mimetype"})
+		}
+		// Missing /assets/ files are already reported by lintAssets
+		data, err := fs.ReadFile(assets, icon.AssetPath())
+		if err != nil {
+			if !strings.HasPrefix(icon.Path(), "/assets/") {
+				issues = append(issues, Issue{file, SeverityWarning, "missing icon " + icon.URL})
+			}
+			continue
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if icon.MIMEType != "" && icon.MIMEType != "image/"+format {
+			issues = append(issues, Issue{file, SeverityWarning,
+				fmt.Sprintf("icon %s is declared %s but is %s", icon.URL, icon.MIMEType, format)})
+		}
+		if config.Width != icon.Width || config.Height != icon.Height {
+			issues = append(issues, Issue{file, SeverityWarning,
+				fmt.Sprintf("icon %s is declared %dx%d but is %dx%d", icon.URL, icon.Width, icon.Height, config.Width, config.Height)})
+		}
+	}
+	return issues
+}
+
+// lintForms reports forms that would not reach the capture endpoint
+func lintForms(file, content string) []Issue {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return []Issue{{file, SeverityError, "failed to parse HTML: " + err.Error()}}
+	}
+
+	var issues []Issue
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "form" {
+			action := attr(n, "action")
+			if u, err := url.Parse(action); err != nil || u.Path != CaptureEndpoint {
+				issues = append(issues, Issue{file, SeverityWarning,
+					fmt.Sprintf("form action %q does not point at %s", action, CaptureEndpoint)})
+			}
+			if !strings.EqualFold(attr(n, "method"), "post") {
+				issues = append(issues, Issue{file, SeverityWarning, "form method is not POST"})
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return issues
+}
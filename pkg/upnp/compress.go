@@ -0,0 +1,161 @@
+package upnp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"goSSDPkit/pkg/template"
+)
+
+// minCompressSize is the smallest response worth compressing; below it the
+// gzip header and a round of inflating cost more than they save
+const minCompressSize = 1024
+
+// maxCompressedCache caps the bytes of compressed assets kept in memory
+const maxCompressedCache = 32 << 20
+
+// Encodings, in the order they are preferred
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// precompressed are the suffixes of asset files holding a compressed copy
+// of the asset without them, as written by brotli and gzip -k
+var precompressed = map[string]string{
+	encodingBrotli: ".br",
+	encodingGzip:   ".gz",
+}
+
+// compressible reports whether responses of contentType shrink when
+// compressed: text, scripts, JSON and XML, including SVG, and WebAssembly
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json",
+		mediaType == "application/xml", mediaType == "image/svg+xml",
+		mediaType == "application/wasm":
+		return true
+	}
+	return false
+}
+
+// accepts reports whether r's Accept-Encoding allows encoding, by name or
+// as *, with a non-zero weight
+func accepts(r *http.Request, encoding string) bool {
+	allowed := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		// An explicit entry beats the wildcard, whatever the order
+		if name == encoding {
+			return q > 0
+		}
+		allowed = q > 0
+	}
+	return allowed
+}
+
+// compresses reports whether responses may be compressed at all, saying
+// in w that they depend on Accept-Encoding. Templates with an HTTP profile
+// are not, as the embedded servers they imitate never do.
+func (s *Server) compresses(w http.ResponseWriter) bool {
+	if s.current.Load().profile != nil {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	return true
+}
+
+// writePage writes a page built for r, gzipped when the client takes it
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, contentType string, page []byte) {
+	w.Header().Set("Content-Type", contentType)
+	if s.compresses(w) && len(page) >= minCompressSize && accepts(r, encodingGzip) {
+		w.Header().Set("Content-Encoding", encodingGzip)
+		page = gzipBytes(page)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(page)
+}
+
+// gzipBytes compresses data at the best ratio
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// compressedAssets keeps the gzipped form of assets, so each is compressed
+// once per template rather than per request
+type compressedAssets struct {
+	mu      sync.Mutex
+	entries map[compressedKey][]byte
+	size    int
+}
+
+// compressedKey names an asset of a template; templates are told apart by
+// their manager, which a reload replaces
+type compressedKey struct {
+	manager *template.Manager
+	path    string
+}
+
+// get returns the gzipped form of content, compressing it the first time
+func (c *compressedAssets) get(key compressedKey, content []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if data, ok := c.entries[key]; ok {
+		return data
+	}
+	data := gzipBytes(content)
+	if c.entries == nil || c.size+len(data) > maxCompressedCache {
+		c.entries, c.size = make(map[compressedKey][]byte), 0
+	}
+	c.entries[key] = data
+	c.size += len(data)
+	return data
+}
+
+// encodeAsset returns the body of an asset for r: a precompressed copy
+// shipped beside it when the client takes one, content gzipped on the fly,
+// or content as is. Content-Encoding is set to match.
+func (s *Server) encodeAsset(w http.ResponseWriter, r *http.Request, assets fs.FS, assetPath, contentType string, content []byte) []byte {
+	if !s.compresses(w) {
+		return content
+	}
+	for _, encoding := range []string{encodingBrotli, encodingGzip} {
+		if !accepts(r, encoding) {
+			continue
+		}
+		if data, err := fs.ReadFile(assets, assetPath+precompressed[encoding]); err == nil {
+			w.Header().Set("Content-Encoding", encoding)
+			return data
+		}
+	}
+	if compressible(contentType) && len(content) >= minCompressSize && accepts(r, encodingGzip) {
+		key := compressedKey{manager: s.current.Load().templateManager, path: assetPath}
+		w.Header().Set("Content-Encoding", encodingGzip)
+		return s.compressed.get(key, content)
+	}
+	return content
+}
@@ -0,0 +1,176 @@
+package upnp
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Defaults for how many connections the server keeps open, in all and from
+// one host, and how many requests it handles at once
+const (
+	DefaultMaxConns        = 1024
+	DefaultMaxConnsPerHost = 64
+	DefaultMaxHandlers     = 256
+)
+
+// WithMaxConns keeps at most n connections open, across the addresses the
+// server listens on. Further connections wait in the listen backlog until
+// one closes. 0 means no limit.
+func WithMaxConns(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// WithMaxConnsPerHost closes connections from a host that already has n
+// open, so one client cannot use them all. Hosts are told apart by the
+// connection's source address. 0 means no limit.
+func WithMaxConnsPerHost(n int) Option {
+	return func(s *Server) {
+		s.maxConnsPerHost = n
+	}
+}
+
+// WithMaxHandlers handles at most n requests at once, answering the rest
+// 503 Service Unavailable. 0 means no limit.
+func WithMaxHandlers(n int) Option {
+	return func(s *Server) {
+		s.maxHandlers = n
+	}
+}
+
+// connLimits counts the connections open, in all and per host, and the
+// requests being handled
+type connLimits struct {
+	slots    chan struct{} // one per open connection, nil for no limit
+	handlers chan struct{} // one per request being handled, nil for no limit
+	mu       sync.Mutex
+	perHost  map[string]int
+}
+
+// newConnLimits creates the limits of a server, 0 meaning no limit
+func newConnLimits(maxConns, maxHandlers int) *connLimits {
+	c := &connLimits{perHost: make(map[string]int)}
+	if maxConns > 0 {
+		c.slots = make(chan struct{}, maxConns)
+	}
+	if maxHandlers > 0 {
+		c.handlers = make(chan struct{}, maxHandlers)
+	}
+	return c
+}
+
+// limitListener accepts connections while the server is under its limits
+type limitListener struct {
+	net.Listener
+	s     *Server
+	done  chan struct{}
+	close sync.Once
+}
+
+// limitConns wraps ln so the server's connection limits apply to it
+func (s *Server) limitConns(ln net.Listener) net.Listener {
+	if s.conns.slots == nil && s.maxConnsPerHost <= 0 {
+		return ln
+	}
+	return &limitListener{Listener: ln, s: s, done: make(chan struct{})}
+}
+
+// Accept waits for a free slot, then for a connection from a host under
+// its own limit
+func (l *limitListener) Accept() (net.Conn, error) {
+	limits := l.s.conns
+	for {
+		if limits.slots != nil {
+			select {
+			case limits.slots <- struct{}{}:
+			case <-l.done:
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if max := l.s.maxConnsPerHost; max > 0 {
+			limits.mu.Lock()
+			over := limits.perHost[host] >= max
+			if !over {
+				limits.perHost[host]++
+			}
+			limits.mu.Unlock()
+			if over {
+				conn.Close()
+				l.release()
+				if l.s.limiter.warn(host, "conns", time.Now()) {
+					l.s.notice("%sRefusing connections from %s, which has %d open", ssdp.WarnBox, host, max)
+				}
+				continue
+			}
+		}
+		return &limitConn{Conn: conn, l: l, host: host}, nil
+	}
+}
+
+// Close stops accepting, including while waiting for a slot
+func (l *limitListener) Close() error {
+	l.close.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// release frees a connection slot
+func (l *limitListener) release() {
+	if l.s.conns.slots != nil {
+		<-l.s.conns.slots
+	}
+}
+
+// limitConn gives its slot back when closed
+type limitConn struct {
+	net.Conn
+	l     *limitListener
+	host  string
+	close sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.close.Do(func() {
+		if c.l.s.maxConnsPerHost > 0 {
+			limits := c.l.s.conns
+			limits.mu.Lock()
+			if limits.perHost[c.host]--; limits.perHost[c.host] <= 0 {
+				delete(limits.perHost, c.host)
+			}
+			limits.mu.Unlock()
+		}
+		c.l.release()
+	})
+	return err
+}
+
+// acquireHandler takes a handler slot for a request, answering 503 and
+// returning false when none is free. Call the returned function when done.
+func (s *Server) acquireHandler(w http.ResponseWriter) (func(), bool) {
+	if s.conns.handlers == nil {
+		return func() {}, true
+	}
+	select {
+	case s.conns.handlers <- struct{}{}:
+		return func() { <-s.conns.handlers }, true
+	default:
+		if s.limiter.warn("", "handlers", time.Now()) {
+			s.notice("%sAll %d request handlers busy, answering 503", ssdp.WarnBox, cap(s.conns.handlers))
+		}
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
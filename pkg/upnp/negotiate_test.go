@@ -0,0 +1,177 @@
+package upnp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/template"
+)
+
+// capturedCreds collects the credentials a server captures
+type capturedCreds struct {
+	events.Nop
+	mu    sync.Mutex
+	creds []events.Credentials
+}
+
+func (c *capturedCreds) OnCredentials(e events.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds = append(c.creds, e)
+}
+
+func (c *capturedCreds) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.creds)
+}
+
+// testNegotiateServer returns a server asking for Negotiate and NTLM
+// authentication on a minimal template, and what it captures
+func testNegotiateServer(t *testing.T) (*Server, *capturedCreds) {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"test/device.xml":   {Data: []byte("<root/>")},
+		"test/present.html": {Data: []byte("<html>welcome</html>")},
+	}
+	manager := template.NewManager(fsys, "test", template.TemplateData{LocalIP: "192.0.2.1", LocalPort: 8080})
+	captured := &capturedCreds{}
+	s, err := NewServer(manager, Config{LocalIP: "192.0.2.1", LocalPort: 8080, Negotiate: true},
+		WithLogger(logging.NewConsoleLogger(io.Discard)), WithEvents(captured))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, captured
+}
+
+// authorize requests the phishing page from addr with an Authorization
+// header of scheme and token
+func authorize(s *Server, addr, scheme string, token []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/present.html", nil)
+	r.RemoteAddr = addr
+	r.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(token))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// ntlmNegotiate is an NTLM negotiate message with no fields set
+var ntlmNegotiate = append([]byte("NTLMSSP\x00\x01\x00\x00\x00"), make([]byte, 20)...)
+
+// ntlmAuthenticate builds an NTLMv2 authenticate message from alice in
+// CORP, with Unicode strings
+func ntlmAuthenticate() []byte {
+	utf16 := func(s string) []byte {
+		var b []byte
+		for _, c := range s {
+			b = binary.LittleEndian.AppendUint16(b, uint16(c))
+		}
+		return b
+	}
+	msg := make([]byte, 64)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	binary.LittleEndian.PutUint32(msg[60:], 1) // Unicode
+	nt := make([]byte, 40)
+	for i, payload := range [][]byte{make([]byte, 24), nt, utf16("CORP"), utf16("alice"), utf16("WS1")} {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(len(msg)))
+		msg = append(msg, payload...)
+	}
+	return msg
+}
+
+// withNTLMField returns a copy of msg with the length and offset pair at
+// offset replaced
+func withNTLMField(msg []byte, offset int, length uint16, start uint32) []byte {
+	msg = append([]byte(nil), msg...)
+	binary.LittleEndian.PutUint16(msg[offset:], length)
+	binary.LittleEndian.PutUint16(msg[offset+2:], length)
+	binary.LittleEndian.PutUint32(msg[offset+4:], start)
+	return msg
+}
+
+func TestNegotiateNTLM(t *testing.T) {
+	s, captured := testNegotiateServer(t)
+	const addr = "192.0.2.10:50000"
+
+	w := authorize(s, addr, "NTLM", ntlmNegotiate)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("negotiate: status %d, want 401", w.Code)
+	}
+	header, ok := strings.CutPrefix(w.Header().Get("WWW-Authenticate"), "NTLM ")
+	if !ok {
+		t.Fatalf("negotiate: no NTLM challenge in %q", w.Header().Get("WWW-Authenticate"))
+	}
+	challenge, err := base64.StdEncoding.DecodeString(header)
+	if err != nil || len(challenge) < 32 {
+		t.Fatalf("negotiate: challenge %q: %v", header, err)
+	}
+
+	w = authorize(s, addr, "NTLM", ntlmAuthenticate())
+	if w.Code != http.StatusOK {
+		t.Fatalf("authenticate: status %d, want 200", w.Code)
+	}
+	if captured.count() != 1 {
+		t.Fatalf("captured %d credentials, want 1", captured.count())
+	}
+	got := captured.creds[0]
+	if got.Source != events.SourceNTLM || got.Username != `CORP\alice` || !strings.Contains(got.Password, ":"+hex.EncodeToString(challenge[24:32])+":") {
+		t.Errorf("captured %+v", got)
+	}
+}
+
+func TestNegotiateHostile(t *testing.T) {
+	valid := ntlmAuthenticate()
+	end := uint32(len(valid))
+	tests := []struct {
+		name   string
+		scheme string
+		token  []byte
+	}{
+		{"empty", "NTLM", nil},
+		{"signature only", "NTLM", []byte("NTLMSSP\x00")},
+		{"header cut short", "NTLM", valid[:40]},
+		{"payload cut short", "NTLM", valid[:len(valid)-1]},
+		{"NT length past the end", "NTLM", withNTLMField(valid, 20, 0xffff, 64)},
+		{"NT offset past the end", "NTLM", withNTLMField(valid, 20, 40, end)},
+		{"NT offset at 4 GiB", "NTLM", withNTLMField(valid, 20, 40, 0xffffffff)},
+		{"NT offset wrapping to negative", "NTLM", withNTLMField(valid, 20, 0xffff, 0x7fffffff)},
+		{"user offset past the end", "NTLM", withNTLMField(valid, 36, 10, 0xfffffff0)},
+		{"domain length past the end", "NTLM", withNTLMField(valid, 28, 0xffff, 0)},
+		{"workstation past the end", "NTLM", withNTLMField(valid, 44, 6, end)},
+		{"SPNEGO length past the end", "Negotiate", []byte{0x60, 0x84, 0xff, 0xff, 0xff, 0xff}},
+		{"SPNEGO response length past the end", "Negotiate", []byte{0xa1, 0x83, 0xff, 0xff, 0xff, 0x30}},
+		{"Kerberos AP-REQ cut short", "Negotiate", []byte{0x60, 0x0f, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x12, 0x01, 0x02, 0x02, 0x01, 0x00, 0x6e, 0x7f}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, captured := testNegotiateServer(t)
+			addr := fmt.Sprintf("192.0.2.10:%d", 50000+i)
+			// Challenge the connection first, so NTLM responses are parsed
+			if w := authorize(s, addr, "NTLM", ntlmNegotiate); w.Code != http.StatusUnauthorized {
+				t.Fatalf("negotiate: status %d, want 401", w.Code)
+			}
+			w := authorize(s, addr, tt.scheme, tt.token)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want 401", w.Code)
+			}
+			if captured.count() != 0 {
+				t.Errorf("captured %+v", captured.creds)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile is the HTTP personality of an embedded device's web server: the
+// status line, Server header, Date format, header spelling and order it
+// answers with. Responses are written straight to the connection, so none
+// of net/http's own headers or ordering give the server away, and the
+// connection is closed after each one, as most embedded servers do.
+type Profile struct {
+	Name  string
+	Proto string // HTTP version of the status line
+	// Server header, none when empty
+	Server string
+	// Layout of the Date header, none when empty
+	DateLayout string
+	// Headers written first, in this order and spelt this way; the rest
+	// follow in alphabetical order
+	Order []string
+	// Headers every response carries unless the handler set them
+	Extra [][2]string
+}
+
+// rfc1123GMT is the Date layout nearly every server uses
+const rfc1123GMT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Profiles are the built-in personalities, by the name templates give in
+// their manifest's http_profile
+var Profiles = map[string]*Profile{
+	"libupnp": {
+		Name:       "libupnp",
+		Proto:      "HTTP/1.1",
+		Server:     "Linux/3.10.0, UPnP/1.0, Portable SDK for UPnP devices/1.6.19",
+		DateLayout: rfc1123GMT,
+		Order:      []string{"CONTENT-LENGTH", "CONTENT-TYPE", "DATE", "LAST-MODIFIED", "SERVER", "X-User-Agent", "CONNECTION", "LOCATION"},
+		Extra:      [][2]string{{"X-User-Agent", "redsonic"}},
+	},
+	"rompager": {
+		Name:       "rompager",
+		Proto:      "HTTP/1.1",
+		Server:     "Allegro-Software-RomPager/4.07 UPnP/1.0",
+		DateLayout: rfc1123GMT,
+		Order:      []string{"Content-Type", "Date", "Server", "Content-Length", "Location", "Connection"},
+	},
+	"goahead": {
+		Name:       "goahead",
+		Proto:      "HTTP/1.0",
+		Server:     "GoAhead-Webs",
+		DateLayout: "Mon Jan 2 15:04:05 2006",
+		Order:      []string{"Server", "Date", "Pragma", "Cache-Control", "Content-Type", "Content-Length", "Location", "Connection"},
+		Extra:      [][2]string{{"Pragma", "no-cache"}, {"Cache-Control", "no-cache"}},
+	},
+	"boa": {
+		Name:       "boa",
+		Proto:      "HTTP/1.0",
+		Server:     "Boa/0.94.14rc21",
+		DateLayout: rfc1123GMT,
+		Order:      []string{"Date", "Server", "Accept-Ranges", "Connection", "Content-Length", "Last-Modified", "Location", "Content-Type"},
+		Extra:      [][2]string{{"Accept-Ranges", "bytes"}},
+	},
+	"mini_httpd": {
+		Name:       "mini_httpd",
+		Proto:      "HTTP/1.1",
+		Server:     "mini_httpd/1.19 19dec2003",
+		DateLayout: rfc1123GMT,
+		Order:      []string{"Server", "Date", "Cache-Control", "Content-Type", "Content-Length", "Location", "Connection"},
+		Extra:      [][2]string{{"Cache-Control", "no-cache,no-store"}},
+	},
+	"windows-upnp": {
+		Name:       "windows-upnp",
+		Proto:      "HTTP/1.1",
+		Server:     "Microsoft-Windows/10.0 UPnP/1.0 UPnP-Device-Host/1.0 Microsoft-HTTPAPI/2.0",
+		DateLayout: rfc1123GMT,
+		Order:      []string{"Content-Length", "Content-Type", "Location", "Server", "Date", "Connection"},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProfile returns the profile called name, nil for net/http's own
+func lookupProfile(name string) (*Profile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown http_profile %q, use one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// profileWriter buffers a response and writes it in a profile's shape once
+// the handler is done. A handler that flushes, such as a tarpit or a
+// proxied stream, gets its headers sent at once and the rest of the body
+// as it comes, delimited by the connection closing.
+type profileWriter struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	profile *Profile
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	written int
+
+	conn      net.Conn
+	out       *bufio.ReadWriter
+	streaming bool
+	// The connection could not be taken over, so the response goes out
+	// through net/http after all
+	passthrough bool
+}
+
+// newProfileWriter answers r through w in the shape of profile
+func newProfileWriter(w http.ResponseWriter, r *http.Request, profile *Profile) *profileWriter {
+	return &profileWriter{w: w, r: r, profile: profile, header: make(http.Header)}
+}
+
+// Header implements http.ResponseWriter
+func (p *profileWriter) Header() http.Header {
+	return p.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (p *profileWriter) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (p *profileWriter) Write(data []byte) (int, error) {
+	p.WriteHeader(http.StatusOK)
+	switch {
+	case p.passthrough:
+		return p.w.Write(data)
+	case !p.streaming:
+		return p.body.Write(data)
+	case !bodyAllowed(p.r, p.status):
+		return len(data), nil
+	}
+	n, err := p.out.Write(data)
+	p.written += n
+	return n, err
+}
+
+// Flush implements http.Flusher, switching to streaming the body
+func (p *profileWriter) Flush() {
+	p.WriteHeader(http.StatusOK)
+	if !p.streaming && !p.passthrough {
+		p.streaming = p.hijack()
+		if p.streaming {
+			p.writeHead(-1)
+			if bodyAllowed(p.r, p.status) {
+				n, _ := p.out.Write(p.body.Bytes())
+				p.written += n
+			}
+		}
+		p.body.Reset()
+	}
+	if p.passthrough {
+		http.NewResponseController(p.w).Flush()
+		return
+	}
+	p.out.Flush()
+}
+
+// SetWriteDeadline lets http.ResponseController move the write deadline,
+// of the connection once it is taken over
+func (p *profileWriter) SetWriteDeadline(deadline time.Time) error {
+	if p.conn != nil {
+		return p.conn.SetWriteDeadline(deadline)
+	}
+	return http.NewResponseController(p.w).SetWriteDeadline(deadline)
+}
+
+// finish sends what the handler wrote, if it has not been streamed, and
+// closes the connection
+func (p *profileWriter) finish() {
+	p.WriteHeader(http.StatusOK)
+	if !p.streaming && !p.passthrough && p.hijack() {
+		length := p.body.Len()
+		switch {
+		case p.r.Method == http.MethodHead && length == 0:
+			// Say how long the body would have been, when the handler did
+			length, _ = strconv.Atoi(p.header.Get("Content-Length"))
+		case p.r.Method == http.MethodHead:
+		case !bodyAllowed(p.r, p.status):
+			length = -1
+		}
+		p.writeHead(length)
+		if bodyAllowed(p.r, p.status) {
+			n, _ := p.out.Write(p.body.Bytes())
+			p.written += n
+		}
+	}
+	if p.passthrough {
+		return
+	}
+	p.out.Flush()
+	p.conn.Close()
+	if rec, ok := p.w.(*accessRecorder); ok {
+		rec.status, rec.bytes = p.status, p.written
+	}
+}
+
+// hijack takes the connection over from net/http, reporting whether it
+// could. If not, what was written so far is sent through net/http.
+func (p *profileWriter) hijack() bool {
+	conn, out, err := http.NewResponseController(p.w).Hijack()
+	if err != nil {
+		p.passthrough = true
+		for key, values := range p.header {
+			p.w.Header()[key] = values
+		}
+		p.w.WriteHeader(p.status)
+		p.w.Write(p.body.Bytes())
+		return false
+	}
+	p.conn, p.out = conn, out
+	return true
+}
+
+// writeHead writes the status line and headers, with a Content-Length
+// unless length is negative
+func (p *profileWriter) writeHead(length int) {
+	header := p.header.Clone()
+	// net/http's own, which no embedded server sends
+	header.Del("X-Content-Type-Options")
+	header.Del("Connection")
+	header.Del("Content-Length")
+	header.Del("Transfer-Encoding")
+	if p.profile.Server != "" && header.Get("Server") == "" {
+		header.Set("Server", p.profile.Server)
+	}
+	if p.profile.DateLayout != "" && header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(p.profile.DateLayout))
+	}
+	for _, extra := range p.profile.Extra {
+		if header.Get(extra[0]) == "" {
+			header.Set(extra[0], extra[1])
+		}
+	}
+	if header.Get("Content-Type") == "" && p.body.Len() > 0 && bodyAllowed(p.r, p.status) {
+		header.Set("Content-Type", http.DetectContentType(p.body.Bytes()))
+	}
+	if length >= 0 {
+		header.Set("Content-Length", strconv.Itoa(length))
+	}
+	header.Set("Connection", "close")
+
+	fmt.Fprintf(p.out, "%s %d %s\r\n", p.profile.Proto, p.status, http.StatusText(p.status))
+	for _, name := range p.profile.Order {
+		key := http.CanonicalHeaderKey(name)
+		for _, value := range header[key] {
+			fmt.Fprintf(p.out, "%s: %s\r\n", name, headerValue.Replace(value))
+		}
+		delete(header, key)
+	}
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(p.out, "%s: %s\r\n", key, headerValue.Replace(value))
+		}
+	}
+	p.out.WriteString("\r\n")
+}
+
+// headerValue keeps header values on one line, as net/http does
+var headerValue = strings.NewReplacer("\r", " ", "\n", " ")
+
+// bodyAllowed reports whether a response to r with status carries a body
+func bodyAllowed(r *http.Request, status int) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,212 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// ProxyPrefix is the path a proxied device's URLs are served under; what
+// follows it is passed on to the device
+const ProxyPrefix = "/ssdp/proxy"
+
+// Descriptor fields a proxy can point at itself
+const (
+	FieldPresentation = "presentationURL"
+	FieldControl      = "controlURL"
+	FieldEvents       = "eventSubURL"
+	FieldSCPD         = "SCPDURL"
+	FieldIcons        = "icons"
+)
+
+// ProxyFields are the descriptor fields a proxy rewrites unless told which
+var ProxyFields = []string{FieldPresentation, FieldControl, FieldEvents, FieldSCPD, FieldIcons}
+
+// proxyTimeout bounds each request to the proxied device
+const proxyTimeout = 10 * time.Second
+
+// maxProxiedDescriptor is the largest device descriptor a proxy rewrites
+const maxProxiedDescriptor = 1 << 20
+
+// descriptorURL matches the URL fields of a device descriptor, icons being
+// the only <url> elements in one
+var descriptorURL = regexp.MustCompile(`<(presentationURL|controlURL|eventSubURL|SCPDURL|url)>([^<]*)</(?:presentationURL|controlURL|eventSubURL|SCPDURL|url)>`)
+
+// urlBase matches the URLBase element of a device descriptor
+var urlBase = regexp.MustCompile(`[ \t]*<URLBase>([^<]*)</URLBase>[ \t]*\r?\n?`)
+
+// Proxy serves a real device's descriptor as the spoofed device's own, with
+// the chosen fields pointed back at the server, which passes what control
+// points send there on to the device. Control points keep working with the
+// device while every call goes through the server and is logged.
+type Proxy struct {
+	// Device descriptor URL of the real device. Empty disables the proxy.
+	Location string
+	// Descriptor fields pointed at the server; the others point straight
+	// at the device. All of ProxyFields when empty.
+	Rewrite []string
+	// Rules modifying the SOAP calls passed through
+	Tamper []Tamper
+}
+
+// Validate checks that the proxy can be used
+func (p Proxy) Validate() error {
+	_, err := newDeviceProxy(p)
+	return err
+}
+
+// deviceProxy is a Proxy ready to serve
+type deviceProxy struct {
+	location *url.URL
+	rewrite  map[string]bool
+	tamper   []tamper
+	client   *http.Client
+	reverse  *httputil.ReverseProxy
+}
+
+// newDeviceProxy prepares p, returning nil when it is disabled
+func newDeviceProxy(p Proxy) (*deviceProxy, error) {
+	if p.Location == "" {
+		return nil, nil
+	}
+	location, err := url.Parse(p.Location)
+	if err != nil || (location.Scheme != "http" && location.Scheme != "https") || location.Host == "" {
+		return nil, fmt.Errorf("proxy location %q must be an http(s) URL", p.Location)
+	}
+
+	fields := p.Rewrite
+	if len(fields) == 0 {
+		fields = ProxyFields
+	}
+	rewrite := make(map[string]bool)
+	for _, field := range fields {
+		if !slices.Contains(ProxyFields, field) {
+			return nil, fmt.Errorf("unknown proxy field %q, use one of %s", field, strings.Join(ProxyFields, ", "))
+		}
+		rewrite[field] = true
+	}
+	tampers, err := compileTampers(p.Tamper)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &url.URL{Scheme: location.Scheme, Host: location.Host}
+	return &deviceProxy{
+		location: location,
+		rewrite:  rewrite,
+		tamper:   tampers,
+		client:   &http.Client{Timeout: proxyTimeout},
+		reverse: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(device)
+				r.Out.URL.Path = strings.TrimPrefix(r.In.URL.Path, ProxyPrefix)
+				r.Out.URL.RawPath = strings.TrimPrefix(r.In.URL.RawPath, ProxyPrefix)
+			},
+			Transport: &http.Transport{ResponseHeaderTimeout: proxyTimeout},
+		},
+	}, nil
+}
+
+// descriptor fetches the device's descriptor and rewrites it
+func (p *deviceProxy) descriptor(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.location.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", p.location, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProxiedDescriptor))
+	if err != nil {
+		return "", err
+	}
+	return p.rewriteDescriptor(string(data)), nil
+}
+
+// rewriteDescriptor points the chosen URL fields of xml at the proxy and
+// makes the others absolute, so they still reach the device once URLBase
+// is gone and relative URLs resolve against the server. Fields on another
+// host than the descriptor cannot be proxied and are left alone.
+func (p *deviceProxy) rewriteDescriptor(xml string) string {
+	base := p.location
+	if m := urlBase.FindStringSubmatch(xml); m != nil {
+		if u, err := base.Parse(strings.TrimSpace(html.UnescapeString(m[1]))); err == nil {
+			base = u
+		}
+		xml = urlBase.ReplaceAllString(xml, "")
+	}
+
+	return descriptorURL.ReplaceAllStringFunc(xml, func(element string) string {
+		m := descriptorURL.FindStringSubmatch(element)
+		name, value := m[1], strings.TrimSpace(html.UnescapeString(m[2]))
+		field := name
+		if name == "url" {
+			field = FieldIcons
+		}
+		if value == "" {
+			return element
+		}
+		u, err := base.Parse(value)
+		if err != nil {
+			return element
+		}
+
+		rewritten := u.String()
+		if p.rewrite[field] && u.Host == p.location.Host && u.Scheme == p.location.Scheme {
+			rewritten = ProxyPrefix + u.EscapedPath()
+			if u.RawQuery != "" {
+				rewritten += "?" + u.RawQuery
+			}
+		}
+		return "<" + name + ">" + html.EscapeString(rewritten) + "</" + name + ">"
+	})
+}
+
+// handleProxy passes a request for one of the proxied device's URLs on to
+// it, logging the call and applying the tamper rules to SOAP calls
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, p *deviceProxy) {
+	e := s.newRequest(r)
+	action := soapAction(r)
+	s.log("%sHost: %s, User-Agent: %s", ssdp.ProxyBox, e.Host, e.UserAgent)
+	s.log("               %s %s", e.Method, strings.TrimPrefix(e.Path, ProxyPrefix))
+	if action != "" {
+		s.log("               SOAPAction: %s", action)
+	}
+	if e.Body != "" {
+		s.log("               Body: %s", formatBody(e))
+	}
+
+	proxy := *p.reverse
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		s.notice("%sError proxying %s to %s: %v", ssdp.WarnBox, r.URL.Path, p.location.Host, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	if action != "" && len(p.tamper) > 0 {
+		if s.tamperRequest(w, r, p, action) {
+			return
+		}
+		// Let the transport ask for and undo compression, so the answer
+		// can be read
+		r.Header.Del("Accept-Encoding")
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			s.tamperResponse(r, resp, p, action)
+			return nil
+		}
+	}
+	proxy.ServeHTTP(w, r)
+}
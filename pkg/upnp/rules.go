@@ -0,0 +1,237 @@
+package upnp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Rule actions
+const (
+	ActionRedirect = "redirect" // send the client to Target
+	ActionPage     = "page"     // serve the template page at Target
+	ActionNotFound = "404"      // answer 404 Not Found
+	ActionTarpit   = "tarpit"   // hold the connection open, trickling bytes
+)
+
+// DefaultTarpit is how long a tarpit holds a connection unless the rule
+// says otherwise
+const DefaultTarpit = 30 * time.Second
+
+// tarpitInterval is how often a tarpit sends a byte to keep the client
+// waiting
+const tarpitInterval = 5 * time.Second
+
+// Rule decides how requests it matches are answered, before the built-in
+// handlers see them. Empty match fields match every request.
+type Rule struct {
+	// Glob the request path must match, as in path.Match, except that a
+	// trailing * also matches across slashes (/admin* matches /admin/x)
+	Path string `yaml:"path"`
+	// Regular expression the User-Agent must match
+	UserAgent string `yaml:"user_agent"`
+	// Addresses or CIDRs the client must be in
+	Source []string `yaml:"source"`
+	// Weekly windows in local time, like "Mon-Fri 09:00-17:00", the
+	// request must arrive in
+	Hours []string `yaml:"hours"`
+
+	Action string `yaml:"action"`
+	// URL to redirect to, or the page to serve: /present.html or one of
+	// the template's routes
+	Target string `yaml:"target"`
+	// How long a tarpit holds the connection. Defaults to DefaultTarpit.
+	Delay time.Duration `yaml:"delay"`
+}
+
+// rule is a Rule ready for matching
+type rule struct {
+	Rule
+	userAgent *regexp.Regexp
+	sources   []*net.IPNet
+	hours     *ssdp.Schedule
+}
+
+// compileRules checks rules and prepares them for matching. Page targets
+// must be pages the template serves.
+func compileRules(rules []Rule, routes map[string]string) ([]rule, error) {
+	compiled := make([]rule, len(rules))
+	for i, r := range rules {
+		c := rule{Rule: r}
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid path %q", i+1, r.Path)
+		}
+		if r.UserAgent != "" {
+			re, err := regexp.Compile(r.UserAgent)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid user agent pattern: %w", i+1, err)
+			}
+			c.userAgent = re
+		}
+		for _, source := range r.Source {
+			n, err := parseSource(source)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			c.sources = append(c.sources, n)
+		}
+		if len(r.Hours) > 0 {
+			hours, err := ssdp.ParseSchedule(r.Hours)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			c.hours = hours
+		}
+
+		switch r.Action {
+		case ActionRedirect:
+			if r.Target == "" {
+				return nil, fmt.Errorf("rule %d: redirect needs a target", i+1)
+			}
+		case ActionPage:
+			if _, ok := routes[r.Target]; !ok && r.Target != "/present.html" {
+				return nil, fmt.Errorf("rule %d: page %q is not served by the template", i+1, r.Target)
+			}
+		case ActionNotFound:
+		case ActionTarpit:
+			if c.Delay == 0 {
+				c.Delay = DefaultTarpit
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i+1, r.Action)
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// parseSource parses a CIDR, taking a bare address as a single host
+func parseSource(source string) (*net.IPNet, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q", source)
+	}
+	return n, nil
+}
+
+// matches reports whether the request from host matches the rule
+func (r *rule) matches(req *http.Request, host string) bool {
+	if r.Path != "" && !matchPath(r.Path, req.URL.Path) {
+		return false
+	}
+	if r.userAgent != nil && !r.userAgent.MatchString(req.Header.Get("User-Agent")) {
+		return false
+	}
+	if r.hours != nil && !r.hours.Active(time.Now()) {
+		return false
+	}
+	if len(r.sources) > 0 {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, n := range r.sources {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchPath reports whether p matches pattern, a trailing * matching the
+// rest of the path
+func matchPath(pattern, p string) bool {
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	if !strings.HasSuffix(pattern, "*") {
+		return false
+	}
+	// Otherwise try the rest of the pattern on each prefix of the path
+	prefix := strings.TrimSuffix(pattern, "*")
+	for i := len(p); i >= 0; i-- {
+		if ok, _ := path.Match(prefix, p[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules answers the request with the first rule it matches, reporting
+// whether one did
+func (s *Server) applyRules(w http.ResponseWriter, r *http.Request) bool {
+	site := s.current.Load()
+	host := s.getClientIP(r)
+	for i := range site.rules {
+		rule := &site.rules[i]
+		if !rule.matches(r, host) {
+			continue
+		}
+		s.log("%sHost: %s, %s %s matched rule %d (%s)", ssdp.NoteBox, host, r.Method, r.URL.Path, i+1, rule.Action)
+
+		switch rule.Action {
+		case ActionRedirect:
+			http.Redirect(w, r, rule.Target, http.StatusFound)
+		case ActionPage:
+			if rule.Target == "/present.html" {
+				s.handlePhishingPage(w, r)
+			} else {
+				s.handleRoute(w, r, site.routes[rule.Target])
+			}
+		case ActionNotFound:
+			http.NotFound(w, r)
+		case ActionTarpit:
+			tarpit(w, r, rule.Delay)
+		}
+		return true
+	}
+	return false
+}
+
+// tarpit keeps the client waiting for d, sending a byte now and then so it
+// does not time out, unless it gives up first. The server's write timeout
+// is pushed back to let it.
+func tarpit(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Now().Add(d + tarpitInterval))
+	defer controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	ticker := time.NewTicker(tarpitInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte(" ")); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
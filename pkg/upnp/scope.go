@@ -0,0 +1,51 @@
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+
+	"goSSDPkit/pkg/scope"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// benignPage is served to hosts outside the engagement scope in place of
+// anything the template would serve
+const benignPage = "<!DOCTYPE html>\n<html><head><title>Welcome</title></head><body><h1>It works!</h1></body></html>\n"
+
+// WithScope serves hosts outside s a benign page instead of the template,
+// never capturing from them, and writes their requests to violations
+// instead of the logs
+func WithScope(s *scope.Scope, violations *scope.Violations) Option {
+	return func(srv *Server) {
+		srv.scope.Store(s)
+		srv.violations = violations
+	}
+}
+
+// SetScope replaces the scope requests are checked against while the
+// server runs, nil for none. Requests from outside it go to the
+// violations log given to WithScope.
+func (s *Server) SetScope(inScope *scope.Scope) {
+	s.scope.Store(inScope)
+}
+
+// WithTrustedProxies believes the X-Forwarded-For and X-Real-IP headers of
+// requests from proxies, such as a redirector in front of the server, for
+// scope and rate limiting. Other clients are judged by their own address.
+func WithTrustedProxies(proxies *scope.Scope) Option {
+	return func(srv *Server) {
+		srv.trustedProxies = proxies
+	}
+}
+
+// serveOutOfScope answers a request from a host outside the engagement
+// scope, recording it as a violation
+func (s *Server) serveOutOfScope(w http.ResponseWriter, r *http.Request, host string) {
+	what := fmt.Sprintf("HTTP %q User-Agent=%q", r.Method+" "+r.URL.RequestURI(), r.UserAgent())
+	if s.violations.Record(host, what) {
+		s.notice("%sServing %s a benign page, as it is out of scope; its requests go to the violations log only", ssdp.WarnBox, host)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(benignPage))
+}
@@ -0,0 +1,77 @@
+package upnp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// ScreenshotPath is where pages upload their screenshot on submit
+const ScreenshotPath = "/ssdp/screenshot"
+
+// DefaultScreenshotScript is where html2canvas, which draws the page, is
+// loaded from unless another URL is given. It is not built in: drop
+// html2canvas.min.js into the templates' assets directory.
+const DefaultScreenshotScript = "/assets/html2canvas.min.js"
+
+// maxScreenshotSize caps the PNG a page may upload
+const maxScreenshotSize = 8 << 20
+
+// screenshotTimeout is how long a submission waits for its screenshot to
+// upload before going ahead without it, in milliseconds
+const screenshotTimeout = 3000
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// screenshotScript returns the scripts injected into pages to picture them
+// on submit: html2canvas loaded from src, and a submit handler that draws
+// the page, uploads the picture and then submits the form. Forms whose
+// submission the page handles itself, and pages where html2canvas did not
+// load, submit as they would have.
+func screenshotScript(src string) string {
+	return `<script src="` + strings.ReplaceAll(src, `"`, "%22") + `"></script>` +
+		`<script>(function(){var busy=false;document.addEventListener("submit",function(e){var f=e.target;` +
+		`if(e.defaultPrevented||busy||!window.html2canvas)return;e.preventDefault();busy=true;var done=false;` +
+		`function go(){if(done)return;done=true;HTMLFormElement.prototype.submit.call(f)}setTimeout(go,` + strconv.Itoa(screenshotTimeout) + `);` +
+		`html2canvas(document.documentElement,{logging:false}).then(function(c){var x=new XMLHttpRequest();` +
+		`x.open("POST","` + ScreenshotPath + `?page="+encodeURIComponent(location.pathname));x.onloadend=go;x.send(c.toDataURL("image/png"))})["catch"](go)})})();</script>`
+}
+
+// handleScreenshot saves the picture a page uploads on submit, at most one
+// a minute from each host so uploads cannot fill the disk
+func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !s.current.Load().config.Screenshots {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(base64.StdEncoding.EncodedLen(maxScreenshotSize)+64)))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	encoded, ok := bytes.CutPrefix(bytes.TrimSpace(data), []byte("data:image/png;base64,"))
+	image := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(image, encoded)
+	if !ok || err != nil || n > maxScreenshotSize || !bytes.HasPrefix(image[:n], pngSignature) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	e := events.Screenshot{Request: s.newRequest(r), Image: image[:n]}
+	if !s.limiter.warn(e.Host, "screenshot", time.Now()) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	e.Path = truncate(r.URL.Query().Get("page"), maxFieldName*4)
+	// The picture is in the event; the body would only repeat it
+	e.Body, e.BodyBinary, e.BodyTruncated = "", false, false
+	s.events.OnScreenshot(e)
+	w.WriteHeader(http.StatusNoContent)
+}
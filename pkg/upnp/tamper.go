@@ -0,0 +1,220 @@
+package upnp
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// Tamper directions
+const (
+	TamperRequest  = "request"  // what the control point sends the device
+	TamperResponse = "response" // what the device answers
+)
+
+// DefaultFaultDescription is the errorDescription of an injected fault
+// unless the rule gives one
+const DefaultFaultDescription = "Action Failed"
+
+// maxSOAPBody is the largest SOAP body a tamper rule is applied to; larger
+// ones pass through untouched
+const maxSOAPBody = 1 << 20
+
+// soapFault is the SOAP envelope of an injected UPnP error
+const soapFault = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`
+
+// Tamper modifies proxied SOAP calls it matches, to see how control points
+// cope with a device answering something else than it did, or failing.
+// Every rule matching a call is applied, in order.
+type Tamper struct {
+	// SOAP action matched, as SERVICE#ACTION or just ACTION. Empty
+	// matches every call.
+	Action string `yaml:"action"`
+	// TamperRequest or TamperResponse. Defaults to TamperResponse.
+	Direction string `yaml:"direction"`
+	// Argument whose value is replaced with Value. With Fault, the fault
+	// is only injected when the argument is present.
+	Argument string `yaml:"argument"`
+	Value    string `yaml:"value"`
+	// UPnP error code answered instead: on requests, without passing the
+	// call on; on responses, after the device has carried it out
+	Fault            int    `yaml:"fault"`
+	FaultDescription string `yaml:"fault_description"`
+}
+
+// tamper is a Tamper ready for matching
+type tamper struct {
+	Tamper
+	argument *regexp.Regexp
+}
+
+// compileTampers checks rules and prepares them for matching
+func compileTampers(rules []Tamper) ([]tamper, error) {
+	compiled := make([]tamper, len(rules))
+	for i, t := range rules {
+		switch t.Direction {
+		case "":
+			t.Direction = TamperResponse
+		case TamperRequest, TamperResponse:
+		default:
+			return nil, fmt.Errorf("tamper rule %d: direction must be %s or %s", i+1, TamperRequest, TamperResponse)
+		}
+		if t.Fault == 0 && t.Argument == "" {
+			return nil, fmt.Errorf("tamper rule %d: needs an argument to replace or a fault", i+1)
+		}
+		if t.Fault < 0 || t.Fault > 999 {
+			return nil, fmt.Errorf("tamper rule %d: invalid fault code %d", i+1, t.Fault)
+		}
+		if t.FaultDescription == "" {
+			t.FaultDescription = DefaultFaultDescription
+		}
+		c := tamper{Tamper: t}
+		if t.Argument != "" {
+			// Arguments are unqualified in UPnP, but allow for a prefix
+			c.argument = regexp.MustCompile(`(<(?:[\w.-]+:)?` + regexp.QuoteMeta(t.Argument) + `(?:\s[^>]*)?>)([^<]*)(</(?:[\w.-]+:)?` + regexp.QuoteMeta(t.Argument) + `>)`)
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// matches reports whether t applies to action in direction
+func (t tamper) matches(direction, action string) bool {
+	if t.Direction != direction {
+		return false
+	}
+	if t.Action == "" || t.Action == action {
+		return true
+	}
+	_, name, _ := strings.Cut(action, "#")
+	return t.Action == name
+}
+
+// soapAction returns the action of a SOAP call, SERVICE#ACTION, without
+// the quotes it is sent in
+func soapAction(r *http.Request) string {
+	return strings.Trim(r.Header.Get("SOAPAction"), `" `)
+}
+
+// tamperBody applies the rules matching action in direction to body. It
+// returns the body to send, a description of each change, and the rule
+// injecting a fault, if one does.
+func tamperBody(rules []tamper, direction, action, body string) (string, []string, *tamper) {
+	var changes []string
+	for i := range rules {
+		t := &rules[i]
+		if !t.matches(direction, action) {
+			continue
+		}
+		if t.Fault != 0 {
+			if t.argument == nil || t.argument.MatchString(body) {
+				return body, changes, t
+			}
+			continue
+		}
+		m := t.argument.FindStringSubmatchIndex(body)
+		if m == nil {
+			continue
+		}
+		old := html.UnescapeString(body[m[4]:m[5]])
+		body = body[:m[4]] + html.EscapeString(t.Value) + body[m[5]:]
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", t.Argument, strconv.Quote(old), strconv.Quote(t.Value)))
+	}
+	return body, changes, nil
+}
+
+// readSOAPBody reads body if it is small enough to tamper with, returning
+// it and a reader giving back all of it either way
+func readSOAPBody(body io.ReadCloser) (string, io.ReadCloser, bool) {
+	data, err := io.ReadAll(io.LimitReader(body, maxSOAPBody+1))
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil || len(data) > maxSOAPBody {
+		return "", rest, false
+	}
+	return string(data), rest, true
+}
+
+// faultBody returns the SOAP fault t injects
+func (t *tamper) faultBody() string {
+	return fmt.Sprintf(soapFault, t.Fault, html.EscapeString(t.FaultDescription))
+}
+
+// tamperRequest applies the request rules to a proxied SOAP call. It
+// reports whether a fault was answered, in which case the call must not
+// be passed on.
+func (s *Server) tamperRequest(w http.ResponseWriter, r *http.Request, p *deviceProxy, action string) bool {
+	if r.Body == nil {
+		return false
+	}
+	body, rest, ok := readSOAPBody(r.Body)
+	r.Body = rest
+	if !ok {
+		return false
+	}
+
+	tampered, changes, fault := tamperBody(p.tamper, TamperRequest, action, body)
+	if fault != nil {
+		s.logTamper(r, action, TamperRequest, []string{fmt.Sprintf("Fault %d (%s) answered", fault.Fault, fault.FaultDescription)}, body, "")
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, fault.faultBody())
+		return true
+	}
+	if len(changes) > 0 {
+		s.logTamper(r, action, TamperRequest, changes, body, tampered)
+		r.Body = io.NopCloser(strings.NewReader(tampered))
+		r.ContentLength = int64(len(tampered))
+	}
+	return false
+}
+
+// tamperResponse applies the response rules to the device's answer to a
+// proxied SOAP call
+func (s *Server) tamperResponse(r *http.Request, resp *http.Response, p *deviceProxy, action string) {
+	body, rest, ok := readSOAPBody(resp.Body)
+	resp.Body = rest
+	if !ok {
+		return
+	}
+
+	tampered, changes, fault := tamperBody(p.tamper, TamperResponse, action, body)
+	if fault != nil {
+		tampered = fault.faultBody()
+		changes = append(changes, fmt.Sprintf("Fault %d (%s) injected after %s", fault.Fault, fault.FaultDescription, resp.Status))
+		resp.StatusCode, resp.Status = http.StatusInternalServerError, "500 Internal Server Error"
+		resp.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	}
+	if len(changes) == 0 {
+		return
+	}
+	s.logTamper(r, action, TamperResponse, changes, body, tampered)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{strings.NewReader(tampered), rest}
+	resp.ContentLength = int64(len(tampered))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(tampered)))
+}
+
+// logTamper logs a tampered SOAP call with the body before and after
+func (s *Server) logTamper(r *http.Request, action, direction string, changes []string, before, after string) {
+	s.notice("%sHost: %s, SOAPAction: %s", ssdp.TamperBox, s.getClientIP(r), action)
+	for _, change := range changes {
+		s.notice("               %s %s", strings.ToUpper(direction[:1])+direction[1:], change)
+	}
+	s.log("               Before: %s", strconv.Quote(before))
+	if after != "" {
+		s.log("               After:  %s", strconv.Quote(after))
+	}
+}
@@ -0,0 +1,178 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"goSSDPkit/pkg/fingerprint"
+	"goSSDPkit/pkg/ssdp"
+)
+
+// WithTLS serves TLS with config on the server's addresses, alongside plain
+// HTTP: a connection opening with a TLS handshake is served over TLS, any
+// other as before, so descriptors and templates linking to http:// keep
+// working. The ClientHello of every TLS connection is fingerprinted with
+// JA3 and JA4, logged, and recorded with the requests made over it.
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config.Clone()
+		// HTTP/2 would need a server of its own
+		s.tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+}
+
+// TLS record and handshake framing
+const (
+	recordHandshake = 0x16
+	recordHeaderLen = 5
+	maxRecordLen    = 16384 + 2048
+	// maxHelloLen bounds the records read for one ClientHello; real ones
+	// fit in one record
+	maxHelloLen = 2 * (recordHeaderLen + maxRecordLen)
+)
+
+// tlsListener hands out connections that switch to TLS when they open with
+// a handshake
+type tlsListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sniffConn{Conn: conn, s: l.s}, nil
+}
+
+// sniffConn is a connection served over TLS if its first byte starts a
+// handshake record, and as plain HTTP otherwise. That is decided on the
+// first read, under the server's header timeout, so a slow client only
+// holds up its own connection.
+type sniffConn struct {
+	net.Conn // as accepted
+	s        *Server
+	once     sync.Once
+	inner    net.Conn // what is read and written once decided
+	err      error
+	hello    *fingerprint.ClientHello // nil for plain HTTP or an unreadable ClientHello
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	c.once.Do(c.sniff)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.inner.Read(p)
+}
+
+func (c *sniffConn) Write(p []byte) (int, error) {
+	c.once.Do(c.sniff)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.inner.Write(p)
+}
+
+// sniff reads the first byte and, for a handshake, the ClientHello, then
+// sets up the connection to serve
+func (c *sniffConn) sniff() {
+	var first [1]byte
+	if _, err := io.ReadFull(c.Conn, first[:]); err != nil {
+		c.err = err
+		return
+	}
+	r := io.MultiReader(bytes.NewReader(first[:]), c.Conn)
+	if first[0] != recordHandshake {
+		c.inner = &readerConn{Conn: c.Conn, r: r}
+		return
+	}
+	br := bufio.NewReaderSize(r, maxHelloLen)
+	if msg, err := peekHello(br); err == nil {
+		if hello, err := fingerprint.ParseClientHello(msg); err == nil {
+			c.hello = hello
+			c.s.logHello(c.RemoteAddr(), hello)
+		}
+	}
+	c.inner = tls.Server(&readerConn{Conn: c.Conn, r: br}, c.s.tlsConfig)
+}
+
+// peekHello returns the handshake message at the start of br, gathered
+// from as many handshake records as it spans, without consuming them
+func peekHello(br *bufio.Reader) ([]byte, error) {
+	var msg []byte
+	offset := 0
+	for {
+		header, err := br.Peek(offset + recordHeaderLen)
+		if err != nil {
+			return nil, err
+		}
+		header = header[offset:]
+		if header[0] != recordHandshake {
+			return nil, errors.New("not a handshake record")
+		}
+		length := int(header[3])<<8 | int(header[4])
+		if length > maxRecordLen {
+			return nil, errors.New("oversized record")
+		}
+		record, err := br.Peek(offset + recordHeaderLen + length)
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, record[offset+recordHeaderLen:]...)
+		offset += recordHeaderLen + length
+		if len(msg) >= 4 && len(msg) >= 4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+			return msg, nil
+		}
+	}
+}
+
+// readerConn reads from r, which has read ahead on the connection
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// logHello logs the fingerprints of a TLS client
+func (s *Server) logHello(addr net.Addr, hello *fingerprint.ClientHello) {
+	host, _, _ := net.SplitHostPort(addr.String())
+	sni := hello.ServerName
+	if sni == "" {
+		sni = "none"
+	}
+	s.log("%sHost: %s, TLS ClientHello, SNI: %s", ssdp.NoteBox, host, sni)
+	s.log("               JA4 %s, JA3 %s", hello.JA4(), hello.JA3())
+}
+
+// connKey is the request context key of the connection a request came on
+type connKey struct{}
+
+// connContext remembers the connection of the requests it serves, so they
+// can be tied to its ClientHello
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if sc, ok := c.(*sniffConn); ok {
+		return context.WithValue(ctx, connKey{}, sc)
+	}
+	return ctx
+}
+
+// helloOf returns the ClientHello of the TLS connection r came on, nil for
+// plain HTTP
+func helloOf(r *http.Request) *fingerprint.ClientHello {
+	if sc, ok := r.Context().Value(connKey{}).(*sniffConn); ok {
+		return sc.hello
+	}
+	return nil
+}
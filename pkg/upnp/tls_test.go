@@ -0,0 +1,98 @@
+package upnp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/logging"
+	"goSSDPkit/pkg/template"
+)
+
+// fetches records the descriptor fetches of a server
+type fetches struct {
+	events.Nop
+	c chan events.Request
+}
+
+func (f fetches) OnDescriptorFetch(e events.Request) { f.c <- e }
+
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSAlongsideHTTP(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test/device.xml":   {Data: []byte("<root/>")},
+		"test/present.html": {Data: []byte("<html>welcome</html>")},
+	}
+	manager := template.NewManager(fsys, "test", template.TemplateData{LocalIP: "127.0.0.1", LocalPort: 8080})
+	got := fetches{c: make(chan events.Request, 2)}
+	s, err := NewServer(manager, Config{LocalIP: "127.0.0.1", LocalPort: 8080},
+		WithLogger(logging.NewConsoleLogger(io.Discard)),
+		WithEvents(got),
+		WithTLS(&tls.Config{Certificates: []tls.Certificate{testCert(t)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx, ln)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "printer.example", NextProtos: []string{"http/1.1"}},
+	}}
+	for _, scheme := range []string{"https", "http"} {
+		resp, err := client.Get(scheme + "://" + ln.Addr().String() + "/ssdp/device-desc.xml")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<root/>") {
+			t.Errorf("%s: status %d, body %q", scheme, resp.StatusCode, body)
+		}
+
+		e := <-got.c
+		if scheme == "http" {
+			if e.JA3 != "" || e.JA4 != "" {
+				t.Errorf("plain HTTP fingerprinted as %s, %s", e.JA3, e.JA4)
+			}
+			continue
+		}
+		// Go offers TLS 1.3 with SNI and ALPN http/1.1
+		if len(e.JA3) != 32 || !strings.HasPrefix(e.JA4, "t13d") || !strings.Contains(e.JA4, "h1_") {
+			t.Errorf("TLS fingerprinted as %q, %q", e.JA3, e.JA4)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package upnp
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"goSSDPkit/pkg/ssdp"
+)
+
+// ResponseIDCookie remembers the ID of the SSDP response that led a
+// browser to the server, for the requests after the first
+const ResponseIDCookie = "rid"
+
+// maxTrackedHosts caps how many hosts the last response ID is remembered
+// for
+const maxTrackedHosts = 10000
+
+// presentationURL matches the presentation URL of a device descriptor
+var presentationURL = regexp.MustCompile(`(<presentationURL>)([^<]*)(</presentationURL>)`)
+
+// responseIDKey is the request context key of the response ID a request is
+// tied to
+type responseIDKey struct{}
+
+// WithTracking follows the response IDs the listener adds to LOCATION URLs
+// (ssdp.WithTracking) through the requests that come after, tying each
+// request and capture to the search that led to it
+func WithTracking(track bool) Option {
+	return func(s *Server) {
+		s.tracking = track
+	}
+}
+
+// track returns r with the ID of the SSDP response that led its client here
+// in its context: the one its URL carries, or failing that its cookie, or
+// the last one seen from the host. An ID in the URL is remembered for the
+// host and in a cookie.
+func (s *Server) track(w http.ResponseWriter, r *http.Request) *http.Request {
+	if !s.tracking {
+		return r
+	}
+	host := s.getClientIP(r)
+	id := r.URL.Query().Get(ssdp.ResponseIDParam)
+	if validResponseID(id) {
+		if c, err := r.Cookie(ResponseIDCookie); err != nil || c.Value != id {
+			http.SetCookie(w, &http.Cookie{
+				Name:     ResponseIDCookie,
+				Value:    id,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		s.trackedMu.Lock()
+		if s.trackedHosts == nil || len(s.trackedHosts) >= maxTrackedHosts {
+			s.trackedHosts = make(map[string]string)
+		}
+		s.trackedHosts[host] = id
+		s.trackedMu.Unlock()
+	} else if c, err := r.Cookie(ResponseIDCookie); err == nil && validResponseID(c.Value) {
+		id = c.Value
+	} else {
+		s.trackedMu.Lock()
+		id = s.trackedHosts[host]
+		s.trackedMu.Unlock()
+	}
+	if id == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), responseIDKey{}, id))
+}
+
+// validResponseID reports whether id looks like an ID the listener gave
+func validResponseID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// responseIDOf returns the response ID r is tied to, if any
+func responseIDOf(r *http.Request) string {
+	id, _ := r.Context().Value(responseIDKey{}).(string)
+	return id
+}
+
+// trackPresentation adds id to the presentation URL of a descriptor, so the
+// browser a client opens on the device carries it to the phishing page
+func trackPresentation(xml, id string) string {
+	return presentationURL.ReplaceAllStringFunc(xml, func(match string) string {
+		parts := presentationURL.FindStringSubmatch(match)
+		sep := "?"
+		if strings.Contains(parts[2], "?") {
+			sep = "&amp;"
+		}
+		return parts[1] + parts[2] + sep + ssdp.ResponseIDParam + "=" + id + parts[3]
+	})
+}
@@ -0,0 +1,75 @@
+package upnp
+
+import (
+	"context"
+	"net/http"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/training"
+)
+
+// maxTargetHosts caps how many hosts are remembered as having opened a
+// training link
+const maxTargetHosts = 10000
+
+// targetKey is the request context key of the training target a request is
+// attributed to
+type targetKey struct{}
+
+// target is a training target a request is attributed to
+type target struct {
+	name, token string
+}
+
+// attribute returns r with the training target it comes from in its
+// context: the one whose token the link carries, or failing that the
+// token's cookie, or the last target whose link was opened from the host.
+// Opening a link sets the cookie and is logged once per host.
+func (s *Server) attribute(w http.ResponseWriter, r *http.Request, site *site) *http.Request {
+	targets := site.config.Targets
+	if len(targets) == 0 {
+		return r
+	}
+	host := s.getClientIP(r)
+	token := r.URL.Query().Get(training.TokenParam)
+	if _, ok := targets[token]; ok {
+		if c, err := r.Cookie(training.TokenCookie); err != nil || c.Value != token {
+			http.SetCookie(w, &http.Cookie{
+				Name:     training.TokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		s.targetsMu.Lock()
+		known := s.targetHosts[host] == token
+		if !known {
+			if s.targetHosts == nil || len(s.targetHosts) >= maxTargetHosts {
+				s.targetHosts = make(map[string]string)
+			}
+			s.targetHosts[host] = token
+		}
+		s.targetsMu.Unlock()
+		if !known {
+			s.log("%sTraining link of %s opened from %s", ssdp.NoteBox, targets[token], host)
+		}
+	} else if c, err := r.Cookie(training.TokenCookie); err == nil && targets[c.Value] != "" {
+		token = c.Value
+	} else {
+		s.targetsMu.Lock()
+		token = s.targetHosts[host]
+		s.targetsMu.Unlock()
+	}
+	name, ok := targets[token]
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), targetKey{}, target{name: name, token: token}))
+}
+
+// targetOf returns the training target r is attributed to, if any
+func targetOf(r *http.Request) target {
+	t, _ := r.Context().Value(targetKey{}).(target)
+	return t
+}
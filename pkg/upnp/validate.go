@@ -0,0 +1,51 @@
+package upnp
+
+import (
+	"context"
+
+	"goSSDPkit/pkg/events"
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/validate"
+)
+
+// WithValidator validates captured credentials with v, instead of the
+// service Config.Validate names
+func WithValidator(v validate.Validator) Option {
+	return func(s *Server) {
+		s.validator = validate.NewChecker(v)
+	}
+}
+
+// newChecker returns the checker for the validation target, or nil for
+// none. The current checker is kept while the target is unchanged, so
+// reloading does not try the same passwords again.
+func (s *Server) newChecker(target string) (*validate.Checker, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if current := s.current.Load(); current != nil && current.config.Validate == target {
+		return current.checker, nil
+	}
+	v, err := validate.New(target)
+	if err != nil {
+		return nil, err
+	}
+	return validate.NewChecker(v), nil
+}
+
+// validate tries c against the validation target, returning its verdict,
+// or "" when there is no target or the attempt failed
+func (s *Server) validate(c events.Credentials) string {
+	checker := s.validator
+	if checker == nil {
+		checker = s.current.Load().checker
+	}
+	if checker == nil {
+		return ""
+	}
+	verdict, err := checker.Check(context.Background(), c)
+	if err != nil {
+		s.log("%sHost: %s, could not validate the credentials of %s: %v", ssdp.WarnBox, c.Host, c.Username, err)
+	}
+	return verdict
+}
@@ -0,0 +1,255 @@
+package upnp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"goSSDPkit/pkg/ssdp"
+	"goSSDPkit/pkg/template"
+)
+
+// DefaultVarTTL is how long a variable's value is kept unless it says
+// otherwise
+const DefaultVarTTL = time.Minute
+
+// Limits on fetching a variable: how long it may take, how much of its
+// output is kept, how long a failure is remembered before trying again, how
+// many values are cached in all and how many fetches of one variable may
+// run at once
+const (
+	varTimeout  = 5 * time.Second
+	maxVarValue = 64 << 10
+	varRetry    = 10 * time.Second
+	maxVarCache = 10000
+	maxVarRuns  = 4
+)
+
+// varName is what a variable may be called, to be usable as {{.Vars.NAME}}
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Var is a template variable, {{.Vars.NAME}} in pages, whose value is the
+// output of a command or the body of an HTTP GET, fetched when a page is
+// rendered and kept for TTL. Command arguments and the URL are templates
+// themselves, seeing the victim as {{.Victim.IP}} and so on, so values can
+// be victim-specific; each distinct command or URL is cached apart.
+type Var struct {
+	Name string `yaml:"name"`
+	// Program and arguments whose standard output, without trailing line
+	// breaks, is the value
+	Command []string `yaml:"command"`
+	// URL whose response body is the value
+	URL string `yaml:"url"`
+	// How long a value is kept. Defaults to DefaultVarTTL.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// compiledVar is a Var with its command and URL parsed
+type compiledVar struct {
+	Var
+	command []*texttemplate.Template
+	url     *texttemplate.Template
+	runs    chan struct{} // fetches running, up to maxVarRuns
+}
+
+// compileVars checks vars and parses their templates
+func compileVars(vars []Var) ([]compiledVar, error) {
+	compiled := make([]compiledVar, len(vars))
+	names := make(map[string]bool)
+	for i, v := range vars {
+		if !varName.MatchString(v.Name) {
+			return nil, fmt.Errorf("var %d: invalid name %q", i+1, v.Name)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("var %s: defined twice", v.Name)
+		}
+		names[v.Name] = true
+		if (len(v.Command) == 0) == (v.URL == "") {
+			return nil, fmt.Errorf("var %s: set either command or url", v.Name)
+		}
+		if v.TTL < 0 {
+			return nil, fmt.Errorf("var %s: ttl must not be negative", v.Name)
+		}
+		if v.TTL == 0 {
+			v.TTL = DefaultVarTTL
+		}
+		c := compiledVar{Var: v, runs: make(chan struct{}, maxVarRuns)}
+		for _, arg := range v.Command {
+			tmpl, err := texttemplate.New(v.Name).Parse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("var %s: %w", v.Name, err)
+			}
+			c.command = append(c.command, tmpl)
+		}
+		if v.URL != "" {
+			tmpl, err := texttemplate.New(v.Name).Parse(v.URL)
+			if err != nil {
+				return nil, fmt.Errorf("var %s: %w", v.Name, err)
+			}
+			c.url = tmpl
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// varCache keeps fetched values until they expire
+type varCache struct {
+	mu      sync.Mutex
+	entries map[string]varEntry
+}
+
+// varEntry is a fetched value and when it expires
+type varEntry struct {
+	value   string
+	expires time.Time
+}
+
+// vars returns the values of the site's variables for victim, fetching
+// those not cached concurrently. A variable that cannot be fetched is
+// logged and left empty.
+func (s *Server) vars(ctx context.Context, site *site, victim template.Victim) map[string]string {
+	if len(site.vars) == 0 {
+		return nil
+	}
+	data := struct{ Victim template.Victim }{victim}
+	values := make(map[string]string, len(site.vars))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, v := range site.vars {
+		wg.Add(1)
+		go func(v compiledVar) {
+			defer wg.Done()
+			value, err := s.varValue(ctx, v, data)
+			if err != nil {
+				s.notice("%sTemplate variable %s: %v", ssdp.WarnBox, v.Name, err)
+			}
+			mu.Lock()
+			values[v.Name] = value
+			mu.Unlock()
+		}(v)
+	}
+	wg.Wait()
+	return values
+}
+
+// varValue returns the value of v for data, from the cache or fetched.
+// Victim data in the cache key comes from the client, which could otherwise
+// start a command on every page, so while maxVarRuns fetches of v are
+// running the cached value is used, expired or not.
+func (s *Server) varValue(ctx context.Context, v compiledVar, data interface{}) (string, error) {
+	var args []string
+	for _, tmpl := range v.command {
+		var arg strings.Builder
+		if err := tmpl.Execute(&arg, data); err != nil {
+			return "", err
+		}
+		args = append(args, arg.String())
+	}
+	var url strings.Builder
+	if v.url != nil {
+		if err := v.url.Execute(&url, data); err != nil {
+			return "", err
+		}
+	}
+	key := v.Name + "\x00" + strings.Join(args, "\x00") + "\x00" + url.String()
+
+	now := time.Now()
+	s.varValues.mu.Lock()
+	entry, ok := s.varValues.entries[key]
+	s.varValues.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+	stale := entry.value
+	select {
+	case v.runs <- struct{}{}:
+		defer func() { <-v.runs }()
+	default:
+		return stale, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, varTimeout)
+	defer cancel()
+	var value string
+	var err error
+	if len(args) > 0 {
+		value, err = commandOutput(ctx, args)
+	} else {
+		value, err = fetchURL(ctx, url.String())
+	}
+	entry = varEntry{value: value, expires: now.Add(v.TTL)}
+	if err != nil {
+		// Try again soon rather than on every page, keeping any value
+		// fetched before
+		entry.expires = now.Add(min(v.TTL, varRetry))
+		entry.value = stale
+	}
+	s.varValues.mu.Lock()
+	if s.varValues.entries == nil || len(s.varValues.entries) >= maxVarCache {
+		s.varValues.entries = make(map[string]varEntry)
+	}
+	s.varValues.entries[key] = entry
+	s.varValues.mu.Unlock()
+	return entry.value, err
+}
+
+// commandOutput runs args and returns its standard output, without trailing
+// line breaks
+func commandOutput(ctx context.Context, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout = &limitedWriter{w: &out, n: maxVarValue}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", args[0], err)
+	}
+	return strings.TrimRight(out.String(), "\r\n"), nil
+}
+
+// fetchURL returns the body of a GET of url, which must answer 200
+func fetchURL(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", req.URL.Redacted(), resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVarValue))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(body), "\r\n"), nil
+}
+
+// limitedWriter keeps the first n bytes written to it, discarding the rest
+// so the writer never blocks
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(data []byte) (int, error) {
+	if l.n > 0 {
+		keep := data
+		if len(keep) > l.n {
+			keep = keep[:l.n]
+		}
+		l.w.Write(keep)
+		l.n -= len(keep)
+	}
+	return len(data), nil
+}
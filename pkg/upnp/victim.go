@@ -0,0 +1,94 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/fingerprint"
+	"goSSDPkit/pkg/template"
+)
+
+// lookupTimeout bounds the reverse DNS lookup made before rendering a page
+const lookupTimeout = time.Second
+
+// maxHostnames is how many reverse DNS answers are remembered
+const maxHostnames = 4096
+
+// hostnames caches reverse DNS answers, so each victim is looked up once
+type hostnames struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+// lookup returns the reverse DNS name of ip, or "" if it has none or the
+// lookup does not answer in time
+func (h *hostnames) lookup(ip string) string {
+	h.mu.Lock()
+	name, ok := h.names[ip]
+	h.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.names == nil || len(h.names) >= maxHostnames {
+		h.names = make(map[string]string)
+	}
+	h.names[ip] = name
+	return name
+}
+
+// victim describes the client of r for personalised pages
+func (s *Server) victim(r *http.Request) template.Victim {
+	ip := s.getClientIP(r)
+	userAgent := r.Header.Get("User-Agent")
+	locale := preferredLanguage(r.Header.Get("Accept-Language"))
+	language, _, _ := strings.Cut(locale, "-")
+	target := targetOf(r)
+	return template.Victim{
+		IP:        ip,
+		Hostname:  s.hostnames.lookup(ip),
+		Locale:    locale,
+		Language:  strings.ToLower(language),
+		OS:        fingerprint.OS(userAgent),
+		UserAgent: userAgent,
+		Target:    target.name,
+		Token:     target.token,
+
+		ResponseID: responseIDOf(r),
+	}
+}
+
+// preferredLanguage returns the language tag the client prefers most in an
+// Accept-Language header, or "" if it names none
+func preferredLanguage(header string) string {
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if _, err := fmt.Sscanf(value, "%g", &q); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
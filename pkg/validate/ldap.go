@@ -0,0 +1,145 @@
+package validate
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// LDAP result codes of a bind
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+// maxLDAPMessage bounds the bind response read
+const maxLDAPMessage = 64 * 1024
+
+// bindRequest is an LDAP simple bind, inside its APPLICATION 0 tag
+type bindRequest struct {
+	Version  int
+	Name     []byte
+	Password []byte `asn1:"tag:0"`
+}
+
+// ldapMessage is an LDAP message carrying a bind request
+type ldapMessage struct {
+	ID   int
+	Bind bindRequest `asn1:"application,tag:0"`
+}
+
+// ldapResponse is an LDAP message carrying any response
+type ldapResponse struct {
+	ID int
+	Op asn1.RawValue
+}
+
+// LDAP validates credentials with a simple bind to a directory server.
+// Active Directory takes the username as user@domain or DOMAIN\user.
+type LDAP struct {
+	addr string
+	tls  bool
+}
+
+// NewLDAP creates an LDAP validator for an ldap:// or ldaps:// URL
+func NewLDAP(u *url.URL) *LDAP {
+	l := &LDAP{addr: u.Host, tls: u.Scheme == "ldaps"}
+	if u.Port() == "" {
+		port := "389"
+		if l.tls {
+			port = "636"
+		}
+		l.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return l
+}
+
+// Validate implements Validator
+func (l *LDAP) Validate(ctx context.Context, username, password string) (bool, error) {
+	dialer := &net.Dialer{Timeout: Timeout}
+	var conn net.Conn
+	var err error
+	if l.tls {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: insecureTLS}).DialContext(ctx, "tcp", l.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", l.addr)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(Timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	request, err := asn1.Marshal(ldapMessage{ID: 1, Bind: bindRequest{Version: 3, Name: []byte(username), Password: []byte(password)}})
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return false, err
+	}
+	data, err := readMessage(conn)
+	if err != nil {
+		return false, fmt.Errorf("failed to read LDAP bind response: %w", err)
+	}
+
+	var resp ldapResponse
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return false, fmt.Errorf("invalid LDAP bind response: %w", err)
+	}
+	if resp.Op.Class != asn1.ClassApplication || resp.Op.Tag != 1 {
+		return false, errors.New("invalid LDAP bind response")
+	}
+	var code asn1.Enumerated
+	if _, err := asn1.Unmarshal(resp.Op.Bytes, &code); err != nil {
+		return false, fmt.Errorf("invalid LDAP bind response: %w", err)
+	}
+	switch code {
+	case ldapSuccess:
+		return true, nil
+	case ldapInvalidCredentials:
+		return false, nil
+	}
+	return false, fmt.Errorf("LDAP bind failed with result %d", code)
+}
+
+// readMessage reads one BER element from r
+func readMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return nil, errors.New("unsupported BER length")
+		}
+		extra := make([]byte, n)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxLDAPMessage {
+		return nil, fmt.Errorf("LDAP message too large: %d bytes", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
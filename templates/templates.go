@@ -0,0 +1,11 @@
+// Package templates bundles the stock templates and shared assets into the
+// binary so it runs without a templates directory next to it.
+package templates
+
+import "embed"
+
+// FS holds the stock templates, rooted at the template names (office365,
+// xxe-exfil, ...) with the shared assets under assets/
+//
+//go:embed assets bitcoin office365 office365-device-code password-vault scanner xxe-exfil xxe-exfil-dns xxe-exfil-ftp xxe-smb
+var FS embed.FS